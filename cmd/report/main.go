@@ -0,0 +1,84 @@
+package main
+
+import (
+    "blockchainlab/simulator/experiment"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+/*
+    Aggregated report of an experiment run with the runner: reads the results
+    of the measurement modules of every run, and writes report.csv,
+    report.json and report.html.
+
+        report -dir results -confidence 0.95 -percentiles 5,50,95 -metrics 'tx_latency.*,fork_rate.stale_rate'
+
+    See experiment.Report for how metrics are named and aggregated. Flags are
+    parsed with a separate flag set, as the simulator packages register their
+    own flags on the default one.
+*/
+func main() {
+    flags := flag.NewFlagSet(os.Args[0],flag.ExitOnError)
+    dir := flags.String("dir",experiment.DEFAULT_OUTPUT_DIR,"output directory of the runner (with " + experiment.INDEX_FILE + ")")
+    outputDir := flags.String("out","","directory of the report (the output directory of the runner if empty)")
+    confidence := flags.Float64("confidence",experiment.DEFAULT_REPORT_CONFIDENCE,"confidence level of the intervals")
+    percentileList := flags.String("percentiles",formatList(experiment.DEFAULT_REPORT_PERCENTILES),"percentiles across replications, separated by commas")
+    metricList := flags.String("metrics","","patterns of the metrics to include, separated by commas (all but the ones by node or tag if empty)")
+    flags.Parse(os.Args[1:])
+
+    percentiles, err := parseList(*percentileList)
+    if err != nil {
+        fmt.Fprintln(os.Stderr,"invalid percentiles:",err)
+        os.Exit(2)
+    }
+
+    patterns := []string{}
+    if *metricList != "" {
+        patterns = strings.Split(*metricList,",")
+    }
+
+    report, err := experiment.NewReport(*dir,*confidence,percentiles,patterns)
+    if err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        os.Exit(1)
+    }
+
+    if *outputDir == "" {
+        *outputDir = *dir
+    }
+    if err := report.Write(*outputDir); err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        os.Exit(1)
+    }
+
+    fmt.Printf("%d points, %d metrics: see %s/%s\n",len(report.Points),len(report.Metrics),*outputDir,experiment.REPORT_HTML_FILE)
+}
+
+func parseList(str string) ([]float64,error) {
+    values := []float64{}
+    for _, field := range strings.Split(str,",") {
+        if field = strings.TrimSpace(field); field == "" {
+            continue
+        }
+
+        value, err := strconv.ParseFloat(field,64)
+        if err != nil {
+            return nil, err
+        }
+        values = append(values,value)
+    }
+
+    return values, nil
+}
+
+func formatList(values []float64) string {
+    fields := make([]string,len(values))
+    for i, value := range values {
+        fields[i] = strconv.FormatFloat(value,'f',-1,64)
+    }
+
+    return strings.Join(fields,",")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+    "blockchainlab/simulator"
+    "blockchainlab/simulator/experiment"
+    "blockchainlab/simulator/utils"
+    "flag"
+    "fmt"
+    "os"
+)
+
+/*
+    Experiment runner: runs a simulation executable for every combination of a
+    sweep, in parallel.
+
+        runner -config base.toml -sweep sweep.toml -out results -jobs 8 -- ./mysim [args]
+
+    See experiment.Sweep for the format of the sweep file. Relative input paths
+    of the base config (e.g., plugins) are resolved from its directory, as each
+    run is executed in its own directory. With -validate, the configs of all
+    runs are checked before starting any of them (only for simulations with
+    the layers of the standard simulator, or plugins). Flags are parsed with a
+    separate flag set, as the simulator packages register their own flags
+    (e.g., --config of the simulation) on the default one.
+*/
+func main() {
+    flags := flag.NewFlagSet(os.Args[0],flag.ExitOnError)
+    baseConfig := flags.String("config","","base configuration file (empty for the defaults of the simulation)")
+    sweepFile := flags.String("sweep","","sweep specification file")
+    outputDir := flags.String("out",experiment.DEFAULT_OUTPUT_DIR,"output directory (one directory per run, plus " + experiment.INDEX_FILE + ")")
+    jobs := flags.Int("jobs",0,"number of runs in parallel (number of CPUs if not positive)")
+    validate := flags.Bool("validate",false,"validate the config of every run with the layers of the standard simulator (and the plugins in the config) before starting")
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(),"usage: %s [flags] -- <simulation command> [args]\n",os.Args[0])
+        flags.PrintDefaults()
+    }
+    flags.Parse(os.Args[1:])
+
+    if *sweepFile == "" || flags.NArg() == 0 {
+        flags.Usage()
+        os.Exit(2)
+    }
+
+    sweep, err := experiment.ReadSweep(*sweepFile)
+    if err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        os.Exit(1)
+    }
+
+    runner := experiment.NewRunner(*baseConfig,sweep,flags.Args(),*outputDir,*jobs,os.Stdout)
+    if *validate {
+        runner.SetValidator(validateRunConfig)
+    }
+
+    index, err := runner.Run()
+    if err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        os.Exit(1)
+    }
+
+    fmt.Printf("%d runs, %d failed: see %s/%s\n",len(index.Runs),index.NumFailed,*outputDir,experiment.INDEX_FILE)
+    if index.NumFailed > 0 {
+        os.Exit(1)
+    }
+}
+
+// validation of the standard simulator
+func validateRunConfig(config *utils.SimulationConfig) error {
+    if err := simulator.LoadPlugins(config); err != nil {
+        return err
+    }
+
+    return simulator.ValidateConfig(config)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+    "blockchainlab/simulator"
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+)
+
+const (
+    COMMAND_RUN                                 = "run"
+    COMMAND_VALIDATE                            = "validate"
+    COMMAND_LIST                                = "list"
+    COMMAND_DUMP_CONFIG                         = "dump-config"
+)
+
+const usage = `usage: %[1]s <command> [flags]
+
+Runs simulations with the layers built into the binary, according to a config file.
+
+commands:
+    run          run the simulation ("setup" section of the config)
+    validate     check the whole config (factories, parameters, unknown keys), without running
+    list         list the registered factories of every kind (including plugins), with their config keys and defaults
+    dump-config  print the effective config (defaults merged with the config file)
+
+Run "%[1]s <command> -h" for the flags of a command.
+`
+
+/*
+    Standard simulator binary: everything is driven by the config file (see
+    configs/sample-config.toml). It includes all layers of the simulator; custom
+    layers can be built as Go plugins and listed in "setup.plugins" (see
+    simulator.LoadPlugins), or linked in their own main package (see
+    examples/basic), which can call the same functions.
+*/
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Fprintf(os.Stderr,usage,os.Args[0])
+        os.Exit(2)
+    }
+
+    command, args := os.Args[1], os.Args[2:]
+    switch command {
+    case COMMAND_RUN:
+        os.Exit(run(args))
+    case COMMAND_VALIDATE:
+        os.Exit(validate(args))
+    case COMMAND_LIST:
+        os.Exit(list(args))
+    case COMMAND_DUMP_CONFIG:
+        os.Exit(dumpConfig(args))
+    case "help", "-h", "-help", "--help":
+        fmt.Printf(usage,os.Args[0])
+        os.Exit(0)
+    }
+
+    fmt.Fprintf(os.Stderr,"unknown command %q\n",command)
+    fmt.Fprintf(os.Stderr,usage,os.Args[0])
+    os.Exit(2)
+}
+
+// ==== commands ====
+
+func run(args []string) int {
+    flags, configFile := newConfigFlagSet(COMMAND_RUN)
+    flags.Parse(args)
+
+    config, ok := loadConfigWithPlugins(flags,*configFile)
+    if !ok {
+        return 1
+    }
+
+    sim, err := simulator.NewSimulationWithConfig(config)
+    if !reportErrors(err) {
+        return 1
+    }
+    if err := sim.Run(); err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        return 1
+    }
+
+    fmt.Printf("simulation %s finished at time %v\n",sim.GetName(),sim.GetTime())
+    return 0
+}
+
+func validate(args []string) int {
+    flags, configFile := newConfigFlagSet(COMMAND_VALIDATE)
+    flags.Parse(args)
+
+    config, ok := loadConfigWithPlugins(flags,*configFile)
+    if !ok || !reportErrors(simulator.ValidateConfig(config)) {
+        return 1
+    }
+
+    fmt.Println("config is valid")
+    return 0
+}
+
+/*
+    Registered factories by kind. Components read their settings from the
+    config section named after them, so the keys and defaults of that section
+    are listed with each of them. Sections that do not belong to a registered
+    component (simulation, measurements, logger, ...) are listed at the end.
+    The factories of the plugins in the config are included.
+*/
+func list(args []string) int {
+    flags, configFile := newConfigFlagSet(COMMAND_LIST)
+    flags.Parse(args)
+
+    if _, ok := loadConfigWithPlugins(flags,*configFile); !ok {
+        return 1
+    }
+
+    sections := configSections()
+    listed := make(map[string]bool)
+
+    for _, kind := range core.REGISTRY_KINDS {
+        fmt.Println(kind)
+        for _, name := range core.GetRegistryKeys(kind) {
+            fmt.Printf("    %s\n",name)
+            printSection(sections[name],"        ")
+            listed[name] = true
+        }
+    }
+
+    fmt.Println("other config sections")
+    for _, section := range sortedSections(sections) {
+        if !listed[section] {
+            fmt.Printf("    [%s]\n",section)
+            printSection(sections[section],"        ")
+        }
+    }
+
+    return 0
+}
+
+func dumpConfig(args []string) int {
+    flags, configFile := newConfigFlagSet(COMMAND_DUMP_CONFIG)
+    flags.Parse(args)
+
+    config, ok := loadConfigWithPlugins(flags,*configFile)
+    if !ok {
+        return 1
+    }
+
+    if err := config.WriteTOML(os.Stdout); err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        return 1
+    }
+
+    return 0
+}
+
+// ==== functions ====
+
+// flag set of a command with the --config flag
+func newConfigFlagSet(command string) (*flag.FlagSet,*string) {
+    flags := flag.NewFlagSet(command,flag.ExitOnError)
+    configFile := flags.String("config",utils.DEFAULT_CONFIG_FILE,"configuration file (the defaults are used if the default file does not exist)")
+
+    return flags, configFile
+}
+
+// read the config file (a missing default file is not an error: the defaults are used)
+func loadConfig(flags *flag.FlagSet,path string) (*utils.SimulationConfig,bool) {
+    explicit := false
+    flags.Visit(func(f *flag.Flag) {
+        explicit = explicit || f.Name == "config"
+    })
+
+    if !explicit {
+        if _, err := os.Stat(path); errors.Is(err,fs.ErrNotExist) {
+            return utils.NewSimulationConfig(), true
+        }
+    }
+
+    config, err := utils.NewSimulationConfigFromFile(path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr,"cannot read config %s: %v\n",path,err)
+        return nil, false
+    }
+
+    return config, true
+}
+
+// read the config and open its plugins, so that their factories and defaults are registered
+func loadConfigWithPlugins(flags *flag.FlagSet,path string) (*utils.SimulationConfig,bool) {
+    config, ok := loadConfig(flags,path)
+    if !ok {
+        return nil, false
+    }
+
+    if err := simulator.LoadPlugins(config); err != nil {
+        fmt.Fprintln(os.Stderr,err)
+        return nil, false
+    }
+
+    return config, true
+}
+
+// print the problems found by a validation (one per line), returns true if there are none
+func reportErrors(err error) bool {
+    if err != nil {
+        fmt.Fprintln(os.Stderr,err)
+    }
+
+    return err == nil
+}
+
+// registered defaults grouped by section (key -> value)
+func configSections() map[string]map[string]interface{} {
+    sections := make(map[string]map[string]interface{})
+    for key, value := range utils.ConfigGetDefaults() {
+        section, name, _ := strings.Cut(key,".")
+        if sections[section] == nil {
+            sections[section] = make(map[string]interface{})
+        }
+        sections[section][name] = value
+    }
+
+    return sections
+}
+
+func sortedSections(sections map[string]map[string]interface{}) []string {
+    names := make([]string,0,len(sections))
+    for name := range sections {
+        names = append(names,name)
+    }
+    sort.Strings(names)
+
+    return names
+}
+
+// keys of a section with their defaults, sorted
+func printSection(section map[string]interface{},indent string) {
+    keys := make([]string,0,len(section))
+    for key := range section {
+        keys = append(keys,key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        fmt.Printf("%s%s = %s\n",indent,key,formatDefault(section[key]))
+    }
+}
+
+// default value in TOML-like notation (no value for defaults that are not set)
+func formatDefault(value interface{}) string {
+    if value == nil {
+        return "(not set)"
+    }
+
+    data, err := json.Marshal(value)
+    if err != nil {
+        return fmt.Sprint(value)
+    }
+
+    return string(data)
+}
@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // ==== interfaces ====
 
 // interface for an application running on a node
@@ -19,9 +23,11 @@ func RegisterApplication(key string, factory func() IApplication) {
     applicationRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewApplicationFromRegistry(key string) IApplication {
-    if factory, ok := applicationRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := applicationRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil
@@ -0,0 +1,434 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "bytes"
+    "encoding/gob"
+    "fmt"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+const (
+    CHECKPOINT_VERSION                          = 1
+    CHECKPOINT_TIME_PLACEHOLDER                 = "{time}"  // replaced by the simulation time in checkpoint paths
+
+    CHECKPOINT_ID_SIMULATION                    = "simulation"
+    CHECKPOINT_ID_GLOBAL_NETWORK                = "global_network"
+    CHECKPOINT_ID_GLOBAL_STATE                  = "global_state"
+    CHECKPOINT_ID_MEASUREMENTS                  = "measurements"
+    CHECKPOINT_ID_END_CONDITION                 = "end_condition"
+)
+
+// ==== interfaces ====
+
+/*
+    Components opt in to checkpoints by implementing this interface. The same
+    applies to the global state and measurement modules. Snapshot is called
+    between events, and Restore is called when resuming, after the component
+    was initialized (see Simulation.Run) and the event queue was restored.
+    Components that do not implement it are resumed with the state they have
+    right after initialization. Handles to timers created when initialized
+    remain valid; components keeping other timers save their ids
+    (ITimer.GetID) and get them back with ISimulation.GetTimer in Restore.
+    EncodeSnapshot and DecodeSnapshot are helpers based on encoding/gob.
+*/
+type ISnapshotable interface {
+    Snapshot() ([]byte,error)                   // serialize the state of the component
+    Restore(data []byte) error                  // restore the state from a snapshot
+}
+
+// ==== concrete structures ====
+
+/*
+    Reference to a component in a checkpoint. Components are identified by
+    their place in the simulation: "simulation", "global_network",
+    "global_state", "measurements", "measurements/<module>", "end_condition",
+    "node/<id>", "node/<id>/network", "node/<id>/behavior", and
+    "node/<id>/application/<i>".
+*/
+type ComponentRef struct {
+    ID string
+}
+
+// pending event in a checkpoint
+type EventCheckpoint struct {
+    Type uint16
+    Time float64
+    ID uint64
+    Destination string                          // component id
+    Data []byte                                 // gob-encoded data (see encodeEventData)
+    Period float64                              // recurring events (see utils.ITimer)
+    Jitter float64
+    TimerID uint64                              // timer of the event (0 for plain events)
+}
+
+/*
+    Checkpoint of a running simulation: event queue, state of the random
+    number generator, and snapshots of the components that opt in
+    (ISnapshotable). Event data and values in snapshots are encoded with
+    encoding/gob, so their concrete types must be registered with gob.Register.
+    Components referenced in event data (directly or in a []interface{}) are
+    replaced by a ComponentRef. Pointers shared by different events are not
+    preserved, i.e. each event gets its own copy of the data when resuming.
+*/
+type SimulationCheckpoint struct {
+    Version uint16
+    Name string
+    Time float64
+    LastActivity float64
+    NextID uint64
+    NextTimerID uint64
+    RNGState utils.RandomState
+    Events []EventCheckpoint
+    Components map[string][]byte
+}
+
+// wrapper for event data, so nil and interface values can be encoded
+type eventData struct {
+    Value interface{}
+}
+
+// maps between component ids and components
+type checkpointComponents struct {
+    byID map[string]interface{}
+    ids map[interface{}]string
+}
+
+// ==== factories ====
+
+func init() {
+    gob.Register(ComponentRef{})
+    gob.Register([]interface{}{})
+    gob.Register(&DefaultMessage{})
+    gob.Register(&DefaultDelivery{})
+    gob.Register(&MessageFault{})
+    gob.Register(&NetworkPartition{})
+}
+
+// build the component maps of a simulation
+func newCheckpointComponents(sim *Simulation) *checkpointComponents {
+    comps := &checkpointComponents{
+        byID:   make(map[string]interface{}),
+        ids:    make(map[interface{}]string),
+    }
+
+    comps.add(CHECKPOINT_ID_SIMULATION,sim)
+    comps.add(CHECKPOINT_ID_GLOBAL_NETWORK,sim.network)
+    comps.add(CHECKPOINT_ID_GLOBAL_STATE,sim.state)
+    comps.add(CHECKPOINT_ID_MEASUREMENTS,sim.measurements)
+    comps.add(CHECKPOINT_ID_END_CONDITION,sim.endCondition)
+    for _, module := range sim.measurements.GetModules() {
+        comps.add(CHECKPOINT_ID_MEASUREMENTS + "/" + module.GetName(),module)
+    }
+
+    sim.nodeMapLock.RLock()
+    defer sim.nodeMapLock.RUnlock()
+
+    for id, node := range sim.nodeMap {
+        prefix := fmt.Sprintf("node/%d",id)
+        comps.add(prefix,node)
+        comps.add(prefix + "/network",node.GetNodeNetwork())
+        comps.add(prefix + "/behavior",node.GetBehavior())
+        for i, app := range node.GetApplications() {
+            comps.add(fmt.Sprintf("%s/application/%d",prefix,i),app)
+        }
+    }
+
+    return comps
+}
+
+// ==== methods ====
+
+func (comps *checkpointComponents) add(id string,comp interface{}) {
+    if isNil(comp) {
+        return
+    }
+
+    comps.byID[id] = comp
+    if reflect.TypeOf(comp).Comparable() {
+        comps.ids[comp] = id
+    }
+}
+
+// id of a component (false if it is not part of the simulation)
+func (comps *checkpointComponents) getID(comp interface{}) (string,bool) {
+    if isNil(comp) || !reflect.TypeOf(comp).Comparable() {
+        return "", false
+    }
+
+    id, ok := comps.ids[comp]
+    return id, ok
+}
+
+// replace references to components by ComponentRef
+func (comps *checkpointComponents) encodeValue(value interface{}) interface{} {
+    if list, ok := value.([]interface{}); ok {
+        encoded := make([]interface{},len(list))
+        for i, v := range list {
+            encoded[i] = comps.encodeValue(v)
+        }
+        return encoded
+    }
+
+    if id, ok := comps.getID(value); ok {
+        return ComponentRef{ID: id}
+    }
+
+    return value
+}
+
+// replace ComponentRef by the components
+func (comps *checkpointComponents) decodeValue(value interface{}) (interface{},error) {
+    switch v := value.(type) {
+    case []interface{}:
+        decoded := make([]interface{},len(v))
+        for i, elem := range v {
+            d, err := comps.decodeValue(elem)
+            if err != nil {
+                return nil, err
+            }
+            decoded[i] = d
+        }
+        return decoded, nil
+    case ComponentRef:
+        if comp, ok := comps.byID[v.ID]; ok {
+            return comp, nil
+        }
+        return nil, fmt.Errorf("component %s not found",v.ID)
+    }
+
+    return value, nil
+}
+
+// build a checkpoint of the simulation: it must be called between events
+func (sim *Simulation) buildCheckpoint() (*SimulationCheckpoint,error) {
+    comps := newCheckpointComponents(sim)
+    queue := sim.evSimulation.GetQueueSnapshot()
+
+    checkpoint := &SimulationCheckpoint{
+        Version:        CHECKPOINT_VERSION,
+        Name:           sim.GetName(),
+        Time:           queue.Time,
+        LastActivity:   queue.LastActivity,
+        NextID:         queue.NextID,
+        NextTimerID:    queue.NextTimerID,
+        RNGState:       sim.rngSource.GetState(),
+        Events:         make([]EventCheckpoint,0,len(queue.Events)),
+        Components:     make(map[string][]byte),
+    }
+
+    // event queue (checkpoint requests are not saved)
+    for _, scheduled := range queue.Events {
+        event := scheduled.Event
+        if event.GetType() == SIMULATION_EVENT_CHECKPOINT {
+            continue
+        }
+
+        dest, ok := comps.getID(event.GetDestination())
+        if !ok && !isNil(event.GetDestination()) {
+            return nil, fmt.Errorf("event %d at %v: destination is not a component of the simulation",event.GetType(),scheduled.Time)
+        }
+
+        data, err := EncodeSnapshot(eventData{Value: comps.encodeValue(event.GetData())})
+        if err != nil {
+            return nil, fmt.Errorf("event %d at %v: cannot encode data: %v",event.GetType(),scheduled.Time,err)
+        }
+
+        checkpoint.Events = append(checkpoint.Events,EventCheckpoint{
+            Type:           event.GetType(),
+            Time:           scheduled.Time,
+            ID:             scheduled.ID,
+            Destination:    dest,
+            Data:           data,
+            Period:         scheduled.Period,
+            Jitter:         scheduled.Jitter,
+            TimerID:        scheduled.TimerID,
+        })
+    }
+
+    // components
+    for id, comp := range comps.byID {
+        if snapshotable, ok := comp.(ISnapshotable); ok {
+            data, err := snapshotable.Snapshot()
+            if err != nil {
+                return nil, fmt.Errorf("cannot take snapshot of %s: %v",id,err)
+            }
+            checkpoint.Components[id] = data
+        }
+    }
+
+    return checkpoint, nil
+}
+
+// write a checkpoint of the simulation to the given file: it must be called between events
+func (sim *Simulation) Checkpoint(path string) error {
+    checkpoint, err := sim.buildCheckpoint()
+    if err != nil {
+        return err
+    }
+
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    err = gob.NewEncoder(file).Encode(checkpoint)
+    if err != nil {
+        return err
+    }
+
+    sim.logger.Info("checkpoint of simulation %s at %v written to %s (%d events, %d components)",sim.GetName(),checkpoint.Time,path,len(checkpoint.Events),len(checkpoint.Components))
+    return nil
+}
+
+// read a checkpoint from the given file
+func ReadCheckpoint(path string) (*SimulationCheckpoint,error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    checkpoint := &SimulationCheckpoint{}
+    err = gob.NewDecoder(file).Decode(checkpoint)
+    if err != nil {
+        return nil, err
+    }
+
+    if checkpoint.Version != CHECKPOINT_VERSION {
+        return nil, fmt.Errorf("checkpoint version %d not supported",checkpoint.Version)
+    }
+
+    return checkpoint, nil
+}
+
+/*
+    Replace the event queue, the state of the random number generator, and
+    the state of the components with the ones in the checkpoint. The
+    simulation must have the same components (same configuration), already
+    initialized.
+*/
+func (sim *Simulation) restoreCheckpoint(checkpoint *SimulationCheckpoint) error {
+    comps := newCheckpointComponents(sim)
+
+    // event queue
+    queue := utils.EventQueueSnapshot{
+        Time:           checkpoint.Time,
+        LastActivity:   checkpoint.LastActivity,
+        NextID:         checkpoint.NextID,
+        NextTimerID:    checkpoint.NextTimerID,
+        Events:         make([]utils.ScheduledEvent,0,len(checkpoint.Events)),
+    }
+
+    for _, evCheckpoint := range checkpoint.Events {
+        var dest utils.IEventDestination = nil
+        if evCheckpoint.Destination != "" {
+            comp, ok := comps.byID[evCheckpoint.Destination]
+            if !ok {
+                return fmt.Errorf("event %d at %v: component %s not found",evCheckpoint.Type,evCheckpoint.Time,evCheckpoint.Destination)
+            }
+
+            dest, ok = comp.(utils.IEventDestination)
+            if !ok {
+                return fmt.Errorf("event %d at %v: component %s is not an event destination",evCheckpoint.Type,evCheckpoint.Time,evCheckpoint.Destination)
+            }
+        }
+
+        wrapper := eventData{}
+        if err := DecodeSnapshot(evCheckpoint.Data,&wrapper); err != nil {
+            return fmt.Errorf("event %d at %v: cannot decode data: %v",evCheckpoint.Type,evCheckpoint.Time,err)
+        }
+
+        data, err := comps.decodeValue(wrapper.Value)
+        if err != nil {
+            return fmt.Errorf("event %d at %v: %v",evCheckpoint.Type,evCheckpoint.Time,err)
+        }
+
+        queue.Events = append(queue.Events,utils.ScheduledEvent{
+            Event:  utils.NewEvent(evCheckpoint.Type,data,dest),
+            Time:   evCheckpoint.Time,
+            ID:     evCheckpoint.ID,
+            Period: evCheckpoint.Period,
+            Jitter: evCheckpoint.Jitter,
+            TimerID: evCheckpoint.TimerID,
+        })
+    }
+
+    // the queue is replaced first, so components can get their timers back when restored (see utils.EventSimulation.GetTimer)
+    sim.evSimulation.SetQueueSnapshot(queue)
+    sim.rngSource.SetState(checkpoint.RNGState)
+
+    // components
+    for id, data := range checkpoint.Components {
+        comp, ok := comps.byID[id]
+
+        // the end condition may differ from the one of the checkpoint: it starts over if it cannot be restored
+        if id == CHECKPOINT_ID_END_CONDITION {
+            snapshotable, ok := comp.(ISnapshotable)
+            if !ok {
+                sim.logger.Warn("end condition does not match the checkpoint, its state is not restored")
+            } else if err := snapshotable.Restore(data); err != nil {
+                sim.logger.Warn("end condition does not match the checkpoint, its state is not restored: %v",err)
+            }
+            continue
+        }
+
+        if !ok {
+            return fmt.Errorf("component %s not found",id)
+        }
+
+        snapshotable, ok := comp.(ISnapshotable)
+        if !ok {
+            return fmt.Errorf("component %s does not support snapshots",id)
+        }
+
+        if err := snapshotable.Restore(data); err != nil {
+            return fmt.Errorf("cannot restore %s: %v",id,err)
+        }
+    }
+
+    sim.logger.Info("simulation %s resumed from checkpoint at %v (%d events, %d components)",sim.GetName(),checkpoint.Time,len(checkpoint.Events),len(checkpoint.Components))
+    return nil
+}
+
+// ==== functions ====
+
+// gob-encode a value (helper for ISnapshotable)
+func EncodeSnapshot(value interface{}) ([]byte,error) {
+    buffer := bytes.Buffer{}
+    err := gob.NewEncoder(&buffer).Encode(value)
+    if err != nil {
+        return nil, err
+    }
+
+    return buffer.Bytes(), nil
+}
+
+// gob-decode a value encoded with EncodeSnapshot (helper for ISnapshotable)
+func DecodeSnapshot(data []byte,value interface{}) error {
+    return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// checkpoint path for the given time: replaces CHECKPOINT_TIME_PLACEHOLDER
+func CheckpointPath(pattern string,time float64) string {
+    return strings.ReplaceAll(pattern,CHECKPOINT_TIME_PLACEHOLDER,strconv.FormatFloat(time,'f',-1,64))
+}
+
+// check if an interface holds nil (including typed nil pointers)
+func isNil(value interface{}) bool {
+    if value == nil {
+        return true
+    }
+
+    v := reflect.ValueOf(value)
+    switch v.Kind() {
+    case reflect.Ptr,reflect.Map,reflect.Slice,reflect.Interface,reflect.Func,reflect.Chan:
+        return v.IsNil()
+    }
+
+    return false
+}
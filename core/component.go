@@ -17,17 +17,35 @@ type ISimulationComponent interface {
 
     // syntax sugar
     ScheduleEvent(event utils.IEvent,delay float64)
+    ScheduleTimer(event utils.IEvent,delay float64) utils.ITimer
+    SchedulePeriodic(event utils.IEvent,delay float64,period float64,jitter float64) utils.ITimer
+    Tag(id uint64,extra string)
     GetSimulation() ISimulation
+    GetConfig() *utils.SimulationConfig
+    GetLogger(tag string) utils.ISimulationLogger
     GetTime() float64
     GetName() string
 }
 
+/*
+    Components created from a registry as "<name>@<instance>" get the
+    instance, and read their settings from the instance section of the config
+    ("<name>.<instance>", see utils.ConfigSection) instead of "<name>". This
+    lets groups of nodes use the same implementation of a layer with different
+    parameters. Implemented by DefaultComponent.
+*/
+type IConfigInstance interface {
+    SetConfigInstance(instance string)
+    GetConfigInstance() string
+}
+
 // ==== concrete sctructures ====
 
 // Default component implementation. Most components should just incorporate it.
 type DefaultComponent struct {
     sim ISimulation
     initialized bool
+    configInstance string                       // instance section of the config (see IConfigInstance)
 }
 
 // ==== methods ====
@@ -36,6 +54,21 @@ func (comp *DefaultComponent) ScheduleEvent(event utils.IEvent,delay float64) {
     comp.GetSimulation().ScheduleEvent(event,delay)
 }
 
+// schedule an event and return a handle to cancel or reset it (e.g., protocol timeouts)
+func (comp *DefaultComponent) ScheduleTimer(event utils.IEvent,delay float64) utils.ITimer {
+    return comp.GetSimulation().ScheduleTimer(event,delay)
+}
+
+// schedule an event after delay that recurs every period, plus or minus a uniform jitter, until cancelled
+func (comp *DefaultComponent) SchedulePeriodic(event utils.IEvent,delay float64,period float64,jitter float64) utils.ITimer {
+    return comp.GetSimulation().SchedulePeriodic(event,delay,period,jitter)
+}
+
+// timestamp a user-defined tag (see SimulationMeasurements)
+func (comp *DefaultComponent) Tag(id uint64,extra string) {
+    comp.GetSimulation().GetMeasurements().Tag(id,extra)
+}
+
 func (comp *DefaultComponent) Init(sim ISimulation,components ...ISimulationComponent) {
     comp.sim = sim
     comp.initialized = true
@@ -45,6 +78,11 @@ func (comp *DefaultComponent) Finish() {
     comp.initialized = false
 }
 
+// implements IConfigInstance (set by the registries, before Init)
+func (comp *DefaultComponent) SetConfigInstance(instance string) {
+    comp.configInstance = instance
+}
+
 func (comp *DefaultComponent) HandleEvent(event utils.IEvent) bool {
     switch event.GetType() {
     case GLOBAL_NETWORK_EVENT_INIT:
@@ -72,6 +110,32 @@ func (comp *DefaultComponent) GetSimulation() ISimulation {
     return comp.sim
 }
 
+// configuration of the simulation of the component
+func (comp *DefaultComponent) GetConfig() *utils.SimulationConfig {
+    return comp.GetSimulation().GetConfig()
+}
+
+/*
+    Section of the config of the component: name is the section of the
+    implementation (usually its tag), the instance it was created with is
+    applied (see IConfigInstance). Components with a config should read it from
+    here, e.g. GetConfigSection(TAG).GetString("key") rather than
+    GetConfig().GetString(TAG + ".key").
+*/
+func (comp *DefaultComponent) GetConfigSection(name string) *utils.ConfigSection {
+    return utils.NewConfigSection(comp.GetConfig(),name,comp.configInstance)
+}
+
+// instance the component was created with (empty if none)
+func (comp *DefaultComponent) GetConfigInstance() string {
+    return comp.configInstance
+}
+
+// logger of the simulation of the component for the given tag
+func (comp *DefaultComponent) GetLogger(tag string) utils.ISimulationLogger {
+    return comp.GetSimulation().GetLogger(tag)
+}
+
 func (comp *DefaultComponent) GetTime() float64 {
     return comp.GetSimulation().GetTime()
 }
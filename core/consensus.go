@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // TODO consensus layer
 
 // ==== interfaces ====
@@ -21,9 +25,11 @@ func RegisterConsensusProtocol(key string, factory func() IConsensusProtocol) {
     consensusRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewConsensusProtocolFromRegistry(key string) IConsensusProtocol {
-    if factory, ok := consensusRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := consensusRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil 
@@ -0,0 +1,456 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+const (
+    DEBUGGER_TAG                                = "debugger"
+    DEFAULT_DEBUGGER_ENABLED                    = false
+    DEFAULT_DEBUGGER_BREAK_ON_START             = true
+    DEFAULT_DEBUGGER_QUEUE_LENGTH               = 10
+    DEBUGGER_INSPECT_MAX_LENGTH                 = 16        // longer collections are shown only by their length
+
+    BREAKPOINT_TYPE                             = "type"
+    BREAKPOINT_NODE                             = "node"
+    BREAKPOINT_TIME                             = "time"
+    BREAKPOINT_STATE                            = "state"
+)
+
+// ==== interfaces ====
+
+// components can implement this interface to control what the debugger shows about their state
+type IInspectable interface {
+    Inspect() string
+}
+
+// ==== concrete structures ====
+
+/*
+    Breakpoint of the debugger: pauses before an event of the given type, an
+    event whose destination belongs to the given node, the first event at or
+    after the given time, or when the state predicate becomes true (see
+    StatePredicate).
+*/
+type Breakpoint struct {
+    Kind string
+    Type uint16
+    Node uint32
+    Time float64
+    Predicate *StatePredicate
+
+    hit bool                                    // time: already reached, state: predicate was true before
+}
+
+/*
+    Interactive step debugger. Before each step of the simulation, it checks
+    the breakpoints against the next event and, when one is hit, pauses and
+    reads commands from the input (a terminal REPL by default). While paused,
+    it is possible to inspect the pending events, nodes, and the global state,
+    and to step one event at a time or continue until the next breakpoint. With
+    the parallel engine, each step is a batch of events.
+*/
+type SimulationDebugger struct {
+    sim *Simulation
+    in *bufio.Scanner
+    out io.Writer
+
+    breakpoints []*Breakpoint
+    stepping bool
+    stepsLeft uint64
+    disabled bool
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(DEBUGGER_TAG + ".enabled",DEFAULT_DEBUGGER_ENABLED)
+    utils.ConfigSetDefault(DEBUGGER_TAG + ".break_on_start",DEFAULT_DEBUGGER_BREAK_ON_START)
+    utils.ConfigSetDefault(DEBUGGER_TAG + ".breakpoints",[]string{})
+}
+
+// debugger reading commands from in and writing to out
+func NewSimulationDebugger(in io.Reader,out io.Writer) *SimulationDebugger {
+    return &SimulationDebugger{
+        sim:            nil,
+        in:             bufio.NewScanner(in),
+        out:            out,
+        breakpoints:    make([]*Breakpoint,0,4),
+        stepping:       false,
+        stepsLeft:      0,
+        disabled:       false,
+    }
+}
+
+/*
+    Debugger according to configuration (nil if disabled), using the terminal.
+    Breakpoints are given as "<kind> <arg>", as in the break command.
+*/
+func NewSimulationDebuggerFromConfig(config *utils.SimulationConfig) (*SimulationDebugger,error) {
+    if !config.GetBool(DEBUGGER_TAG + ".enabled") {
+        return nil, nil
+    }
+
+    debugger := NewSimulationDebugger(os.Stdin,os.Stdout)
+    debugger.stepping = config.GetBool(DEBUGGER_TAG + ".break_on_start")
+    for _, str := range config.GetStringSlice(DEBUGGER_TAG + ".breakpoints") {
+        kind, arg, _ := strings.Cut(strings.TrimSpace(str)," ")
+        bp, err := ParseBreakpoint(kind,arg)
+        if err != nil {
+            return nil, utils.NewConfigError(DEBUGGER_TAG + ".breakpoints","%v",err)
+        }
+        debugger.AddBreakpoint(bp)
+    }
+
+    return debugger, nil
+}
+
+// parse a breakpoint of the given kind (type, node, time, or state)
+func ParseBreakpoint(kind string,arg string) (*Breakpoint,error) {
+    arg = strings.TrimSpace(arg)
+    bp := &Breakpoint{Kind: kind}
+
+    switch kind {
+    case BREAKPOINT_TYPE:
+        tp, err := strconv.ParseUint(arg,10,16)
+        if err != nil {
+            return nil, fmt.Errorf("invalid event type %q",arg)
+        }
+        bp.Type = uint16(tp)
+    case BREAKPOINT_NODE:
+        id, err := strconv.ParseUint(arg,10,32)
+        if err != nil {
+            return nil, fmt.Errorf("invalid node id %q",arg)
+        }
+        bp.Node = uint32(id)
+    case BREAKPOINT_TIME:
+        t, err := strconv.ParseFloat(arg,64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid time %q",arg)
+        }
+        bp.Time = t
+    case BREAKPOINT_STATE:
+        predicate, err := ParseStatePredicate(arg)
+        if err != nil {
+            return nil, err
+        }
+        bp.Predicate = predicate
+    default:
+        return nil, fmt.Errorf("unknown breakpoint kind %q (type, node, time, or state)",kind)
+    }
+
+    return bp, nil
+}
+
+// ==== methods ====
+
+func (debugger *SimulationDebugger) Init(sim *Simulation) {
+    debugger.sim = sim
+}
+
+func (debugger *SimulationDebugger) AddBreakpoint(bp *Breakpoint) *SimulationDebugger {
+    debugger.breakpoints = append(debugger.breakpoints,bp)
+    return debugger
+}
+
+// called by the simulation before each step: pauses if a breakpoint is hit
+func (debugger *SimulationDebugger) BeforeStep() {
+    if debugger.disabled {
+        return
+    }
+
+    event, time, ok := debugger.sim.evSimulation.Peek()
+    if !ok {
+        return
+    }
+
+    reason := ""
+    if debugger.stepping {
+        if debugger.stepsLeft == 0 {
+            reason = "step"
+        } else {
+            debugger.stepsLeft--
+        }
+    }
+
+    // check all breakpoints, so time and state breakpoints are updated
+    for i, bp := range debugger.breakpoints {
+        if bp.check(event,time,debugger.sim.GetGlobalState()) && reason == "" {
+            reason = fmt.Sprintf("breakpoint %d (%v)",i,bp)
+        }
+    }
+
+    if reason != "" {
+        debugger.pause(reason)
+    }
+}
+
+// check if the breakpoint is hit by the next event
+func (bp *Breakpoint) check(event utils.IEvent,time float64,state ISimulationGlobalState) bool {
+    switch bp.Kind {
+    case BREAKPOINT_TYPE:
+        return event.GetType() == bp.Type
+    case BREAKPOINT_NODE:
+        nodeID, ok := destinationNodeID(event.GetDestination())
+        return ok && nodeID == bp.Node
+    case BREAKPOINT_TIME:
+        if !bp.hit && time >= bp.Time {
+            bp.hit = true
+            return true
+        }
+    case BREAKPOINT_STATE:
+        value := bp.Predicate.Eval(state)
+        hit := value && !bp.hit
+        bp.hit = value
+        return hit
+    }
+
+    return false
+}
+
+func (bp *Breakpoint) String() string {
+    switch bp.Kind {
+    case BREAKPOINT_TYPE:
+        return fmt.Sprintf("type %d",bp.Type)
+    case BREAKPOINT_NODE:
+        return fmt.Sprintf("node %d",bp.Node)
+    case BREAKPOINT_TIME:
+        return fmt.Sprintf("time %v",bp.Time)
+    case BREAKPOINT_STATE:
+        return fmt.Sprintf("state %v",bp.Predicate)
+    }
+
+    return bp.Kind
+}
+
+// REPL: read commands until the simulation should go on
+func (debugger *SimulationDebugger) pause(reason string) {
+    debugger.printf("paused at time %v: %s\n",debugger.sim.GetTime(),reason)
+    debugger.printQueue(1)
+
+    for {
+        debugger.printf("(debug) ")
+        if !debugger.in.Scan() {
+            debugger.printf("\ninput closed: debugger disabled\n")
+            debugger.disabled = true
+            return
+        }
+
+        fields := strings.Fields(debugger.in.Text())
+        if len(fields) == 0 {
+            continue
+        }
+
+        if debugger.command(fields[0],fields[1:]) {
+            return
+        }
+    }
+}
+
+// run a command: returns true if the simulation should go on
+func (debugger *SimulationDebugger) command(cmd string,args []string) bool {
+    switch cmd {
+    case "help","h":
+        debugger.printf("%s",DEBUGGER_HELP)
+    case "step","s":
+        n := uint64(1)
+        if len(args) > 0 {
+            if v, err := strconv.ParseUint(args[0],10,64); err == nil && v > 0 {
+                n = v
+            }
+        }
+        debugger.stepping = true
+        debugger.stepsLeft = n - 1
+        return true
+    case "continue","c":
+        debugger.stepping = false
+        return true
+    case "quit","q":
+        debugger.disabled = true
+        debugger.sim.halt()
+        return true
+    case "time","t":
+        debugger.printf("%v\n",debugger.sim.GetTime())
+    case "queue":
+        n := DEFAULT_DEBUGGER_QUEUE_LENGTH
+        if len(args) > 0 {
+            if v, err := strconv.Atoi(args[0]); err == nil {
+                n = v
+            }
+        }
+        debugger.printQueue(n)
+    case "node":
+        if len(args) < 1 {
+            debugger.printf("usage: node <id>\n")
+            break
+        }
+        id, err := strconv.ParseUint(args[0],10,32)
+        if err != nil {
+            debugger.printf("invalid node id %q\n",args[0])
+            break
+        }
+        debugger.printNode(uint32(id))
+    case "state":
+        debugger.printState(args)
+    case "break","b":
+        if len(args) < 2 {
+            debugger.printf("usage: break <type|node|time|state> <arg>\n")
+            break
+        }
+        bp, err := ParseBreakpoint(args[0],strings.Join(args[1:]," "))
+        if err != nil {
+            debugger.printf("%v\n",err)
+            break
+        }
+        debugger.AddBreakpoint(bp)
+        debugger.printf("breakpoint %d: %v\n",len(debugger.breakpoints) - 1,bp)
+    case "breaks":
+        for i, bp := range debugger.breakpoints {
+            debugger.printf("%d: %v\n",i,bp)
+        }
+    case "delete","d":
+        if len(args) < 1 {
+            debugger.printf("usage: delete <breakpoint>\n")
+            break
+        }
+        i, err := strconv.Atoi(args[0])
+        if err != nil || i < 0 || i >= len(debugger.breakpoints) {
+            debugger.printf("invalid breakpoint %q\n",args[0])
+            break
+        }
+        debugger.breakpoints = append(debugger.breakpoints[:i],debugger.breakpoints[i+1:]...)
+    default:
+        debugger.printf("unknown command %q (try help)\n",cmd)
+    }
+
+    return false
+}
+
+// print the next n pending events
+func (debugger *SimulationDebugger) printQueue(n int) {
+    queue := debugger.sim.evSimulation.GetQueueSnapshot()
+    debugger.printf("%d pending events\n",len(queue.Events))
+    for i, scheduled := range queue.Events {
+        if i >= n {
+            break
+        }
+
+        event := scheduled.Event
+        debugger.printf("  [%d] time=%v type=%d dest=%s data=%T\n",scheduled.ID,scheduled.Time,event.GetType(),describeDestination(event.GetDestination()),event.GetData())
+    }
+}
+
+// print a node and the state of its layers
+func (debugger *SimulationDebugger) printNode(id uint32) {
+    node := debugger.sim.GetNode(id)
+    if node == nil {
+        debugger.printf("node %d does not exist\n",id)
+        return
+    }
+
+    debugger.printf("node %d: %s (type %d)\n",id,node.GetName(),node.GetType())
+    if nnet := node.GetNodeNetwork(); nnet != nil {
+        debugger.printf("  network: %s, connected=%v, neighbors=%v\n",nnet.GetName(),nnet.GetGlobalNetwork() != nil && nnet.IsConnected(),nnet.GetNeighbors())
+        debugger.printf("    %s\n",inspect(nnet))
+    }
+    if behavior := node.GetBehavior(); behavior != nil {
+        debugger.printf("  behavior: %s\n    %s\n",behavior.GetName(),inspect(behavior))
+    }
+    for i, app := range node.GetApplications() {
+        debugger.printf("  application %d: %s\n    %s\n",i,app.GetName(),inspect(app))
+    }
+}
+
+// print the global state: number of blocks, or the value of the given keys
+func (debugger *SimulationDebugger) printState(keys []string) {
+    state := debugger.sim.GetGlobalState()
+    if state == nil {
+        debugger.printf("no global state\n")
+        return
+    }
+
+    if len(keys) == 0 {
+        debugger.printf("%d blocks registered\n",state.GetNumBlocks())
+        return
+    }
+
+    for _, key := range keys {
+        debugger.printf("%s = %v\n",key,state.Get(key))
+    }
+}
+
+func (debugger *SimulationDebugger) printf(format string,args ...interface{}) {
+    fmt.Fprintf(debugger.out,format,args...)
+}
+
+/*
+    State of a component: the result of Inspect (see IInspectable), or its
+    fields, omitting embedded structures and locks. References are shown only
+    by their type, and long collections only by their length.
+*/
+func inspect(comp interface{}) string {
+    if inspectable, ok := comp.(IInspectable); ok {
+        return inspectable.Inspect()
+    }
+
+    value := reflect.Indirect(reflect.ValueOf(comp))
+    if value.Kind() != reflect.Struct {
+        return fmt.Sprintf("%v",comp)
+    }
+
+    fields := make([]string,0,value.NumField())
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+        if field.Anonymous || field.Type.PkgPath() == "sync" {
+            continue
+        }
+
+        fieldValue := value.Field(i)
+        var str string
+        switch fieldValue.Kind() {
+        case reflect.Ptr,reflect.Interface,reflect.Func,reflect.Chan:
+            if fieldValue.IsNil() {
+                str = "nil"
+            } else if fieldValue.Kind() == reflect.Interface {
+                str = "<" + fieldValue.Elem().Type().String() + ">"
+            } else {
+                str = "<" + fieldValue.Type().String() + ">"
+            }
+        case reflect.Map,reflect.Slice:
+            if fieldValue.Len() > DEBUGGER_INSPECT_MAX_LENGTH {
+                str = fmt.Sprintf("<%s len=%d>",fieldValue.Type(),fieldValue.Len())
+            } else {
+                str = fmt.Sprintf("%v",fieldValue)
+            }
+        default:
+            str = fmt.Sprintf("%v",fieldValue)
+        }
+
+        fields = append(fields,field.Name + "=" + str)
+    }
+
+    return strings.Join(fields," ")
+}
+
+const DEBUGGER_HELP = `commands:
+  step|s [n]                        handle the next n events (default 1) and pause
+  continue|c                        run until the next breakpoint
+  quit|q                            stop the simulation now (pending events are not handled)
+  time|t                            current simulation time
+  queue [n]                         next n pending events (default 10)
+  node <id>                         node layers, neighbors, and state
+  state [key...]                    global state (number of blocks, or values of the keys)
+  break|b <type|node|time|state> <arg>
+                                    add a breakpoint, e.g. "break type 40", "break state #blocks>=10"
+  breaks                            list breakpoints
+  delete|d <i>                      delete a breakpoint
+  help|h                            this help
+`
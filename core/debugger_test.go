@@ -0,0 +1,143 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "strings"
+    "testing"
+)
+
+const (
+    TEST_DEBUG_EVENT                            = 900
+)
+
+// ==== concrete structures ====
+
+// global network that only handles its init event
+type testGlobalNetwork struct {
+    IGlobalNetwork
+}
+
+// destination that records the times of the events it handles
+type testDestination struct {
+    handled []float64
+}
+
+// ==== factories ====
+
+/*
+    Run a simulation with events of type TEST_DEBUG_EVENT at the given times,
+    and a debugger with the given breakpoints that reads the given commands.
+    Returns the times of the events handled and the output of the debugger.
+*/
+func runTestDebugger(t *testing.T,breakpoints []string,commands string,times ...float64) ([]float64,string) {
+    t.Helper()
+
+    isim, err := NewSimulationWithConfig(utils.NewSimulationConfig())
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+    sim := isim.(*Simulation)
+    sim.SetGlobalNetwork(&testGlobalNetwork{}).SetEndCondition(NewTimeEndCondition(100))
+
+    out := &strings.Builder{}
+    sim.debugger = NewSimulationDebugger(strings.NewReader(commands),out)
+    for _, str := range breakpoints {
+        kind, arg, _ := strings.Cut(str," ")
+        bp, err := ParseBreakpoint(kind,arg)
+        if err != nil {
+            t.Fatalf("breakpoint %q: %v",str,err)
+        }
+        sim.debugger.AddBreakpoint(bp)
+    }
+
+    dest := &testDestination{}
+    for _, time := range times {
+        sim.ScheduleEvent(utils.NewEvent(TEST_DEBUG_EVENT,nil,dest),time)
+    }
+
+    if err := sim.Run(); err != nil {
+        t.Fatalf("simulation failed: %v",err)
+    }
+
+    return dest.handled, out.String()
+}
+
+// ==== methods ====
+
+func (net *testGlobalNetwork) HandleEvent(event utils.IEvent) bool { return true }
+
+func (dest *testDestination) HandleEvent(event utils.IEvent) bool {
+    dest.handled = append(dest.handled,event.GetTime())
+    return true
+}
+
+// ==== tests ====
+
+// breakpoints of each kind, or invalid
+func TestParseBreakpoint(t *testing.T) {
+    tests := []struct{
+        kind string
+        arg string
+        want string                             // String of the breakpoint ("" if invalid)
+    }{
+        {"type","40","type 40"},
+        {"type"," 7 ","type 7"},
+        {"type","block","" },
+        {"type","70000",""},
+        {"node","3","node 3"},
+        {"node","-1",""},
+        {"time","1.5","time 1.5"},
+        {"time","soon",""},
+        {"state","#blocks>=10","state #blocks>=10"},
+        {"state","phase == done","state phase==done"},
+        {"state","phase",""},
+        {"height","10",""},
+    }
+
+    for _, test := range tests {
+        bp, err := ParseBreakpoint(test.kind,test.arg)
+        if test.want == "" {
+            if err == nil {
+                t.Errorf("%s %q: got %v, want an error",test.kind,test.arg,bp)
+            }
+            continue
+        }
+
+        if err != nil {
+            t.Errorf("%s %q: %v",test.kind,test.arg,err)
+        } else if bp.String() != test.want {
+            t.Errorf("%s %q: got %v, want %s",test.kind,test.arg,bp,test.want)
+        }
+    }
+}
+
+// commands read while paused: events handled and number of pauses
+func TestDebuggerCommands(t *testing.T) {
+    tests := []struct{
+        name string
+        breakpoints []string
+        commands string
+        handled int
+        pauses int
+    }{
+        {"continue",[]string{"type 900"},"c\ncontinue\nc\n",3,3},
+        {"step",[]string{"time 1"},"step\ns\nc\n",3,3},
+        {"step n",[]string{"time 1"},"step 2\nc\n",3,2},
+        {"break",[]string{"time 1"},"break time 2.5\nc\nc\n",3,2},
+        {"delete",[]string{"type 900"},"delete 0\nc\n",3,1},
+        {"quit",[]string{"time 2"},"quit\n",2,1},
+        {"quit while stepping",[]string{"time 1"},"s\nq\n",1,2},
+        {"input closed",[]string{"type 900"},"",3,1},
+        {"inspect",[]string{"time 1"},"help\ntime\nqueue 2\nstate\nnode 1\nbreaks\nbogus\nc\n",3,1},
+    }
+
+    for _, test := range tests {
+        handled, output := runTestDebugger(t,test.breakpoints,test.commands,1,1,3)
+        if len(handled) != test.handled {
+            t.Errorf("%s: %d events handled (%v), want %d",test.name,len(handled),handled,test.handled)
+        }
+        if pauses := strings.Count(output,"paused at"); pauses != test.pauses {
+            t.Errorf("%s: %d pauses, want %d\n%s",test.name,pauses,test.pauses,output)
+        }
+    }
+}
@@ -1,13 +1,35 @@
 package core
 
 import (
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "math"
     "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    CI_END_CONDITION_TAG                        = "ci_end_condition"    // config section of CIEndCondition
+    DEFAULT_CI_CONFIDENCE                       = 0.95
+    DEFAULT_CI_BATCHES                          = 20
+    DEFAULT_CI_MIN_BATCH_SIZE                   = 10
+    DEFAULT_CI_CHECK_INTERVAL                   = 10.0                  // simulation time between evaluations
 )
 
 // ==== interfaces ====
 
-// interface for a generic end condition checker
+/*
+    Interface for a generic end condition checker. Init is called once before
+    the simulation starts (e.g., to register hooks), and Check after each step
+    of the simulation. Init cannot fail: requirements on the configuration are
+    checked before the simulation is created (see IConfigValidator). End
+    conditions with state can opt in to checkpoints (see ISnapshotable).
+*/
 type IEndCondition interface {
+    Init(sim ISimulation)
     Check(sim ISimulation) bool
 }
 
@@ -22,16 +44,146 @@ type TimeEndCondition struct {
     endTime float64
 }
 
+/*
+    End condition based on the number of events handled (including the ones
+    not handled by their destination).
+
+    Implements: IEndCondition, ISnapshotable, and utils.IEventPostTriggerHandler
+*/
+type EventsEndCondition struct {
+    maxEvents uint64
+    count uint64
+}
+
+/*
+    End condition met when a fraction of the nodes accepted a block at the
+    given height (see ISimulationGlobalState.GetBlockHeight: genesis blocks
+    have height 0). Requires a global state (never met without it).
+
+    Implements: IEndCondition, ISnapshotable, and utils.IEventPreTriggerHandler
+*/
+type HeightEndCondition struct {
+    height uint64
+    fraction float64
+    state ISimulationGlobalState
+    reached map[uint32]bool
+    lock sync.Mutex
+}
+
+/*
+    End condition based on the number of confirmed transactions: a block is
+    confirmed when a node accepts a block k-1 blocks above it (k=1 means the
+    block itself was accepted), and a transaction is confirmed when the first
+    block that includes it is. Requires a global state (never met without it).
+
+    Implements: IEndCondition, ISnapshotable, and utils.IEventPreTriggerHandler
+*/
+type TxsEndCondition struct {
+    maxTxs int
+    confirmations uint64
+    state ISimulationGlobalState
+    confirmedBlocks map[uint64]bool
+    confirmedTxs map[uint64]bool
+    lock sync.Mutex
+}
+
+/*
+    End condition based on the real (wall-clock) time since the simulation
+    started.
+
+    Implements: IEndCondition
+*/
+type WallclockEndCondition struct {
+    timeout time.Duration
+    start time.Time
+}
+
+/*
+    End condition met when no events other than timers (see
+    ISimulationComponent.ScheduleTimer and SchedulePeriodic) were handled for
+    the given period of simulation time.
+
+    Implements: IEndCondition
+*/
+type QuiescenceEndCondition struct {
+    period float64
+}
+
+/*
+    End condition over the global state (see StatePredicate).
+
+    Implements: IEndCondition
+*/
+type StateEndCondition struct {
+    predicate *StatePredicate
+}
+
+/*
+    Statistical stopping rule: met when the confidence interval of the mean of
+    a metric (see SimulationMeasurements.GetMetric) is narrower than the given
+    relative precision, i.e. half-width / |mean| <= precision. Samples of a
+    metric are usually correlated, so the interval is computed with the method
+    of batch means. The number of batches, the confidence level, the minimum
+    batch size, and how often (in simulation time) the interval is evaluated
+    are read from the config section "ci_end_condition" of the simulation when
+    it is created from the config.
+
+    Implements: IEndCondition, ISnapshotable, and IConfigValidator
+*/
+type CIEndCondition struct {
+    metric string
+    precision float64
+    confidence float64
+    batches int
+    minBatchSize int
+    checkInterval float64
+    nextCheck float64
+    fromConfig bool                             // settings are read from the config of the simulation in Init
+    logger utils.ISimulationLogger
+
+    // last estimate
+    mean float64
+    halfWidth float64
+    numSamples int
+}
+
+/*
+    Composition of end conditions: met when all of them ("and") or any of them
+    ("or") are met.
+
+    Implements: IEndCondition, ISnapshotable, and IConfigValidator
+*/
+type CompositeEndCondition struct {
+    all bool
+    conditions []IEndCondition
+}
+
 // ==== factories ====
 
-var endConditionRegistry map[string]func(arg string) IEndCondition = make(map[string]func(arg string) IEndCondition)
+var endConditionRegistry map[string]func(arg string) (IEndCondition,error) = make(map[string]func(arg string) (IEndCondition,error))
 
 func init(){
-    // register end condition "time"
+    // config
+    utils.ConfigSetDefault(CI_END_CONDITION_TAG + ".confidence",DEFAULT_CI_CONFIDENCE)
+    utils.ConfigSetDefault(CI_END_CONDITION_TAG + ".batches",DEFAULT_CI_BATCHES)
+    utils.ConfigSetDefault(CI_END_CONDITION_TAG + ".min_batch_size",DEFAULT_CI_MIN_BATCH_SIZE)
+    utils.ConfigSetDefault(CI_END_CONDITION_TAG + ".check_interval",DEFAULT_CI_CHECK_INTERVAL)
+
+    // register end conditions
     RegisterEndCondition("time",NewTimeEndConditionFromConfig)
+    RegisterEndCondition("events",NewEventsEndConditionFromConfig)
+    RegisterEndCondition("height",NewHeightEndConditionFromConfig)
+    RegisterEndCondition("txs",NewTxsEndConditionFromConfig)
+    RegisterEndCondition("wallclock",NewWallclockEndConditionFromConfig)
+    RegisterEndCondition("quiescence",NewQuiescenceEndConditionFromConfig)
+    RegisterEndCondition("state",NewStateEndConditionFromConfig)
+    RegisterEndCondition("ci",NewCIEndConditionFromConfig)
+    RegisterEndCondition("and",NewAndEndConditionFromConfig)
+    RegisterEndCondition("or",NewOrEndConditionFromConfig)
 }
 
-func RegisterEndCondition(key string, factory func(arg string) IEndCondition) {
+// register a factory of end conditions from their parameter in the config (invalid parameters are reported as errors)
+func RegisterEndCondition(key string, factory func(arg string) (IEndCondition,error)) {
     if _, ok := endConditionRegistry[key]; ok {
         panic("factory for " + key + " already registered!")
     }
@@ -39,12 +191,32 @@ func RegisterEndCondition(key string, factory func(arg string) IEndCondition) {
     endConditionRegistry[key] = factory
 }
 
-func NewEndConditionFromRegistry(key string, arg string) IEndCondition {
+func NewEndConditionFromRegistry(key string, arg string) (IEndCondition,error) {
     if factory, ok := endConditionRegistry[key]; ok {
         return factory(arg)
     }
 
-    return nil
+    return nil, fmt.Errorf("no %s factory registered for %q",REGISTRY_END_CONDITION,key)
+}
+
+/*
+    Parse an end condition in the form "name(arg)", e.g. "time(600)" or
+    "or(height(1000),time(172800))".
+*/
+func ParseEndCondition(expr string) (IEndCondition,error) {
+    expr = strings.TrimSpace(expr)
+    open := strings.Index(expr,"(")
+    if open <= 0 || !strings.HasSuffix(expr,")") {
+        return nil, fmt.Errorf("invalid end condition %q: expected name(arg)",expr)
+    }
+
+    name := strings.TrimSpace(expr[:open])
+    end, err := NewEndConditionFromRegistry(name,expr[open+1:len(expr)-1])
+    if err != nil {
+        return nil, fmt.Errorf("invalid end condition %q: %v",expr,err)
+    }
+
+    return end, nil
 }
 
 // factory for TimeEndCondition
@@ -54,21 +226,698 @@ func NewTimeEndCondition(endTime float64) IEndCondition {
     }
 }
 
-func NewTimeEndConditionFromConfig(arg string) IEndCondition {
-    endTime, err := strconv.ParseFloat(arg,64)
+func NewTimeEndConditionFromConfig(arg string) (IEndCondition,error) {
+    endTime, err := strconv.ParseFloat(strings.TrimSpace(arg),64)
     if err != nil {
-        panic(err)
+        return nil, fmt.Errorf("time end condition: invalid time %q",arg)
     }
 
-    return &TimeEndCondition{
-        endTime:    endTime,
+    return NewTimeEndCondition(endTime), nil
+}
+
+// factory for EventsEndCondition
+func NewEventsEndCondition(maxEvents uint64) IEndCondition {
+    return &EventsEndCondition{
+        maxEvents:  maxEvents,
+        count:      0,
+    }
+}
+
+func NewEventsEndConditionFromConfig(arg string) (IEndCondition,error) {
+    maxEvents, err := strconv.ParseUint(strings.TrimSpace(arg),10,64)
+    if err != nil {
+        return nil, fmt.Errorf("events end condition: invalid number of events %q",arg)
     }
+
+    return NewEventsEndCondition(maxEvents), nil
+}
+
+// factory for HeightEndCondition: fraction of the nodes in (0,1]
+func NewHeightEndCondition(height uint64,fraction float64) (IEndCondition,error) {
+    if fraction <= 0 || fraction > 1 {
+        return nil, fmt.Errorf("height end condition: fraction of nodes must be in (0,1], got %v",fraction)
+    }
+
+    return &HeightEndCondition{
+        height:     height,
+        fraction:   fraction,
+        reached:    make(map[uint32]bool),
+        lock:       sync.Mutex{},
+    }, nil
+}
+
+// arg: "<height>" (all nodes) or "<height>,<fraction of nodes>"
+func NewHeightEndConditionFromConfig(arg string) (IEndCondition,error) {
+    args := splitEndConditionArgs(arg)
+    if len(args) < 1 || len(args) > 2 {
+        return nil, fmt.Errorf("height end condition: expected \"<height>\" or \"<height>,<fraction>\", got %q",arg)
+    }
+
+    height, err := strconv.ParseUint(args[0],10,64)
+    if err != nil {
+        return nil, fmt.Errorf("height end condition: invalid height %q",args[0])
+    }
+
+    fraction := 1.0
+    if len(args) == 2 {
+        fraction, err = strconv.ParseFloat(args[1],64)
+        if err != nil {
+            return nil, fmt.Errorf("height end condition: invalid fraction of nodes %q",args[1])
+        }
+    }
+
+    return NewHeightEndCondition(height,fraction)
+}
+
+// factory for TxsEndCondition: at least 1 confirmation
+func NewTxsEndCondition(maxTxs int,confirmations uint64) (IEndCondition,error) {
+    if confirmations < 1 {
+        return nil, fmt.Errorf("txs end condition: confirmations must be at least 1")
+    }
+
+    return &TxsEndCondition{
+        maxTxs:             maxTxs,
+        confirmations:      confirmations,
+        confirmedBlocks:    make(map[uint64]bool),
+        confirmedTxs:       make(map[uint64]bool),
+        lock:               sync.Mutex{},
+    }, nil
+}
+
+// arg: "<transactions>" (1 confirmation) or "<transactions>,<confirmations>"
+func NewTxsEndConditionFromConfig(arg string) (IEndCondition,error) {
+    args := splitEndConditionArgs(arg)
+    if len(args) < 1 || len(args) > 2 {
+        return nil, fmt.Errorf("txs end condition: expected \"<transactions>\" or \"<transactions>,<confirmations>\", got %q",arg)
+    }
+
+    maxTxs, err := strconv.Atoi(args[0])
+    if err != nil {
+        return nil, fmt.Errorf("txs end condition: invalid number of transactions %q",args[0])
+    }
+
+    confirmations := uint64(1)
+    if len(args) == 2 {
+        confirmations, err = strconv.ParseUint(args[1],10,64)
+        if err != nil {
+            return nil, fmt.Errorf("txs end condition: invalid number of confirmations %q",args[1])
+        }
+    }
+
+    return NewTxsEndCondition(maxTxs,confirmations)
+}
+
+// factory for WallclockEndCondition
+func NewWallclockEndCondition(timeout time.Duration) IEndCondition {
+    return &WallclockEndCondition{
+        timeout:    timeout,
+    }
+}
+
+// arg: a duration (e.g., "90s" or "2h") or a number of seconds
+func NewWallclockEndConditionFromConfig(arg string) (IEndCondition,error) {
+    arg = strings.TrimSpace(arg)
+    timeout, err := time.ParseDuration(arg)
+    if err != nil {
+        seconds, errSeconds := strconv.ParseFloat(arg,64)
+        if errSeconds != nil {
+            return nil, fmt.Errorf("wallclock end condition: invalid duration %q (e.g., \"90s\", \"2h\" or seconds)",arg)
+        }
+        timeout = time.Duration(seconds * float64(time.Second))
+    }
+
+    return NewWallclockEndCondition(timeout), nil
+}
+
+// factory for QuiescenceEndCondition
+func NewQuiescenceEndCondition(period float64) IEndCondition {
+    return &QuiescenceEndCondition{
+        period:     period,
+    }
+}
+
+func NewQuiescenceEndConditionFromConfig(arg string) (IEndCondition,error) {
+    period, err := strconv.ParseFloat(strings.TrimSpace(arg),64)
+    if err != nil {
+        return nil, fmt.Errorf("quiescence end condition: invalid period %q",arg)
+    }
+
+    return NewQuiescenceEndCondition(period), nil
+}
+
+// factory for StateEndCondition
+func NewStateEndCondition(predicate *StatePredicate) IEndCondition {
+    return &StateEndCondition{
+        predicate:  predicate,
+    }
+}
+
+func NewStateEndConditionFromConfig(arg string) (IEndCondition,error) {
+    predicate, err := ParseStatePredicate(arg)
+    if err != nil {
+        return nil, fmt.Errorf("state end condition: %v",err)
+    }
+
+    return NewStateEndCondition(predicate), nil
+}
+
+// factory for CIEndCondition: precision relative to the mean, confidence in (0,1)
+func NewCIEndCondition(metric string,precision float64,confidence float64,batches int,minBatchSize int,checkInterval float64) (IEndCondition,error) {
+    end := &CIEndCondition{
+        metric:         metric,
+        precision:      precision,
+        confidence:     confidence,
+        batches:        batches,
+        minBatchSize:   minBatchSize,
+        checkInterval:  checkInterval,
+        nextCheck:      0,
+        fromConfig:     false,
+        logger:         nil,
+    }
+    if err := end.check(); err != nil {
+        return nil, fmt.Errorf("ci end condition: %v",err)
+    }
+
+    return end, nil
+}
+
+// arg: "<module>.<metric>,<relative precision>" (e.g., "block_propagation.coverage90,0.05")
+func NewCIEndConditionFromConfig(arg string) (IEndCondition,error) {
+    args := splitEndConditionArgs(arg)
+    if len(args) != 2 {
+        return nil, fmt.Errorf("ci end condition: expected \"<module>.<metric>,<relative precision>\", got %q",arg)
+    }
+
+    precision, err := strconv.ParseFloat(args[1],64)
+    if err != nil {
+        return nil, fmt.Errorf("ci end condition: invalid precision %q",args[1])
+    }
+    if _, _, ok := strings.Cut(args[0],"."); !ok {
+        return nil, fmt.Errorf("ci end condition: invalid metric %q, expected <module>.<metric>",args[0])
+    }
+
+    return &CIEndCondition{
+        metric:         args[0],
+        precision:      precision,
+        nextCheck:      0,
+        fromConfig:     true,
+        logger:         nil,
+    }, nil
+}
+
+// factory for CompositeEndCondition: met when all conditions are met
+func NewAndEndCondition(conditions ...IEndCondition) IEndCondition {
+    return &CompositeEndCondition{
+        all:        true,
+        conditions: conditions,
+    }
+}
+
+// factory for CompositeEndCondition: met when any condition is met
+func NewOrEndCondition(conditions ...IEndCondition) IEndCondition {
+    return &CompositeEndCondition{
+        all:        false,
+        conditions: conditions,
+    }
+}
+
+// arg: list of end conditions in the form "name(arg)", separated by commas
+func NewAndEndConditionFromConfig(arg string) (IEndCondition,error) {
+    conditions, err := parseEndConditionList(arg)
+    if err != nil {
+        return nil, fmt.Errorf("and end condition: %v",err)
+    }
+
+    return NewAndEndCondition(conditions...), nil
+}
+
+// arg: list of end conditions in the form "name(arg)", separated by commas
+func NewOrEndConditionFromConfig(arg string) (IEndCondition,error) {
+    conditions, err := parseEndConditionList(arg)
+    if err != nil {
+        return nil, fmt.Errorf("or end condition: %v",err)
+    }
+
+    return NewOrEndCondition(conditions...), nil
 }
 
 // ==== methods ====
 
+func (end *TimeEndCondition) Init(sim ISimulation) {}
+
 // check if end condition is met
 func (end *TimeEndCondition) Check(sim ISimulation) bool {
     return sim.GetTime() >= end.endTime
 }
 
+func (end *EventsEndCondition) Init(sim ISimulation) {
+    sim.GetHooks().RegisterPostTriggerAll(end)
+}
+
+func (end *EventsEndCondition) EventPostTrigger(ev utils.IEvent) {
+    atomic.AddUint64(&end.count,1)
+}
+
+func (end *EventsEndCondition) Check(sim ISimulation) bool {
+    return atomic.LoadUint64(&end.count) >= end.maxEvents
+}
+
+// implements ISnapshotable
+func (end *EventsEndCondition) Snapshot() ([]byte,error) {
+    return EncodeSnapshot(atomic.LoadUint64(&end.count))
+}
+
+// implements ISnapshotable
+func (end *EventsEndCondition) Restore(data []byte) error {
+    var count uint64
+    if err := DecodeSnapshot(data,&count); err != nil {
+        return err
+    }
+
+    atomic.StoreUint64(&end.count,count)
+    return nil
+}
+
+func (end *HeightEndCondition) Init(sim ISimulation) {
+    end.state = sim.GetGlobalState()
+    if end.state == nil {
+        sim.GetLogger(SIMULATION_TAG).Error("height end condition requires a global state: it will never be met")
+        return
+    }
+
+    sim.GetHooks().RegisterPreTrigger(BLOCK_EVENT_ACCEPTED,end)
+}
+
+func (end *HeightEndCondition) EventPreTrigger(ev utils.IEvent) {
+    node, ok := ev.GetDestination().(INode)
+    if !ok {
+        return
+    }
+
+    block, ok := ev.GetData().(IBlock)
+    if !ok {
+        return
+    }
+
+    height, ok := end.state.GetBlockHeight(block.GetHash())
+    if !ok || height < end.height {
+        return
+    }
+
+    end.lock.Lock()
+    end.reached[node.GetID()] = true
+    end.lock.Unlock()
+}
+
+func (end *HeightEndCondition) Check(sim ISimulation) bool {
+    numNodes := sim.GetNumNodes()
+    if numNodes == 0 {
+        return false
+    }
+
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    return float64(len(end.reached)) >= math.Ceil(end.fraction * float64(numNodes))
+}
+
+// implements ISnapshotable
+func (end *HeightEndCondition) Snapshot() ([]byte,error) {
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    return EncodeSnapshot(end.reached)
+}
+
+// implements ISnapshotable
+func (end *HeightEndCondition) Restore(data []byte) error {
+    reached := make(map[uint32]bool)
+    if err := DecodeSnapshot(data,&reached); err != nil {
+        return err
+    }
+
+    end.lock.Lock()
+    end.reached = reached
+    end.lock.Unlock()
+
+    return nil
+}
+
+func (end *TxsEndCondition) Init(sim ISimulation) {
+    end.state = sim.GetGlobalState()
+    if end.state == nil {
+        sim.GetLogger(SIMULATION_TAG).Error("txs end condition requires a global state: it will never be met")
+        return
+    }
+
+    sim.GetHooks().RegisterPreTrigger(BLOCK_EVENT_ACCEPTED,end)
+}
+
+/*
+    A node accepted a new tip: confirm the block k-1 blocks below it and its
+    ancestors, until one that was already confirmed.
+*/
+func (end *TxsEndCondition) EventPreTrigger(ev utils.IEvent) {
+    block, ok := ev.GetData().(IBlock)
+    if !ok {
+        return
+    }
+
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    // walk back k-1 blocks
+    for i := uint64(1); i < end.confirmations; i++ {
+        parent, ok := GetBlockParent(block)
+        if !ok {
+            return
+        }
+
+        block = end.state.GetBlock(parent)
+        if block == nil {
+            return
+        }
+    }
+
+    for block != nil && !end.confirmedBlocks[block.GetHash()] {
+        end.confirmedBlocks[block.GetHash()] = true
+        for _, txList := range block.GetTransactions() {
+            for _, tx := range txList {
+                end.confirmedTxs[tx.GetHash()] = true
+            }
+        }
+
+        parent, ok := GetBlockParent(block)
+        if !ok {
+            return
+        }
+        block = end.state.GetBlock(parent)
+    }
+}
+
+func (end *TxsEndCondition) Check(sim ISimulation) bool {
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    return len(end.confirmedTxs) >= end.maxTxs
+}
+
+// state of TxsEndCondition, for snapshots
+type txsEndConditionGob struct {
+    ConfirmedBlocks map[uint64]bool
+    ConfirmedTxs map[uint64]bool
+}
+
+// implements ISnapshotable
+func (end *TxsEndCondition) Snapshot() ([]byte,error) {
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    return EncodeSnapshot(txsEndConditionGob{
+        ConfirmedBlocks:    end.confirmedBlocks,
+        ConfirmedTxs:       end.confirmedTxs,
+    })
+}
+
+// implements ISnapshotable
+func (end *TxsEndCondition) Restore(data []byte) error {
+    snapshot := txsEndConditionGob{}
+    if err := DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    end.lock.Lock()
+    defer end.lock.Unlock()
+
+    end.confirmedBlocks = snapshot.ConfirmedBlocks
+    if end.confirmedBlocks == nil {
+        end.confirmedBlocks = make(map[uint64]bool)
+    }
+
+    end.confirmedTxs = snapshot.ConfirmedTxs
+    if end.confirmedTxs == nil {
+        end.confirmedTxs = make(map[uint64]bool)
+    }
+
+    return nil
+}
+
+func (end *WallclockEndCondition) Init(sim ISimulation) {
+    end.start = time.Now()
+}
+
+func (end *WallclockEndCondition) Check(sim ISimulation) bool {
+    return time.Since(end.start) >= end.timeout
+}
+
+func (end *QuiescenceEndCondition) Init(sim ISimulation) {}
+
+func (end *QuiescenceEndCondition) Check(sim ISimulation) bool {
+    return sim.GetTime() - sim.GetLastActivity() >= end.period
+}
+
+func (end *StateEndCondition) Init(sim ISimulation) {}
+
+func (end *StateEndCondition) Check(sim ISimulation) bool {
+    return end.predicate.Eval(sim.GetGlobalState())
+}
+
+/*
+    Read the settings from the config of the simulation (if created from it),
+    already checked by ValidateConfig. An unavailable metric is logged, and
+    the condition is never met.
+*/
+func (end *CIEndCondition) Init(sim ISimulation) {
+    end.logger = sim.GetLogger(SIMULATION_TAG)
+    if end.fromConfig {
+        end.readConfig(sim.GetConfig())
+    }
+
+    if _, err := sim.GetMeasurements().GetMetric(end.metric); err != nil {
+        end.logger.Error("ci end condition: %v: it will never be met",err)
+    }
+}
+
+func (end *CIEndCondition) Check(sim ISimulation) bool {
+    now := sim.GetTime()
+    if now < end.nextCheck {
+        return false
+    }
+    end.nextCheck = now + end.checkInterval
+
+    samples, err := sim.GetMeasurements().GetMetric(end.metric)
+    if err != nil || end.check() != nil || len(samples) < end.batches * end.minBatchSize {
+        return false
+    }
+
+    end.mean, end.halfWidth = utils.ConfidenceInterval(utils.BatchMeans(samples,end.batches),end.confidence)
+    end.numSamples = len(samples)
+    if end.mean == 0 || end.halfWidth / math.Abs(end.mean) > end.precision {
+        return false
+    }
+
+    end.logger.Info("%s = %v +/- %v (%v confidence, %d samples in %d batches) at %v",end.metric,end.mean,end.halfWidth,end.confidence,end.numSamples,end.batches,now)
+    return true
+}
+
+// state of CIEndCondition, for snapshots
+type ciEndConditionGob struct {
+    NextCheck float64
+    Mean float64
+    HalfWidth float64
+    NumSamples int
+}
+
+// implements ISnapshotable
+func (end *CIEndCondition) Snapshot() ([]byte,error) {
+    return EncodeSnapshot(ciEndConditionGob{
+        NextCheck:      end.nextCheck,
+        Mean:           end.mean,
+        HalfWidth:      end.halfWidth,
+        NumSamples:     end.numSamples,
+    })
+}
+
+// implements ISnapshotable
+func (end *CIEndCondition) Restore(data []byte) error {
+    snapshot := ciEndConditionGob{}
+    if err := DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    end.nextCheck = snapshot.NextCheck
+    end.mean = snapshot.Mean
+    end.halfWidth = snapshot.HalfWidth
+    end.numSamples = snapshot.NumSamples
+
+    return nil
+}
+
+/*
+    Implements IConfigValidator: settings of section "ci_end_condition" (if
+    created from the config), and the module of the metric must be in the
+    measurement modules and provide the metric.
+*/
+func (end *CIEndCondition) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+
+    settings := *end
+    if settings.fromConfig {
+        settings.readConfig(config)
+    }
+    errs.AddError(CI_END_CONDITION_TAG,settings.check())
+
+    module, metric, _ := strings.Cut(end.metric,".")
+    found := false
+    for _, name := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules") {
+        found = found || name == module
+    }
+    if !found {
+        errs.Add(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules","module %q of the metric of the ci end condition is not enabled",module)
+    } else if source, ok := NewMeasurementModuleFromRegistry(module).(IMetricSource); !ok {
+        errs.Add(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules","module %q of the ci end condition does not provide metrics",module)
+    } else if !source.HasMetric(metric) {
+        errs.Add(CI_END_CONDITION_TAG,"metric %q not provided by module %s (available: %v)",metric,module,source.GetMetricNames())
+    }
+
+    return errs.Err()
+}
+
+func (end *CIEndCondition) readConfig(config *utils.SimulationConfig) {
+    end.confidence = config.GetFloat64(CI_END_CONDITION_TAG + ".confidence")
+    end.batches = config.GetInt(CI_END_CONDITION_TAG + ".batches")
+    end.minBatchSize = config.GetInt(CI_END_CONDITION_TAG + ".min_batch_size")
+    end.checkInterval = config.GetFloat64(CI_END_CONDITION_TAG + ".check_interval")
+}
+
+func (end *CIEndCondition) check() error {
+    if end.precision <= 0 || end.confidence <= 0 || end.confidence >= 1 || end.batches < 2 || end.minBatchSize < 1 || end.checkInterval < 0 {
+        return fmt.Errorf("precision must be positive, confidence in (0,1), batches at least 2, min_batch_size positive, and check_interval not negative")
+    }
+
+    return nil
+}
+
+func (end *CompositeEndCondition) Init(sim ISimulation) {
+    for _, condition := range end.conditions {
+        condition.Init(sim)
+    }
+}
+
+func (end *CompositeEndCondition) Check(sim ISimulation) bool {
+    for _, condition := range end.conditions {
+        if condition.Check(sim) != end.all {
+            return !end.all
+        }
+    }
+
+    return end.all
+}
+
+// implements ISnapshotable: snapshots of the conditions (nil if not snapshotable)
+func (end *CompositeEndCondition) Snapshot() ([]byte,error) {
+    snapshots := make([][]byte,len(end.conditions))
+    for i, condition := range end.conditions {
+        if snapshotable, ok := condition.(ISnapshotable); ok {
+            data, err := snapshotable.Snapshot()
+            if err != nil {
+                return nil, err
+            }
+            snapshots[i] = data
+        }
+    }
+
+    return EncodeSnapshot(snapshots)
+}
+
+// implements ISnapshotable
+func (end *CompositeEndCondition) Restore(data []byte) error {
+    snapshots := make([][]byte,0,len(end.conditions))
+    if err := DecodeSnapshot(data,&snapshots); err != nil {
+        return err
+    }
+
+    if len(snapshots) != len(end.conditions) {
+        return fmt.Errorf("end condition snapshot has %d conditions, expected %d",len(snapshots),len(end.conditions))
+    }
+
+    for i, condition := range end.conditions {
+        if snapshotable, ok := condition.(ISnapshotable); ok && snapshots[i] != nil {
+            if err := snapshotable.Restore(snapshots[i]); err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+// implements IConfigValidator: the conditions that check the config
+func (end *CompositeEndCondition) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    for _, condition := range end.conditions {
+        if validator, ok := condition.(IConfigValidator); ok {
+            errs.AddError("",validator.ValidateConfig(config))
+        }
+    }
+
+    return errs.Err()
+}
+
+// ==== getters ====
+
+// last estimate of the metric: mean, half-width of the confidence interval, and number of samples
+func (end *CIEndCondition) GetEstimate() (float64,float64,int) {
+    return end.mean, end.halfWidth, end.numSamples
+}
+
+func (end *CompositeEndCondition) GetConditions() []IEndCondition {
+    return end.conditions
+}
+
+// ==== functions ====
+
+// split at the commas that are not within parentheses
+func splitEndConditionArgs(arg string) []string {
+    args := make([]string,0,2)
+    depth := 0
+    start := 0
+    for i, c := range arg {
+        switch c {
+        case '(':
+            depth++
+        case ')':
+            depth--
+        case ',':
+            if depth == 0 {
+                args = append(args,strings.TrimSpace(arg[start:i]))
+                start = i + 1
+            }
+        }
+    }
+
+    if last := strings.TrimSpace(arg[start:]); last != "" || len(args) > 0 {
+        args = append(args,last)
+    }
+
+    return args
+}
+
+// parse a list of end conditions for composition
+func parseEndConditionList(arg string) ([]IEndCondition,error) {
+    exprs := splitEndConditionArgs(arg)
+    if len(exprs) == 0 {
+        return nil, fmt.Errorf("no conditions given")
+    }
+
+    conditions := make([]IEndCondition,0,len(exprs))
+    for _, expr := range exprs {
+        condition, err := ParseEndCondition(expr)
+        if err != nil {
+            return nil, err
+        }
+        conditions = append(conditions,condition)
+    }
+
+    return conditions, nil
+}
@@ -0,0 +1,414 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "testing"
+    "time"
+)
+
+// ==== concrete structures ====
+
+// simulation with a given time, last activity and number of nodes (the rest is a real simulation)
+type testSimulation struct {
+    ISimulation
+    time float64
+    lastActivity float64
+    numNodes uint32
+}
+
+// node with an id only
+type testNode struct {
+    INode
+    id uint32
+}
+
+// block with a parent and transactions
+type testBlock struct {
+    hash uint64
+    parent uint64
+    txs []ITransaction
+}
+
+// transaction with a hash only
+type testTx struct {
+    hash uint64
+}
+
+// measurement module with a single metric of given samples
+type testMetricModule struct {
+    DefaultMeasurementModule
+    samples []float64
+}
+
+// ==== factories ====
+
+func newTestSimulation() *testSimulation {
+    isim, err := NewSimulationWithConfig(utils.NewSimulationConfig())
+    if err != nil {
+        panic(err)
+    }
+    sim := &testSimulation{ISimulation: isim}
+    sim.SetGlobalState(NewSimulationGlobalState())
+    sim.GetGlobalState().Init(sim)
+
+    return sim
+}
+
+// ==== methods ====
+
+func (sim *testSimulation) GetTime() float64 { return sim.time }
+func (sim *testSimulation) GetLastActivity() float64 { return sim.lastActivity }
+func (sim *testSimulation) GetNumNodes() uint32 { return sim.numNodes }
+
+func (node *testNode) GetID() uint32 { return node.id }
+
+func (block *testBlock) GetHash() uint64 { return block.hash }
+func (block *testBlock) GetType() uint16 { return BLOCK_STANDARD }
+func (block *testBlock) GetTime() float64 { return 0 }
+func (block *testBlock) GetCreator() uint32 { return 0 }
+func (block *testBlock) GetSize() uint64 { return 0 }
+func (block *testBlock) Verify() bool { return true }
+func (block *testBlock) GetTransactions() map[uint16][]ITransaction { return map[uint16][]ITransaction{0: block.txs} }
+
+func (block *testBlock) GetReferences() map[uint16][]uint64 {
+    if block.parent == 0 {
+        return map[uint16][]uint64{}
+    }
+
+    return map[uint16][]uint64{BREF_STANDARD: {block.parent}}
+}
+
+func (tx *testTx) GetHash() uint64 { return tx.hash }
+func (tx *testTx) GetType() uint16 { return 0 }
+func (tx *testTx) GetTime() float64 { return 0 }
+func (tx *testTx) GetCreator() uint32 { return 0 }
+func (tx *testTx) GetSize() uint64 { return 0 }
+func (tx *testTx) Verify() bool { return true }
+
+func (module *testMetricModule) GetFinalResult() interface{} { return nil }
+func (module *testMetricModule) GetMetricNames() []string { return []string{"metric"} }
+func (module *testMetricModule) HasMetric(name string) bool { return name == "metric" }
+
+func (module *testMetricModule) GetMetric(name string) ([]float64,bool) {
+    return module.samples, name == "metric"
+}
+
+// ==== tests ====
+
+// end conditions in the form "name(arg)", composed with "and"/"or" and nested, or invalid
+func TestParseEndCondition(t *testing.T) {
+    tests := []struct{
+        expr string
+        check func(end IEndCondition) bool      // nil if the expression is invalid
+    }{
+        {"time(600)",func(end IEndCondition) bool { return end.(*TimeEndCondition).endTime == 600 }},
+        {" time( 1.5 ) ",func(end IEndCondition) bool { return end.(*TimeEndCondition).endTime == 1.5 }},
+        {"events(1000)",func(end IEndCondition) bool { return end.(*EventsEndCondition).maxEvents == 1000 }},
+        {"height(10)",func(end IEndCondition) bool { e := end.(*HeightEndCondition); return e.height == 10 && e.fraction == 1 }},
+        {"height(10, 0.5)",func(end IEndCondition) bool { e := end.(*HeightEndCondition); return e.height == 10 && e.fraction == 0.5 }},
+        {"txs(100)",func(end IEndCondition) bool { e := end.(*TxsEndCondition); return e.maxTxs == 100 && e.confirmations == 1 }},
+        {"txs(100,6)",func(end IEndCondition) bool { e := end.(*TxsEndCondition); return e.maxTxs == 100 && e.confirmations == 6 }},
+        {"wallclock(90s)",func(end IEndCondition) bool { return end.(*WallclockEndCondition).timeout == 90 * time.Second }},
+        {"wallclock(2.5)",func(end IEndCondition) bool { return end.(*WallclockEndCondition).timeout == 2500 * time.Millisecond }},
+        {"quiescence(30)",func(end IEndCondition) bool { return end.(*QuiescenceEndCondition).period == 30 }},
+        {"state(phase==done)",func(end IEndCondition) bool { return end.(*StateEndCondition).predicate.String() == "phase==done" }},
+        {"state(#blocks>=10)",func(end IEndCondition) bool { return end.(*StateEndCondition).predicate.Operator == ">=" }},
+        {"ci(tx_latency.inclusion,0.05)",func(end IEndCondition) bool { e := end.(*CIEndCondition); return e.metric == "tx_latency.inclusion" && e.precision == 0.05 }},
+        {"or(height(1000),time(172800))",func(end IEndCondition) bool {
+            e := end.(*CompositeEndCondition)
+            return !e.all && len(e.conditions) == 2 && e.conditions[1].(*TimeEndCondition).endTime == 172800
+        }},
+        {"and(or(time(1), events(2)), txs(3,2))",func(end IEndCondition) bool {
+            e := end.(*CompositeEndCondition)
+            inner, ok := e.conditions[0].(*CompositeEndCondition)
+            return e.all && len(e.conditions) == 2 && ok && !inner.all && len(inner.conditions) == 2
+        }},
+
+        // invalid
+        {"",nil},
+        {"time",nil},
+        {"time(600",nil},
+        {"(600)",nil},
+        {"bogus(1)",nil},
+        {"time(soon)",nil},
+        {"events(-1)",nil},
+        {"height()",nil},
+        {"height(1,0.5,2)",nil},
+        {"height(1,0)",nil},
+        {"height(1,1.5)",nil},
+        {"txs(10,0)",nil},
+        {"txs(many)",nil},
+        {"wallclock(soon)",nil},
+        {"quiescence()",nil},
+        {"state(phase)",nil},
+        {"ci(metric,0.05)",nil},
+        {"ci(tx_latency.inclusion)",nil},
+        {"or()",nil},
+        {"and(time(1),bogus(2))",nil},
+        {"or(time(1),and(events(2),height(x)))",nil},
+    }
+
+    for _, test := range tests {
+        end, err := ParseEndCondition(test.expr)
+        switch {
+        case test.check == nil && err == nil:
+            t.Errorf("%q: expected an error, got %T",test.expr,end)
+        case test.check != nil && err != nil:
+            t.Errorf("%q: unexpected error: %v",test.expr,err)
+        case test.check != nil && !test.check(end):
+            t.Errorf("%q: unexpected condition %#v",test.expr,end)
+        }
+    }
+}
+
+// composite conditions in the config are given as separate parameters, joined with commas
+func TestEndConditionFromRegistry(t *testing.T) {
+    end, err := NewEndConditionFromRegistry("or","height(1000),time(172800)")
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    if composite := end.(*CompositeEndCondition); composite.all || len(composite.conditions) != 2 {
+        t.Errorf("unexpected condition %#v",end)
+    }
+
+    if _, err := NewEndConditionFromRegistry("bogus","1"); err == nil {
+        t.Errorf("expected an error for an unregistered end condition")
+    }
+}
+
+func TestTimeEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    end := NewTimeEndCondition(600)
+    end.Init(sim)
+
+    for _, test := range []struct{ time float64; met bool }{{0,false},{599.9,false},{600,true},{700,true}} {
+        sim.time = test.time
+        if end.Check(sim) != test.met {
+            t.Errorf("at %v: met=%v, want %v",test.time,!test.met,test.met)
+        }
+    }
+}
+
+func TestEventsEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    end := NewEventsEndCondition(3)
+    end.Init(sim)
+
+    for i := 0; i < 3; i++ {
+        if end.Check(sim) {
+            t.Fatalf("met after %d events",i)
+        }
+        end.(*EventsEndCondition).EventPostTrigger(utils.NewEvent(0,nil,nil))
+    }
+    if !end.Check(sim) {
+        t.Errorf("not met after 3 events")
+    }
+}
+
+// a fraction of the nodes must accept a block at the height (genesis is 0)
+func TestHeightEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    sim.numNodes = 4
+    blocks := []*testBlock{{hash: 1},{hash: 2,parent: 1},{hash: 3,parent: 2}}
+    for _, block := range blocks {
+        sim.GetGlobalState().PutBlock(block)
+    }
+
+    end, err := NewHeightEndCondition(2,0.5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    end.Init(sim)
+
+    accept := func(node uint32,block *testBlock) {
+        end.(*HeightEndCondition).EventPreTrigger(utils.NewEvent(BLOCK_EVENT_ACCEPTED,IBlock(block),&testNode{id: node}))
+    }
+
+    accept(0,blocks[2])
+    accept(1,blocks[1])
+    accept(2,blocks[1])
+    if end.Check(sim) {
+        t.Errorf("met with 1 of 4 nodes at height 2")
+    }
+
+    accept(1,blocks[2])
+    if !end.Check(sim) {
+        t.Errorf("not met with 2 of 4 nodes at height 2")
+    }
+
+    sim.numNodes = 0
+    if end.Check(sim) {
+        t.Errorf("met without nodes")
+    }
+}
+
+// transactions are confirmed by the block k-1 blocks below the accepted tip, and its ancestors
+func TestTxsEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    blocks := []*testBlock{
+        {hash: 1},
+        {hash: 2,parent: 1,txs: []ITransaction{&testTx{hash: 10},&testTx{hash: 11}}},
+        {hash: 3,parent: 2,txs: []ITransaction{&testTx{hash: 11},&testTx{hash: 12}}},
+        {hash: 4,parent: 3},
+    }
+    for _, block := range blocks {
+        sim.GetGlobalState().PutBlock(block)
+    }
+
+    end, err := NewTxsEndCondition(3,2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    end.Init(sim)
+
+    accept := func(block *testBlock) {
+        end.(*TxsEndCondition).EventPreTrigger(utils.NewEvent(BLOCK_EVENT_ACCEPTED,IBlock(block),&testNode{id: 0}))
+    }
+
+    accept(blocks[2])
+    if end.Check(sim) {
+        t.Errorf("met with 2 confirmed transactions")
+    }
+
+    accept(blocks[3])
+    if !end.Check(sim) {
+        t.Errorf("not met with 3 confirmed transactions")
+    }
+}
+
+func TestWallclockEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    expired, pending := NewWallclockEndCondition(0), NewWallclockEndCondition(time.Hour)
+    expired.Init(sim)
+    pending.Init(sim)
+
+    if !expired.Check(sim) || pending.Check(sim) {
+        t.Errorf("timeout 0: met=%v, timeout 1h: met=%v",expired.Check(sim),pending.Check(sim))
+    }
+}
+
+func TestQuiescenceEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    end := NewQuiescenceEndCondition(30)
+    end.Init(sim)
+
+    sim.time, sim.lastActivity = 100, 80
+    if end.Check(sim) {
+        t.Errorf("met after 20 seconds without activity")
+    }
+
+    sim.lastActivity = 70
+    if !end.Check(sim) {
+        t.Errorf("not met after 30 seconds without activity")
+    }
+}
+
+func TestStateEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    end, err := NewStateEndConditionFromConfig("phase==done")
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    end.Init(sim)
+
+    if end.Check(sim) {
+        t.Errorf("met with a missing key")
+    }
+    sim.GetGlobalState().Put("phase","running")
+    if end.Check(sim) {
+        t.Errorf("met with phase=running")
+    }
+    sim.GetGlobalState().Put("phase","done")
+    if !end.Check(sim) {
+        t.Errorf("not met with phase=done")
+    }
+}
+
+// met when the confidence interval of the mean is narrow enough, with enough samples
+func TestCIEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    module := &testMetricModule{DefaultMeasurementModule: NewDefaultMeasurementModule("test")}
+    sim.GetMeasurements().AddModule(module)
+
+    end, err := NewCIEndCondition("test.metric",0.05,0.95,2,5,0)
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    end.Init(sim)
+
+    module.samples = []float64{10,10.1,9.9,10,10.1,9.9,10,10.1,9.9}
+    if end.Check(sim) {
+        t.Errorf("met with fewer samples than batches * min_batch_size")
+    }
+
+    module.samples = append(module.samples,10)
+    if !end.Check(sim) {
+        t.Errorf("not met with a narrow interval")
+    }
+
+    module.samples = []float64{1,20,3,40,5,60,7,80,9,100}
+    if end.Check(sim) {
+        t.Errorf("met with a wide interval")
+    }
+
+    if _, err := NewCIEndCondition("test.metric",0.05,1.5,2,5,0); err == nil {
+        t.Errorf("expected an error with confidence out of (0,1)")
+    }
+}
+
+// the next check time is restored from a snapshot, so a resumed run checks at the same times
+func TestCIEndConditionSnapshot(t *testing.T) {
+    sim := newTestSimulation()
+    module := &testMetricModule{DefaultMeasurementModule: NewDefaultMeasurementModule("test")}
+    module.samples = []float64{10,10.1,9.9,10,10.1,9.9,10,10.1,9.9,10}
+    sim.GetMeasurements().AddModule(module)
+
+    newCondition := func() IEndCondition {
+        end, err := NewCIEndCondition("test.metric",0.05,0.95,2,5,10)
+        if err != nil {
+            t.Fatalf("unexpected error: %v",err)
+        }
+        end.Init(sim)
+        return end
+    }
+
+    module.samples[0] = 1000
+    end := newCondition()
+    sim.time = 5
+    if end.Check(sim) {
+        t.Errorf("met with a wide interval")
+    }
+    data, err := end.(ISnapshotable).Snapshot()
+    if err != nil {
+        t.Fatalf("snapshot: %v",err)
+    }
+
+    module.samples[0] = 10
+    restored := newCondition()
+    if err := restored.(ISnapshotable).Restore(data); err != nil {
+        t.Fatalf("restore: %v",err)
+    }
+    sim.time = 10
+    if restored.Check(sim) {
+        t.Errorf("checked before the next check time of the snapshot")
+    }
+    sim.time = 15
+    if !restored.Check(sim) {
+        t.Errorf("not met at the next check time of the snapshot")
+    }
+}
+
+func TestCompositeEndCondition(t *testing.T) {
+    sim := newTestSimulation()
+    and := NewAndEndCondition(NewTimeEndCondition(10),NewTimeEndCondition(20))
+    or := NewOrEndCondition(NewTimeEndCondition(10),NewTimeEndCondition(20))
+    and.Init(sim)
+    or.Init(sim)
+
+    for _, test := range []struct{ time float64; and bool; or bool }{{5,false,false},{15,false,true},{25,true,true}} {
+        sim.time = test.time
+        if and.Check(sim) != test.and || or.Check(sim) != test.or {
+            t.Errorf("at %v: and=%v or=%v, want and=%v or=%v",test.time,and.Check(sim),or.Check(sim),test.and,test.or)
+        }
+    }
+}
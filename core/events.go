@@ -26,6 +26,7 @@ const (
     SIMULATION_EVENT_STOP                               = 1     // stop simulation
     SIMULATION_EVENT_ADD_NODE                           = 2     // add a node
     SIMULATION_EVENT_REMOVE_NODE                        = 3     // remove a node
+    SIMULATION_EVENT_CHECKPOINT                         = 4     // write a checkpoint (data: path)
 
     // node
     NODE_EVENT_INIT                                     = 10    // init node
@@ -35,13 +36,25 @@ const (
     // global network
     GLOBAL_NETWORK_EVENT_INIT                           = 20    // init global network
     GLOBAL_NETWORK_EVENT_SEND_MESSAGE                   = 21    // send message
+    GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED                = 22    // message dropped for a receiver (data: *MessageFault)
+    GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED             = 23    // message delivered twice to a receiver (data: *MessageFault)
+    GLOBAL_NETWORK_EVENT_PARTITION                      = 24    // partition the network (data: *NetworkPartition)
+    GLOBAL_NETWORK_EVENT_HEAL                           = 25    // heal the partition (data: bool, release held messages)
+    GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED               = 26    // message not sent to a receiver, e.g. not linked (data: *MessageFault)
     
     // note network
     NODE_NETWORK_EVENT_MESSAGE_RECEIVED                 = 30    // message received from global network
     NODE_NETWORK_EVENT_CONNECT                          = 31    // connect to global network
     NODE_NETWORK_EVENT_DISCONNECT                       = 32    // disconnect from global network
 
-    // block generation
-    BLOCK_EVENT_NEW                                     = 40    // new block created
+    /*
+        block generation: emitted by the node layers that create and select
+        blocks (the default layers do not create blocks), and used by the
+        global state and by the block measurement modules. The genesis block
+        must be announced with BLOCK_EVENT_NEW like any other block, so that
+        the heights of its descendants can be resolved.
+    */
+    BLOCK_EVENT_NEW                                     = 40    // new block created (data: IBlock)
+    BLOCK_EVENT_ACCEPTED                                = 41    // block accepted by a node as the tip of its main chain (data: IBlock, destination: INode)
 )
 
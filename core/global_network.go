@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // ==== interfaces ====
 
 /*
@@ -8,6 +12,8 @@ package core
     be sent in two modes: broadcast and p2p. In broadcast mode, a message is
     sent to multiple nodes (all or a subset), while in p2p mode a message is
     sent to just one specific node. The mode is determined by IMessageDelivery.
+    The network can be partitioned into groups of nodes that cannot
+    communicate with each other until the partition is healed.
 */
 type IGlobalNetwork interface {
     ISimulationComponent
@@ -19,6 +25,57 @@ type IGlobalNetwork interface {
     EnableBroadcast(node INode) IGlobalNetwork      // start sending global broadcasts to given node
     DisableBroadcast(node INode) IGlobalNetwork     // stop sending global broadcasts to given node
     IsBroadcastEnabled(node INode) bool             // check if the given node is receiving global broadcast
+    Partition(partition *NetworkPartition) IGlobalNetwork // split the nodes into groups (replaces the current partition)
+    Heal(release bool) IGlobalNetwork               // remove the partition: held messages are delivered (release) or discarded
+    GetPartition() *NetworkPartition                // current partition (nil if none)
+}
+
+/*
+    Global network with an explicit graph of links between nodes, which only
+    delivers messages over links. Node networks that keep neighbors add and
+    remove the links of their node with their neighbors, so the overlay is
+    enforced.
+*/
+type ILinkGlobalNetwork interface {
+    IGlobalNetwork
+
+    ConnectLink(from uint32,to uint32,link *NetworkLink) ILinkGlobalNetwork // add a link (both ways if undirected; nil: default attributes)
+    DisconnectLink(from uint32,to uint32) ILinkGlobalNetwork                // remove a link (both ways if undirected)
+    IsLinked(from uint32,to uint32) bool                                    // check if messages can be sent from a node to another
+    GetLinks(nodeID uint32) []uint32                                        // nodes a node can send messages to, sorted
+    IsDirected() bool                                                       // check if links work one way only
+}
+
+// ==== concrete structures ====
+
+// attributes of a link of an ILinkGlobalNetwork
+type NetworkLink struct {
+    Latency float64                                 // seconds (negative: sampled for each message)
+    Bandwidth float64                               // bits per second (0: unlimited)
+}
+
+/*
+    Data of GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,
+    GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED and
+    GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED events: global networks that model
+    faults schedule them (with the global network as destination) when they
+    drop, duplicate or refuse to send a message for a receiver, so that
+    measurement modules can count them with hooks.
+*/
+type MessageFault struct {
+    Message IMessage
+    Receiver uint32                                 // node id of the receiver
+}
+
+/*
+    Partition of the global network (data of GLOBAL_NETWORK_EVENT_PARTITION
+    events). Messages between nodes of different groups are dropped, or held
+    until the partition is healed; nodes that are not in any group form one
+    more group. Messages already in transit are delivered.
+*/
+type NetworkPartition struct {
+    Groups [][]uint32                               // node ids of each group
+    Hold bool                                       // hold messages between groups instead of dropping them
 }
 
 // ==== factories ====
@@ -33,9 +90,11 @@ func RegisterGlobalNetwork(key string, factory func() IGlobalNetwork) {
     globalNetworkRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewGlobalNetworkFromRegistry(key string) IGlobalNetwork {
-    if factory, ok := globalNetworkRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := globalNetworkRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil 
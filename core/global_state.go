@@ -17,9 +17,11 @@ type ISimulationGlobalState interface {
     utils.IEventPreTriggerHandler
     utils.IEventPostTriggerHandler
 
-    // TODO block registry
-    //PutBlock(block IBlock)
-    //GetBlock(id uint64) IBlock
+    // block registry
+    PutBlock(block IBlock)
+    GetBlock(hash uint64) IBlock
+    GetNumBlocks() int
+    GetBlockHeight(hash uint64) (uint64,bool)   // height of a block (genesis is 0), false if its chain is not registered
 
     // key/value store
     Put(key string,value interface{})
@@ -33,26 +35,28 @@ type ISimulationGlobalState interface {
 
 /*
     Default implementation of the global state. Registers every new block by
-    hooking into the new block event. New implementations of the gobal state
-    should include this default implementation, unless they do not want to
-    register all blocks.
+    hooking into the new block event (and the accepted block event, in case a
+    block was not announced). New implementations of the gobal state should
+    include this default implementation, unless they do not want to register
+    all blocks.
 
     Implements: ISimulationGlobalState
 */
 type SimulationGlobalState struct {
     sim ISimulation
+    logger utils.ISimulationLogger
 
     stateLock sync.RWMutex
     state map[string]interface{}
 
-    //blockRegistry map[uint64]IBlock
-    //blockLock sync.RWMutex
+    blockRegistry map[uint64]IBlock
+    blockHeight map[uint64]uint64
+    blockLock sync.RWMutex
 }
 
 // ==== factories ====
 
 var globalStateRegistry map[string]func() ISimulationGlobalState = make(map[string]func() ISimulationGlobalState)
-var globalStateLogger utils.ISimulationLogger
 
 func init(){
     RegisterGlobalState(DEFAULT_GLOBAL_STATE_TAG,NewSimulationGlobalState)
@@ -67,22 +71,22 @@ func RegisterGlobalState(key string, factory func() ISimulationGlobalState) {
 }
 
 func NewSimulationGlobalState() ISimulationGlobalState {
-    if globalStateLogger == nil {
-        globalStateLogger = utils.GetSimulationLogger(DEFAULT_GLOBAL_STATE_TAG)
-    }
-
     return &SimulationGlobalState{
         sim:                nil,
+        logger:             nil,
         state:              make(map[string]interface{}),
         stateLock:          sync.RWMutex{},
-        //blockRegistry:      make(map[uint64]IBlock),
-        //blockLock:          sync.RWMutex{},
+        blockRegistry:      make(map[uint64]IBlock),
+        blockHeight:        make(map[uint64]uint64),
+        blockLock:          sync.RWMutex{},
     }
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewGlobalStateFromRegistry(key string) ISimulationGlobalState {
-    if factory, ok := globalStateRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := globalStateRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil
@@ -91,9 +95,11 @@ func NewGlobalStateFromRegistry(key string) ISimulationGlobalState {
 // ==== methods ====
 
 func (global *SimulationGlobalState) Init(sim ISimulation){
-    globalStateLogger.Debug("initializing: registering to new block events")
     global.sim = sim
+    global.logger = sim.GetLogger(DEFAULT_GLOBAL_STATE_TAG)
+    global.logger.Debug("initializing: registering to new block events")
     sim.GetHooks().RegisterPreTrigger(BLOCK_EVENT_NEW,global)    
+    sim.GetHooks().RegisterPreTrigger(BLOCK_EVENT_ACCEPTED,global)    
 }
 
 func (global *SimulationGlobalState) Finish() {
@@ -105,8 +111,13 @@ func (global *SimulationGlobalState) EventScheduled(ev utils.IEvent) {
 func (global *SimulationGlobalState) EventPreTrigger(ev utils.IEvent) {
     switch ev.GetType() {
     case BLOCK_EVENT_NEW:
-        //block := ev.GetData().(IBlock)
-        //global.PutBlock(block)
+        if block, ok := ev.GetData().(IBlock); ok {
+            global.PutBlock(block)
+        }
+    case BLOCK_EVENT_ACCEPTED:
+        if block, ok := ev.GetData().(IBlock); ok && global.GetBlock(block.GetHash()) == nil {
+            global.PutBlock(block)
+        }
     }
 }
 
@@ -127,7 +138,59 @@ func (global *SimulationGlobalState) Get(key string) interface{} {
     return global.state[key]
 }
 
+// K/V store and registered blocks of the global state, for snapshots
+type globalStateGob struct {
+    State map[string]interface{}
+    Blocks []IBlock
+}
+
 /*
+    Implements ISnapshotable. The concrete types of values in the K/V store and
+    of blocks must be registered with gob.Register.
+*/
+func (global *SimulationGlobalState) Snapshot() ([]byte,error) {
+    global.stateLock.RLock()
+    defer global.stateLock.RUnlock()
+    global.blockLock.RLock()
+    defer global.blockLock.RUnlock()
+
+    snapshot := globalStateGob{
+        State:      global.state,
+        Blocks:     make([]IBlock,0,len(global.blockRegistry)),
+    }
+    for _, block := range global.blockRegistry {
+        snapshot.Blocks = append(snapshot.Blocks,block)
+    }
+
+    return EncodeSnapshot(snapshot)
+}
+
+// implements ISnapshotable
+func (global *SimulationGlobalState) Restore(data []byte) error {
+    snapshot := globalStateGob{}
+    if err := DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    global.stateLock.Lock()
+    global.state = snapshot.State
+    if global.state == nil {
+        global.state = make(map[string]interface{})
+    }
+    global.stateLock.Unlock()
+
+    global.blockLock.Lock()
+    defer global.blockLock.Unlock()
+
+    global.blockRegistry = make(map[uint64]IBlock,len(snapshot.Blocks))
+    global.blockHeight = make(map[uint64]uint64)
+    for _, block := range snapshot.Blocks {
+        global.blockRegistry[block.GetHash()] = block
+    }
+
+    return nil
+}
+
 func (global *SimulationGlobalState) PutBlock(block IBlock) {
     global.blockLock.Lock()
     defer global.blockLock.Unlock()
@@ -135,11 +198,12 @@ func (global *SimulationGlobalState) PutBlock(block IBlock) {
     hash := block.GetHash()
     if oldBlock, ok := global.blockRegistry[hash]; ok {
         if oldBlock != block {
-            globalStateLogger.Warn("hash collision: old block created at %v by %v, new block created at %v by %v",oldBlock.GetTime(),oldBlock.GetCreator(),block.GetTime(),block.GetCreator())
+            global.logger.Warn("hash collision: old block created at %v by %v, new block created at %v by %v",oldBlock.GetTime(),oldBlock.GetCreator(),block.GetTime(),block.GetCreator())
         }
+        return
     } 
 
-    globalStateLogger.Debug("registering new block at %v by %v",block.GetTime(),block.GetCreator())
+    global.logger.Debug("registering new block at %v by %v",block.GetTime(),block.GetCreator())
     global.blockRegistry[hash] = block
 }
 
@@ -153,5 +217,54 @@ func (global *SimulationGlobalState) GetBlock(hash uint64) IBlock {
 
     return nil
 }
+
+func (global *SimulationGlobalState) GetNumBlocks() int {
+    global.blockLock.RLock()
+    defer global.blockLock.RUnlock()
+
+    return len(global.blockRegistry)
+}
+
+/*
+    Height of a registered block, following the parent references (see
+    GetBlockParent). A block without parent is a genesis block (height 0). The
+    heights are cached once computed.
 */
+func (global *SimulationGlobalState) GetBlockHeight(hash uint64) (uint64,bool) {
+    global.blockLock.Lock()
+    defer global.blockLock.Unlock()
+
+    // walk back until a block with known height or a genesis block
+    chain := make([]uint64,0,16)
+    var height uint64
+    current := hash
+    for {
+        if h, ok := global.blockHeight[current]; ok {
+            height = h
+            break
+        }
+
+        block, ok := global.blockRegistry[current]
+        if !ok {
+            return 0, false
+        }
 
+        chain = append(chain,current)
+        parent, ok := GetBlockParent(block)
+        if !ok {
+            global.blockHeight[current] = 0
+            height = 0
+            chain = chain[:len(chain)-1]
+            break
+        }
+        current = parent
+    }
+
+    // cache heights of the chain
+    for i := len(chain) - 1; i >= 0; i-- {
+        height++
+        global.blockHeight[chain[i]] = height
+    }
+
+    return global.blockHeight[hash], true
+}
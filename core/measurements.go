@@ -3,6 +3,12 @@ package core
 import (
     "sync"
     "blockchainlab/simulator/utils"
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
 )
 
 const (
@@ -12,23 +18,44 @@ const (
     SIMULATION_MEASUREMENT_TYPE_TAG             = 1
 
     SIMULATION_MEASUREMENT_INITIAL_SIZE         = 65535
+
+    DEFAULT_MEASUREMENTS_OUTPUT                 = ""                                // no raw output
 )
 
 // ==== interfaces ====
 
-/* 
+/*
     A measurement module takes custom measurements during and/or after the
-    simulation. Each module writes its results to a separate json file.
+    simulation. Each module writes its results to a separate json file, and
+    reads its settings from the config section named after it. Modules listed in
+    "measurements.measurement_modules" are built when the simulation is created
+    and initialized when it starts running: settings should be read from the
+    config of the simulation and events collected by registering hooks in Init,
+    while tags are forwarded by the simulation.
 */
 type ISimulationMeasurementModule interface {
-    Init(sim ISimulation)                           // initialize measurement module
-    Tag(id uint64,time float64,extra string)        //  
+    Init(sim ISimulation)                           // initialize measurement module (register hooks here)
+    Tag(id uint64,time float64,extra string)        // user-defined tag timestamped during the simulation
     GetFinalResult() interface{}                    // struct with final result of the module
     GetOutputPath() string                          // path of the output json file
+    GetName() string                                // module name (also its config section)
+}
+
+/*
+    Measurement modules can expose samples of their metrics while the
+    simulation runs, e.g. for statistical end conditions (see CIEndCondition).
+    Samples are returned in the order they were observed, and metrics are
+    referred to as "<module>.<metric>" (see GetMetric).
+*/
+type IMetricSource interface {
+    GetMetricNames() []string                       // names of the main metrics provided by the module (e.g., for help)
+    HasMetric(name string) bool                     // check if the module provides a metric (also the ones not listed)
+    GetMetric(name string) ([]float64,bool)         // samples of a metric so far (false if unknown)
 }
 
 // ==== concrete structures ====
 
+// a single raw measurement: a triggered event or a user-defined tag
 type RawMeasurementEntry struct {
     tp uint8
     id uint64
@@ -36,20 +63,52 @@ type RawMeasurementEntry struct {
     extra string
 }
 
+/*
+    Default measurement module implementation: keeps the simulation and its
+    logger, and reads the output path from "<name>.output" (unless it was set
+    explicitly). Most modules should just incorporate it.
+*/
+type DefaultMeasurementModule struct {
+    sim ISimulation
+    logger utils.ISimulationLogger
+    name string
+    outputPath string
+    outputPathSet bool
+}
+
+/*
+    Raw measurements of a simulation. Timestamps the event types and tags listed
+    in the configuration (section "measurements"), and writes all entries to the
+    output file when the simulation finishes. It also manages the measurement
+    modules, whose results are written when the simulation finishes.
+
+    Implements: IEventPreTriggerHandler
+*/
 type SimulationMeasurements struct {
     sim ISimulation
+    logger utils.ISimulationLogger
     lock sync.RWMutex
     outputPath string
     entries []RawMeasurementEntry
+    modules []ISimulationMeasurementModule
+
+    // filters
+    allEvents bool
+    eventTypes map[uint16]bool
+    allTags bool
+    tags map[uint64]bool
 }
 
 // ==== factories ====
 
 var measurementModuleRegistry map[string]func() ISimulationMeasurementModule = make(map[string]func() ISimulationMeasurementModule)
-var measurementLogger utils.ISimulationLogger
 
 func init(){
-    RegisterGlobalState(DEFAULT_GLOBAL_STATE_TAG,NewSimulationGlobalState)
+    // config
+    utils.ConfigSetDefault(SIMULATION_MEASUREMENTS_TAG + ".event_type_list",[]string{})
+    utils.ConfigSetDefault(SIMULATION_MEASUREMENTS_TAG + ".tag_list",[]string{})
+    utils.ConfigSetDefault(SIMULATION_MEASUREMENTS_TAG + ".output",DEFAULT_MEASUREMENTS_OUTPUT)
+    utils.ConfigSetDefault(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules",[]string{})
 }
 
 func RegisterMeasurementModule(key string, factory func() ISimulationMeasurementModule) {
@@ -60,18 +119,71 @@ func RegisterMeasurementModule(key string, factory func() ISimulationMeasurement
     measurementModuleRegistry[key] = factory
 }
 
-func NewSimulationMeasurements() *SimulationMeasurements {
-    if measurementLogger == nil {
-        measurementLogger = utils.GetSimulationLogger(DEFAULT_GLOBAL_STATE_TAG)
-    }
-
-    return &SimulationMeasurements{
+// measurements according to the given configuration (section "measurements"), or an error if it is invalid
+func NewSimulationMeasurements(config *utils.SimulationConfig) (*SimulationMeasurements,error) {
+    meas := &SimulationMeasurements{
         sim:                nil,
+        logger:             nil,
         lock:               sync.RWMutex{},
-        state:              make(map[string]interface{}),
-        stateLock:          sync.RWMutex{},
-        //blockRegistry:      make(map[uint64]IBlock),
-        //blockLock:          sync.RWMutex{},
+        outputPath:         "",
+        entries:            make([]RawMeasurementEntry,0,SIMULATION_MEASUREMENT_INITIAL_SIZE),
+        modules:            make([]ISimulationMeasurementModule,0,4),
+        allEvents:          false,
+        eventTypes:         make(map[uint16]bool),
+        allTags:            false,
+        tags:               make(map[uint64]bool),
+    }
+
+    meas.outputPath = config.GetString(SIMULATION_MEASUREMENTS_TAG + ".output")
+
+    // event types
+    for _, str := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".event_type_list") {
+        if str == "all" {
+            meas.allEvents = true
+            continue
+        }
+
+        tp, err := strconv.ParseUint(str,10,16)
+        if err != nil {
+            return nil, utils.NewConfigError(SIMULATION_MEASUREMENTS_TAG + ".event_type_list","invalid event type %q",str)
+        }
+        meas.eventTypes[uint16(tp)] = true
+    }
+
+    // tags
+    for _, str := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".tag_list") {
+        if str == "all" {
+            meas.allTags = true
+            continue
+        }
+
+        id, err := strconv.ParseUint(str,10,64)
+        if err != nil {
+            return nil, utils.NewConfigError(SIMULATION_MEASUREMENTS_TAG + ".tag_list","invalid tag %q",str)
+        }
+        meas.tags[id] = true
+    }
+
+    // measurement modules
+    for _, name := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules") {
+        module := NewMeasurementModuleFromRegistry(name)
+        if module == nil {
+            return nil, utils.NewConfigError(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules","no %s factory registered for %q",REGISTRY_MEASUREMENT_MODULE,name)
+        }
+        meas.AddModule(module)
+    }
+
+    return meas, nil
+}
+
+// factory for DefaultMeasurementModule: the output path is read from section 'name' in Init
+func NewDefaultMeasurementModule(name string) DefaultMeasurementModule {
+    return DefaultMeasurementModule{
+        sim:            nil,
+        logger:         nil,
+        name:           name,
+        outputPath:     "",
+        outputPathSet:  false,
     }
 }
 
@@ -85,4 +197,335 @@ func NewMeasurementModuleFromRegistry(key string) ISimulationMeasurementModule {
 
 // ==== methods ====
 
+// hook into the event types listed in the config
+func (meas *SimulationMeasurements) Init(sim ISimulation) {
+    meas.sim = sim
+    meas.logger = sim.GetLogger(SIMULATION_MEASUREMENTS_TAG)
+
+    // hooks
+    hooks := sim.GetHooks()
+    if meas.allEvents {
+        hooks.RegisterPreTriggerAll(meas)
+    } else {
+        for tp := range meas.eventTypes {
+            hooks.RegisterPreTrigger(tp,meas)
+        }
+    }
+
+    meas.logger.Debug("initializing: %d event types (all=%v), %d tags (all=%v)",len(meas.eventTypes),meas.allEvents,len(meas.tags),meas.allTags)
+
+    // measurement modules
+    for _, module := range meas.modules {
+        meas.logger.Debug("initializing module %s",module.GetName())
+        module.Init(sim)
+    }
+}
+
+/*
+    Write raw measurements to the output file (if set, and some events or tags
+    are measured) and the final result of each module to its json file. Every
+    output is attempted, and the first error is returned.
+*/
+func (meas *SimulationMeasurements) Finish() error {
+    var firstErr error = nil
+
+    if meas.outputPath != "" && meas.isFiltering() {
+        meas.logger.Debug("writing %d raw measurements to %s",meas.GetNumEntries(),meas.outputPath)
+        firstErr = meas.Write(meas.outputPath)
+    }
+
+    for _, module := range meas.modules {
+        err := meas.writeModuleResult(module)
+        if err != nil {
+            meas.logger.Error("cannot write results of module %s: %v",module.GetName(),err)
+            if firstErr == nil {
+                firstErr = err
+            }
+        }
+    }
+
+    return firstErr
+}
+
+// add a measurement module: it must be added before the simulation starts
+func (meas *SimulationMeasurements) AddModule(module ISimulationMeasurementModule) *SimulationMeasurements {
+    meas.lock.Lock()
+    defer meas.lock.Unlock()
+
+    meas.modules = append(meas.modules,module)
+    return meas
+}
+
+/*
+    Timestamp a user-defined tag at the current simulation time. The tag is
+    forwarded to all modules, and recorded as a raw measurement if it passes the
+    tag filter.
+*/
+func (meas *SimulationMeasurements) Tag(id uint64,extra string) {
+    time := meas.sim.GetTime()
+    for _, module := range meas.modules {
+        module.Tag(id,time,extra)
+    }
+
+    if !meas.allTags && !meas.tags[id] {
+        return
+    }
+
+    meas.addEntry(SIMULATION_MEASUREMENT_TYPE_TAG,id,time,extra)
+}
+
+// timestamp an event right before it is triggered
+func (meas *SimulationMeasurements) EventPreTrigger(ev utils.IEvent) {
+    meas.addEntry(SIMULATION_MEASUREMENT_TYPE_EVENT,uint64(ev.GetType()),ev.GetTime(),describeDestination(ev.GetDestination()))
+}
+
+// whether any event type or tag is measured
+func (meas *SimulationMeasurements) isFiltering() bool {
+    return meas.allEvents || meas.allTags || len(meas.eventTypes) > 0 || len(meas.tags) > 0
+}
+
+func (meas *SimulationMeasurements) addEntry(tp uint8,id uint64,time float64,extra string) {
+    meas.lock.Lock()
+    defer meas.lock.Unlock()
+
+    meas.entries = append(meas.entries,RawMeasurementEntry{
+        tp:         tp,
+        id:         id,
+        time:       time,
+        extra:      extra,
+    })
+}
+
+/*
+    Write all raw measurements to the given file, one entry per line. Each line
+    has tab-separated fields: type (0: event, 1: tag), id (event type or tag
+    id), simulation time, and extra information.
+*/
+func (meas *SimulationMeasurements) Write(path string) error {
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    writer := bufio.NewWriter(file)
+
+    meas.lock.RLock()
+    for _, entry := range meas.entries {
+        _, err = fmt.Fprintf(writer,"%d\t%d\t%v\t%s\n",entry.tp,entry.id,entry.time,entry.extra)
+        if err != nil {
+            meas.lock.RUnlock()
+            return err
+        }
+    }
+    meas.lock.RUnlock()
+
+    return writer.Flush()
+}
+
+// exported fields of RawMeasurementEntry for snapshots
+type rawMeasurementEntryGob struct {
+    Type uint8
+    ID uint64
+    Time float64
+    Extra string
+}
+
+// implements ISnapshotable: raw entries (modules take their own snapshots)
+func (meas *SimulationMeasurements) Snapshot() ([]byte,error) {
+    meas.lock.RLock()
+    defer meas.lock.RUnlock()
+
+    entries := make([]rawMeasurementEntryGob,len(meas.entries))
+    for i, entry := range meas.entries {
+        entries[i] = rawMeasurementEntryGob{entry.tp,entry.id,entry.time,entry.extra}
+    }
+
+    return EncodeSnapshot(entries)
+}
 
+// implements ISnapshotable
+func (meas *SimulationMeasurements) Restore(data []byte) error {
+    entries := make([]rawMeasurementEntryGob,0)
+    if err := DecodeSnapshot(data,&entries); err != nil {
+        return err
+    }
+
+    meas.lock.Lock()
+    defer meas.lock.Unlock()
+
+    meas.entries = make([]RawMeasurementEntry,len(entries),len(entries) + SIMULATION_MEASUREMENT_INITIAL_SIZE)
+    for i, entry := range entries {
+        meas.entries[i] = RawMeasurementEntry{entry.Type,entry.ID,entry.Time,entry.Extra}
+    }
+
+    return nil
+}
+
+// marshal the final result of a module to its json file
+func (meas *SimulationMeasurements) writeModuleResult(module ISimulationMeasurementModule) error {
+    path := module.GetOutputPath()
+    if path == "" {
+        return nil
+    }
+
+    data, err := json.MarshalIndent(module.GetFinalResult(),"","    ")
+    if err != nil {
+        return err
+    }
+
+    meas.logger.Debug("writing results of module %s to %s",module.GetName(),path)
+    return os.WriteFile(path,data,0644)
+}
+
+func (module *DefaultMeasurementModule) Init(sim ISimulation) {
+    module.sim = sim
+    module.logger = sim.GetLogger(module.name)
+    if !module.outputPathSet {
+        module.outputPath = sim.GetConfig().GetString(module.name + ".output")
+    }
+}
+
+func (module *DefaultMeasurementModule) Tag(id uint64,time float64,extra string) {
+}
+
+// human-readable identification of an event destination
+func describeDestination(dest utils.IEventDestination) string {
+    switch d := dest.(type) {
+    case nil:
+        return ""
+    case INode:
+        return fmt.Sprintf("%s:%d",d.GetName(),d.GetID())
+    case INodeNetwork:
+        if node := d.GetNode(); node != nil {
+            return fmt.Sprintf("%s:%d",d.GetName(),node.GetID())
+        }
+        return d.GetName()
+    case ISimulationComponent:
+        return d.GetName()
+    case ISimulation:
+        return d.GetName()
+    }
+
+    return fmt.Sprintf("%T",dest)
+}
+
+// ==== getters ====
+
+// copy of all raw measurements taken so far
+func (meas *SimulationMeasurements) GetEntries() []RawMeasurementEntry {
+    meas.lock.RLock()
+    defer meas.lock.RUnlock()
+
+    entries := make([]RawMeasurementEntry,len(meas.entries))
+    copy(entries,meas.entries)
+    return entries
+}
+
+func (meas *SimulationMeasurements) GetNumEntries() int {
+    meas.lock.RLock()
+    defer meas.lock.RUnlock()
+
+    return len(meas.entries)
+}
+
+func (meas *SimulationMeasurements) GetOutputPath() string {
+    return meas.outputPath
+}
+
+func (meas *SimulationMeasurements) GetModules() []ISimulationMeasurementModule {
+    meas.lock.RLock()
+    defer meas.lock.RUnlock()
+
+    modules := make([]ISimulationMeasurementModule,len(meas.modules))
+    copy(modules,meas.modules)
+    return modules
+}
+
+// returns the module with the given name, or nil if it is not included
+func (meas *SimulationMeasurements) GetModule(name string) ISimulationMeasurementModule {
+    meas.lock.RLock()
+    defer meas.lock.RUnlock()
+
+    for _, module := range meas.modules {
+        if module.GetName() == name {
+            return module
+        }
+    }
+
+    return nil
+}
+
+/*
+    Samples of a metric in the form "<module>.<metric>" (e.g.,
+    "block_propagation.coverage90"). The module must be included in the
+    simulation and implement IMetricSource.
+*/
+func (meas *SimulationMeasurements) GetMetric(name string) ([]float64,error) {
+    dot := strings.Index(name,".")
+    if dot <= 0 {
+        return nil, fmt.Errorf("invalid metric %q: expected <module>.<metric>",name)
+    }
+
+    module := meas.GetModule(name[:dot])
+    if module == nil {
+        return nil, fmt.Errorf("invalid metric %q: measurement module %s is not included in %s.measurement_modules",name,name[:dot],SIMULATION_MEASUREMENTS_TAG)
+    }
+
+    source, ok := module.(IMetricSource)
+    if !ok {
+        return nil, fmt.Errorf("invalid metric %q: measurement module %s does not provide metrics",name,name[:dot])
+    }
+
+    samples, ok := source.GetMetric(name[dot+1:])
+    if !ok {
+        return nil, fmt.Errorf("invalid metric %q: available metrics of %s are %v",name,name[:dot],source.GetMetricNames())
+    }
+
+    return samples, nil
+}
+
+func (module *DefaultMeasurementModule) GetSimulation() ISimulation {
+    return module.sim
+}
+
+// logger of the module (tagged with its name), available after Init
+func (module *DefaultMeasurementModule) GetLogger() utils.ISimulationLogger {
+    return module.logger
+}
+
+func (module *DefaultMeasurementModule) GetName() string {
+    return module.name
+}
+
+func (module *DefaultMeasurementModule) GetOutputPath() string {
+    return module.outputPath
+}
+
+func (entry RawMeasurementEntry) GetType() uint8 {
+    return entry.tp
+}
+
+func (entry RawMeasurementEntry) GetID() uint64 {
+    return entry.id
+}
+
+func (entry RawMeasurementEntry) GetTime() float64 {
+    return entry.time
+}
+
+func (entry RawMeasurementEntry) GetExtra() string {
+    return entry.extra
+}
+
+// ==== setters ====
+
+func (meas *SimulationMeasurements) SetOutputPath(path string) *SimulationMeasurements {
+    meas.outputPath = path
+    return meas
+}
+
+func (module *DefaultMeasurementModule) SetOutputPath(path string) {
+    module.outputPath = path
+    module.outputPathSet = true
+}
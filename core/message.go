@@ -19,7 +19,7 @@ const (
 // A network message sent from a node to one or more nodes
 type IMessage interface {
     GetData() interface{}                   // message data (receiver must cast to correct type)
-    GetSize() uint64                        // size of the message
+    GetSize() uint64                        // size of the message in bytes
     GetSender() uint32                      // node id of the sender
     GetDelivery() IMessageDelivery          // delivery mode
     GetTag() int32                          // custom tag
@@ -121,11 +121,20 @@ func NewP2PMessageNodeTypesExcept(data interface{},sender uint32,nodes []uint32)
     return NewMessage(data,sender,delivery)
 }
 
-// complete factory for DefaultMessage
+/*
+    Complete factory for DefaultMessage. The size of the message is the size
+    of its data for blocks and transactions (ILedgerElement.GetSize), and the
+    size of the type of the data otherwise; it can be changed with SetSize.
+*/
 func NewMessage(data interface{},sender uint32,delivery IMessageDelivery) IMessage {
+    size := uint64(reflect.TypeOf(data).Size())
+    if element, ok := data.(ILedgerElement); ok {
+        size = element.GetSize()
+    }
+
     return &DefaultMessage{
         data:           data,
-        size:           uint64(reflect.TypeOf(data).Size()),
+        size:           size,
         sender:         sender,
         delivery:       delivery,
         tag:            0, // optional
@@ -141,6 +150,84 @@ func NewMessageDelivery(tp uint16,targets []uint32) IMessageDelivery {
     }
 }
 
+// ==== functions ====
+
+// check if the delivery type is broadcast (otherwise it is p2p)
+func IsBroadcastDeliveryType(tp uint16) bool {
+    switch tp {
+    case MESSAGE_DELIVERY_TYPE_BROADCAST_NODES,MESSAGE_DELIVERY_TYPE_BROADCAST_NODE_TYPES,MESSAGE_DELIVERY_TYPE_BROADCAST_NODE_TYPES_EXCEPT:
+        return true
+    }
+
+    return false
+}
+
+// ==== methods ====
+
+// exported fields of DefaultMessage for encoding/gob (checkpoints)
+type defaultMessageGob struct {
+    Data interface{}
+    Size uint64
+    Sender uint32
+    Delivery IMessageDelivery
+    Tag int32
+    Time float64
+}
+
+// exported fields of DefaultDelivery for encoding/gob (checkpoints)
+type defaultDeliveryGob struct {
+    Type uint16
+    Targets []uint32
+}
+
+// implements gob.GobEncoder: the concrete type of the data must be registered with gob.Register
+func (msg *DefaultMessage) GobEncode() ([]byte,error) {
+    return EncodeSnapshot(defaultMessageGob{
+        Data:       msg.data,
+        Size:       msg.size,
+        Sender:     msg.sender,
+        Delivery:   msg.delivery,
+        Tag:        msg.tag,
+        Time:       msg.time,
+    })
+}
+
+// implements gob.GobDecoder
+func (msg *DefaultMessage) GobDecode(data []byte) error {
+    decoded := defaultMessageGob{}
+    if err := DecodeSnapshot(data,&decoded); err != nil {
+        return err
+    }
+
+    msg.data = decoded.Data
+    msg.size = decoded.Size
+    msg.sender = decoded.Sender
+    msg.delivery = decoded.Delivery
+    msg.tag = decoded.Tag
+    msg.time = decoded.Time
+    return nil
+}
+
+// implements gob.GobEncoder
+func (del *DefaultDelivery) GobEncode() ([]byte,error) {
+    return EncodeSnapshot(defaultDeliveryGob{
+        Type:       del.tp,
+        Targets:    del.targets,
+    })
+}
+
+// implements gob.GobDecoder
+func (del *DefaultDelivery) GobDecode(data []byte) error {
+    decoded := defaultDeliveryGob{}
+    if err := DecodeSnapshot(data,&decoded); err != nil {
+        return err
+    }
+
+    del.tp = decoded.Type
+    del.targets = decoded.Targets
+    return nil
+}
+
 // ==== getters ====
 
 func (msg *DefaultMessage) GetData() interface{} {
@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // node types
 const (
     NODE_TYPE_FULL                  = 0 // full node
@@ -65,9 +69,11 @@ func RegisterNode(key string, factory func() INode) {
     nodeRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewNodeFromRegistry(key string) INode {
-    if factory, ok := nodeRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := nodeRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil 
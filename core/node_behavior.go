@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // ==== interfaces ====
 
 // behavior of a node
@@ -22,9 +26,11 @@ func RegisterNodeBehavior(key string, factory func() INodeBehavior) {
     behaviorRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewNodeBehaviorFromRegistry(key string) INodeBehavior {
-    if factory, ok := behaviorRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := behaviorRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil 
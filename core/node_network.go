@@ -1,5 +1,9 @@
 package core
 
+import (
+    "blockchainlab/simulator/utils"
+)
+
 // ==== interfaces ====
 
 /* 
@@ -21,6 +25,7 @@ type INodeNetwork interface {
     IsConnected() bool
 
     GetGlobalNetwork() IGlobalNetwork
+    GetNode() INode
     
     AddNeighbor(nodeID uint32)
     RemoveNeighbor(nodeID uint32)
@@ -41,9 +46,11 @@ func RegisterNodeNetwork(key string, factory func() INodeNetwork) {
     nodeNetworkRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewNodeNetworkFromRegistry(key string) INodeNetwork {
-    if factory, ok := nodeNetworkRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := nodeNetworkRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }   
 
     return nil 
@@ -89,6 +89,18 @@ type ILedgerElement interface {
     Verify() bool
 }
 
+// ==== functions ====
+
+// hash of the parent of a block: its first standard reference (false if there is none, e.g. genesis)
+func GetBlockParent(block IBlock) (uint64,bool) {
+    refs := block.GetReferences()[BREF_STANDARD]
+    if len(refs) == 0 {
+        return 0, false
+    }
+
+    return refs[0], true
+}
+
 // ==== factories ====
 
 var nodeStorageRegistry map[string]func() INodeStorage = make(map[string]func() INodeStorage)
@@ -101,9 +113,11 @@ func RegisterNodeStorage(key string, factory func() INodeStorage) {
     nodeStorageRegistry[key] = factory
 }
 
+// key: "<name>", or "<name>@<instance>" to read the instance section of the config (see IConfigInstance)
 func NewNodeStorageFromRegistry(key string) INodeStorage {
-    if factory, ok := nodeStorageRegistry[key]; ok {
-        return factory()
+    name, instance := utils.SplitConfigInstance(key)
+    if factory, ok := nodeStorageRegistry[name]; ok {
+        return withConfigInstance(factory(),instance)
     }
 
     return nil 
@@ -0,0 +1,99 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "sort"
+)
+
+// kinds of factory registries (see GetRegistryKeys)
+const (
+    REGISTRY_NODE                               = "node"
+    REGISTRY_NODE_NETWORK                       = "node_network"
+    REGISTRY_NODE_BEHAVIOR                      = "node_behavior"
+    REGISTRY_NODE_STORAGE                       = "node_storage"
+    REGISTRY_CONSENSUS                          = "consensus"
+    REGISTRY_APPLICATION                        = "application"
+    REGISTRY_GLOBAL_NETWORK                     = "global_network"
+    REGISTRY_GLOBAL_STATE                       = "global_state"
+    REGISTRY_END_CONDITION                      = "end_condition"
+    REGISTRY_MEASUREMENT_MODULE                 = "measurement_module"
+)
+
+var REGISTRY_KINDS                              = []string{
+    REGISTRY_NODE,
+    REGISTRY_NODE_NETWORK,
+    REGISTRY_NODE_BEHAVIOR,
+    REGISTRY_NODE_STORAGE,
+    REGISTRY_CONSENSUS,
+    REGISTRY_APPLICATION,
+    REGISTRY_GLOBAL_NETWORK,
+    REGISTRY_GLOBAL_STATE,
+    REGISTRY_END_CONDITION,
+    REGISTRY_MEASUREMENT_MODULE,
+}
+
+// ==== functions ====
+
+/*
+    Sorted names registered for a kind of component (nil for unknown kinds).
+    Factories are registered in init functions (of the simulator packages or of
+    plugins loaded before creating the simulation), so registries are
+    read-only once the simulation starts.
+*/
+func GetRegistryKeys(kind string) []string {
+    switch kind {
+    case REGISTRY_NODE:
+        return sortedRegistryKeys(nodeRegistry)
+    case REGISTRY_NODE_NETWORK:
+        return sortedRegistryKeys(nodeNetworkRegistry)
+    case REGISTRY_NODE_BEHAVIOR:
+        return sortedRegistryKeys(behaviorRegistry)
+    case REGISTRY_NODE_STORAGE:
+        return sortedRegistryKeys(nodeStorageRegistry)
+    case REGISTRY_CONSENSUS:
+        return sortedRegistryKeys(consensusRegistry)
+    case REGISTRY_APPLICATION:
+        return sortedRegistryKeys(applicationRegistry)
+    case REGISTRY_GLOBAL_NETWORK:
+        return sortedRegistryKeys(globalNetworkRegistry)
+    case REGISTRY_GLOBAL_STATE:
+        return sortedRegistryKeys(globalStateRegistry)
+    case REGISTRY_END_CONDITION:
+        return sortedRegistryKeys(endConditionRegistry)
+    case REGISTRY_MEASUREMENT_MODULE:
+        return sortedRegistryKeys(measurementModuleRegistry)
+    }
+
+    return nil
+}
+
+// check if a factory is registered with the given name for a kind of component ("<name>@<instance>" is accepted)
+func IsRegistered(kind string,key string) bool {
+    name, _ := utils.SplitConfigInstance(key)
+    for _, registered := range GetRegistryKeys(kind) {
+        if registered == name {
+            return true
+        }
+    }
+
+    return false
+}
+
+func sortedRegistryKeys[F any](registry map[string]F) []string {
+    keys := make([]string,0,len(registry))
+    for key := range registry {
+        keys = append(keys,key)
+    }
+    sort.Strings(keys)
+
+    return keys
+}
+
+// set the instance of a component created from a registry, if it has one (see IConfigInstance)
+func withConfigInstance[C any](component C,instance string) C {
+    if configurable, ok := any(component).(IConfigInstance); ok && instance != "" {
+        configurable.SetConfigInstance(instance)
+    }
+
+    return component
+}
@@ -6,12 +6,22 @@ import (
     "errors"
     "fmt"
     "math/rand"
+    "sort"
 )
 
 const (
     SIMULATION_TAG              = "simulation"
     DEFAULT_SIMULATION_NAME     = "defaultSim"
     DEFAULT_SEED                = 0
+    DEFAULT_ENGINE              = ENGINE_SEQUENTIAL
+    DEFAULT_BATCH_WINDOW        = 0.0
+    DEFAULT_BATCH_SIZE          = 1000
+    DEFAULT_WORKERS             = 0
+    DEFAULT_CHECKPOINT_OUTPUT   = "checkpoint-" + CHECKPOINT_TIME_PLACEHOLDER + ".gob"
+    DEFAULT_RESUME              = ""
+
+    ENGINE_SEQUENTIAL           = "sequential"
+    ENGINE_PARALLEL             = "parallel"
 )
 
 // ==== interfaces ====
@@ -28,6 +38,12 @@ type ISimulation interface {
     AddNode(node INode) ISimulation                             // add a node to the simulation
     RemoveNode(node_id uint32) error                            // remove a node from the simulation
     ScheduleEvent(event utils.IEvent,delay float64)             // schedule an event
+    // schedule an event that can be cancelled or reset, or that recurs every period (+/- jitter)
+    ScheduleTimer(event utils.IEvent,delay float64) utils.ITimer
+    SchedulePeriodic(event utils.IEvent,delay float64,period float64,jitter float64) utils.ITimer
+    GetTimer(id uint64) utils.ITimer                            // pending or recurring timer with the given id (nil if none)
+    ScheduleCheckpoint(delay float64,path string)               // write a checkpoint after the given delay
+    Checkpoint(path string) error                               // write a checkpoint now (only between events)
 
     GetGlobalNetwork() IGlobalNetwork                           // get the global network for the simulation
     GetGlobalState() ISimulationGlobalState                     // get the global state
@@ -36,13 +52,21 @@ type ISimulation interface {
     GetNumNodes() uint32                                        // get the number of nodes in the simulation
     GetNode(node_id uint32) INode                               // get the node with the given id
     GetHooks() *utils.SimulationHooks                           // get hook manager
+    GetMeasurements() *SimulationMeasurements                   // get raw measurements
+    GetConfig() *utils.SimulationConfig                         // get the configuration of the simulation
+    GetLogger(tag string) utils.ISimulationLogger               // get a logger of the simulation for the given tag
+    GetTrace() ISimulationTrace                                 // get the event trace (nil if disabled)
     GetTime() float64                                           // get simulation time
+    GetLastActivity() float64                                   // get time of the last event not scheduled by a timer
     GetName() string                                            // get simulation name
     GetRNG() *rand.Rand                                         // get random number generator
 
     SetGlobalNetwork(net IGlobalNetwork) ISimulation            // set the global network for the simulation
     SetGlobalState(state ISimulationGlobalState) ISimulation    // set the global network for the simulation
     SetEndCondition(end IEndCondition) ISimulation              // set the simulation end condition
+    SetResume(path string) ISimulation                          // resume from the given checkpoint when running ("" to start from zero)
+    SetTrace(trace ISimulationTrace) ISimulation                // record or verify a trace of events (nil to disable)
+    SetDebugger(debugger *SimulationDebugger) ISimulation       // pause on breakpoints before events (nil to disable)
 }
 
 // ==== concrete structures  ====
@@ -55,16 +79,30 @@ type ISimulation interface {
 */
 type Simulation struct {
     evSimulation utils.IEventSimulation
+    config *utils.SimulationConfig
+    loggers *utils.SimulationLoggers
+    logger utils.ISimulationLogger
     network IGlobalNetwork
     state ISimulationGlobalState
+    measurements *SimulationMeasurements
+    trace ISimulationTrace
+    debugger *SimulationDebugger
     nodeMap map[uint32]INode
     running bool
     endCondition IEndCondition
     name string
     rng *rand.Rand
+    rngSource *utils.LockedSource
+
+    // checkpoints
+    resumePath string
+    checkpointTimes []float64
+    checkpointOutput string
+    pendingCheckpoints []string
     
     nodeMapLock sync.RWMutex
     runningLock sync.RWMutex
+    checkpointLock sync.Mutex
 }
 
 // ==== factories ====
@@ -73,30 +111,128 @@ func init(){
     // config
     utils.ConfigSetDefault(SIMULATION_TAG + ".name", DEFAULT_SIMULATION_NAME)
     utils.ConfigSetDefault(SIMULATION_TAG + ".seed", DEFAULT_SEED)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".engine", DEFAULT_ENGINE)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".batch_window", DEFAULT_BATCH_WINDOW)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".batch_size", DEFAULT_BATCH_SIZE)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".workers", DEFAULT_WORKERS)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".checkpoint_times", []float64{})
+    utils.ConfigSetDefault(SIMULATION_TAG + ".checkpoint_output", DEFAULT_CHECKPOINT_OUTPUT)
+    utils.ConfigSetDefault(SIMULATION_TAG + ".resume", DEFAULT_RESUME)
 }
 
-var simLogger utils.ISimulationLogger
+// basic factory for Simulation, with the default configuration
+func NewSimulation() (ISimulation,error) {
+    return NewSimulationWithConfig(utils.NewSimulationConfig())
+}
 
-// basic factory for Simulation 
-func NewSimulation() ISimulation {
-    if simLogger == nil {
-        simLogger = utils.GetSimulationLogger(SIMULATION_TAG)
-    }
+/*
+    Factory for Simulation with its own configuration: config, loggers and
+    measurements are not shared with other simulations of the process, and
+    components get them through the simulation (GetConfig and GetLogger).
+    Returns a utils.ConfigError if a setting read here is invalid (see
+    ValidateSimulationConfig to get all of them at once).
+*/
+func NewSimulationWithConfig(config *utils.SimulationConfig) (ISimulation,error) {
+    loggers := utils.NewSimulationLoggers(config)
+    logger := loggers.Get(SIMULATION_TAG)
 
-    config := utils.GetSimulationConfig()
     seed := config.GetInt64(SIMULATION_TAG + ".seed")
+    rngSource := utils.NewLockedSource(seed)
+    rng := rand.New(rngSource)
+    evSimulation, err := newEventSimulation(config,logger)
+    if err != nil {
+        return nil, err
+    }
+    evSimulation.SetRNG(rng)
+
+    measurements, err := NewSimulationMeasurements(config)
+    if err != nil {
+        return nil, err
+    }
+    trace, err := NewSimulationTraceFromConfig(config)
+    if err != nil {
+        return nil, err
+    }
+    debugger, err := NewSimulationDebuggerFromConfig(config)
+    if err != nil {
+        return nil, err
+    }
+
     return &Simulation {
-        evSimulation:   utils.NewEventSimulation(),
+        evSimulation:   evSimulation,
+        config:         config,
+        loggers:        loggers,
+        logger:         logger,
         nodeMap:        make(map[uint32]INode),
         network:        nil,
         state:          nil,
+        measurements:   measurements,
+        trace:          trace,
+        debugger:       debugger,
         running:        false,
         endCondition:   nil,
         nodeMapLock:    sync.RWMutex{},
         runningLock:    sync.RWMutex{},
         name:           config.GetString(SIMULATION_TAG + ".name"),
-        rng:            rand.New(rand.NewSource(seed)),
+        rng:            rng,
+        rngSource:      rngSource,
+        resumePath:         config.GetString(SIMULATION_TAG + ".resume"),
+        checkpointTimes:    config.GetFloat64Slice(SIMULATION_TAG + ".checkpoint_times"),
+        checkpointOutput:   config.GetString(SIMULATION_TAG + ".checkpoint_output"),
+        pendingCheckpoints: make([]string,0,1),
+        checkpointLock:     sync.Mutex{},
+    }, nil
+}
+
+// build the event simulation engine according to configuration
+func newEventSimulation(config *utils.SimulationConfig,logger utils.ISimulationLogger) (utils.IEventSimulation,error) {
+    engine := config.GetString(SIMULATION_TAG + ".engine")
+    switch engine {
+    case ENGINE_SEQUENTIAL:
+        return utils.NewEventSimulation(), nil
+    case ENGINE_PARALLEL:
+        batchWindow := config.GetFloat64(SIMULATION_TAG + ".batch_window")
+        batchSize := config.GetInt(SIMULATION_TAG + ".batch_size")
+        if batchWindow < 0 {
+            return nil, utils.NewConfigError(SIMULATION_TAG + ".batch_window","must not be negative")
+        }
+        if batchSize < 1 {
+            return nil, utils.NewConfigError(SIMULATION_TAG + ".batch_size","must be positive")
+        }
+
+        logger.Info("parallel engine with batch window %v and batch size %d",batchWindow,batchSize)
+        return utils.NewParallelEventSimulation(batchWindow,batchSize,config.GetInt(SIMULATION_TAG + ".workers"),eventGroup), nil
+    }
+
+    return nil, utils.NewConfigError(SIMULATION_TAG + ".engine","engine %q not supported (%q or %q)",engine,ENGINE_SEQUENTIAL,ENGINE_PARALLEL)
+}
+
+/*
+    Group of an event in the parallel engine: events targeting the same node,
+    or any component that knows its node (e.g. INodeNetwork), are handled
+    sequentially. Other events are grouped by destination.
+*/
+func eventGroup(event utils.IEvent) interface{} {
+    dest := event.GetDestination()
+    if nodeID, ok := destinationNodeID(dest); ok {
+        return nodeID
     }
+
+    return dest
+}
+
+// id of the node of an event destination: a node, or a component that knows its node
+func destinationNodeID(dest utils.IEventDestination) (uint32,bool) {
+    switch d := dest.(type) {
+    case INode:
+        return d.GetID(), true
+    case interface{ GetNode() INode }:
+        if node := d.GetNode(); node != nil {
+            return node.GetID(), true
+        }
+    }
+
+    return 0, false
 }
 
 // ==== methods ====
@@ -105,14 +241,22 @@ func NewSimulation() ISimulation {
 func (sim *Simulation) Run() error {
     // check if everything is set, otherwise log and return an error
     if sim.GetEndCondition() == nil {
-        simLogger.Error("end condition is not set")    
+        sim.logger.Error("end condition is not set")    
         return errors.New("end condition is not set")
     } else if sim.GetGlobalNetwork() == nil {
-        simLogger.Error("global network is not set")
+        sim.logger.Error("global network is not set")
         return errors.New("global network is not set")
     }
 
-    simLogger.Info("starting simulation %s with %d nodes",sim.GetName(),sim.GetNumNodes())
+    sim.logger.Info("starting simulation %s with %d nodes",sim.GetName(),sim.GetNumNodes())
+
+    // initialize trace before the first event is scheduled
+    if sim.trace != nil {
+        if err := sim.trace.Init(sim); err != nil {
+            sim.logger.Error("cannot initialize trace: %v",err)
+            return err
+        }
+    }
 
     // initialize components by scheduling init events
     // nodes are expected to connect to the global network and create the first non-init events
@@ -124,24 +268,60 @@ func (sim *Simulation) Run() error {
         sim.state.Init(sim)
     }
 
+    // initialize measurements
+    sim.measurements.Init(sim)
+
+    // initialize end condition (e.g., to register hooks)
+    if sim.endCondition != nil {
+        sim.endCondition.Init(sim)
+    }
+
     // initialize global network
     sim.ScheduleEvent(utils.NewEvent(GLOBAL_NETWORK_EVENT_INIT,sim,sim.GetGlobalNetwork()),0)
 
     // initialize nodes
     args := []interface{}{sim,sim.GetGlobalNetwork()}
-    for _, node := range sim.nodeMap {
-        sim.ScheduleEvent(utils.NewEvent(NODE_EVENT_INIT,args,node),0)
+    for _, nodeID := range sim.getNodeIDs() {
+        sim.ScheduleEvent(utils.NewEvent(NODE_EVENT_INIT,args,sim.nodeMap[nodeID]),0)
     }
     
     sim.running = true
     sim.nodeMapLock.RUnlock()
     sim.runningLock.Unlock()
 
+    // resume from checkpoint
+    if sim.resumePath != "" {
+        if err := sim.resume(); err != nil {
+            sim.logger.Error("cannot resume from %s: %v",sim.resumePath,err)
+            return err
+        }
+    }
+
+    // checkpoints requested in the config (only the ones after the current time)
+    for _, time := range sim.checkpointTimes {
+        if delay := time - sim.GetTime(); delay >= 0 {
+            sim.ScheduleCheckpoint(delay,CheckpointPath(sim.checkpointOutput,time))
+        }
+    }
+
+    if sim.debugger != nil {
+        sim.debugger.Init(sim)
+    }
+
     // main loop
     var eventProcessed bool
     for sim.IsRunning() {
+        // pause on breakpoints (quitting the debugger stops before the next step)
+        if sim.debugger != nil {
+            sim.debugger.BeforeStep()
+            if !sim.IsRunning() {
+                break
+            }
+        }
+
         // advance simulation
         eventProcessed = sim.evSimulation.Step()
+        sim.writePendingCheckpoints()
 
         /*
             Check if simulation reached the end. It will continue only if all of the conditions below are true:
@@ -159,17 +339,83 @@ func (sim *Simulation) Run() error {
         sim.state.Finish()
     }
 
-    simLogger.Info("simulation %s finished",sim.GetName())
-    simLogger.Sync()
-    return nil
+    // write raw measurements
+    err := sim.measurements.Finish()
+    if err != nil {
+        sim.logger.Error("cannot write measurements: %v",err)
+    }
+
+    // finish trace: a divergence is returned as an error (see TraceDivergence)
+    if sim.trace != nil {
+        if traceErr := sim.trace.Finish(); traceErr != nil && err == nil {
+            err = traceErr
+        }
+    }
+
+    sim.logger.Info("simulation %s finished",sim.GetName())
+    sim.loggers.Sync()
+    return err
+}
+
+/*
+    Resume from the checkpoint file set with SetResume (or "simulation.resume").
+    Components are initialized as usual: all events at time 0 (init events and
+    the events they schedule without delay) are handled, so components can
+    connect to each other. Then the event queue is replaced by the one in the
+    checkpoint, and the components that support snapshots are restored.
+*/
+func (sim *Simulation) resume() error {
+    checkpoint, err := ReadCheckpoint(sim.resumePath)
+    if err != nil {
+        return err
+    }
+
+    for {
+        _, time, ok := sim.evSimulation.Peek()
+        if !ok || time > 0 {
+            break
+        }
+        sim.evSimulation.Step()
+    }
+
+    return sim.restoreCheckpoint(checkpoint)
+}
+
+// write checkpoints requested by events handled in the last step
+func (sim *Simulation) writePendingCheckpoints() {
+    sim.checkpointLock.Lock()
+    pending := sim.pendingCheckpoints
+    sim.pendingCheckpoints = make([]string,0,1)
+    sim.checkpointLock.Unlock()
+
+    for _, path := range pending {
+        if err := sim.Checkpoint(path); err != nil {
+            sim.logger.Error("cannot write checkpoint %s: %v",path,err)
+        }
+    }
+}
+
+// write a checkpoint after the given delay (between events, see Checkpoint)
+func (sim *Simulation) ScheduleCheckpoint(delay float64,path string) {
+    sim.ScheduleEvent(utils.NewEvent(SIMULATION_EVENT_CHECKPOINT,path,sim),delay)
 }
 
 // request simulation to stop
 func (sim *Simulation) Stop(){
-    simLogger.Info("requesting simulation %s to stop",sim.GetName())
+    sim.logger.Info("requesting simulation %s to stop",sim.GetName())
     sim.ScheduleEvent(utils.NewEvent(SIMULATION_EVENT_STOP,nil,sim),0)
 }
 
+// stop the main loop before the next step, without handling the pending events (unlike Stop)
+func (sim *Simulation) halt() {
+    sim.logger.Info("halting simulation %s",sim.GetName())
+
+    sim.runningLock.Lock()
+    defer sim.runningLock.Unlock()
+
+    sim.running = false
+}
+
 // add a new node to the simulation
 func (sim *Simulation) AddNode(node INode) ISimulation {
     // mutex for concurrent access to the node map
@@ -206,6 +452,19 @@ func (sim *Simulation) ScheduleEvent(event utils.IEvent,delay float64) {
     sim.evSimulation.Schedule(event,delay)
 }
 
+func (sim *Simulation) ScheduleTimer(event utils.IEvent,delay float64) utils.ITimer {
+    return sim.evSimulation.ScheduleTimer(event,delay)
+}
+
+func (sim *Simulation) SchedulePeriodic(event utils.IEvent,delay float64,period float64,jitter float64) utils.ITimer {
+    return sim.evSimulation.SchedulePeriodic(event,delay,period,jitter)
+}
+
+// timer with the given id, e.g. to get a handle back after resuming from a checkpoint (see ISnapshotable)
+func (sim *Simulation) GetTimer(id uint64) utils.ITimer {
+    return sim.evSimulation.GetTimer(id)
+}
+
 func (sim *Simulation) HandleEvent(event utils.IEvent) bool {
     switch event.GetType() {
     case SIMULATION_EVENT_STOP:
@@ -219,6 +478,12 @@ func (sim *Simulation) HandleEvent(event utils.IEvent) bool {
     case SIMULATION_EVENT_REMOVE_NODE:
         sim.RemoveNode(event.GetData().(uint32))
         return true
+    case SIMULATION_EVENT_CHECKPOINT:
+        // written by the main loop, once the current step finishes
+        sim.checkpointLock.Lock()
+        sim.pendingCheckpoints = append(sim.pendingCheckpoints,event.GetData().(string))
+        sim.checkpointLock.Unlock()
+        return true
     }
 
     return false
@@ -230,6 +495,22 @@ func (sim *Simulation) GetHooks() *utils.SimulationHooks {
     return sim.evSimulation.GetHooks()
 }
 
+func (sim *Simulation) GetMeasurements() *SimulationMeasurements {
+    return sim.measurements
+}
+
+func (sim *Simulation) GetConfig() *utils.SimulationConfig {
+    return sim.config
+}
+
+func (sim *Simulation) GetLogger(tag string) utils.ISimulationLogger {
+    return sim.loggers.Get(tag)
+}
+
+func (sim *Simulation) GetTrace() ISimulationTrace {
+    return sim.trace
+}
+
 func (sim *Simulation) GetName() string {
     return sim.name
 }
@@ -264,6 +545,21 @@ func (sim *Simulation) GetTime() float64 {
     return sim.evSimulation.Now()
 }
 
+func (sim *Simulation) GetLastActivity() float64 {
+    return sim.evSimulation.LastActivity()
+}
+
+// sorted ids of all nodes, so they are always visited in the same order (caller must hold nodeMapLock)
+func (sim *Simulation) getNodeIDs() []uint32 {
+    ids := make([]uint32,0,len(sim.nodeMap))
+    for id := range sim.nodeMap {
+        ids = append(ids,id)
+    }
+    sort.Slice(ids,func(i,j int) bool { return ids[i] < ids[j] })
+
+    return ids
+}
+
 // returns the node with the given id
 func (sim *Simulation) GetNode(node_id uint32) INode {
     sim.nodeMapLock.RLock()
@@ -296,3 +592,18 @@ func (sim *Simulation) SetEndCondition(end IEndCondition) ISimulation {
     return sim
 }
 
+func (sim *Simulation) SetTrace(trace ISimulationTrace) ISimulation {
+    sim.trace = trace
+    return sim
+}
+
+func (sim *Simulation) SetDebugger(debugger *SimulationDebugger) ISimulation {
+    sim.debugger = debugger
+    return sim
+}
+
+func (sim *Simulation) SetResume(path string) ISimulation {
+    sim.resumePath = path
+    return sim
+}
+
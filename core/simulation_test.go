@@ -0,0 +1,40 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "errors"
+    "testing"
+)
+
+// ==== tests ====
+
+// invalid settings read by the simulation itself are returned as errors with their key, instead of panicking
+func TestNewSimulationWithInvalidConfig(t *testing.T) {
+    tests := []struct{
+        key string
+        value interface{}
+    }{
+        {SIMULATION_TAG + ".engine","threads"},
+        {SIMULATION_TAG + ".batch_size",0},
+        {TRACE_TAG + ".mode","replay"},
+        {SIMULATION_MEASUREMENTS_TAG + ".event_type_list",[]string{"block"}},
+        {SIMULATION_MEASUREMENTS_TAG + ".tag_list",[]string{"-1"}},
+        {SIMULATION_MEASUREMENTS_TAG + ".measurement_modules",[]string{"bogus"}},
+        {DEBUGGER_TAG + ".breakpoints",[]string{"height 10"}},
+    }
+
+    for _, test := range tests {
+        config := utils.NewSimulationConfig()
+        config.Set(SIMULATION_TAG + ".engine",ENGINE_PARALLEL)
+        config.Set(DEBUGGER_TAG + ".enabled",true)
+        config.Set(test.key,test.value)
+
+        sim, err := NewSimulationWithConfig(config)
+        configErr := &utils.ConfigError{}
+        if sim != nil || err == nil {
+            t.Errorf("%s = %v: simulation created",test.key,test.value)
+        } else if !errors.As(err,&configErr) || configErr.Key != test.key {
+            t.Errorf("%s = %v: got %v, want an error for the key",test.key,test.value,err)
+        }
+    }
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+const (
+    STATE_PREDICATE_NUM_BLOCKS                  = "#blocks"     // pseudo-key: number of blocks in the registry
+)
+
+// comparison operators, longest first so "<=" is not parsed as "<"
+var statePredicateOperators                     = []string{">=","<=","==","!=",">","<"}
+
+// ==== concrete structures ====
+
+/*
+    Predicate over the global state in the form "<key><op><value>", where op is
+    one of >=, <=, ==, !=, >, or <. The key is looked up in the K/V store, or is
+    the pseudo-key "#blocks" (number of registered blocks). Values are compared
+    as numbers when both sides are numeric, otherwise as strings (only == and
+    != are valid for strings). A missing key never matches.
+*/
+type StatePredicate struct {
+    Key string
+    Operator string
+    Value string
+}
+
+// ==== factories ====
+
+func ParseStatePredicate(expr string) (*StatePredicate,error) {
+    for _, op := range statePredicateOperators {
+        if idx := strings.Index(expr,op); idx > 0 {
+            predicate := &StatePredicate{
+                Key:        strings.TrimSpace(expr[:idx]),
+                Operator:   op,
+                Value:      strings.TrimSpace(expr[idx+len(op):]),
+            }
+
+            if predicate.Key == "" || predicate.Value == "" {
+                break
+            }
+
+            return predicate, nil
+        }
+    }
+
+    return nil, fmt.Errorf("invalid state predicate %q: expected <key><op><value> with op in %v",expr,statePredicateOperators)
+}
+
+// ==== methods ====
+
+// evaluate the predicate on the given global state (false if it is nil)
+func (predicate *StatePredicate) Eval(state ISimulationGlobalState) bool {
+    if state == nil {
+        return false
+    }
+
+    var value interface{}
+    if predicate.Key == STATE_PREDICATE_NUM_BLOCKS {
+        value = state.GetNumBlocks()
+    } else {
+        value = state.Get(predicate.Key)
+    }
+
+    if value == nil {
+        return false
+    }
+
+    // numeric comparison
+    left, leftOk := toFloat64(value)
+    right, err := strconv.ParseFloat(predicate.Value,64)
+    if leftOk && err == nil {
+        switch predicate.Operator {
+        case ">=":
+            return left >= right
+        case "<=":
+            return left <= right
+        case "==":
+            return left == right
+        case "!=":
+            return left != right
+        case ">":
+            return left > right
+        case "<":
+            return left < right
+        }
+    }
+
+    // string comparison
+    str := fmt.Sprint(value)
+    switch predicate.Operator {
+    case "==":
+        return str == predicate.Value
+    case "!=":
+        return str != predicate.Value
+    }
+
+    return false
+}
+
+func (predicate *StatePredicate) String() string {
+    return predicate.Key + predicate.Operator + predicate.Value
+}
+
+// ==== functions ====
+
+// convert numeric values to float64
+func toFloat64(value interface{}) (float64,bool) {
+    switch v := value.(type) {
+    case int:
+        return float64(v), true
+    case int8:
+        return float64(v), true
+    case int16:
+        return float64(v), true
+    case int32:
+        return float64(v), true
+    case int64:
+        return float64(v), true
+    case uint:
+        return float64(v), true
+    case uint8:
+        return float64(v), true
+    case uint16:
+        return float64(v), true
+    case uint32:
+        return float64(v), true
+    case uint64:
+        return float64(v), true
+    case float32:
+        return float64(v), true
+    case float64:
+        return v, true
+    }
+
+    return 0, false
+}
@@ -0,0 +1,490 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "reflect"
+    "sync"
+)
+
+const (
+    TRACE_TAG                                   = "trace"
+    TRACE_MAGIC                                 = "SIMTRACE"
+    TRACE_VERSION                               = 1
+    TRACE_RECORD_SIZE                           = 35        // bytes per record (see TraceRecord)
+    TRACE_MIN_PRUNE_SIZE                        = 1024      // pending events tracked before pruning aborted ones
+
+    TRACE_KIND_SCHEDULED                        = 0
+    TRACE_KIND_TRIGGERED                        = 1
+
+    TRACE_MODE_OFF                              = ""
+    TRACE_MODE_RECORD                           = "record"
+    TRACE_MODE_VERIFY                           = "verify"
+
+    DEFAULT_TRACE_MODE                          = TRACE_MODE_OFF
+    DEFAULT_TRACE_FILE                          = "simulation.trace"
+    DEFAULT_TRACE_STOP_ON_DIVERGENCE            = true
+)
+
+// ==== interfaces ====
+
+/*
+    A trace follows every event scheduled and triggered during the simulation
+    (see TraceRecorder and TraceVerifier). It is initialized before the first
+    event is scheduled and finished when the simulation ends.
+*/
+type ISimulationTrace interface {
+    Init(sim ISimulation) error
+    Finish() error
+}
+
+/*
+    Event data can implement this interface to control its digest in traces.
+    Otherwise, the digest is based on GetHash (blocks, transactions), on the
+    fields of messages, on values of basic types, or only on the type name.
+*/
+type ITraceDigester interface {
+    TraceDigest() uint64
+}
+
+// ==== concrete structures ====
+
+/*
+    A single record of a trace. Records are written in binary (little endian)
+    with a fixed size, in the order of the fields.
+*/
+type TraceRecord struct {
+    Kind uint8                                  // TRACE_KIND_SCHEDULED or TRACE_KIND_TRIGGERED
+    Type uint16                                 // event type
+    Destination uint64                          // hash of the destination id (see ComponentRef)
+    Time float64                                // scheduled time
+    Seq uint64                                  // order in which the event was scheduled (starting from 1)
+    Digest uint64                               // digest of the event data
+}
+
+// first difference between a trace and the execution (Expected or Actual is nil if one of them ended)
+type TraceDivergence struct {
+    Index uint64
+    Expected *TraceRecord
+    Actual *TraceRecord
+}
+
+/*
+    Turns scheduled and triggered events into trace records, and passes them to
+    the handler of the recorder or verifier that incorporates it. The order in
+    which pending events were scheduled is kept until they are triggered:
+    aborted events (including cancelled timers) are pruned whenever the number
+    of pending events doubles, and the rest when the trace is finished.
+
+    Implements: IEventScheduledHandler and IEventPreTriggerHandler
+*/
+type EventTracer struct {
+    sim ISimulation
+    logger utils.ISimulationLogger
+    comps *checkpointComponents
+    compsNodes uint32                           // number of nodes when comps was built
+    seqs map[utils.IEvent]uint64
+    pruneSize int                               // number of pending events that triggers pruning
+    nextSeq uint64
+    index uint64
+    stop bool                                   // stop the simulation once the lock is released
+    lock sync.Mutex
+    handler func(record *TraceRecord)
+}
+
+/*
+    Writes a compact trace of every event scheduled and triggered to a file.
+
+    Implements: ISimulationTrace
+*/
+type TraceRecorder struct {
+    EventTracer
+
+    path string
+    file *os.File
+    writer *bufio.Writer
+    err error
+}
+
+/*
+    Re-runs a simulation against a recorded trace, and reports the first event
+    where the execution diverges from it. It can stop the simulation as soon as
+    a divergence is found.
+
+    Implements: ISimulationTrace
+*/
+type TraceVerifier struct {
+    EventTracer
+
+    path string
+    file *os.File
+    reader *bufio.Reader
+    stopOnDivergence bool
+    divergence *TraceDivergence
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(TRACE_TAG + ".mode",DEFAULT_TRACE_MODE)
+    utils.ConfigSetDefault(TRACE_TAG + ".file",DEFAULT_TRACE_FILE)
+    utils.ConfigSetDefault(TRACE_TAG + ".stop_on_divergence",DEFAULT_TRACE_STOP_ON_DIVERGENCE)
+}
+
+func NewTraceRecorder(path string) *TraceRecorder {
+    recorder := &TraceRecorder{
+        EventTracer:    newEventTracer(),
+        path:           path,
+    }
+    recorder.handler = recorder.write
+
+    return recorder
+}
+
+func NewTraceVerifier(path string,stopOnDivergence bool) *TraceVerifier {
+    verifier := &TraceVerifier{
+        EventTracer:        newEventTracer(),
+        path:               path,
+        stopOnDivergence:   stopOnDivergence,
+    }
+    verifier.handler = verifier.verify
+
+    return verifier
+}
+
+// trace according to configuration (nil if disabled), or an error if the mode is not supported
+func NewSimulationTraceFromConfig(config *utils.SimulationConfig) (ISimulationTrace,error) {
+    mode := config.GetString(TRACE_TAG + ".mode")
+    path := config.GetString(TRACE_TAG + ".file")
+    switch mode {
+    case TRACE_MODE_OFF:
+        return nil, nil
+    case TRACE_MODE_RECORD:
+        return NewTraceRecorder(path), nil
+    case TRACE_MODE_VERIFY:
+        return NewTraceVerifier(path,config.GetBool(TRACE_TAG + ".stop_on_divergence")), nil
+    }
+
+    return nil, utils.NewConfigError(TRACE_TAG + ".mode","trace mode %q not supported (%q, %q or %q)",mode,TRACE_MODE_OFF,TRACE_MODE_RECORD,TRACE_MODE_VERIFY)
+}
+
+func newEventTracer() EventTracer {
+    return EventTracer{
+        sim:        nil,
+        logger:     nil,
+        comps:      nil,
+        compsNodes: 0,
+        seqs:       make(map[utils.IEvent]uint64),
+        pruneSize:  TRACE_MIN_PRUNE_SIZE,
+        nextSeq:    1,
+        index:      0,
+        stop:       false,
+        lock:       sync.Mutex{},
+        handler:    nil,
+    }
+}
+
+// ==== methods ====
+
+func (tracer *EventTracer) init(sim *Simulation) {
+    tracer.sim = sim
+    tracer.logger = sim.GetLogger(TRACE_TAG)
+    tracer.comps = newCheckpointComponents(sim)
+    tracer.compsNodes = sim.GetNumNodes()
+
+    hooks := sim.GetHooks()
+    hooks.RegisterScheduledAll(tracer)
+    hooks.RegisterPreTriggerAll(tracer)
+}
+
+func (tracer *EventTracer) EventScheduled(ev utils.IEvent) {
+    tracer.lock.Lock()
+    seq := tracer.nextSeq
+    tracer.nextSeq++
+    tracer.seqs[ev] = seq
+    if len(tracer.seqs) >= tracer.pruneSize {
+        tracer.prune()
+    }
+    tracer.handle(TRACE_KIND_SCHEDULED,ev,seq)
+    tracer.lock.Unlock()
+
+    tracer.checkStop()
+}
+
+func (tracer *EventTracer) EventPreTrigger(ev utils.IEvent) {
+    tracer.lock.Lock()
+    seq := tracer.seqs[ev]
+    delete(tracer.seqs,ev)
+    tracer.handle(TRACE_KIND_TRIGGERED,ev,seq)
+    tracer.lock.Unlock()
+
+    tracer.checkStop()
+}
+
+// forget aborted events, which are never triggered, with the lock held
+func (tracer *EventTracer) prune() {
+    for ev := range tracer.seqs {
+        if ev.GetState() == utils.EVENT_STATE_ABORTED {
+            delete(tracer.seqs,ev)
+        }
+    }
+
+    tracer.pruneSize = 2 * len(tracer.seqs)
+    if tracer.pruneSize < TRACE_MIN_PRUNE_SIZE {
+        tracer.pruneSize = TRACE_MIN_PRUNE_SIZE
+    }
+}
+
+// forget the events still pending when the simulation ends
+func (tracer *EventTracer) finish() {
+    tracer.seqs = make(map[utils.IEvent]uint64)
+    tracer.pruneSize = TRACE_MIN_PRUNE_SIZE
+}
+
+// stop the simulation if requested by the handler (stopping schedules an event, which is traced)
+func (tracer *EventTracer) checkStop() {
+    tracer.lock.Lock()
+    stop := tracer.stop
+    tracer.stop = false
+    tracer.lock.Unlock()
+
+    if stop {
+        tracer.sim.Stop()
+    }
+}
+
+func (tracer *EventTracer) handle(kind uint8,ev utils.IEvent,seq uint64) {
+    record := TraceRecord{
+        Kind:           kind,
+        Type:           ev.GetType(),
+        Destination:    tracer.destinationHash(ev.GetDestination()),
+        Time:           ev.GetTime(),
+        Seq:            seq,
+        Digest:         tracer.digest(ev.GetData()),
+    }
+
+    tracer.handler(&record)
+    tracer.index++
+}
+
+// hash of the component id of a destination (components added later are looked up again)
+func (tracer *EventTracer) destinationHash(dest utils.IEventDestination) uint64 {
+    if isNil(dest) {
+        return 0
+    }
+
+    id, ok := tracer.comps.getID(dest)
+    if numNodes := tracer.sim.GetNumNodes(); !ok && numNodes != tracer.compsNodes {
+        tracer.comps = newCheckpointComponents(tracer.sim.(*Simulation))
+        tracer.compsNodes = numNodes
+        id, ok = tracer.comps.getID(dest)
+    }
+
+    if !ok {
+        id = describeDestination(dest)
+    }
+
+    return utils.HashString(id)
+}
+
+// digest of event data (see ITraceDigester)
+func (tracer *EventTracer) digest(data interface{}) uint64 {
+    if isNil(data) {
+        return 0
+    }
+
+    switch v := data.(type) {
+    case ITraceDigester:
+        return v.TraceDigest()
+    case utils.IHashable:
+        return v.GetHash()
+    case IMessage:
+        hasher := utils.NewHasher()
+        buffer := make([]byte,0,64)
+        buffer = binary.LittleEndian.AppendUint32(buffer,v.GetSender())
+        buffer = binary.LittleEndian.AppendUint32(buffer,uint32(v.GetTag()))
+        buffer = binary.LittleEndian.AppendUint64(buffer,v.GetSize())
+        if delivery := v.GetDelivery(); delivery != nil {
+            buffer = binary.LittleEndian.AppendUint16(buffer,delivery.GetDeliveryType())
+            for _, target := range delivery.GetDeliveryTargets() {
+                buffer = binary.LittleEndian.AppendUint32(buffer,target)
+            }
+        }
+        buffer = binary.LittleEndian.AppendUint64(buffer,tracer.digest(v.GetData()))
+        hasher.WriteBytes(buffer)
+        return hasher.Hash()
+    case []interface{}:
+        hasher := utils.NewHasher()
+        for _, elem := range v {
+            hasher.WriteBytes(binary.LittleEndian.AppendUint64(nil,tracer.digest(elem)))
+        }
+        return hasher.Hash()
+    }
+
+    if id, ok := tracer.comps.getID(data); ok {
+        return utils.HashString(id)
+    }
+
+    // values of basic types, otherwise only the type (addresses are not deterministic)
+    switch reflect.TypeOf(data).Kind() {
+    case reflect.Bool,reflect.String,reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,
+            reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64,reflect.Float32,reflect.Float64:
+        return utils.HashString(fmt.Sprintf("%T:%v",data,data))
+    }
+
+    return utils.HashString(fmt.Sprintf("%T",data))
+}
+
+func (recorder *TraceRecorder) Init(sim ISimulation) error {
+    file, err := os.Create(recorder.path)
+    if err != nil {
+        return err
+    }
+
+    recorder.file = file
+    recorder.writer = bufio.NewWriter(file)
+    recorder.writer.WriteString(TRACE_MAGIC)
+    binary.Write(recorder.writer,binary.LittleEndian,uint16(TRACE_VERSION))
+
+    recorder.init(sim.(*Simulation))
+    recorder.logger.Info("recording trace to %s",recorder.path)
+    return nil
+}
+
+func (recorder *TraceRecorder) write(record *TraceRecord) {
+    if recorder.err == nil {
+        recorder.err = binary.Write(recorder.writer,binary.LittleEndian,record)
+    }
+}
+
+// flush the trace to the file (the first error while writing is returned)
+func (recorder *TraceRecorder) Finish() error {
+    recorder.lock.Lock()
+    defer recorder.lock.Unlock()
+
+    recorder.finish()
+    err := recorder.err
+    if flushErr := recorder.writer.Flush(); err == nil {
+        err = flushErr
+    }
+    if closeErr := recorder.file.Close(); err == nil {
+        err = closeErr
+    }
+
+    recorder.logger.Info("%d records written to %s",recorder.index,recorder.path)
+    return err
+}
+
+func (verifier *TraceVerifier) Init(sim ISimulation) error {
+    file, err := os.Open(verifier.path)
+    if err != nil {
+        return err
+    }
+
+    verifier.file = file
+    verifier.reader = bufio.NewReader(file)
+
+    header := make([]byte,len(TRACE_MAGIC))
+    var version uint16
+    if _, err := io.ReadFull(verifier.reader,header); err != nil || string(header) != TRACE_MAGIC {
+        file.Close()
+        return fmt.Errorf("%s is not a trace file",verifier.path)
+    }
+    if err := binary.Read(verifier.reader,binary.LittleEndian,&version); err != nil || version != TRACE_VERSION {
+        file.Close()
+        return fmt.Errorf("trace version %d not supported",version)
+    }
+
+    verifier.init(sim.(*Simulation))
+    verifier.logger.Info("verifying execution against trace %s",verifier.path)
+    return nil
+}
+
+// next record of the trace (nil if it ended)
+func (verifier *TraceVerifier) next() *TraceRecord {
+    record := &TraceRecord{}
+    if err := binary.Read(verifier.reader,binary.LittleEndian,record); err != nil {
+        return nil
+    }
+
+    return record
+}
+
+func (verifier *TraceVerifier) verify(record *TraceRecord) {
+    if verifier.divergence != nil {
+        return
+    }
+
+    expected := verifier.next()
+    if expected != nil && *expected == *record {
+        return
+    }
+
+    verifier.diverged(expected,record)
+    verifier.stop = verifier.stopOnDivergence
+}
+
+func (verifier *TraceVerifier) diverged(expected *TraceRecord,actual *TraceRecord) {
+    verifier.divergence = &TraceDivergence{
+        Index:      verifier.index,
+        Expected:   expected,
+        Actual:     actual,
+    }
+    verifier.logger.Error("%v",verifier.divergence)
+}
+
+// check that the trace has no records left, and return the divergence (if any) as an error
+func (verifier *TraceVerifier) Finish() error {
+    verifier.lock.Lock()
+    defer verifier.lock.Unlock()
+
+    verifier.finish()
+    if verifier.divergence == nil {
+        if expected := verifier.next(); expected != nil {
+            verifier.diverged(expected,nil)
+        }
+    }
+    verifier.file.Close()
+
+    if verifier.divergence != nil {
+        return verifier.divergence
+    }
+
+    verifier.logger.Info("execution matches trace %s (%d records)",verifier.path,verifier.index)
+    return nil
+}
+
+func (divergence *TraceDivergence) Error() string {
+    return fmt.Sprintf("execution diverges from trace at record %d: expected %v, got %v",divergence.Index,divergence.Expected,divergence.Actual)
+}
+
+func (record *TraceRecord) String() string {
+    kind := "scheduled"
+    if record.Kind == TRACE_KIND_TRIGGERED {
+        kind = "triggered"
+    }
+
+    return fmt.Sprintf("{%s type=%d dest=%016x time=%v seq=%d digest=%016x}",kind,record.Type,record.Destination,record.Time,record.Seq,record.Digest)
+}
+
+// ==== getters ====
+
+// first divergence found so far (nil if none)
+func (verifier *TraceVerifier) GetDivergence() *TraceDivergence {
+    verifier.lock.Lock()
+    defer verifier.lock.Unlock()
+
+    return verifier.divergence
+}
+
+// check if an error is a trace divergence
+func IsTraceDivergence(err error) bool {
+    var divergence *TraceDivergence
+    return errors.As(err,&divergence)
+}
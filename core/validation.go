@@ -0,0 +1,101 @@
+package core
+
+import (
+    "blockchainlab/simulator/utils"
+    "strconv"
+    "strings"
+)
+
+// ==== interfaces ====
+
+/*
+    Optional interface of components, end conditions and measurement modules
+    that check their settings before the simulation is created, so that
+    mistakes are reported all together instead of making the simulation
+    panic in Init. Errors should be utils.ConfigErrors or utils.ConfigError,
+    so that they carry the key with the problem.
+*/
+type IConfigValidator interface {
+    ValidateConfig(config *utils.SimulationConfig) error
+}
+
+// ==== functions ====
+
+// validate the config with the given component, if it implements IConfigValidator
+func ValidateComponentConfig(component interface{},config *utils.SimulationConfig) error {
+    if validator, ok := component.(IConfigValidator); ok {
+        return validator.ValidateConfig(config)
+    }
+
+    return nil
+}
+
+/*
+    Check the sections of the configuration read by the simulation itself:
+    simulation (engine and checkpoints), trace, measurements (including the
+    measurement modules), and debugger. Returns utils.ConfigErrors with every
+    problem found, or nil.
+*/
+func ValidateSimulationConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+
+    // simulation
+    switch engine := config.GetString(SIMULATION_TAG + ".engine"); engine {
+    case ENGINE_SEQUENTIAL:
+    case ENGINE_PARALLEL:
+        if config.GetFloat64(SIMULATION_TAG + ".batch_window") < 0 {
+            errs.Add(SIMULATION_TAG + ".batch_window","must not be negative")
+        }
+        if config.GetInt(SIMULATION_TAG + ".batch_size") < 1 {
+            errs.Add(SIMULATION_TAG + ".batch_size","must be positive")
+        }
+    default:
+        errs.Add(SIMULATION_TAG + ".engine","engine %q not supported (%q or %q)",engine,ENGINE_SEQUENTIAL,ENGINE_PARALLEL)
+    }
+    if _, err := config.ParseFloat64Slice(SIMULATION_TAG + ".checkpoint_times"); err != nil {
+        errs.AddError(SIMULATION_TAG + ".checkpoint_times",err)
+    }
+
+    // trace
+    switch mode := config.GetString(TRACE_TAG + ".mode"); mode {
+    case TRACE_MODE_OFF:
+    case TRACE_MODE_RECORD,TRACE_MODE_VERIFY:
+        if config.GetString(TRACE_TAG + ".file") == "" {
+            errs.Add(TRACE_TAG + ".file","required with mode %q",mode)
+        }
+    default:
+        errs.Add(TRACE_TAG + ".mode","trace mode %q not supported (%q, %q or %q)",mode,TRACE_MODE_OFF,TRACE_MODE_RECORD,TRACE_MODE_VERIFY)
+    }
+
+    // measurements
+    for _, str := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".event_type_list") {
+        if _, err := strconv.ParseUint(str,10,16); err != nil && str != "all" {
+            errs.Add(SIMULATION_MEASUREMENTS_TAG + ".event_type_list","invalid event type %q",str)
+        }
+    }
+    for _, str := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".tag_list") {
+        if _, err := strconv.ParseUint(str,10,64); err != nil && str != "all" {
+            errs.Add(SIMULATION_MEASUREMENTS_TAG + ".tag_list","invalid tag %q",str)
+        }
+    }
+    for _, name := range config.GetStringSlice(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules") {
+        module := NewMeasurementModuleFromRegistry(name)
+        if module == nil {
+            errs.Add(SIMULATION_MEASUREMENTS_TAG + ".measurement_modules","no %s factory registered for %q",REGISTRY_MEASUREMENT_MODULE,name)
+            continue
+        }
+        errs.AddError(name,ValidateComponentConfig(module,config))
+    }
+
+    // debugger
+    if config.GetBool(DEBUGGER_TAG + ".enabled") {
+        for _, str := range config.GetStringSlice(DEBUGGER_TAG + ".breakpoints") {
+            kind, arg, _ := strings.Cut(strings.TrimSpace(str)," ")
+            if _, err := ParseBreakpoint(kind,arg); err != nil {
+                errs.AddError(DEBUGGER_TAG + ".breakpoints",err)
+            }
+        }
+    }
+
+    return errs.Err()
+}
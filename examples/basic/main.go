@@ -1,24 +1,41 @@
 package main
 
 import (
+    "errors"
+    "flag"
     "fmt"
+    "io/fs"
+    "os"
     "blockchainlab/simulator"
+    "blockchainlab/simulator/core"
     "blockchainlab/simulator/utils"
 )
 
 func main() {
-    logger := utils.GetSimulationLogger("main")
-    logger.Info("starting main file")
+    configFile := flag.String("config",utils.DEFAULT_CONFIG_FILE,"configuration file")
+    flag.Parse()
 
-    // create simulation form config file (section 'setup')
-    sim := simulator.NewSimulationFromConfig()
+    // create simulation form config file (section 'setup'), or from the defaults if there is no default file
+    var sim core.ISimulation
+    var err error
+    if _, statErr := os.Stat(*configFile); *configFile == utils.DEFAULT_CONFIG_FILE && errors.Is(statErr,fs.ErrNotExist) {
+        sim, err = simulator.NewSimulationWithConfig(utils.NewSimulationConfig())
+    } else {
+        sim, err = simulator.NewSimulationFromConfig(*configFile)
+    }
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+
+    logger := sim.GetLogger("main")
+    logger.Info("starting main file")
 
     // run
-    err := sim.Run()
+    err = sim.Run()
     if err != nil {
         fmt.Println(err)
     }
-    
+
     logger.Info("finishing main file")
 }
-
@@ -0,0 +1,433 @@
+package experiment
+
+import (
+    "blockchainlab/simulator/utils"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    REPORT_CSV_FILE                             = "report.csv"
+    REPORT_JSON_FILE                            = "report.json"
+    REPORT_HTML_FILE                            = "report.html"
+
+    DEFAULT_REPORT_CONFIDENCE                   = 0.95
+)
+
+var DEFAULT_REPORT_PERCENTILES                  = []float64{5,25,50,75,95}  // percentiles of the metrics across replications
+
+// ==== concrete structures ====
+
+// aggregate of a metric over the replications of a parameter point
+type MetricSummary struct {
+    utils.SampleSummary
+    CILow float64                               `json:"ci_low"`
+    CIHigh float64                              `json:"ci_high"`
+}
+
+// runs that only differ by seed, and the aggregates of their metrics
+type ReportPoint struct {
+    ID int                                      `json:"id"`
+    Parameters map[string]interface{}           `json:"parameters"`   // overrides of the point (without the seed)
+    Runs []int                                  `json:"runs"`         // ids of the successful runs
+    NumFailed int                               `json:"num_failed"`
+    Metrics map[string]*MetricSummary           `json:"metrics"`
+}
+
+/*
+    Aggregated report of an experiment run with Runner. The final result of
+    every measurement module of a run (the json files written to the output
+    paths of the modules in the config of the run) is flattened into metrics
+    named "<module>.<field>.<field>..." (e.g. "tx_latency.inclusion.mean"):
+    only numbers in nested objects are taken, arrays (time series, per-block
+    results) are skipped, and so are maps by node id or tag unless a pattern
+    reaches them (see NewReport). Runs are grouped by their parameters except
+    the seed, and each metric is summarized over the replications of every
+    point: mean, standard deviation, confidence interval of the mean, and
+    percentiles.
+*/
+type Report struct {
+    Dir string                                  `json:"dir"`
+    Confidence float64                          `json:"confidence"`
+    Percentiles []float64                       `json:"percentiles"`
+    Parameters []string                         `json:"parameters"`   // keys of the parameters that vary, sorted
+    Metrics []string                            `json:"metrics"`      // names of all metrics, sorted
+    Points []*ReportPoint                       `json:"points"`
+}
+
+// ==== factories ====
+
+// read the index written by Runner in the output directory
+func ReadIndex(dir string) (*Index,error) {
+    data, err := os.ReadFile(filepath.Join(dir,INDEX_FILE))
+    if err != nil {
+        return nil, err
+    }
+
+    index := &Index{}
+    if err := json.Unmarshal(data,index); err != nil {
+        return nil, fmt.Errorf("%s: %v",filepath.Join(dir,INDEX_FILE),err)
+    }
+
+    return index, nil
+}
+
+/*
+    Build the report of the experiment in dir (the output directory of Runner).
+    Only metrics matching one of the patterns are included (see path.Match,
+    e.g. "tx_latency.*"), or all of them if there are none. Metrics in maps by
+    node id or tag are included only for patterns with a segment for the ids
+    (e.g. "message_traffic.nodes.*", not "message_traffic.*"). Failed runs are
+    counted but not aggregated.
+*/
+func NewReport(dir string,confidence float64,percentiles []float64,patterns []string) (*Report,error) {
+    if confidence <= 0 || confidence >= 1 {
+        return nil, fmt.Errorf("confidence must be in (0,1)")
+    }
+    for _, pattern := range patterns {
+        if _, err := path.Match(pattern,""); err != nil {
+            return nil, fmt.Errorf("invalid metric pattern %q: %v",pattern,err)
+        }
+    }
+
+    index, err := ReadIndex(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    seedKey := DEFAULT_SEED_KEY
+    if index.Sweep != nil && index.Sweep.SeedKey != "" {
+        seedKey = index.Sweep.SeedKey
+    }
+
+    report := &Report{
+        Dir:            dir,
+        Confidence:     confidence,
+        Percentiles:    percentiles,
+        Parameters:     make([]string,0),
+        Metrics:        make([]string,0),
+        Points:         make([]*ReportPoint,0),
+    }
+
+    // group runs by parameters (in order of the first run of each point)
+    points := make(map[string]*ReportPoint)
+    samples := make(map[*ReportPoint]map[string][]float64)
+    parameters := make(map[string]bool)
+    metrics := make(map[string]bool)
+    for _, run := range index.Runs {
+        overrides := make(map[string]interface{},len(run.Parameters))
+        for key, value := range run.Parameters {
+            if key != seedKey {
+                overrides[key] = value
+                parameters[key] = true
+            }
+        }
+
+        key, err := json.Marshal(overrides) // keys are sorted
+        if err != nil {
+            return nil, err
+        }
+
+        point, ok := points[string(key)]
+        if !ok {
+            point = &ReportPoint{
+                ID:         len(report.Points),
+                Parameters: overrides,
+                Runs:       make([]int,0),
+                NumFailed:  0,
+                Metrics:    make(map[string]*MetricSummary),
+            }
+            points[string(key)] = point
+            samples[point] = make(map[string][]float64)
+            report.Points = append(report.Points,point)
+        }
+
+        if run.Status != RUN_STATUS_OK {
+            point.NumFailed++
+            continue
+        }
+
+        values, err := readRunMetrics(filepath.Join(dir,run.Dir),patterns)
+        if err != nil {
+            return nil, fmt.Errorf("run %d: %v",run.ID,err)
+        }
+
+        point.Runs = append(point.Runs,run.ID)
+        for name, value := range values {
+            samples[point][name] = append(samples[point][name],value)
+            metrics[name] = true
+        }
+    }
+
+    // aggregate
+    for _, point := range report.Points {
+        for name, values := range samples[point] {
+            point.Metrics[name] = summarizeMetric(values,confidence,percentiles)
+        }
+    }
+
+    report.Parameters = sortedKeys(parameters)
+    report.Metrics = sortedKeys(metrics)
+
+    return report, nil
+}
+
+// ==== methods ====
+
+// write the report to dir as CSV, JSON and HTML
+func (report *Report) Write(dir string) error {
+    if err := os.MkdirAll(dir,0755); err != nil {
+        return err
+    }
+
+    writers := []struct{
+        file string
+        write func(io.Writer) error
+    }{
+        {REPORT_CSV_FILE,report.WriteCSV},
+        {REPORT_JSON_FILE,report.WriteJSON},
+        {REPORT_HTML_FILE,report.WriteHTML},
+    }
+
+    for _, writer := range writers {
+        file, err := os.Create(filepath.Join(dir,writer.file))
+        if err != nil {
+            return err
+        }
+
+        err = writer.write(file)
+        if closeErr := file.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %v",writer.file,err)
+        }
+    }
+
+    return nil
+}
+
+/*
+    One row per point and metric: point id, the parameters, the metric name,
+    and its summary (number of replications, mean, standard deviation,
+    confidence interval, min, max, percentiles).
+*/
+func (report *Report) WriteCSV(w io.Writer) error {
+    writer := csv.NewWriter(w)
+
+    header := []string{"point"}
+    header = append(header,report.Parameters...)
+    header = append(header,"metric","n","mean","stddev","ci_low","ci_high","min","max")
+    for _, p := range report.Percentiles {
+        header = append(header,utils.PercentileKey(p))
+    }
+    if err := writer.Write(header); err != nil {
+        return err
+    }
+
+    for _, point := range report.Points {
+        for _, name := range report.Metrics {
+            summary, ok := point.Metrics[name]
+            if !ok {
+                continue
+            }
+
+            row := []string{strconv.Itoa(point.ID)}
+            for _, key := range report.Parameters {
+                row = append(row,FormatParameter(point.Parameters[key]))
+            }
+            row = append(row,name,strconv.Itoa(summary.Count),formatFloat(summary.Mean),formatFloat(summary.StdDev),
+                formatFloat(summary.CILow),formatFloat(summary.CIHigh),formatFloat(summary.Min),formatFloat(summary.Max))
+            for _, p := range report.Percentiles {
+                row = append(row,formatFloat(summary.Percentiles[utils.PercentileKey(p)]))
+            }
+            if err := writer.Write(row); err != nil {
+                return err
+            }
+        }
+    }
+
+    writer.Flush()
+    return writer.Error()
+}
+
+func (report *Report) WriteJSON(w io.Writer) error {
+    data, err := json.MarshalIndent(report,"","    ")
+    if err != nil {
+        return err
+    }
+
+    _, err = w.Write(data)
+    return err
+}
+
+// ==== getters ====
+
+// short description of the parameters of a point (e.g., "a=1, b=[2,3]")
+func (point *ReportPoint) GetLabel() string {
+    if len(point.Parameters) == 0 {
+        return "all runs"
+    }
+
+    keys := make([]string,0,len(point.Parameters))
+    for key := range point.Parameters {
+        keys = append(keys,key)
+    }
+    sort.Strings(keys)
+
+    parts := make([]string,len(keys))
+    for i, key := range keys {
+        parts[i] = key + "=" + FormatParameter(point.Parameters[key])
+    }
+
+    return strings.Join(parts,", ")
+}
+
+// ==== functions ====
+
+// parameter value as text: strings as they are, other values as json
+func FormatParameter(value interface{}) string {
+    if str, ok := value.(string); ok {
+        return str
+    }
+
+    data, err := json.Marshal(value)
+    if err != nil {
+        return fmt.Sprint(value)
+    }
+
+    return string(data)
+}
+
+/*
+    Numeric fields matching the patterns in the final results of the
+    measurement modules of a run: the modules and their output paths are read
+    from the config of the run ("<module>.output", "<module>.json" if not set,
+    relative to the run directory).
+*/
+func readRunMetrics(dir string,patterns []string) (map[string]float64,error) {
+    config, err := utils.NewSimulationConfigFromFile(filepath.Join(dir,RUN_CONFIG_FILE))
+    if err != nil {
+        return nil, err
+    }
+
+    metrics := make(map[string]float64)
+    for _, module := range config.GetStringSlice("measurements.measurement_modules") {
+        file := module + ".json"
+        if config.IsSet(module + ".output") {
+            file = config.GetString(module + ".output")
+        }
+        if file == "" {
+            continue
+        }
+        if !filepath.IsAbs(file) {
+            file = filepath.Join(dir,file)
+        }
+
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+
+        var result interface{}
+        if err := json.Unmarshal(data,&result); err != nil {
+            return nil, fmt.Errorf("%s: %v",file,err)
+        }
+
+        flattenMetrics(module,result,patterns,metrics)
+    }
+
+    return metrics, nil
+}
+
+/*
+    Numbers in nested objects matching the patterns, with dotted names (arrays
+    are skipped). Objects by node id or tag (see isByID) are only entered for
+    the patterns with a segment for their keys.
+*/
+func flattenMetrics(prefix string,value interface{},patterns []string,metrics map[string]float64) {
+    switch v := value.(type) {
+    case float64:
+        if matchMetric(prefix,patterns) {
+            metrics[prefix] = v
+        }
+    case map[string]interface{}:
+        if len(v) > 0 && isByID(v) {
+            segments := strings.Count(prefix,".") + 1
+            reaching := make([]string,0,len(patterns))
+            for _, pattern := range patterns {
+                if strings.Count(pattern,".") + 1 > segments {
+                    reaching = append(reaching,pattern)
+                }
+            }
+            if len(reaching) == 0 {
+                return
+            }
+            patterns = reaching
+        }
+
+        for key, nested := range v {
+            flattenMetrics(prefix + "." + key,nested,patterns,metrics)
+        }
+    }
+}
+
+// objects by node id or tag: numeric keys and nested objects (histograms, with numbers, are not)
+func isByID(object map[string]interface{}) bool {
+    for key, value := range object {
+        if _, err := strconv.ParseInt(key,10,64); err != nil {
+            return false
+        }
+        if _, ok := value.(map[string]interface{}); !ok {
+            return false
+        }
+    }
+
+    return true
+}
+
+func matchMetric(name string,patterns []string) bool {
+    if len(patterns) == 0 {
+        return true
+    }
+
+    for _, pattern := range patterns {
+        if ok, _ := path.Match(pattern,name); ok {
+            return true
+        }
+    }
+
+    return false
+}
+
+func summarizeMetric(values []float64,confidence float64,percentiles []float64) *MetricSummary {
+    mean, halfWidth := utils.ConfidenceInterval(values,confidence)
+
+    return &MetricSummary{
+        SampleSummary:  utils.NewSampleSummary(values,percentiles),
+        CILow:          mean - halfWidth,
+        CIHigh:         mean + halfWidth,
+    }
+}
+
+func sortedKeys(set map[string]bool) []string {
+    keys := make([]string,0,len(set))
+    for key := range set {
+        keys = append(keys,key)
+    }
+    sort.Strings(keys)
+
+    return keys
+}
+
+func formatFloat(value float64) string {
+    return strconv.FormatFloat(value,'g',-1,64)
+}
@@ -0,0 +1,167 @@
+package experiment
+
+import (
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "html"
+    "io"
+    "math"
+    "strings"
+    "time"
+)
+
+// chart layout (pixels)
+const (
+    CHART_WIDTH                                 = 720
+    CHART_HEIGHT                                = 260
+    CHART_MARGIN_LEFT                           = 80
+    CHART_MARGIN_RIGHT                          = 20
+    CHART_MARGIN_TOP                            = 15
+    CHART_MARGIN_BOTTOM                         = 35
+    CHART_TICKS                                 = 5
+)
+
+const reportHTMLStyle = `
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em 0; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+th { background: #f0f0f0; }
+td.text { text-align: left; }
+h2 { margin-top: 2em; }
+h3 { margin-bottom: 0.2em; font-family: monospace; }
+svg text { font-size: 11px; fill: #444; }
+.minmax { stroke: #bbb; stroke-width: 1; }
+.ci { stroke: #1f77b4; stroke-width: 3; }
+.mean { fill: #1f77b4; }
+.axis { stroke: #888; stroke-width: 1; }
+.grid { stroke: #eee; stroke-width: 1; }
+`
+
+// ==== methods ====
+
+/*
+    Self-contained HTML page (no external resources): the parameters of every
+    point, and for each metric a chart of the mean with its confidence
+    interval (thick bar) and range over the replications (thin line) per
+    point, followed by the table of its summary.
+*/
+func (report *Report) WriteHTML(w io.Writer) error {
+    var b strings.Builder
+
+    b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Experiment report</title>\n")
+    b.WriteString("<style>" + reportHTMLStyle + "</style>\n</head>\n<body>\n")
+    fmt.Fprintf(&b,"<h1>Experiment report</h1>\n<p>%s &mdash; %d points, %d metrics, %v%% confidence intervals. Generated %s.</p>\n",
+        html.EscapeString(report.Dir),len(report.Points),len(report.Metrics),report.Confidence * 100,time.Now().Format(time.RFC1123))
+
+    // points
+    b.WriteString("<h2>Points</h2>\n<table>\n<tr><th>point</th>")
+    for _, key := range report.Parameters {
+        fmt.Fprintf(&b,"<th>%s</th>",html.EscapeString(key))
+    }
+    b.WriteString("<th>runs</th><th>failed</th></tr>\n")
+    for _, point := range report.Points {
+        fmt.Fprintf(&b,"<tr><td>%d</td>",point.ID)
+        for _, key := range report.Parameters {
+            fmt.Fprintf(&b,"<td class=\"text\">%s</td>",html.EscapeString(FormatParameter(point.Parameters[key])))
+        }
+        fmt.Fprintf(&b,"<td>%d</td><td>%d</td></tr>\n",len(point.Runs),point.NumFailed)
+    }
+    b.WriteString("</table>\n")
+
+    // metrics
+    b.WriteString("<h2>Metrics</h2>\n")
+    for _, name := range report.Metrics {
+        fmt.Fprintf(&b,"<h3 id=\"%s\">%s</h3>\n",html.EscapeString(name),html.EscapeString(name))
+        report.writeChart(&b,name)
+        report.writeMetricTable(&b,name)
+    }
+
+    b.WriteString("</body>\n</html>\n")
+
+    _, err := io.WriteString(w,b.String())
+    return err
+}
+
+// SVG chart of a metric: one column per point
+func (report *Report) writeChart(b *strings.Builder,name string) {
+    // value range of the chart
+    low, high := math.Inf(1), math.Inf(-1)
+    for _, point := range report.Points {
+        if summary, ok := point.Metrics[name]; ok {
+            low = math.Min(low,math.Min(summary.Min,summary.CILow))
+            high = math.Max(high,math.Max(summary.Max,summary.CIHigh))
+        }
+    }
+    if math.IsInf(low,0) {
+        return
+    }
+    if high - low < 1e-12 * math.Max(1,math.Abs(high)) {
+        pad := math.Max(1,math.Abs(high)) * 0.1
+        low, high = low - pad, high + pad
+    }
+
+    plotWidth := float64(CHART_WIDTH - CHART_MARGIN_LEFT - CHART_MARGIN_RIGHT)
+    plotHeight := float64(CHART_HEIGHT - CHART_MARGIN_TOP - CHART_MARGIN_BOTTOM)
+    y := func(value float64) float64 {
+        return CHART_MARGIN_TOP + plotHeight * (high - value) / (high - low)
+    }
+    column := plotWidth / float64(len(report.Points))
+    x := func(i int) float64 {
+        return CHART_MARGIN_LEFT + column * (float64(i) + 0.5)
+    }
+
+    fmt.Fprintf(b,"<svg width=\"%d\" height=\"%d\" xmlns=\"http://www.w3.org/2000/svg\">\n",CHART_WIDTH,CHART_HEIGHT)
+
+    // y axis with grid
+    for i := 0; i <= CHART_TICKS; i++ {
+        value := low + (high - low) * float64(i) / CHART_TICKS
+        fmt.Fprintf(b,"<line class=\"grid\" x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\"/>",CHART_MARGIN_LEFT,y(value),CHART_WIDTH - CHART_MARGIN_RIGHT,y(value))
+        fmt.Fprintf(b,"<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%.4g</text>\n",CHART_MARGIN_LEFT - 6,y(value) + 4,value)
+    }
+    fmt.Fprintf(b,"<line class=\"axis\" x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%.1f\"/>\n",CHART_MARGIN_LEFT,CHART_MARGIN_TOP,CHART_MARGIN_LEFT,CHART_MARGIN_TOP + plotHeight)
+    fmt.Fprintf(b,"<line class=\"axis\" x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\"/>\n",CHART_MARGIN_LEFT,CHART_MARGIN_TOP + plotHeight,CHART_WIDTH - CHART_MARGIN_RIGHT,CHART_MARGIN_TOP + plotHeight)
+
+    // points
+    for i, point := range report.Points {
+        fmt.Fprintf(b,"<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%d<title>%s</title></text>\n",x(i),CHART_MARGIN_TOP + plotHeight + 16,point.ID,html.EscapeString(point.GetLabel()))
+
+        summary, ok := point.Metrics[name]
+        if !ok {
+            continue
+        }
+
+        fmt.Fprintf(b,"<g><title>%s\nmean %.6g, %v%% CI [%.6g, %.6g], range [%.6g, %.6g], n=%d</title>",
+            html.EscapeString(point.GetLabel()),summary.Mean,report.Confidence * 100,summary.CILow,summary.CIHigh,summary.Min,summary.Max,summary.Count)
+        fmt.Fprintf(b,"<line class=\"minmax\" x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\"/>",x(i),y(summary.Min),x(i),y(summary.Max))
+        fmt.Fprintf(b,"<line class=\"ci\" x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\"/>",x(i),y(summary.CILow),x(i),y(summary.CIHigh))
+        fmt.Fprintf(b,"<circle class=\"mean\" cx=\"%.1f\" cy=\"%.1f\" r=\"4\"/></g>\n",x(i),y(summary.Mean))
+    }
+    fmt.Fprintf(b,"<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">point</text>\n",CHART_MARGIN_LEFT + plotWidth / 2,CHART_HEIGHT - 2)
+
+    b.WriteString("</svg>\n")
+}
+
+// table with the summary of a metric per point
+func (report *Report) writeMetricTable(b *strings.Builder,name string) {
+    b.WriteString("<table>\n<tr><th>point</th><th>n</th><th>mean</th><th>stddev</th><th>CI</th><th>min</th><th>max</th>")
+    for _, p := range report.Percentiles {
+        fmt.Fprintf(b,"<th>%s</th>",utils.PercentileKey(p))
+    }
+    b.WriteString("</tr>\n")
+
+    for _, point := range report.Points {
+        summary, ok := point.Metrics[name]
+        if !ok {
+            continue
+        }
+
+        fmt.Fprintf(b,"<tr><td title=\"%s\">%d</td><td>%d</td><td>%.6g</td><td>%.6g</td><td>[%.6g, %.6g]</td><td>%.6g</td><td>%.6g</td>",
+            html.EscapeString(point.GetLabel()),point.ID,summary.Count,summary.Mean,summary.StdDev,summary.CILow,summary.CIHigh,summary.Min,summary.Max)
+        for _, p := range report.Percentiles {
+            fmt.Fprintf(b,"<td>%.6g</td>",summary.Percentiles[utils.PercentileKey(p)])
+        }
+        b.WriteString("</tr>\n")
+    }
+
+    b.WriteString("</table>\n")
+}
@@ -0,0 +1,82 @@
+package experiment
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+// writes a run directory with a config and the given files
+func writeTestRun(t *testing.T,dir string,config string,files map[string]string) {
+    t.Helper()
+
+    if err := os.MkdirAll(dir,0755); err != nil {
+        t.Fatal(err)
+    }
+    files[RUN_CONFIG_FILE] = config
+    for name, content := range files {
+        if err := os.WriteFile(filepath.Join(dir,name),[]byte(content),0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// only the output files of the configured modules are read, and maps by node or tag only when asked for
+func TestReadRunMetrics(t *testing.T) {
+    dir := t.TempDir()
+    writeTestRun(t,dir,`
+[measurements]
+measurement_modules = ["message_traffic","fork_rate"]
+
+[fork_rate]
+output = "forks/result.json"
+`,map[string]string{
+        "message_traffic.json":     `{"total": {"sent": {"count": 10}}, "nodes": {"0": {"sent": {"count": 4}}, "1": {"sent": {"count": 6}}}, "time_series": [{"load": 1}]}`,
+        "checkpoint.json":          `{"not": {"a": 1}}`,
+        "measurements.json":        `{"raw": 1}`,
+    })
+    if err := os.MkdirAll(filepath.Join(dir,"forks"),0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(dir,"forks","result.json"),[]byte(`{"stale_rate": 0.1, "fork_lengths": {"1": 3, "2": 1}}`),0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct{
+        patterns []string
+        metrics []string
+    }{
+        {nil,[]string{"fork_rate.fork_lengths.1","fork_rate.fork_lengths.2","fork_rate.stale_rate","message_traffic.total.sent.count"}},
+        {[]string{"message_traffic.*"},[]string{"message_traffic.total.sent.count"}},
+        {[]string{"message_traffic.nodes.*"},[]string{"message_traffic.nodes.0.sent.count","message_traffic.nodes.1.sent.count"}},
+        {[]string{"*.nodes.1.sent.count","fork_rate.stale_rate"},[]string{"fork_rate.stale_rate","message_traffic.nodes.1.sent.count"}},
+    }
+
+    for _, test := range tests {
+        metrics, err := readRunMetrics(dir,test.patterns)
+        if err != nil {
+            t.Fatalf("%v: %v",test.patterns,err)
+        }
+
+        names := make([]string,0,len(metrics))
+        for name := range metrics {
+            names = append(names,name)
+        }
+        sort.Strings(names)
+        if !reflect.DeepEqual(names,test.metrics) {
+            t.Errorf("%v: metrics %v, want %v",test.patterns,names,test.metrics)
+        }
+    }
+}
+
+// a missing result of a configured module is an error
+func TestReadRunMetricsMissingResult(t *testing.T) {
+    dir := t.TempDir()
+    writeTestRun(t,dir,"[measurements]\nmeasurement_modules = [\"tx_latency\"]\n",map[string]string{})
+
+    if _, err := readRunMetrics(dir,nil); err == nil {
+        t.Errorf("expected an error for the missing tx_latency.json")
+    }
+}
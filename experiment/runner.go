@@ -0,0 +1,403 @@
+package experiment
+
+import (
+    "blockchainlab/simulator/utils"
+    "github.com/spf13/viper"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    INDEX_FILE                                  = "index.json"      // index of all runs, in the output directory
+    RUN_CONFIG_FILE                             = "config.toml"     // config of a run, in its directory
+    RUN_OUTPUT_FILE                             = "output.log"      // stdout and stderr of a run, in its directory
+    RUN_DIR_FORMAT                              = "run-%04d"
+    DEFAULT_OUTPUT_DIR                          = "results"
+
+    RUN_STATUS_PENDING                          = "pending"
+    RUN_STATUS_OK                               = "ok"
+    RUN_STATUS_FAILED                           = "failed"
+)
+
+/*
+    Keys of the config with paths of input files (a path or a list of paths),
+    made absolute in the config of each run, since runs are executed in their
+    own directory. The trace file is an input only when verifying.
+*/
+var RUN_INPUT_PATH_KEYS                         = []string{"setup.plugins","simulation.resume","trace.file"}
+
+var runInputPathConditions                      = map[string]func(config *viper.Viper) bool{
+    "trace.file":   func(config *viper.Viper) bool { return config.GetString("trace.mode") == "verify" },
+}
+
+// ==== concrete structures ====
+
+// a single run of the experiment, as listed in the index
+type RunInfo struct {
+    ID int                                      `json:"id"`
+    Dir string                                  `json:"dir"`          // relative to the output directory
+    Parameters map[string]interface{}           `json:"parameters"`   // config overrides of the run
+    Status string                               `json:"status"`
+    ExitCode int                                `json:"exit_code"`
+    Error string                                `json:"error,omitempty"`
+    Duration float64                            `json:"duration"`     // wall-clock seconds
+}
+
+// index of the experiment, written to the output directory
+type Index struct {
+    BaseConfig string                           `json:"base_config"`
+    Command []string                            `json:"command"`
+    Sweep *Sweep                                `json:"sweep"`
+    Started time.Time                           `json:"started"`
+    Finished time.Time                          `json:"finished"`
+    NumFailed int                               `json:"num_failed"`
+    Runs []*RunInfo                             `json:"runs"`
+}
+
+/*
+    Runs every combination of a sweep, each in a separate process (any
+    executable built with the layers of the experiment). Every run gets its
+    own directory in the output directory, with the base config plus the
+    overrides of the run (config.toml) and the output of the process
+    (output.log). The command is run in that directory with "--config
+    config.toml" appended, so relative output paths of the simulation (e.g.,
+    measurement results) end up in the run directory, while relative input
+    paths (see RUN_INPUT_PATH_KEYS) are made absolute: from the directory of
+    the base config, or from the working directory if set by the sweep. Up to
+    "jobs" runs are executed in parallel, and the index is updated every time
+    a run finishes.
+*/
+type Runner struct {
+    baseConfig string
+    sweep *Sweep
+    command []string
+    outputDir string
+    jobs int
+    progress io.Writer
+    validate func(*utils.SimulationConfig) error
+
+    index *Index
+    lock sync.Mutex
+}
+
+// ==== factories ====
+
+/*
+    Creates a runner. The command is the simulation executable and its
+    arguments (e.g., a main package built with the custom layers of the
+    experiment). The number of jobs defaults to the number of CPUs if not
+    positive. Progress is reported to the given writer (nil to disable).
+*/
+func NewRunner(baseConfig string,sweep *Sweep,command []string,outputDir string,jobs int,progress io.Writer) *Runner {
+    if jobs <= 0 {
+        jobs = runtime.NumCPU()
+    }
+
+    return &Runner{
+        baseConfig:     baseConfig,
+        sweep:          sweep,
+        command:        command,
+        outputDir:      outputDir,
+        jobs:           jobs,
+        progress:       progress,
+        validate:       nil,
+        lock:           sync.Mutex{},
+    }
+}
+
+// ==== methods ====
+
+/*
+    Check the config of every run before starting any of them (e.g., with the
+    validation of the simulator), so that mistakes in the sweep do not show
+    up halfway through the experiment. Nil disables the check (default).
+*/
+func (runner *Runner) SetValidator(validate func(*utils.SimulationConfig) error) *Runner {
+    runner.validate = validate
+    return runner
+}
+
+// run all combinations: returns the index, and an error if the experiment could not be set up
+func (runner *Runner) Run() (*Index,error) {
+    if len(runner.command) == 0 {
+        return nil, fmt.Errorf("no simulation command given")
+    }
+
+    // commands with a path are run from the run directories
+    command := make([]string,len(runner.command))
+    copy(command,runner.command)
+    if strings.ContainsRune(command[0],os.PathSeparator) {
+        abs, err := filepath.Abs(command[0])
+        if err != nil {
+            return nil, err
+        }
+        command[0] = abs
+    }
+
+    if err := os.MkdirAll(runner.outputDir,0755); err != nil {
+        return nil, err
+    }
+
+    // set up runs
+    runner.index = &Index{
+        BaseConfig: runner.baseConfig,
+        Command:    runner.command,
+        Sweep:      runner.sweep,
+        Started:    time.Now(),
+    }
+
+    for id, parameters := range runner.sweep.Runs() {
+        run := &RunInfo{
+            ID:             id,
+            Dir:            fmt.Sprintf(RUN_DIR_FORMAT,id),
+            Parameters:     parameters,
+            Status:         RUN_STATUS_PENDING,
+        }
+
+        if err := runner.writeRunConfig(run); err != nil {
+            return nil, fmt.Errorf("run %d: %v",id,err)
+        }
+        runner.index.Runs = append(runner.index.Runs,run)
+    }
+
+    if err := runner.validateRuns(); err != nil {
+        return nil, err
+    }
+
+    if err := runner.writeIndex(); err != nil {
+        return nil, err
+    }
+
+    // run in parallel
+    runs := make(chan *RunInfo)
+    wg := sync.WaitGroup{}
+    finished := 0
+    for i := 0; i < runner.jobs; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for run := range runs {
+                runner.execute(run,command)
+
+                runner.lock.Lock()
+                finished++
+                if runner.progress != nil {
+                    fmt.Fprintf(runner.progress,"[%d/%d] %s %s (%.1fs)\n",finished,len(runner.index.Runs),run.Dir,run.Status,run.Duration)
+                }
+                runner.lock.Unlock()
+
+                if err := runner.writeIndex(); err != nil && runner.progress != nil {
+                    fmt.Fprintf(runner.progress,"cannot write index: %v\n",err)
+                }
+            }
+        }()
+    }
+
+    for _, run := range runner.index.Runs {
+        runs <- run
+    }
+    close(runs)
+    wg.Wait()
+
+    runner.lock.Lock()
+    runner.index.Finished = time.Now()
+    for _, run := range runner.index.Runs {
+        if run.Status != RUN_STATUS_OK {
+            runner.index.NumFailed++
+        }
+    }
+    runner.lock.Unlock()
+
+    return runner.index, runner.writeIndex()
+}
+
+// base config plus the overrides of the run, with absolute input paths
+func (runner *Runner) writeRunConfig(run *RunInfo) error {
+    dir := filepath.Join(runner.outputDir,run.Dir)
+    if err := os.MkdirAll(dir,0755); err != nil {
+        return err
+    }
+
+    workDir, err := os.Getwd()
+    if err != nil {
+        return err
+    }
+    baseDir := workDir
+
+    config := viper.New()
+    if runner.baseConfig != "" {
+        config.SetConfigFile(runner.baseConfig)
+        if err := config.ReadInConfig(); err != nil {
+            return err
+        }
+
+        path, err := filepath.Abs(runner.baseConfig)
+        if err != nil {
+            return err
+        }
+        baseDir = filepath.Dir(path)
+    }
+
+    for key, value := range run.Parameters {
+        config.Set(key,value)
+    }
+
+    for _, key := range RUN_INPUT_PATH_KEYS {
+        if condition, ok := runInputPathConditions[key]; !config.IsSet(key) || (ok && !condition(config)) {
+            continue
+        }
+
+        from := baseDir
+        if _, ok := run.Parameters[key]; ok {
+            from = workDir
+        }
+        config.Set(key,absolutePaths(config.Get(key),from))
+    }
+
+    return config.WriteConfigAs(filepath.Join(dir,RUN_CONFIG_FILE))
+}
+
+/*
+    Check the config of every run with the validator, if set: problems are
+    reported once with the runs they occur in, and no run is started if there
+    is any.
+*/
+func (runner *Runner) validateRuns() error {
+    if runner.validate == nil {
+        return nil
+    }
+
+    problems := make([]string,0)
+    problemRuns := make(map[string][]string)
+    for _, run := range runner.index.Runs {
+        config, err := utils.NewSimulationConfigFromFile(filepath.Join(runner.outputDir,run.Dir,RUN_CONFIG_FILE))
+        if err == nil {
+            err = runner.validate(config)
+        }
+        if err == nil {
+            continue
+        }
+
+        for _, problem := range strings.Split(err.Error(),"\n") {
+            if _, ok := problemRuns[problem]; !ok {
+                problems = append(problems,problem)
+            }
+            problemRuns[problem] = append(problemRuns[problem],run.Dir)
+        }
+    }
+
+    if len(problems) == 0 {
+        return nil
+    }
+
+    lines := make([]string,len(problems))
+    for i, problem := range problems {
+        lines[i] = fmt.Sprintf("%s (%s)",problem,strings.Join(problemRuns[problem],", "))
+    }
+
+    return fmt.Errorf("invalid run configs, no run started:\n%s",strings.Join(lines,"\n"))
+}
+
+// run the simulation process and record the outcome
+func (runner *Runner) execute(run *RunInfo,command []string) {
+    dir := filepath.Join(runner.outputDir,run.Dir)
+    start := time.Now()
+
+    status, exitCode, errMsg := RUN_STATUS_OK, 0, ""
+    output, err := os.Create(filepath.Join(dir,RUN_OUTPUT_FILE))
+    if err == nil {
+        args := append(command[1:len(command):len(command)],"--config",RUN_CONFIG_FILE)
+        cmd := exec.Command(command[0],args...)
+        cmd.Dir = dir
+        cmd.Stdout = output
+        cmd.Stderr = output
+        err = cmd.Run()
+        output.Close()
+
+        if exitErr, ok := err.(*exec.ExitError); ok {
+            exitCode = exitErr.ExitCode()
+        }
+    }
+
+    if err != nil {
+        status, errMsg = RUN_STATUS_FAILED, err.Error()
+        if exitCode == 0 {
+            exitCode = -1
+        }
+    }
+
+    runner.lock.Lock()
+    run.Status = status
+    run.ExitCode = exitCode
+    run.Error = errMsg
+    run.Duration = time.Since(start).Seconds()
+    runner.lock.Unlock()
+}
+
+// write the index to the output directory (through a temporary file, so it is never partially written)
+func (runner *Runner) writeIndex() error {
+    runner.lock.Lock()
+    defer runner.lock.Unlock()
+
+    data, err := json.MarshalIndent(runner.index,"","  ")
+    if err != nil {
+        return err
+    }
+
+    path := filepath.Join(runner.outputDir,INDEX_FILE)
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp,data,0644); err != nil {
+        return err
+    }
+
+    return os.Rename(tmp,path)
+}
+
+// ==== getters ====
+
+func (runner *Runner) GetIndex() *Index {
+    return runner.index
+}
+
+// ==== functions ====
+
+// relative paths (a path or a list of paths) made absolute from the given directory
+func absolutePaths(value interface{},dir string) interface{} {
+    absolute := func(path string) string {
+        if path == "" || filepath.IsAbs(path) {
+            return path
+        }
+        return filepath.Join(dir,path)
+    }
+
+    switch v := value.(type) {
+    case string:
+        return absolute(v)
+    case []string:
+        paths := make([]string,len(v))
+        for i, path := range v {
+            paths[i] = absolute(path)
+        }
+        return paths
+    case []interface{}:
+        paths := make([]interface{},len(v))
+        for i, path := range v {
+            if str, ok := path.(string); ok {
+                paths[i] = absolute(str)
+            } else {
+                paths[i] = path
+            }
+        }
+        return paths
+    }
+
+    return value
+}
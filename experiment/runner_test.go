@@ -0,0 +1,81 @@
+package experiment
+
+import (
+    "github.com/spf13/viper"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+// relative input paths of the base config are resolved from its directory, outputs are left relative
+func TestRunConfigHasAbsoluteInputPaths(t *testing.T) {
+    dir := t.TempDir()
+    baseDir := filepath.Join(dir,"experiments")
+    if err := os.MkdirAll(baseDir,0755); err != nil {
+        t.Fatal(err)
+    }
+
+    base := filepath.Join(baseDir,"base.toml")
+    content := `
+[setup]
+plugins = ["plugins/miner.so","/opt/plugins/net.so"]
+
+[simulation]
+resume = "checkpoints/warmup.gob"
+
+[trace]
+mode = "record"
+file = "run.trace"
+
+[tx_latency]
+output = "tx_latency.json"
+`
+    if err := os.WriteFile(base,[]byte(content),0644); err != nil {
+        t.Fatal(err)
+    }
+
+    sweep := NewSweep([]Parameter{{Key: "setup.node_count_list",Values: []interface{}{[]int{10}}}},0,DEFAULT_SEED_KEY,DEFAULT_SEED_START)
+    runner := NewRunner(base,sweep,[]string{"simulator"},filepath.Join(dir,"results"),1,nil)
+    run := &RunInfo{ID: 0,Dir: "run-0000",Parameters: map[string]interface{}{"simulation.seed": 1}}
+    if err := runner.writeRunConfig(run); err != nil {
+        t.Fatalf("cannot write run config: %v",err)
+    }
+
+    config := viper.New()
+    config.SetConfigFile(filepath.Join(dir,"results",run.Dir,RUN_CONFIG_FILE))
+    if err := config.ReadInConfig(); err != nil {
+        t.Fatalf("cannot read run config: %v",err)
+    }
+
+    plugins := []string{filepath.Join(baseDir,"plugins/miner.so"),"/opt/plugins/net.so"}
+    if got := config.GetStringSlice("setup.plugins"); !reflect.DeepEqual(got,plugins) {
+        t.Errorf("setup.plugins = %v, want %v",got,plugins)
+    }
+    if got, want := config.GetString("simulation.resume"),filepath.Join(baseDir,"checkpoints/warmup.gob"); got != want {
+        t.Errorf("simulation.resume = %q, want %q",got,want)
+    }
+    if got := config.GetString("trace.file"); got != "run.trace" {
+        t.Errorf("trace.file (recorded) = %q, want it relative to the run directory",got)
+    }
+    if got := config.GetString("tx_latency.output"); got != "tx_latency.json" {
+        t.Errorf("tx_latency.output = %q, want it relative to the run directory",got)
+    }
+
+    // the trace is an input when verifying, and paths set by the sweep are relative to the working directory
+    workDir, _ := os.Getwd()
+    run = &RunInfo{ID: 1,Dir: "run-0001",Parameters: map[string]interface{}{"trace.mode": "verify","simulation.resume": "other.gob"}}
+    if err := runner.writeRunConfig(run); err != nil {
+        t.Fatalf("cannot write run config: %v",err)
+    }
+    config.SetConfigFile(filepath.Join(dir,"results",run.Dir,RUN_CONFIG_FILE))
+    if err := config.ReadInConfig(); err != nil {
+        t.Fatalf("cannot read run config: %v",err)
+    }
+    if got, want := config.GetString("trace.file"),filepath.Join(baseDir,"run.trace"); got != want {
+        t.Errorf("trace.file (verified) = %q, want %q",got,want)
+    }
+    if got, want := config.GetString("simulation.resume"),filepath.Join(workDir,"other.gob"); got != want {
+        t.Errorf("simulation.resume (sweep) = %q, want %q",got,want)
+    }
+}
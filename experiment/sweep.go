@@ -0,0 +1,161 @@
+package experiment
+
+import (
+    "github.com/spf13/viper"
+    "fmt"
+    "reflect"
+    "sort"
+)
+
+const (
+    SWEEP_TAG                                   = "sweep"           // section with the parameters to sweep
+    REPLICATIONS_TAG                            = "replications"    // section with the seed replication settings
+
+    DEFAULT_SEED_KEY                            = "simulation.seed"
+    DEFAULT_SEED_START                          = 1
+)
+
+// ==== concrete structures ====
+
+// config key and the values it takes in the sweep
+type Parameter struct {
+    Key string                                  `json:"key"`
+    Values []interface{}                        `json:"values"`
+}
+
+/*
+    Sweep specification: every combination of the values of the parameters is
+    run, and each combination is replicated with different seeds. Read from a
+    TOML file like:
+
+        [sweep]
+        "default_global_network.p2p_config" = [[0.05,0.05,0.01,0.5],[0.1,0.05,0.01,0.5]]
+        "setup.node_count_list" = [[10],[50]]
+
+        [replications]
+        count = 30                      # seeds seed_start, seed_start+1, ...
+        seed_key = "simulation.seed"
+        seed_start = 1
+
+    Parameters are sorted by key, and combinations are enumerated with the last
+    parameter varying fastest (replications innermost).
+*/
+type Sweep struct {
+    Parameters []Parameter                      `json:"parameters"`
+    Replications int                            `json:"replications"`
+    SeedKey string                              `json:"seed_key"`
+    SeedStart int64                             `json:"seed_start"`
+}
+
+// ==== factories ====
+
+func NewSweep(parameters []Parameter,replications int,seedKey string,seedStart int64) *Sweep {
+    sorted := make([]Parameter,len(parameters))
+    copy(sorted,parameters)
+    sort.SliceStable(sorted,func(i,j int) bool {
+        return sorted[i].Key < sorted[j].Key
+    })
+
+    return &Sweep{
+        Parameters:     sorted,
+        Replications:   replications,
+        SeedKey:        seedKey,
+        SeedStart:      seedStart,
+    }
+}
+
+// read a sweep specification from a TOML file
+func ReadSweep(path string) (*Sweep,error) {
+    config := viper.New()
+    config.SetConfigFile(path)
+    config.SetDefault(REPLICATIONS_TAG + ".count",0)
+    config.SetDefault(REPLICATIONS_TAG + ".seed_key",DEFAULT_SEED_KEY)
+    config.SetDefault(REPLICATIONS_TAG + ".seed_start",DEFAULT_SEED_START)
+    if err := config.ReadInConfig(); err != nil {
+        return nil, err
+    }
+
+    // keys may be quoted ("section.key") or nested tables ([sweep.section])
+    values := make(map[string]interface{})
+    flattenSweep("",config.GetStringMap(SWEEP_TAG),values)
+
+    parameters := make([]Parameter,0,len(values))
+    for key, value := range values {
+        list := reflect.ValueOf(value)
+        if list.Kind() != reflect.Slice || list.Len() == 0 {
+            return nil, fmt.Errorf("%s: sweep parameter %s must be a non-empty list of values",path,key)
+        }
+
+        parameter := Parameter{
+            Key:    key,
+            Values: make([]interface{},list.Len()),
+        }
+        for i := range parameter.Values {
+            parameter.Values[i] = list.Index(i).Interface()
+        }
+        parameters = append(parameters,parameter)
+    }
+
+    replications := config.GetInt(REPLICATIONS_TAG + ".count")
+    if replications < 0 {
+        return nil, fmt.Errorf("%s: %s.count must not be negative",path,REPLICATIONS_TAG)
+    }
+
+    return NewSweep(parameters,replications,config.GetString(REPLICATIONS_TAG + ".seed_key"),config.GetInt64(REPLICATIONS_TAG + ".seed_start")), nil
+}
+
+// ==== methods ====
+
+// config overrides of every run, in order
+func (sweep *Sweep) Runs() []map[string]interface{} {
+    runs := []map[string]interface{}{make(map[string]interface{})}
+    for _, parameter := range sweep.Parameters {
+        next := make([]map[string]interface{},0,len(runs) * len(parameter.Values))
+        for _, run := range runs {
+            for _, value := range parameter.Values {
+                next = append(next,extendRun(run,parameter.Key,value))
+            }
+        }
+        runs = next
+    }
+
+    if sweep.Replications > 0 {
+        next := make([]map[string]interface{},0,len(runs) * sweep.Replications)
+        for _, run := range runs {
+            for i := 0; i < sweep.Replications; i++ {
+                next = append(next,extendRun(run,sweep.SeedKey,sweep.SeedStart + int64(i)))
+            }
+        }
+        runs = next
+    }
+
+    return runs
+}
+
+// ==== functions ====
+
+// copy of run with one more parameter
+func extendRun(run map[string]interface{},key string,value interface{}) map[string]interface{} {
+    extended := make(map[string]interface{},len(run) + 1)
+    for k, v := range run {
+        extended[k] = v
+    }
+    extended[key] = value
+
+    return extended
+}
+
+// flatten nested tables into dotted keys
+func flattenSweep(prefix string,table map[string]interface{},values map[string]interface{}) {
+    for key, value := range table {
+        if prefix != "" {
+            key = prefix + "." + key
+        }
+
+        if nested, ok := value.(map[string]interface{}); ok {
+            flattenSweep(key,nested,values)
+        } else {
+            values[key] = value
+        }
+    }
+}
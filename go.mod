@@ -3,6 +3,8 @@ module blockchainlab/simulator
 go 1.19
 
 require (
+	github.com/pelletier/go-toml/v2 v2.0.5
+	github.com/spf13/cast v1.5.0
 	github.com/spf13/viper v1.14.0
 	github.com/zeebo/xxh3 v1.0.2
 	go.uber.org/zap v1.21.0
@@ -15,9 +17,7 @@ require (
 	github.com/magiconair/properties v1.8.6 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/pelletier/go-toml v1.9.5 // indirect
-	github.com/pelletier/go-toml/v2 v2.0.5 // indirect
 	github.com/spf13/afero v1.9.2 // indirect
-	github.com/spf13/cast v1.5.0 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/subosito/gotenv v1.4.1 // indirect
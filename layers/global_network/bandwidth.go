@@ -0,0 +1,270 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "math"
+    "sync"
+)
+
+const (
+    BANDWIDTH_GNET_TAG                          = "bandwidth_global_network"        // tag for registry, log and config section
+    DEFAULT_UPLOAD_BANDWIDTH                    = 20000000.0                        // bits per second
+    DEFAULT_DOWNLOAD_BANDWIDTH                  = 50000000.0                        // bits per second
+)
+
+// ==== concrete structures  ====
+
+/*
+    Global network in which every node has a finite upload and download
+    bandwidth. A message is transmitted to each receiver separately, and both
+    links transmit one message at a time, in the order messages are sent: the
+    upload link of the sender is busy for size over upload bandwidth, and the
+    download link of the receiver starts when the first bit arrives
+    (propagation latency after the upload starts) and is busy for size over
+    download bandwidth, but cannot finish before the last bit arrives. The
+    message is delivered when its download finishes, so its delay is queueing
+    time on both links, plus transmission time at the bandwidth of the slower
+    link, plus propagation latency, which is sampled from the distributions
+    for broadcast and p2p messages as in DefaultGlobalNetwork. Large messages,
+    e.g. blocks, thus take longer and delay the messages sent after them by
+    the same node, or to the same node.
+
+    Bandwidths are the same for all nodes, unless set for a node with
+    SetNodeBandwidth.
+
+    Implements: IGlobalNetwork, ISnapshotable, and IConfigValidator
+*/
+type BandwidthGlobalNetwork struct {
+    *DefaultGlobalNetwork
+
+    upload float64                              // bits per second
+    download float64                            // bits per second
+    nodeBandwidth map[uint32][2]float64         // upload and download bandwidths set explicitly
+
+    uploadFree map[uint32]float64               // time at which the upload link of a node is free
+    downloadFree map[uint32]float64             // time at which the download link of a node is free
+    linkLock sync.Mutex
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".upload_bandwidth",DEFAULT_UPLOAD_BANDWIDTH)
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".download_bandwidth",DEFAULT_DOWNLOAD_BANDWIDTH)
+
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".broadcast_distribution",DEFAULT_BROADCAST_DISTRIBUTION)
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".p2p_distribution",DEFAULT_P2P_DISTRIBUTION)
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".broadcast_config",nil)
+    utils.ConfigSetDefault(BANDWIDTH_GNET_TAG + ".p2p_config",nil)
+    setFaultDefaults(BANDWIDTH_GNET_TAG)
+    setPartitionDefaults(BANDWIDTH_GNET_TAG)
+
+    // register factory
+    core.RegisterGlobalNetwork(BANDWIDTH_GNET_TAG,NewBandwidthGlobalNetwork)
+}
+
+// factory for BandwidthGlobalNetwork: bandwidths and distributions are read from the config of the simulation in Init
+func NewBandwidthGlobalNetwork() core.IGlobalNetwork {
+    return &BandwidthGlobalNetwork{
+        DefaultGlobalNetwork:       NewDefaultGlobalNetwork().(*DefaultGlobalNetwork),
+        upload:                     0,
+        download:                   0,
+        nodeBandwidth:              make(map[uint32][2]float64),
+        uploadFree:                 make(map[uint32]float64),
+        downloadFree:               make(map[uint32]float64),
+        linkLock:                   sync.Mutex{},
+    }
+}
+
+// ==== methods ====
+
+func (net *BandwidthGlobalNetwork) Init(sim core.ISimulation,components ...core.ISimulationComponent){
+    net.DefaultComponent.Init(sim)
+    net.logger = sim.GetLogger(BANDWIDTH_GNET_TAG)
+
+    // the config is checked by ValidateConfig before creating the simulation
+    config := net.GetConfigSection(BANDWIDTH_GNET_TAG)
+    net.upload = config.GetFloat64("upload_bandwidth")
+    net.download = config.GetFloat64("download_bandwidth")
+    net.initSamplers(config)
+    net.initFaults(config)
+    net.initPartitions(config)
+
+    net.logger.Debug("initializing with upload=%v, download=%v, p2pSampler=%v and broadcastSampler=%v",net.upload,net.download,net.p2pSampler.GetDistName(),net.broadcastSampler.GetDistName())
+}
+
+func (net *BandwidthGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetwork {
+    now := net.GetTime()
+    msg.SetTime(now)
+    receivers, isBroadcast := net.GetReceivers(msg)
+
+    sampler := net.p2pSampler
+    if isBroadcast {
+        sampler = net.broadcastSampler
+    }
+
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    sender := msg.GetSender()
+    bits := float64(msg.GetSize()) * 8
+    upload, _ := net.getBandwidth(sender)
+    for _, node := range receivers {
+        nodeID := node.GetID()
+        _, download := net.getBandwidth(nodeID)
+        latency := sampler.Sample()
+
+        // upload after the messages sent before by the sender
+        uploadStart := math.Max(now,net.uploadFree[sender])
+        uploadEnd := uploadStart + bits / upload
+        net.uploadFree[sender] = uploadEnd
+
+        // download after the messages sent before to the receiver, not before the last bit arrives
+        downloadStart := math.Max(uploadStart + latency,net.downloadFree[nodeID])
+        downloadEnd := math.Max(downloadStart + bits / download,uploadEnd + latency)
+        net.downloadFree[nodeID] = downloadEnd
+
+        net.deliver(msg,node,downloadEnd - now,isBroadcast)
+    }
+
+    return net
+}
+
+func (net *BandwidthGlobalNetwork) Disconnect(node core.INode) core.IGlobalNetwork {
+    net.DefaultGlobalNetwork.Disconnect(node)
+
+    // messages in transit are still delivered, but the links of the node are free when it connects again
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    delete(net.uploadFree,node.GetID())
+    delete(net.downloadFree,node.GetID())
+
+    return net
+}
+
+// implements core.IConfigValidator: bandwidths, distributions and their parameters
+func (net *BandwidthGlobalNetwork) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    section := utils.NewConfigSection(config,BANDWIDTH_GNET_TAG,net.GetConfigInstance())
+
+    for _, key := range []string{"upload_bandwidth","download_bandwidth"} {
+        if section.GetFloat64(key) <= 0 {
+            errs.Add(section.GetKey(key),"must be positive")
+        }
+    }
+    net.validateSamplers(section,&errs)
+    net.validateFaults(section,&errs)
+    net.validatePartitions(section,&errs)
+
+    return errs.Err()
+}
+
+// set the upload and download bandwidths of a node (bits per second), instead of the configured ones
+func (net *BandwidthGlobalNetwork) SetNodeBandwidth(nodeID uint32,upload float64,download float64) {
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    net.nodeBandwidth[nodeID] = [2]float64{upload,download}
+}
+
+/*
+    Implements core.ISnapshotable: broadcast settings, bandwidths set
+    explicitly, and when links are free (messages in transit are in the queue
+    of events).
+*/
+func (net *BandwidthGlobalNetwork) Snapshot() ([]byte,error) {
+    broadcast, err := net.DefaultGlobalNetwork.Snapshot()
+    if err != nil {
+        return nil, err
+    }
+
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    return core.EncodeSnapshot(bandwidthGlobalNetworkGob{
+        Broadcast:      broadcast,
+        NodeBandwidth:  net.nodeBandwidth,
+        UploadFree:     net.uploadFree,
+        DownloadFree:   net.downloadFree,
+    })
+}
+
+// implements core.ISnapshotable
+func (net *BandwidthGlobalNetwork) Restore(data []byte) error {
+    snapshot := bandwidthGlobalNetworkGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    if err := net.DefaultGlobalNetwork.Restore(snapshot.Broadcast); err != nil {
+        return err
+    }
+
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    net.nodeBandwidth = snapshot.NodeBandwidth
+    if net.nodeBandwidth == nil {
+        net.nodeBandwidth = make(map[uint32][2]float64)
+    }
+    net.uploadFree = snapshot.UploadFree
+    if net.uploadFree == nil {
+        net.uploadFree = make(map[uint32]float64)
+    }
+    net.downloadFree = snapshot.DownloadFree
+    if net.downloadFree == nil {
+        net.downloadFree = make(map[uint32]float64)
+    }
+
+    return nil
+}
+
+// state of BandwidthGlobalNetwork, for snapshots
+type bandwidthGlobalNetworkGob struct {
+    Broadcast []byte
+    NodeBandwidth map[uint32][2]float64
+    UploadFree map[uint32]float64
+    DownloadFree map[uint32]float64
+}
+
+// ==== getters ====
+
+func (net *BandwidthGlobalNetwork) GetName() string {
+    return BANDWIDTH_GNET_TAG
+}
+
+// upload and download bandwidths of a node (bits per second)
+func (net *BandwidthGlobalNetwork) GetNodeBandwidth(nodeID uint32) (float64,float64) {
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    return net.getBandwidth(nodeID)
+}
+
+// time until the upload link of a node is free, i.e. how long a message sent now would wait
+func (net *BandwidthGlobalNetwork) GetUploadBacklog(nodeID uint32) float64 {
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    return math.Max(0,net.uploadFree[nodeID] - net.GetTime())
+}
+
+// time until the download link of a node is free, i.e. until it has received the messages already sent to it
+func (net *BandwidthGlobalNetwork) GetDownloadBacklog(nodeID uint32) float64 {
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    return math.Max(0,net.downloadFree[nodeID] - net.GetTime())
+}
+
+// bandwidths of a node, with linkLock held
+func (net *BandwidthGlobalNetwork) getBandwidth(nodeID uint32) (float64,float64) {
+    if bandwidth, ok := net.nodeBandwidth[nodeID]; ok {
+        return bandwidth[0], bandwidth[1]
+    }
+
+    return net.upload, net.download
+}
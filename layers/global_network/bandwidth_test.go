@@ -0,0 +1,111 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Messages of 1000 bytes with a latency of 0.1 s, an upload bandwidth of
+    8000 bits/s (1 s per message) and a download bandwidth of 16000 bits/s
+    (0.5 s per message), unless set for a node. A message is delivered when
+    both its upload and its download have finished.
+*/
+func TestBandwidthQueues(t *testing.T) {
+    type testSend struct {
+        from uint32
+        to uint32                                   // 0: broadcast
+        time float64
+    }
+
+    tests := []struct{
+        name string
+        numNodes int
+        slow uint32                                 // node with a download bandwidth of 4000 bits/s (0: none)
+        sends []testSend
+        want map[int]map[uint32][]float64           // reception times of each message at each node
+    }{
+        {
+            // the upload link is busy for the upload time only, even if the receiver is slower
+            "back-to-back sends",3,2,
+            []testSend{{1,2,1},{1,3,1}},
+            map[int]map[uint32][]float64{0: {2: {3.1}},1: {3: {3.1}}},
+        },
+        {
+            // copies of a broadcast are uploaded one after the other, the slow receiver queues the next message
+            "slow receiver",3,3,
+            []testSend{{1,0,1},{2,3,3}},
+            map[int]map[uint32][]float64{0: {2: {2.1},3: {4.1}},1: {3: {6.1}}},
+        },
+        {
+            // uploads from different senders overlap, downloads at the receiver are queued
+            "concurrent senders",4,0,
+            []testSend{{1,4,1},{2,4,1},{3,4,1}},
+            map[int]map[uint32][]float64{0: {4: {2.1}},1: {4: {2.6}},2: {4: {3.1}}},
+        },
+    }
+
+    for _, test := range tests {
+        net := newTestNetwork(t,BANDWIDTH_GNET_TAG,test.numNodes,0.1,map[string]interface{}{
+            BANDWIDTH_GNET_TAG + ".upload_bandwidth":       8000.0,
+            BANDWIDTH_GNET_TAG + ".download_bandwidth":     16000.0,
+        })
+        if test.slow != 0 {
+            net.gnet.(*BandwidthGlobalNetwork).SetNodeBandwidth(test.slow,8000,4000)
+        }
+
+        msgs := make([]core.IMessage,len(test.sends))
+        for i, send := range test.sends {
+            if send.to == 0 {
+                msgs[i] = core.NewBroadcastMessage(i,send.from).SetSize(1000)
+            } else {
+                msgs[i] = core.NewP2PMessage(i,send.from,send.to).SetSize(1000)
+            }
+            net.send(msgs[i],send.time)
+        }
+        net.run(10)
+
+        for i, msg := range msgs {
+            for node := uint32(1); node <= uint32(test.numNodes); node++ {
+                if got := net.receptions(node,msg); !equalTimes(got,test.want[i][node]) {
+                    t.Errorf("%s: message %d received by node %d at %v, want %v",test.name,i,node,got,test.want[i][node])
+                }
+            }
+        }
+    }
+}
+
+/*
+    When links are free is kept in snapshots: a message of 1000 bytes from
+    node 1 to node 2 at time 1, restored in a simulation at time 0.
+*/
+func TestBandwidthSnapshot(t *testing.T) {
+    settings := map[string]interface{}{
+        BANDWIDTH_GNET_TAG + ".upload_bandwidth":       8000.0,
+        BANDWIDTH_GNET_TAG + ".download_bandwidth":     16000.0,
+    }
+    net := newTestNetwork(t,BANDWIDTH_GNET_TAG,2,0.1,settings)
+    net.send(core.NewP2PMessage(0,1,2).SetSize(1000),1)
+    net.run(1)
+
+    data, err := net.gnet.(*BandwidthGlobalNetwork).Snapshot()
+    if err != nil {
+        t.Fatalf("cannot take snapshot: %v",err)
+    }
+
+    restored := newTestNetwork(t,BANDWIDTH_GNET_TAG,2,0.1,settings)
+    restored.run(0)
+    gnet := restored.gnet.(*BandwidthGlobalNetwork)
+    if err := gnet.Restore(data); err != nil {
+        t.Fatalf("cannot restore snapshot: %v",err)
+    }
+
+    if upload, download := gnet.GetUploadBacklog(1), gnet.GetDownloadBacklog(2); !equalTime(upload,2) || !equalTime(download,2.1) {
+        t.Errorf("upload backlog of node 1 %v, download backlog of node 2 %v, want 2 and 2.1",upload,download)
+    }
+    if upload, download := gnet.GetUploadBacklog(2), gnet.GetDownloadBacklog(1); upload != 0 || download != 0 {
+        t.Errorf("upload backlog of node 2 %v, download backlog of node 1 %v, want 0",upload,download)
+    }
+}
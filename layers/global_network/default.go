@@ -3,6 +3,7 @@ package global_network
 import (
     "blockchainlab/simulator/core"
     "blockchainlab/simulator/utils"
+    "sort"
     "strconv"
     "sync"
     "math/rand"
@@ -28,14 +29,18 @@ var DEFAULT_ZIPF_CONFIG                         = []float64{}
 
 /*
     Simple global network that uses statistical distributions to compute the
-    propagation delay of p2p and broadcast messages. 
+    propagation delay of p2p and broadcast messages. Messages can be lost,
+    duplicated, and reordered, with faults set per delivery type or per link
+    (see MessageFaults), and the network can be partitioned, from the config
+    or with Partition and Heal. 
 
-    Implements: IGlobalNetwork
+    Implements: IGlobalNetwork and IConfigValidator
 */
 type DefaultGlobalNetwork struct {
     core.DefaultComponent
 
     nodeMap map[uint32]core.INode
+    nodeIDs []uint32                            // sorted ids of connected nodes, so delivery order is deterministic
     nodeTypeMap map[uint16][]core.INode
     nodeMapLock sync.RWMutex
     globalBroadcastActive map[uint32]bool
@@ -43,10 +48,23 @@ type DefaultGlobalNetwork struct {
     broadcastSampler utils.ISimulationSampler
     p2pSampler utils.ISimulationSampler
 
+    broadcastFaults MessageFaults
+    p2pFaults MessageFaults
+    linkFaults map[uint64]MessageFaults         // faults of links, by linkKey
+    faultsLock sync.RWMutex
+    rng *rand.Rand
+
+    partition *core.NetworkPartition
+    partitionGroups map[uint32]int              // group of each node in the partition (0: not in any group)
+    heldMessages []heldMessage
+    partitionLock sync.Mutex
+
     broadcastDist string
     broadcastConfig []string
     p2pDist string
     p2pConfig []string
+
+    logger utils.ISimulationLogger
 }
 
 // ==== factories ====
@@ -58,13 +76,25 @@ func init() {
     
     utils.ConfigSetDefault(DEFAULT_GNET_TAG + ".broadcast_config", nil)
     utils.ConfigSetDefault(DEFAULT_GNET_TAG + ".p2p_config", nil)
+    setFaultDefaults(DEFAULT_GNET_TAG)
+    setPartitionDefaults(DEFAULT_GNET_TAG)
 
     // register factory
     core.RegisterGlobalNetwork(DEFAULT_GNET_TAG,NewDefaultGlobalNetwork)
 }
 
 // build a sampler accoring to configuration
-func buildSampler(distName string, distConfig []string,rng *rand.Rand) utils.ISimulationSampler {
+func buildSampler(distName string, distConfig []string,rng *rand.Rand) (utils.ISimulationSampler,error) {
+    configValues, err := samplerConfig(distName,distConfig)
+    if err != nil {
+        return nil, err
+    }
+
+    return utils.NewSampler(distName,configValues,rng)
+}
+
+// parameters of a distribution: the configured ones, or the defaults of the distribution if not set
+func samplerConfig(distName string, distConfig []string) ([]float64,error) {
     var configValues []float64 = nil
 
     if distConfig != nil {
@@ -72,7 +102,7 @@ func buildSampler(distName string, distConfig []string,rng *rand.Rand) utils.ISi
         for _, str := range distConfig {
             f, err := strconv.ParseFloat(str,64)
             if err != nil {
-                panic(err)
+                return nil, err
             }
             configValues = append(configValues,f)
         }
@@ -91,36 +121,33 @@ func buildSampler(distName string, distConfig []string,rng *rand.Rand) utils.ISi
         }
     }
 
-    return utils.NewSampler(distName,configValues,rng)
+    return configValues, nil
 }
 
-var gnetLogger utils.ISimulationLogger = nil
-
+// factory for DefaultGlobalNetwork: distributions are read from the config of the simulation in Init
 func NewDefaultGlobalNetwork() core.IGlobalNetwork {
-    config := utils.GetSimulationConfig()
-
-    broadcastDist := config.GetString(DEFAULT_GNET_TAG + ".broadcast_distribution") 
-    broadcastConfig := config.GetStringSlice(DEFAULT_GNET_TAG + ".broadcast_config")
-
-    p2pDist := config.GetString(DEFAULT_GNET_TAG + ".p2p_distribution")
-    p2pConfig := config.GetStringSlice(DEFAULT_GNET_TAG + ".p2p_config")
-
-    // gnetLogger
-    if gnetLogger == nil {
-        gnetLogger = utils.GetSimulationLogger(DEFAULT_GNET_TAG)
-    }
-   
     return &DefaultGlobalNetwork{
         nodeMap:                    make(map[uint32]core.INode),
+        nodeIDs:                    make([]uint32,0,100),
         nodeTypeMap:                make(map[uint16][]core.INode),
         nodeMapLock:                sync.RWMutex{},
         globalBroadcastActive:      make(map[uint32]bool),
         broadcastSampler:           nil,
         p2pSampler:                 nil,
-        broadcastDist:              broadcastDist,
-        broadcastConfig:            broadcastConfig,
-        p2pDist:                    p2pDist,
-        p2pConfig:                  p2pConfig,
+        broadcastFaults:            MessageFaults{},
+        p2pFaults:                  MessageFaults{},
+        linkFaults:                 make(map[uint64]MessageFaults),
+        faultsLock:                 sync.RWMutex{},
+        rng:                        nil,
+        partition:                  nil,
+        partitionGroups:            nil,
+        heldMessages:               make([]heldMessage,0),
+        partitionLock:              sync.Mutex{},
+        broadcastDist:              "",
+        broadcastConfig:            nil,
+        p2pDist:                    "",
+        p2pConfig:                  nil,
+        logger:                     nil,
     }
 }
 
@@ -128,12 +155,70 @@ func NewDefaultGlobalNetwork() core.IGlobalNetwork {
 
 func (net *DefaultGlobalNetwork) Init(sim core.ISimulation,components ...core.ISimulationComponent){
     net.DefaultComponent.Init(sim)
+    net.logger = sim.GetLogger(DEFAULT_GNET_TAG)
+    config := net.GetConfigSection(DEFAULT_GNET_TAG)
+    net.initSamplers(config)
+    net.initFaults(config)
+    net.initPartitions(config)
+
+    net.logger.Debug("initializing with p2pSampler=%v and broadcastSampler=%v",net.p2pSampler.GetDistName(),net.broadcastSampler.GetDistName())
+}
+
+// implements core.IConfigValidator: distributions and their parameters, faults, and partitions
+func (net *DefaultGlobalNetwork) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    section := utils.NewConfigSection(config,DEFAULT_GNET_TAG,net.GetConfigInstance())
+    net.validateSamplers(section,&errs)
+    net.validateFaults(section,&errs)
+    net.validatePartitions(section,&errs)
+
+    return errs.Err()
+}
 
-    rng := sim.GetRNG()
-    net.broadcastSampler = buildSampler(net.broadcastDist,net.broadcastConfig,rng)
-    net.p2pSampler = buildSampler(net.p2pDist,net.p2pConfig,rng)
+/*
+    Build the samplers for broadcast and p2p messages from a section of the
+    config, which is checked by validateSamplers before creating the
+    simulation. Networks embedding DefaultGlobalNetwork use it with their own
+    section. If the config was not validated, invalid distributions are
+    logged and replaced by the default ones.
+*/
+func (net *DefaultGlobalNetwork) initSamplers(config *utils.ConfigSection) {
+    net.broadcastDist = config.GetString("broadcast_distribution") 
+    net.broadcastConfig = config.GetStringSlice("broadcast_config")
+    net.p2pDist = config.GetString("p2p_distribution")
+    net.p2pConfig = config.GetStringSlice("p2p_config")
+
+    var err error
+    rng := net.GetSimulation().GetRNG()
+    if net.broadcastSampler, err = buildSampler(net.broadcastDist,net.broadcastConfig,rng); err != nil {
+        net.logger.Error("%s: broadcast distribution: %v: using the default (%s)",config.GetName(),err,DEFAULT_BROADCAST_DISTRIBUTION)
+        net.broadcastDist, net.broadcastConfig = DEFAULT_BROADCAST_DISTRIBUTION, nil
+        net.broadcastSampler, _ = buildSampler(net.broadcastDist,nil,rng)
+    }
+    if net.p2pSampler, err = buildSampler(net.p2pDist,net.p2pConfig,rng); err != nil {
+        net.logger.Error("%s: p2p distribution: %v: using the default (%s)",config.GetName(),err,DEFAULT_P2P_DISTRIBUTION)
+        net.p2pDist, net.p2pConfig = DEFAULT_P2P_DISTRIBUTION, nil
+        net.p2pSampler, _ = buildSampler(net.p2pDist,nil,rng)
+    }
+}
+
+// check the distributions and their parameters in a section of the config
+func (net *DefaultGlobalNetwork) validateSamplers(section *utils.ConfigSection,errs *utils.ConfigErrors) {
+    for _, kind := range []string{"broadcast","p2p"} {
+        distName := section.GetString(kind + "_distribution")
+        distConfig := section.GetStringSlice(kind + "_config")
 
-    gnetLogger.Debug("initializing with p2pSampler=%v and broadcastSampler=%v",net.p2pSampler.GetDistName(),net.broadcastSampler.GetDistName())
+        configValues, err := samplerConfig(distName,distConfig)
+        if err != nil {
+            errs.AddError(section.GetKey(kind + "_config"),err)
+            continue
+        }
+        if err := utils.CheckSamplerConfig(distName,configValues); err != nil && distConfig != nil {
+            errs.AddError(section.GetKey(kind + "_config"),err)
+        } else if err != nil {
+            errs.AddError(section.GetKey(kind + "_distribution"),err)
+        }
+    }
 }
 
 func (net *DefaultGlobalNetwork) HandleEvent(event utils.IEvent) bool {
@@ -147,24 +232,50 @@ func (net *DefaultGlobalNetwork) HandleEvent(event utils.IEvent) bool {
         msg := event.GetData().(core.IMessage)
         dest.SendMessage(msg)
         return true
+    case core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED:
+        return true // only reported to hooks
+    case core.GLOBAL_NETWORK_EVENT_PARTITION:
+        dest.Partition(event.GetData().(*core.NetworkPartition))
+        return true
+    case core.GLOBAL_NETWORK_EVENT_HEAL:
+        dest.Heal(event.GetData().(bool))
+        return true
     }
 
     return false
 }
 
 func (net *DefaultGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetwork {
-    var sampler utils.ISimulationSampler
+    msg.SetTime(net.GetTime())
+    receivers, isBroadcast := net.GetReceivers(msg)
+
+    sampler := net.p2pSampler
+    if isBroadcast {
+        sampler = net.broadcastSampler
+    }
+
+    for _, node := range receivers {
+        net.deliver(msg,node,sampler.Sample(),isBroadcast)
+    }
+
+    return net
+}
+
+/*
+    Connected nodes a message is delivered to according to its delivery (no
+    loopback, and only nodes with broadcast enabled for global broadcasts), in
+    a deterministic order, and whether it is a broadcast. Networks embedding
+    DefaultGlobalNetwork use it to compute delays in their own way.
+*/
+func (net *DefaultGlobalNetwork) GetReceivers(msg core.IMessage) ([]core.INode,bool) {
     isBroadcast := false
     dtype := 0 // 0: specific nodes, 1: types, 2: excl. types
     
-    msg.SetTime(net.GetTime())
     delivery := msg.GetDelivery()
     switch delivery.GetDeliveryType() {
     case core.MESSAGE_DELIVERY_TYPE_P2P_NODES,core.MESSAGE_DELIVERY_TYPE_P2P_NODE_TYPES,core.MESSAGE_DELIVERY_TYPE_P2P_NODE_TYPES_EXCEPT:
-        sampler = net.p2pSampler
         isBroadcast = false
     default:
-        sampler = net.broadcastSampler
         isBroadcast = true
     }
 
@@ -179,10 +290,14 @@ func (net *DefaultGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetw
         panic("delivery type not supported")
     }
 
+    receivers := make([]core.INode,0)
     net.nodeMapLock.RLock()
+    defer net.nodeMapLock.RUnlock()
+
     targets := delivery.GetDeliveryTargets()
     if targets == nil { // all nodes
-        for _, node := range net.nodeMap {
+        for _, nodeID := range net.nodeIDs {
+            node := net.nodeMap[nodeID]
             if node.GetID() == msg.GetSender() { // no loopback
                 continue
             }
@@ -191,20 +306,16 @@ func (net *DefaultGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetw
                 continue
             }
     
-            ev := utils.NewEvent(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,node.GetNodeNetwork())
-            delay := sampler.Sample()
-            net.ScheduleEvent(ev,delay)
+            receivers = append(receivers,node)
         }
     } else {
         switch dtype {
         case 0: // specific nodes
             for _, nodeID := range targets {
                 if node,ok := net.nodeMap[nodeID]; ok {
-                    ev := utils.NewEvent(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,node.GetNodeNetwork())
-                    delay := sampler.Sample()
-                    net.ScheduleEvent(ev,delay)
+                    receivers = append(receivers,node)
                 } else {
-                    gnetLogger.Debug("node %d not connected",nodeID)
+                    net.logger.Debug("node %d not connected",nodeID)
                 }
             }
         case 1: // nodes of specified types
@@ -215,14 +326,19 @@ func (net *DefaultGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetw
                             continue
                         }
 
-                        ev := utils.NewEvent(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,node.GetNodeNetwork())
-                        delay := sampler.Sample()
-                        net.ScheduleEvent(ev,delay)
+                        receivers = append(receivers,node)
                     }
                 }
             }
         default: // nodes not of specified types
-            for tp, nodeList := range net.nodeTypeMap {
+            types := make([]uint16,0,len(net.nodeTypeMap))
+            for tp := range net.nodeTypeMap {
+                types = append(types,tp)
+            }
+            sort.Slice(types,func(i,j int) bool { return types[i] < types[j] })
+
+            for _, tp := range types {
+                nodeList := net.nodeTypeMap[tp]
                 found := false
                 for _, targetType := range targets {
                     if uint32(tp) == targetType {
@@ -237,17 +353,14 @@ func (net *DefaultGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetw
                             continue
                         }
 
-                        ev := utils.NewEvent(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,node.GetNodeNetwork())
-                        delay := sampler.Sample()
-                        net.ScheduleEvent(ev,delay)
+                        receivers = append(receivers,node)
                     }
                 }
             }
         }
     }
-    net.nodeMapLock.RUnlock()
 
-    return net
+    return receivers, isBroadcast
 }
 
 func (net *DefaultGlobalNetwork) Connect(node core.INode) core.IGlobalNetwork {
@@ -257,6 +370,10 @@ func (net *DefaultGlobalNetwork) Connect(node core.INode) core.IGlobalNetwork {
     if _, ok := net.nodeMap[node.GetID()]; !ok {
         // node map
         net.nodeMap[node.GetID()] = node
+        idx := sort.Search(len(net.nodeIDs),func(i int) bool { return net.nodeIDs[i] >= node.GetID() })
+        net.nodeIDs = append(net.nodeIDs,0)
+        copy(net.nodeIDs[idx+1:],net.nodeIDs[idx:])
+        net.nodeIDs[idx] = node.GetID()
 
         // node type map
         tp := node.GetType()
@@ -273,9 +390,9 @@ func (net *DefaultGlobalNetwork) Connect(node core.INode) core.IGlobalNetwork {
             net.globalBroadcastActive[node.GetID()] = false
         }
 
-        gnetLogger.Debug("node %d connected",node.GetID())
+        net.logger.Debug("node %d connected",node.GetID())
     } else {
-        gnetLogger.Debug("node %d already connected",node.GetID())
+        net.logger.Debug("node %d already connected",node.GetID())
     }
 
     return net
@@ -287,6 +404,8 @@ func (net *DefaultGlobalNetwork) Disconnect(node core.INode) core.IGlobalNetwork
     
     if _, ok := net.nodeMap[node.GetID()]; ok {
         delete(net.nodeMap,node.GetID())
+        idx := sort.Search(len(net.nodeIDs),func(i int) bool { return net.nodeIDs[i] >= node.GetID() })
+        net.nodeIDs = append(net.nodeIDs[:idx],net.nodeIDs[idx+1:]...)
        
         tp := node.GetType()
         last := len(net.nodeTypeMap[tp]) - 1
@@ -299,9 +418,9 @@ func (net *DefaultGlobalNetwork) Disconnect(node core.INode) core.IGlobalNetwork
         }
 
         delete(net.globalBroadcastActive,node.GetID())
-        gnetLogger.Debug("node %d disconnected",node.GetID())
+        net.logger.Debug("node %d disconnected",node.GetID())
     } else {
-        gnetLogger.Debug("node %d not connected",node.GetID())
+        net.logger.Debug("node %d not connected",node.GetID())
     }
 
     return net
@@ -323,7 +442,7 @@ func (net *DefaultGlobalNetwork) EnableBroadcast(node core.INode) core.IGlobalNe
     if _, ok := net.nodeMap[node.GetID()]; ok {
         net.globalBroadcastActive[node.GetID()] = true
     } else {
-        gnetLogger.Debug("node %d not connected",node.GetID())
+        net.logger.Debug("node %d not connected",node.GetID())
     }
     
     return net
@@ -336,7 +455,7 @@ func (net *DefaultGlobalNetwork) DisableBroadcast(node core.INode) core.IGlobalN
     if _, ok := net.nodeMap[node.GetID()]; ok {
         net.globalBroadcastActive[node.GetID()] = false
     } else {
-        gnetLogger.Debug("node %d not connected",node.GetID())
+        net.logger.Debug("node %d not connected",node.GetID())
     }
 
     return net
@@ -347,6 +466,74 @@ func (net *DefaultGlobalNetwork) IsBroadcastEnabled(node core.INode) bool {
     return active && ok
 }
 
+/*
+    Implements core.ISnapshotable: broadcast settings of the nodes (nodes
+    connect again when resuming), faults, and partition with its held
+    messages (scheduled partitions are in the queue of events).
+*/
+func (net *DefaultGlobalNetwork) Snapshot() ([]byte,error) {
+    net.nodeMapLock.RLock()
+    defer net.nodeMapLock.RUnlock()
+    net.faultsLock.RLock()
+    defer net.faultsLock.RUnlock()
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    return core.EncodeSnapshot(defaultGlobalNetworkGob{
+        BroadcastActive:    net.globalBroadcastActive,
+        BroadcastFaults:    net.broadcastFaults,
+        P2PFaults:          net.p2pFaults,
+        LinkFaults:         net.linkFaults,
+        Partition:          net.partition,
+        HeldMessages:       net.heldMessages,
+    })
+}
+
+// implements core.ISnapshotable
+func (net *DefaultGlobalNetwork) Restore(data []byte) error {
+    snapshot := defaultGlobalNetworkGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    net.nodeMapLock.Lock()
+    defer net.nodeMapLock.Unlock()
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    for nodeID := range net.nodeMap {
+        net.globalBroadcastActive[nodeID] = snapshot.BroadcastActive[nodeID]
+    }
+
+    net.broadcastFaults = snapshot.BroadcastFaults
+    net.p2pFaults = snapshot.P2PFaults
+    net.linkFaults = snapshot.LinkFaults
+    if net.linkFaults == nil {
+        net.linkFaults = make(map[uint64]MessageFaults)
+    }
+
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    net.setPartition(snapshot.Partition)
+    net.heldMessages = snapshot.HeldMessages
+    if net.heldMessages == nil {
+        net.heldMessages = make([]heldMessage,0)
+    }
+
+    return nil
+}
+
+// state of DefaultGlobalNetwork, for snapshots
+type defaultGlobalNetworkGob struct {
+    BroadcastActive map[uint32]bool
+    BroadcastFaults MessageFaults
+    P2PFaults MessageFaults
+    LinkFaults map[uint64]MessageFaults
+    Partition *core.NetworkPartition
+    HeldMessages []heldMessage
+}
+
 // ==== getters ====
 
 func (net *DefaultGlobalNetwork) GetName() string {
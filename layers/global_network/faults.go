@@ -0,0 +1,240 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "math"
+)
+
+// Default faults: messages are always delivered, once, with the delay of the network
+const (
+    DEFAULT_MESSAGE_LOSS                        = 0.0                               // probability that a message is lost
+    DEFAULT_MESSAGE_DUPLICATION                 = 0.0                               // probability that a message is delivered twice
+    DEFAULT_MESSAGE_REORDERING                  = 0.0                               // max extra delay (seconds)
+    LINK_FAULTS_ROW_LEN                         = 5                                 // [from,to,loss,duplication,reordering]
+)
+
+// ==== concrete structures  ====
+
+/*
+    Faults of the messages sent through a global network, for a delivery type
+    or for a link: probability that a message is lost, probability that it is
+    delivered twice, and max extra delay (seconds) added uniformly at random to
+    each delivery, so that messages sent within that window may arrive out of
+    order (bounded reordering).
+*/
+type MessageFaults struct {
+    Loss float64
+    Duplication float64
+    Reordering float64
+}
+
+// ==== functions ====
+
+// set the defaults of the fault keys in the config section of a global network
+func setFaultDefaults(tag string) {
+    for _, kind := range []string{"broadcast","p2p"} {
+        utils.ConfigSetDefault(tag + "." + kind + "_loss",DEFAULT_MESSAGE_LOSS)
+        utils.ConfigSetDefault(tag + "." + kind + "_duplication",DEFAULT_MESSAGE_DUPLICATION)
+        utils.ConfigSetDefault(tag + "." + kind + "_reordering",DEFAULT_MESSAGE_REORDERING)
+    }
+    utils.ConfigSetDefault(tag + ".link_faults",[][]float64{})
+}
+
+// check that probabilities are in [0,1] and the reordering window is not negative
+func checkFaults(faults MessageFaults) error {
+    if faults.Loss < 0 || faults.Loss > 1 {
+        return fmt.Errorf("loss must be in [0,1], got %v",faults.Loss)
+    }
+    if faults.Duplication < 0 || faults.Duplication > 1 {
+        return fmt.Errorf("duplication must be in [0,1], got %v",faults.Duplication)
+    }
+    if faults.Reordering < 0 {
+        return fmt.Errorf("reordering must not be negative, got %v",faults.Reordering)
+    }
+
+    return nil
+}
+
+// key of a link in the map of link faults
+func linkKey(from uint32,to uint32) uint64 {
+    return uint64(from) << 32 | uint64(to)
+}
+
+// ==== methods ====
+
+/*
+    Read the faults from a section of the config, which is checked by
+    validateFaults before creating the simulation. Networks embedding
+    DefaultGlobalNetwork use it with their own section.
+*/
+func (net *DefaultGlobalNetwork) initFaults(config *utils.ConfigSection) {
+    net.rng = net.GetSimulation().GetRNG()
+
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    net.broadcastFaults = MessageFaults{
+        Loss:           config.GetFloat64("broadcast_loss"),
+        Duplication:    config.GetFloat64("broadcast_duplication"),
+        Reordering:     config.GetFloat64("broadcast_reordering"),
+    }
+    net.p2pFaults = MessageFaults{
+        Loss:           config.GetFloat64("p2p_loss"),
+        Duplication:    config.GetFloat64("p2p_duplication"),
+        Reordering:     config.GetFloat64("p2p_reordering"),
+    }
+
+    links, _ := config.ParseFloat64Matrix("link_faults")
+    for _, link := range links {
+        net.linkFaults[linkKey(uint32(link[0]),uint32(link[1]))] = MessageFaults{
+            Loss:           link[2],
+            Duplication:    link[3],
+            Reordering:     link[4],
+        }
+    }
+}
+
+// check the faults of the delivery types and of the links in a section of the config
+func (net *DefaultGlobalNetwork) validateFaults(section *utils.ConfigSection,errs *utils.ConfigErrors) {
+    for _, kind := range []string{"broadcast","p2p"} {
+        for _, key := range []string{kind + "_loss",kind + "_duplication"} {
+            if p := section.GetFloat64(key); p < 0 || p > 1 {
+                errs.Add(section.GetKey(key),"must be in [0,1]")
+            }
+        }
+        if section.GetFloat64(kind + "_reordering") < 0 {
+            errs.Add(section.GetKey(kind + "_reordering"),"must not be negative")
+        }
+    }
+
+    links, err := section.ParseFloat64Matrix("link_faults")
+    if err != nil {
+        errs.AddError(section.GetKey("link_faults"),err)
+        return
+    }
+    for i, link := range links {
+        if len(link) != LINK_FAULTS_ROW_LEN {
+            errs.Add(section.GetKey("link_faults"),"row %d: expected [from,to,loss,duplication,reordering], got %v",i,link)
+            continue
+        }
+        for _, id := range link[:2] {
+            if id < 0 || id > math.MaxUint32 || id != math.Trunc(id) {
+                errs.Add(section.GetKey("link_faults"),"row %d: invalid node id %v",i,id)
+            }
+        }
+        if err := checkFaults(MessageFaults{Loss: link[2],Duplication: link[3],Reordering: link[4]}); err != nil {
+            errs.Add(section.GetKey("link_faults"),"row %d: %v",i,err)
+        }
+    }
+}
+
+/*
+    Schedule the delivery of a message to a node after the given delay,
+    unless a partition separates sender and receiver (see Partition), applying
+    the faults of the link, or of the delivery type if the link has none: the
+    message may be lost, delayed by up to the reordering window, or delivered
+    twice. Losses (including messages dropped by partitions) and duplicates
+    are reported with
+    GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED and
+    GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED events. The random number generator
+    is only used for faults that are set, so networks without faults behave
+    exactly as before.
+*/
+func (net *DefaultGlobalNetwork) deliver(msg core.IMessage,node core.INode,delay float64,isBroadcast bool) {
+    faults := net.GetFaults(msg.GetSender(),node.GetID(),isBroadcast)
+    gnet := net.GetSimulation().GetGlobalNetwork()
+
+    if stopped, dropped := net.stopByPartition(msg,node,delay,isBroadcast); stopped {
+        if dropped {
+            net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,&core.MessageFault{Message: msg,Receiver: node.GetID()},gnet),0)
+        }
+        return
+    }
+
+    if faults.Loss > 0 && net.rng.Float64() < faults.Loss {
+        net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,&core.MessageFault{Message: msg,Receiver: node.GetID()},gnet),0)
+        return
+    }
+
+    copies := 1
+    if faults.Duplication > 0 && net.rng.Float64() < faults.Duplication {
+        net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,&core.MessageFault{Message: msg,Receiver: node.GetID()},gnet),0)
+        copies = 2
+    }
+
+    for i := 0; i < copies; i++ {
+        extra := 0.0
+        if faults.Reordering > 0 {
+            extra = net.rng.Float64() * faults.Reordering
+        }
+
+        ev := utils.NewEvent(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,node.GetNodeNetwork())
+        net.ScheduleEvent(ev,delay + extra)
+    }
+}
+
+// set the faults of broadcast messages (links with their own faults are not affected)
+func (net *DefaultGlobalNetwork) SetBroadcastFaults(faults MessageFaults) error {
+    if err := checkFaults(faults); err != nil {
+        return err
+    }
+
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    net.broadcastFaults = faults
+    return nil
+}
+
+// set the faults of p2p messages (links with their own faults are not affected)
+func (net *DefaultGlobalNetwork) SetP2PFaults(faults MessageFaults) error {
+    if err := checkFaults(faults); err != nil {
+        return err
+    }
+
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    net.p2pFaults = faults
+    return nil
+}
+
+// set the faults of the messages from a node to another, for both delivery types
+func (net *DefaultGlobalNetwork) SetLinkFaults(from uint32,to uint32,faults MessageFaults) error {
+    if err := checkFaults(faults); err != nil {
+        return err
+    }
+
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    net.linkFaults[linkKey(from,to)] = faults
+    return nil
+}
+
+// remove the faults of a link, which then has the faults of the delivery type
+func (net *DefaultGlobalNetwork) RemoveLinkFaults(from uint32,to uint32) {
+    net.faultsLock.Lock()
+    defer net.faultsLock.Unlock()
+
+    delete(net.linkFaults,linkKey(from,to))
+}
+
+// ==== getters ====
+
+// faults of the messages from a node to another: the ones of the link, or of the delivery type
+func (net *DefaultGlobalNetwork) GetFaults(from uint32,to uint32,isBroadcast bool) MessageFaults {
+    net.faultsLock.RLock()
+    defer net.faultsLock.RUnlock()
+
+    if faults, ok := net.linkFaults[linkKey(from,to)]; ok {
+        return faults
+    }
+    if isBroadcast {
+        return net.broadcastFaults
+    }
+
+    return net.p2pFaults
+}
@@ -0,0 +1,167 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Faults that always or never happen, with a latency of 0.1 s: node 1
+    broadcasts a message to nodes 2 and 3 at time 1, and sends a p2p message
+    to node 2 at time 2. Losses and duplicates are reported when the message
+    is sent.
+*/
+func TestMessageFaults(t *testing.T) {
+    tests := []struct{
+        name string
+        settings map[string]interface{}
+        received map[uint32][]float64               // reception times at nodes 2 and 3
+        dropped map[uint32]int
+        duplicated map[uint32]int
+    }{
+        {
+            "no faults",
+            map[string]interface{}{},
+            map[uint32][]float64{2: {1.1,2.1},3: {1.1}},
+            map[uint32]int{},
+            map[uint32]int{},
+        },
+        {
+            "broadcast loss",
+            map[string]interface{}{DEFAULT_GNET_TAG + ".broadcast_loss": 1.0},
+            map[uint32][]float64{2: {2.1},3: {}},
+            map[uint32]int{2: 1,3: 1},
+            map[uint32]int{},
+        },
+        {
+            "p2p duplication",
+            map[string]interface{}{DEFAULT_GNET_TAG + ".p2p_duplication": 1.0},
+            map[uint32][]float64{2: {1.1,2.1,2.1},3: {1.1}},
+            map[uint32]int{},
+            map[uint32]int{2: 1},
+        },
+        {
+            // the faults of a link replace the ones of the delivery type
+            "link faults",
+            map[string]interface{}{
+                DEFAULT_GNET_TAG + ".broadcast_duplication":    1.0,
+                DEFAULT_GNET_TAG + ".link_faults":              [][]float64{{1,2,1,0,0}},
+            },
+            map[uint32][]float64{2: {},3: {1.1,1.1}},
+            map[uint32]int{2: 2},
+            map[uint32]int{3: 1},
+        },
+    }
+
+    for _, test := range tests {
+        net := newTestNetwork(t,DEFAULT_GNET_TAG,3,0.1,test.settings)
+        net.send(core.NewBroadcastMessage(0,1).SetSize(100),1)
+        net.send(core.NewP2PMessage(1,1,2).SetSize(100),2)
+        net.run(10)
+
+        for _, node := range []uint32{2,3} {
+            times := make([]float64,0)
+            for _, delivery := range net.nodes[node - 1].nnet.received {
+                times = append(times,delivery.time)
+            }
+            if !equalTimes(times,test.received[node]) {
+                t.Errorf("%s: node %d received messages at %v, want %v",test.name,node,times,test.received[node])
+            }
+            if got := net.countFaults(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,node); got != test.dropped[node] {
+                t.Errorf("%s: %d messages to node %d dropped, want %d",test.name,got,node,test.dropped[node])
+            }
+            if got := net.countFaults(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,node); got != test.duplicated[node] {
+                t.Errorf("%s: %d messages to node %d duplicated, want %d",test.name,got,node,test.duplicated[node])
+            }
+        }
+    }
+}
+
+/*
+    Node 1 sends 1000 p2p messages to node 2, one every 0.01 s, with a loss
+    of 0.2, a duplication of 0.1 and a reordering window of 0.5 s: every
+    message is dropped or delivered within the window (twice if duplicated),
+    some messages arrive out of order, and the same seed gives the same
+    deliveries.
+*/
+func TestMessageFaultsRandom(t *testing.T) {
+    run := func() (*testNetwork,[]core.IMessage) {
+        net := newTestNetwork(t,DEFAULT_GNET_TAG,2,0.1,map[string]interface{}{
+            DEFAULT_GNET_TAG + ".p2p_loss":             0.2,
+            DEFAULT_GNET_TAG + ".p2p_duplication":      0.1,
+            DEFAULT_GNET_TAG + ".p2p_reordering":       0.5,
+        })
+        msgs := make([]core.IMessage,1000)
+        for i := range msgs {
+            msgs[i] = core.NewP2PMessage(i,1,2).SetSize(100)
+            net.send(msgs[i],1 + float64(i) * 0.01)
+        }
+        net.run(20)
+        return net, msgs
+    }
+
+    net, msgs := run()
+    numDropped, numDuplicated := 0, 0
+    for _, fault := range net.faults {
+        switch fault.tp {
+        case core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED:
+            numDropped++
+        case core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED:
+            numDuplicated++
+        }
+    }
+    if numDropped < 160 || numDropped > 240 || numDuplicated < 50 || numDuplicated > 110 {
+        t.Errorf("%d messages dropped and %d duplicated, want about 200 and 80",numDropped,numDuplicated)
+    }
+
+    for i, msg := range msgs {
+        times := net.receptions(2,msg)
+        if len(times) == 0 && !hasFault(net,core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,msg) || len(times) == 2 && !hasFault(net,core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,msg) || len(times) > 2 {
+            t.Fatalf("message %d received %d times",i,len(times))
+        }
+        sent := 1 + float64(i) * 0.01
+        for _, time := range times {
+            if time < sent + 0.1 - 1e-9 || time > sent + 0.6 + 1e-9 {
+                t.Fatalf("message %d sent at %v received at %v, want within [%v,%v]",i,sent,time,sent + 0.1,sent + 0.6)
+            }
+        }
+    }
+
+    received := net.received(2)
+    reordered := false
+    for i := 1; i < len(received); i++ {
+        if received[i].GetData().(int) < received[i - 1].GetData().(int) {
+            reordered = true
+            break
+        }
+    }
+    if !reordered {
+        t.Errorf("messages received in order")
+    }
+
+    again, _ := run()
+    other := again.received(2)
+    if len(other) != len(received) {
+        t.Fatalf("%d messages received with the same seed, want %d",len(other),len(received))
+    }
+    for i := range received {
+        if other[i].GetData() != received[i].GetData() {
+            t.Fatalf("reception %d: message %v with the same seed, want %v",i,other[i].GetData(),received[i].GetData())
+        }
+    }
+}
+
+// ==== functions ====
+
+// whether a drop or duplicate of type tp was reported for a message
+func hasFault(net *testNetwork,tp uint16,msg core.IMessage) bool {
+    for _, fault := range net.faults {
+        if fault.tp == tp && fault.msg == msg {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,230 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "strconv"
+    "testing"
+)
+
+const (
+    TEST_EVENT_CALLBACK                         = 30001                             // event that calls a testCallback
+)
+
+// ==== concrete structures ====
+
+/*
+    Simulation of a global network with nodes that only connect to it and
+    record the messages they receive. Messages are sent with SEND_MESSAGE
+    events, so the network handles them at the time they are sent, and drops,
+    duplicates and rejections reported by the network are recorded too.
+*/
+type testNetwork struct {
+    t *testing.T
+    sim core.ISimulation
+    gnet core.IGlobalNetwork
+    nodes []*testNode
+    faults []testDelivery                       // drops, duplicates and rejections, in the order they were reported
+}
+
+// full node that connects to the global network when initialized
+type testNode struct {
+    core.INode
+    id uint32
+    nnet *testNodeNetwork
+}
+
+// node network that records the messages received by its node
+type testNodeNetwork struct {
+    core.INodeNetwork
+    node *testNode
+    received []testDelivery
+}
+
+// destination of events that call a function, e.g. to check the network during a simulation
+type testCallback func()
+
+// message delivered to (or dropped, duplicated or rejected for) a node at a time
+type testDelivery struct {
+    tp uint16
+    msg core.IMessage
+    node uint32
+    time float64
+}
+
+// ==== factories ====
+
+/*
+    Global network created from the registry with the given name, with the
+    given number of nodes and settings, and a latency of exactly latency
+    seconds for broadcast and p2p messages (as a uniform distribution in
+    [latency,latency]) for networks that sample it (negative: not set).
+*/
+func newTestNetwork(t *testing.T,name string,numNodes int,latency float64,settings map[string]interface{}) *testNetwork {
+    t.Helper()
+
+    config := utils.NewSimulationConfig()
+    config.Set("simulation.seed",7)
+    if latency >= 0 {
+        value := strconv.FormatFloat(latency,'g',-1,64)
+        for _, kind := range []string{"broadcast","p2p"} {
+            config.Set(name + "." + kind + "_distribution","uniform")
+            config.Set(name + "." + kind + "_config",[]string{value,value})
+        }
+    }
+    for key, value := range settings {
+        config.Set(key,value)
+    }
+
+    sim, err := core.NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+    gnet := core.NewGlobalNetworkFromRegistry(name)
+    if gnet == nil {
+        t.Fatalf("global network %s not registered",name)
+    }
+    if validator, ok := gnet.(core.IConfigValidator); ok {
+        if err := validator.ValidateConfig(config); err != nil {
+            t.Fatalf("invalid config: %v",err)
+        }
+    }
+    sim.SetGlobalNetwork(gnet)
+
+    net := &testNetwork{t: t,sim: sim,gnet: gnet,nodes: make([]*testNode,0,numNodes),faults: make([]testDelivery,0)}
+    for i := 0; i < numNodes; i++ {
+        node := &testNode{}
+        node.nnet = &testNodeNetwork{node: node,received: make([]testDelivery,0)}
+        sim.AddNode(node)
+        net.nodes = append(net.nodes,node)
+    }
+    sim.GetHooks().RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,net)
+    sim.GetHooks().RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,net)
+    sim.GetHooks().RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED,net)
+
+    return net
+}
+
+// ==== methods ====
+
+// send a message at the given time, after 0 (the network and the nodes are initialized at 0)
+func (net *testNetwork) send(msg core.IMessage,time float64) {
+    net.sim.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE,msg,net.gnet),time)
+}
+
+// schedule an event for the global network at the given time, after 0 (e.g., a partition)
+func (net *testNetwork) schedule(tp uint16,data interface{},time float64) {
+    net.sim.ScheduleEvent(utils.NewEvent(tp,data,net.gnet),time)
+}
+
+// call a function at the given time, after 0
+func (net *testNetwork) at(time float64,callback func()) {
+    net.sim.ScheduleEvent(utils.NewEvent(TEST_EVENT_CALLBACK,nil,testCallback(callback)),time)
+}
+
+// run the simulation until the given time
+func (net *testNetwork) run(end float64) {
+    net.t.Helper()
+
+    net.sim.SetEndCondition(core.NewTimeEndCondition(end))
+    if err := net.sim.Run(); err != nil {
+        net.t.Fatalf("simulation failed: %v",err)
+    }
+}
+
+// times at which a node received a message, in order
+func (net *testNetwork) receptions(node uint32,msg core.IMessage) []float64 {
+    times := make([]float64,0)
+    for _, delivery := range net.nodes[node - 1].nnet.received {
+        if delivery.msg == msg {
+            times = append(times,delivery.time)
+        }
+    }
+
+    return times
+}
+
+// messages received by a node, in order
+func (net *testNetwork) received(node uint32) []core.IMessage {
+    msgs := make([]core.IMessage,0)
+    for _, delivery := range net.nodes[node - 1].nnet.received {
+        msgs = append(msgs,delivery.msg)
+    }
+
+    return msgs
+}
+
+// number of drops, duplicates or rejections of type tp reported for a node
+func (net *testNetwork) countFaults(tp uint16,node uint32) int {
+    count := 0
+    for _, fault := range net.faults {
+        if fault.tp == tp && fault.node == node {
+            count++
+        }
+    }
+
+    return count
+}
+
+// implements utils.IEventPreTriggerHandler (for drops, duplicates and rejections)
+func (net *testNetwork) EventPreTrigger(ev utils.IEvent) {
+    fault := ev.GetData().(*core.MessageFault)
+    net.faults = append(net.faults,testDelivery{tp: ev.GetType(),msg: fault.Message,node: fault.Receiver,time: ev.GetTime()})
+}
+
+func (callback testCallback) HandleEvent(ev utils.IEvent) bool {
+    callback()
+    return true
+}
+
+func (node *testNode) GetID() uint32 { return node.id }
+func (node *testNode) GetType() uint16 { return core.NODE_TYPE_FULL }
+func (node *testNode) GetNodeNetwork() core.INodeNetwork { return node.nnet }
+
+func (node *testNode) SetID(id uint32) core.INode {
+    node.id = id
+    return node
+}
+
+func (node *testNode) HandleEvent(ev utils.IEvent) bool {
+    switch ev.GetType() {
+    case core.NODE_EVENT_INIT:
+        ev.GetData().([]interface{})[1].(core.IGlobalNetwork).Connect(node)
+        return true
+    case core.NODE_EVENT_FINISH:
+        return true
+    }
+
+    return false
+}
+
+func (nnet *testNodeNetwork) GetNode() core.INode { return nnet.node }
+
+func (nnet *testNodeNetwork) HandleEvent(ev utils.IEvent) bool {
+    if ev.GetType() != core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED {
+        return false
+    }
+
+    nnet.received = append(nnet.received,testDelivery{tp: ev.GetType(),msg: ev.GetData().(core.IMessage),node: nnet.node.id,time: ev.GetTime()})
+    return true
+}
+
+// ==== functions ====
+
+// whether two times are equal, up to rounding errors
+func equalTime(a float64,b float64) bool {
+    return a - b < 1e-9 && b - a < 1e-9
+}
+
+func equalTimes(a []float64,b []float64) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if !equalTime(a[i],b[i]) {
+            return false
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,302 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "github.com/spf13/cast"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// Modes of scheduled partitions
+const (
+    PARTITION_MODE_DROP                         = "drop"                            // messages between groups are dropped
+    PARTITION_MODE_HOLD                         = "hold"                            // messages between groups are held until healing
+    PARTITION_HEAL_RELEASE                      = "release"                         // held messages are delivered when healing
+    PARTITION_HEAL_DISCARD                      = "discard"                         // held messages are dropped when healing
+)
+
+// ==== concrete structures  ====
+
+// partition scheduled in the config
+type scheduledPartition struct {
+    start float64
+    end float64                                 // negative: never healed
+    partition *core.NetworkPartition
+    release bool
+}
+
+// message held by a partition
+type heldMessage struct {
+    Message core.IMessage
+    Receiver uint32
+    Delay float64                               // delay of the network when it was sent
+    IsBroadcast bool
+}
+
+// ==== functions ====
+
+// set the defaults of the partition keys in the config section of a global network
+func setPartitionDefaults(tag string) {
+    utils.ConfigSetDefault(tag + ".partitions",[]interface{}{})
+}
+
+/*
+    Read the partitions scheduled in a section of the config, a list of
+    tables with keys "start", "end" (optional), "groups", "mode" (optional,
+    "drop" or "hold") and "heal" (optional, "release" or "discard"). All
+    problems are added to errs, and the partitions are sorted by start time.
+*/
+func parsePartitions(section *utils.ConfigSection,errs *utils.ConfigErrors) []scheduledPartition {
+    key := section.GetKey("partitions")
+    list, err := cast.ToSliceE(section.Get("partitions"))
+    if err != nil {
+        errs.Add(key,"expected a list of tables, got %v",section.Get("partitions"))
+        return nil
+    }
+
+    partitions := make([]scheduledPartition,0,len(list))
+    for i, item := range list {
+        table, err := cast.ToStringMapE(item)
+        if err != nil {
+            errs.Add(key,"partition %d: expected a table, got %v",i,item)
+            continue
+        }
+
+        scheduled, err := parsePartition(table)
+        if err != nil {
+            errs.Add(key,"partition %d: %v",i,err)
+            continue
+        }
+        partitions = append(partitions,scheduled)
+    }
+
+    sort.SliceStable(partitions,func(i,j int) bool { return partitions[i].start < partitions[j].start })
+    for i := 1; i < len(partitions); i++ {
+        previous := partitions[i - 1]
+        if previous.end < 0 || previous.end > partitions[i].start {
+            errs.Add(key,"partitions starting at %v and %v overlap",previous.start,partitions[i].start)
+        }
+    }
+
+    return partitions
+}
+
+// read one scheduled partition
+func parsePartition(table map[string]interface{}) (scheduledPartition,error) {
+    scheduled := scheduledPartition{
+        start:      0,
+        end:        -1,
+        partition:  &core.NetworkPartition{Groups: make([][]uint32,0),Hold: false},
+        release:    true,
+    }
+
+    for name := range table {
+        switch name {
+        case "start","end","groups","mode","heal":
+        default:
+            return scheduled, fmt.Errorf("unknown key %q",name)
+        }
+    }
+
+    var err error
+    if scheduled.start, err = cast.ToFloat64E(table["start"]); err != nil || scheduled.start < 0 {
+        return scheduled, fmt.Errorf("start must be a time >= 0, got %v",table["start"])
+    }
+    if end, ok := table["end"]; ok {
+        if scheduled.end, err = cast.ToFloat64E(end); err != nil || scheduled.end <= scheduled.start {
+            return scheduled, fmt.Errorf("end must be a time after start, got %v",end)
+        }
+    }
+
+    groups, err := cast.ToSliceE(table["groups"])
+    if err != nil || len(groups) == 0 {
+        return scheduled, fmt.Errorf("groups must be a list of lists of node ids, got %v",table["groups"])
+    }
+    seen := make(map[uint32]bool)
+    for _, item := range groups {
+        ids, err := cast.ToSliceE(item)
+        if err != nil || len(ids) == 0 {
+            return scheduled, fmt.Errorf("group must be a non-empty list of node ids, got %v",item)
+        }
+
+        group := make([]uint32,0,len(ids))
+        for _, value := range ids {
+            id, err := cast.ToFloat64E(value)
+            if err != nil || id < 0 || id > math.MaxUint32 || id != math.Trunc(id) {
+                return scheduled, fmt.Errorf("invalid node id %v",value)
+            }
+            if seen[uint32(id)] {
+                return scheduled, fmt.Errorf("node %v is in more than one group",value)
+            }
+            seen[uint32(id)] = true
+            group = append(group,uint32(id))
+        }
+        scheduled.partition.Groups = append(scheduled.partition.Groups,group)
+    }
+
+    switch mode := cast.ToString(table["mode"]); mode {
+    case "",PARTITION_MODE_DROP:
+        scheduled.partition.Hold = false
+    case PARTITION_MODE_HOLD:
+        scheduled.partition.Hold = true
+    default:
+        return scheduled, fmt.Errorf("mode must be %q or %q, got %q",PARTITION_MODE_DROP,PARTITION_MODE_HOLD,mode)
+    }
+
+    switch heal := cast.ToString(table["heal"]); heal {
+    case "",PARTITION_HEAL_RELEASE:
+        scheduled.release = true
+    case PARTITION_HEAL_DISCARD:
+        scheduled.release = false
+    default:
+        return scheduled, fmt.Errorf("heal must be %q or %q, got %q",PARTITION_HEAL_RELEASE,PARTITION_HEAL_DISCARD,heal)
+    }
+
+    return scheduled, nil
+}
+
+// ==== methods ====
+
+/*
+    Schedule the partitions in a section of the config, which is checked by
+    validatePartitions before creating the simulation. It must be called in
+    Init, at time 0. Networks embedding DefaultGlobalNetwork use it with their
+    own section.
+*/
+func (net *DefaultGlobalNetwork) initPartitions(config *utils.ConfigSection) {
+    errs := make(utils.ConfigErrors,0)
+    gnet := net.GetSimulation().GetGlobalNetwork()
+    for _, scheduled := range parsePartitions(config,&errs) {
+        net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_PARTITION,scheduled.partition,gnet),scheduled.start)
+        if scheduled.end >= 0 {
+            net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_HEAL,scheduled.release,gnet),scheduled.end)
+        }
+    }
+}
+
+// check the partitions scheduled in a section of the config
+func (net *DefaultGlobalNetwork) validatePartitions(section *utils.ConfigSection,errs *utils.ConfigErrors) {
+    parsePartitions(section,errs)
+}
+
+/*
+    Implements core.IGlobalNetwork: from now on, messages between nodes of
+    different groups are dropped or held. Messages held by a previous
+    partition stay held until healing. A nil partition heals the network,
+    releasing held messages.
+*/
+func (net *DefaultGlobalNetwork) Partition(partition *core.NetworkPartition) core.IGlobalNetwork {
+    if partition == nil {
+        return net.Heal(true)
+    }
+
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    net.setPartition(partition)
+    net.logger.Info("network partitioned into %d groups (hold=%v)",len(partition.Groups),partition.Hold)
+
+    return net
+}
+
+/*
+    Implements core.IGlobalNetwork: held messages are delivered now, with the
+    delay they had when sent (and the faults of their link), or discarded and
+    reported as dropped.
+*/
+func (net *DefaultGlobalNetwork) Heal(release bool) core.IGlobalNetwork {
+    net.partitionLock.Lock()
+    held := net.heldMessages
+    net.partition = nil
+    net.partitionGroups = nil
+    net.heldMessages = make([]heldMessage,0)
+    net.partitionLock.Unlock()
+
+    if release {
+        net.logger.Info("network partition healed: releasing %d held messages",len(held))
+    } else {
+        net.logger.Info("network partition healed: discarding %d held messages",len(held))
+    }
+
+    gnet := net.GetSimulation().GetGlobalNetwork()
+    for _, message := range held {
+        net.nodeMapLock.RLock()
+        node, connected := net.nodeMap[message.Receiver]
+        net.nodeMapLock.RUnlock()
+
+        if release && connected {
+            net.deliver(message.Message,node,message.Delay,message.IsBroadcast)
+        } else {
+            if release {
+                net.logger.Debug("node %d not connected",message.Receiver)
+            }
+            fault := &core.MessageFault{Message: message.Message,Receiver: message.Receiver}
+            net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,fault,gnet),0)
+        }
+    }
+
+    return net
+}
+
+/*
+    Whether a message from a node to another is stopped by the partition, and
+    whether it must be dropped by the caller (otherwise it is held until
+    healing).
+*/
+func (net *DefaultGlobalNetwork) stopByPartition(msg core.IMessage,node core.INode,delay float64,isBroadcast bool) (bool,bool) {
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    if net.partition == nil || net.partitionGroups[msg.GetSender()] == net.partitionGroups[node.GetID()] {
+        return false, false
+    }
+
+    if net.partition.Hold {
+        net.heldMessages = append(net.heldMessages,heldMessage{
+            Message:        msg,
+            Receiver:       node.GetID(),
+            Delay:          delay,
+            IsBroadcast:    isBroadcast,
+        })
+        return true, false
+    }
+
+    return true, true
+}
+
+// set the partition and the map from nodes to groups, with partitionLock held
+func (net *DefaultGlobalNetwork) setPartition(partition *core.NetworkPartition) {
+    net.partition = partition
+    net.partitionGroups = nil
+    if partition == nil {
+        return
+    }
+
+    // nodes not in any group are in group 0
+    net.partitionGroups = make(map[uint32]int)
+    for i, group := range partition.Groups {
+        for _, nodeID := range group {
+            net.partitionGroups[nodeID] = i + 1
+        }
+    }
+}
+
+// ==== getters ====
+
+// implements core.IGlobalNetwork
+func (net *DefaultGlobalNetwork) GetPartition() *core.NetworkPartition {
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    return net.partition
+}
+
+// number of messages held by the partition
+func (net *DefaultGlobalNetwork) GetHeldMessages() int {
+    net.partitionLock.Lock()
+    defer net.partitionLock.Unlock()
+
+    return len(net.heldMessages)
+}
@@ -0,0 +1,97 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Nodes 1 and 2 are separated from nodes 3 and 4 from time 1 to time 3
+    (from the config, or with partition and heal events), with a latency of
+    0.1 s: node 1 broadcasts a message at time 2, during the partition, and
+    another one at time 4, after healing. Held messages are released with the
+    delay they had when sent.
+*/
+func TestPartitions(t *testing.T) {
+    scheduled := func(mode string,heal string) map[string]interface{} {
+        return map[string]interface{}{
+            DEFAULT_GNET_TAG + ".partitions": []interface{}{
+                map[string]interface{}{
+                    "start":    1.0,
+                    "end":      3.0,
+                    "groups":   []interface{}{[]interface{}{1,2},[]interface{}{3,4}},
+                    "mode":     mode,
+                    "heal":     heal,
+                },
+            },
+        }
+    }
+
+    tests := []struct{
+        name string
+        settings map[string]interface{}
+        partition *core.NetworkPartition            // set with events instead of the config
+        release bool
+        held int                                    // messages held during the partition
+        received map[uint32][]float64               // reception times at nodes 2 to 4
+        dropped map[uint32]int
+    }{
+        {
+            "drop",scheduled(PARTITION_MODE_DROP,PARTITION_HEAL_RELEASE),nil,false,0,
+            map[uint32][]float64{2: {2.1,4.1},3: {4.1},4: {4.1}},
+            map[uint32]int{3: 1,4: 1},
+        },
+        {
+            "hold and release",scheduled(PARTITION_MODE_HOLD,PARTITION_HEAL_RELEASE),nil,false,2,
+            map[uint32][]float64{2: {2.1,4.1},3: {3.1,4.1},4: {3.1,4.1}},
+            map[uint32]int{},
+        },
+        {
+            "hold and discard",scheduled(PARTITION_MODE_HOLD,PARTITION_HEAL_DISCARD),nil,false,2,
+            map[uint32][]float64{2: {2.1,4.1},3: {4.1},4: {4.1}},
+            map[uint32]int{3: 1,4: 1},
+        },
+        {
+            // node 4 is not in any group, so it is separated from both
+            "events",map[string]interface{}{},&core.NetworkPartition{Groups: [][]uint32{{1,2},{3}},Hold: true},true,2,
+            map[uint32][]float64{2: {2.1,4.1},3: {3.1,4.1},4: {3.1,4.1}},
+            map[uint32]int{},
+        },
+    }
+
+    for _, test := range tests {
+        net := newTestNetwork(t,DEFAULT_GNET_TAG,4,0.1,test.settings)
+        if test.partition != nil {
+            net.schedule(core.GLOBAL_NETWORK_EVENT_PARTITION,test.partition,1)
+            net.schedule(core.GLOBAL_NETWORK_EVENT_HEAL,test.release,3)
+        }
+        net.send(core.NewBroadcastMessage(0,1).SetSize(100),2)
+        net.send(core.NewBroadcastMessage(1,1).SetSize(100),4)
+
+        held := -1
+        net.at(2.5,func() { held = net.gnet.(*DefaultGlobalNetwork).GetHeldMessages() })
+        net.run(10)
+
+        if held != test.held {
+            t.Errorf("%s: %d messages held during the partition, want %d",test.name,held,test.held)
+        }
+        if partition := net.gnet.GetPartition(); partition != nil {
+            t.Errorf("%s: partition %v after healing",test.name,partition)
+        }
+
+        for _, node := range []uint32{2,3,4} {
+            times := make([]float64,0)
+            for _, delivery := range net.nodes[node - 1].nnet.received {
+                times = append(times,delivery.time)
+            }
+            if !equalTimes(times,test.received[node]) {
+                t.Errorf("%s: node %d received messages at %v, want %v",test.name,node,times,test.received[node])
+            }
+            if got := net.countFaults(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,node); got != test.dropped[node] {
+                t.Errorf("%s: %d messages to node %d dropped, want %d",test.name,got,node,test.dropped[node])
+            }
+        }
+    }
+}
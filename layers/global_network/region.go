@@ -0,0 +1,335 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "math/rand"
+    "strconv"
+    "sync"
+)
+
+const (
+    REGION_GNET_TAG                             = "region_global_network"           // tag for registry, log and config section
+    DEFAULT_REGION_LATENCY_JITTER               = 0.1                               // latency varies uniformly by +/- 10%
+)
+
+// Default regions, as in SimBlock (Bitcoin, 2019): proportions of nodes, latencies in seconds, and bandwidths in bits per second
+var DEFAULT_REGIONS                             = []string{"north_america","europe","south_america","asia_pacific","japan","australia"}
+var DEFAULT_REGION_DISTRIBUTION                 = []float64{0.3316,0.4998,0.0090,0.1177,0.0224,0.0195}
+var DEFAULT_REGION_LATENCY                      = [][]float64{
+    {0.036,0.119,0.255,0.310,0.154,0.208},
+    {0.119,0.012,0.221,0.242,0.266,0.350},
+    {0.255,0.221,0.137,0.347,0.256,0.269},
+    {0.310,0.242,0.347,0.099,0.172,0.278},
+    {0.154,0.266,0.256,0.172,0.009,0.163},
+    {0.208,0.350,0.269,0.278,0.163,0.022},
+}
+var DEFAULT_REGION_UPLOAD_BANDWIDTH             = []float64{19200000,20700000,5800000,15700000,10200000,11300000}
+var DEFAULT_REGION_DOWNLOAD_BANDWIDTH           = []float64{52000000,40000000,18000000,22800000,22800000,29900000}
+
+// ==== concrete structures  ====
+
+/*
+    Global network with geographically distributed nodes. Every node is
+    assigned to a region according to the configured proportions, and the
+    delay of a message from a node to another is the latency between their
+    regions (varied uniformly by the jitter) plus its transmission time, i.e.
+    the size of the message over the bandwidth of the link: the minimum
+    between the upload bandwidth of the region of the sender and the download
+    bandwidth of the region of the receiver. Links are independent, i.e.
+    concurrent messages do not share bandwidth. The same model is used for p2p
+    and broadcast messages.
+
+    The region of a node is drawn from a hash of the node id and the seed of
+    the simulation, so it does not depend on the order in which nodes connect
+    (e.g., with the parallel engine), and it can be set explicitly with
+    SetNodeRegion.
+
+    Implements: IGlobalNetwork, ISnapshotable, and IConfigValidator
+*/
+type RegionGlobalNetwork struct {
+    *DefaultGlobalNetwork
+
+    regions []string
+    cumulative []float64                        // cumulative distribution of the nodes over the regions
+    latency [][]float64                         // seconds, by region of sender and receiver
+    upload []float64                            // bits per second, by region
+    download []float64                          // bits per second, by region
+    jitter float64
+
+    seed int64
+    rng *rand.Rand
+    nodeRegions map[uint32]int                  // regions set explicitly
+    nodeRegionsLock sync.RWMutex
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".regions",DEFAULT_REGIONS)
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".region_distribution",DEFAULT_REGION_DISTRIBUTION)
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".latency",DEFAULT_REGION_LATENCY)
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".upload_bandwidth",DEFAULT_REGION_UPLOAD_BANDWIDTH)
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".download_bandwidth",DEFAULT_REGION_DOWNLOAD_BANDWIDTH)
+    utils.ConfigSetDefault(REGION_GNET_TAG + ".latency_jitter",DEFAULT_REGION_LATENCY_JITTER)
+    setFaultDefaults(REGION_GNET_TAG)
+    setPartitionDefaults(REGION_GNET_TAG)
+
+    // register factory
+    core.RegisterGlobalNetwork(REGION_GNET_TAG,NewRegionGlobalNetwork)
+}
+
+// factory for RegionGlobalNetwork: regions are read from the config of the simulation in Init
+func NewRegionGlobalNetwork() core.IGlobalNetwork {
+    return &RegionGlobalNetwork{
+        DefaultGlobalNetwork:       NewDefaultGlobalNetwork().(*DefaultGlobalNetwork),
+        regions:                    nil,
+        cumulative:                 nil,
+        latency:                    nil,
+        upload:                     nil,
+        download:                   nil,
+        jitter:                     0,
+        seed:                       0,
+        rng:                        nil,
+        nodeRegions:                make(map[uint32]int),
+        nodeRegionsLock:            sync.RWMutex{},
+    }
+}
+
+// ==== methods ====
+
+func (net *RegionGlobalNetwork) Init(sim core.ISimulation,components ...core.ISimulationComponent){
+    net.DefaultComponent.Init(sim)
+    net.logger = sim.GetLogger(REGION_GNET_TAG)
+
+    // the config is checked by ValidateConfig before creating the simulation
+    config := net.GetConfigSection(REGION_GNET_TAG)
+    net.regions = config.GetStringSlice("regions")
+    distribution, _ := config.ParseFloat64Slice("region_distribution")
+    net.latency, _ = config.ParseFloat64Matrix("latency")
+    net.upload, _ = config.ParseFloat64Slice("upload_bandwidth")
+    net.download, _ = config.ParseFloat64Slice("download_bandwidth")
+    net.jitter = config.GetFloat64("latency_jitter")
+    net.initFaults(config)
+    net.initPartitions(config)
+
+    total := 0.0
+    for _, p := range distribution {
+        total += p
+    }
+    net.cumulative = make([]float64,len(distribution))
+    sum := 0.0
+    for i, p := range distribution {
+        sum += p
+        net.cumulative[i] = sum / total
+    }
+
+    net.seed = sim.GetConfig().GetInt64(core.SIMULATION_TAG + ".seed")
+    net.rng = sim.GetRNG()
+
+    net.logger.Debug("initializing with regions %v",net.regions)
+}
+
+func (net *RegionGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetwork {
+    msg.SetTime(net.GetTime())
+    receivers, isBroadcast := net.GetReceivers(msg)
+
+    from := net.getRegion(msg.GetSender())
+    for _, node := range receivers {
+        net.deliver(msg,node,net.GetDelay(from,net.getRegion(node.GetID()),msg.GetSize()),isBroadcast)
+    }
+
+    return net
+}
+
+/*
+    Delay of a message of the given size (in bytes) between two regions:
+    latency, varied by the jitter, plus transmission time.
+*/
+func (net *RegionGlobalNetwork) GetDelay(from int,to int,size uint64) float64 {
+    latency := net.latency[from][to]
+    if net.jitter > 0 {
+        latency *= 1 + net.jitter * (2 * net.rng.Float64() - 1)
+    }
+
+    bandwidth := net.upload[from]
+    if net.download[to] < bandwidth {
+        bandwidth = net.download[to]
+    }
+
+    return latency + float64(size) * 8 / bandwidth
+}
+
+/*
+    Implements core.IConfigValidator: regions, proportions, latency matrix,
+    bandwidths (one per region), and jitter.
+*/
+func (net *RegionGlobalNetwork) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    section := utils.NewConfigSection(config,REGION_GNET_TAG,net.GetConfigInstance())
+
+    regions := section.GetStringSlice("regions")
+    if len(regions) == 0 {
+        errs.Add(section.GetKey("regions"),"at least one region is required")
+    }
+    names := make(map[string]bool)
+    for _, region := range regions {
+        if names[region] {
+            errs.Add(section.GetKey("regions"),"region %q is repeated",region)
+        }
+        names[region] = true
+    }
+
+    // one value per region, not negative (bandwidths must be positive)
+    checkList := func(key string,positive bool) []float64 {
+        values, err := section.ParseFloat64Slice(key)
+        if err != nil {
+            errs.AddError(section.GetKey(key),err)
+            return nil
+        }
+        if len(values) != len(regions) {
+            errs.Add(section.GetKey(key),"expected one value per region (%d), got %d",len(regions),len(values))
+        }
+        for _, value := range values {
+            if value < 0 || (positive && value == 0) {
+                errs.Add(section.GetKey(key),"invalid value %v",value)
+            }
+        }
+        return values
+    }
+
+    total := 0.0
+    for _, p := range checkList("region_distribution",false) {
+        total += p
+    }
+    if total <= 0 {
+        errs.Add(section.GetKey("region_distribution"),"proportions must sum to a positive value")
+    }
+    checkList("upload_bandwidth",true)
+    checkList("download_bandwidth",true)
+
+    latency, err := section.ParseFloat64Matrix("latency")
+    if err != nil {
+        errs.AddError(section.GetKey("latency"),err)
+    } else {
+        if len(latency) != len(regions) {
+            errs.Add(section.GetKey("latency"),"expected one row per region (%d), got %d",len(regions),len(latency))
+        }
+        for i, row := range latency {
+            if len(row) != len(regions) {
+                errs.Add(section.GetKey("latency"),"row %d: expected one value per region (%d), got %d",i,len(regions),len(row))
+            }
+            for _, value := range row {
+                if value < 0 {
+                    errs.Add(section.GetKey("latency"),"row %d: invalid value %v",i,value)
+                }
+            }
+        }
+    }
+
+    if jitter := section.GetFloat64("latency_jitter"); jitter < 0 || jitter >= 1 {
+        errs.Add(section.GetKey("latency_jitter"),"must be in [0,1)")
+    }
+    net.validateFaults(section,&errs)
+    net.validatePartitions(section,&errs)
+
+    return errs.Err()
+}
+
+// set the region of a node explicitly, instead of drawing it
+func (net *RegionGlobalNetwork) SetNodeRegion(nodeID uint32,region string) error {
+    for i, name := range net.regions {
+        if name == region {
+            net.nodeRegionsLock.Lock()
+            net.nodeRegions[nodeID] = i
+            net.nodeRegionsLock.Unlock()
+            return nil
+        }
+    }
+
+    return fmt.Errorf("unknown region %q",region)
+}
+
+/*
+    Implements core.ISnapshotable: broadcast settings and regions set
+    explicitly (drawn regions do not change when resuming).
+*/
+func (net *RegionGlobalNetwork) Snapshot() ([]byte,error) {
+    broadcast, err := net.DefaultGlobalNetwork.Snapshot()
+    if err != nil {
+        return nil, err
+    }
+
+    net.nodeRegionsLock.RLock()
+    defer net.nodeRegionsLock.RUnlock()
+
+    return core.EncodeSnapshot(regionGlobalNetworkGob{
+        Broadcast:      broadcast,
+        NodeRegions:    net.nodeRegions,
+    })
+}
+
+// implements core.ISnapshotable
+func (net *RegionGlobalNetwork) Restore(data []byte) error {
+    snapshot := regionGlobalNetworkGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    if err := net.DefaultGlobalNetwork.Restore(snapshot.Broadcast); err != nil {
+        return err
+    }
+
+    net.nodeRegionsLock.Lock()
+    defer net.nodeRegionsLock.Unlock()
+
+    net.nodeRegions = snapshot.NodeRegions
+    if net.nodeRegions == nil {
+        net.nodeRegions = make(map[uint32]int)
+    }
+
+    return nil
+}
+
+// state of RegionGlobalNetwork, for snapshots
+type regionGlobalNetworkGob struct {
+    Broadcast []byte
+    NodeRegions map[uint32]int
+}
+
+// ==== getters ====
+
+func (net *RegionGlobalNetwork) GetName() string {
+    return REGION_GNET_TAG
+}
+
+// region of a node (connected or not)
+func (net *RegionGlobalNetwork) GetNodeRegion(nodeID uint32) string {
+    return net.regions[net.getRegion(nodeID)]
+}
+
+func (net *RegionGlobalNetwork) GetRegions() []string {
+    return net.regions
+}
+
+// index of the region of a node: set explicitly, or drawn from the hash of the seed and the node id
+func (net *RegionGlobalNetwork) getRegion(nodeID uint32) int {
+    net.nodeRegionsLock.RLock()
+    region, ok := net.nodeRegions[nodeID]
+    net.nodeRegionsLock.RUnlock()
+    if ok {
+        return region
+    }
+
+    hash := utils.HashString(strconv.FormatInt(net.seed,10) + "/" + strconv.FormatUint(uint64(nodeID),10))
+    u := float64(hash >> 11) / (1 << 53)
+    for i, c := range net.cumulative {
+        if u < c {
+            return i
+        }
+    }
+
+    return len(net.cumulative) - 1
+}
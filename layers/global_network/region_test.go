@@ -0,0 +1,100 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Regions "a" and "b" without jitter: nodes 1 and 2 are set in region a and
+    node 3 in region b, before the network is initialized again when the
+    simulation runs (regions set explicitly are kept). The delay of a message
+    of 1000 bytes is the latency between the regions plus 8000 bits over the
+    upload bandwidth of the region of the sender or the download bandwidth of
+    the region of the receiver, whichever is lower.
+*/
+func TestRegionDelay(t *testing.T) {
+    net := newTestNetwork(t,REGION_GNET_TAG,3,-1,map[string]interface{}{
+        REGION_GNET_TAG + ".regions":               []string{"a","b"},
+        REGION_GNET_TAG + ".region_distribution":   []float64{0.5,0.5},
+        REGION_GNET_TAG + ".latency":               [][]float64{{0.1,0.3},{0.3,0.05}},
+        REGION_GNET_TAG + ".upload_bandwidth":      []float64{8000,16000},
+        REGION_GNET_TAG + ".download_bandwidth":    []float64{16000,4000},
+        REGION_GNET_TAG + ".latency_jitter":        0.0,
+    })
+    gnet := net.gnet.(*RegionGlobalNetwork)
+    gnet.Init(net.sim)
+    for node, region := range map[uint32]string{1: "a",2: "a",3: "b"} {
+        if err := gnet.SetNodeRegion(node,region); err != nil {
+            t.Fatalf("cannot set region of node %d: %v",node,err)
+        }
+    }
+    if err := gnet.SetNodeRegion(1,"c"); err == nil {
+        t.Errorf("unknown region c: no error")
+    }
+
+    broadcast := core.NewBroadcastMessage(0,1).SetSize(1000)
+    p2p := core.NewP2PMessage(1,3,1).SetSize(1000)
+    net.send(broadcast,1)
+    net.send(p2p,1)
+    net.run(10)
+
+    tests := []struct{
+        msg core.IMessage
+        node uint32
+        want []float64
+    }{
+        {broadcast,2,[]float64{2.1}},       // a to a: 0.1 + 8000 / 8000
+        {broadcast,3,[]float64{3.3}},       // a to b: 0.3 + 8000 / 4000
+        {p2p,1,[]float64{1.8}},             // b to a: 0.3 + 8000 / 16000
+        {p2p,2,[]float64{}},
+    }
+
+    for i, test := range tests {
+        if got := net.receptions(test.node,test.msg); !equalTimes(got,test.want) {
+            t.Errorf("test %d: received by node %d at %v, want %v",i,test.node,got,test.want)
+        }
+    }
+}
+
+// regions drawn from the seed and the node id follow the proportions, and only depend on the seed
+func TestRegionDistribution(t *testing.T) {
+    newNetwork := func(seed int) *RegionGlobalNetwork {
+        net := newTestNetwork(t,REGION_GNET_TAG,0,-1,map[string]interface{}{
+            "simulation.seed":                          seed,
+            REGION_GNET_TAG + ".regions":               []string{"a","b","c"},
+            REGION_GNET_TAG + ".region_distribution":   []float64{2,3,5},
+            REGION_GNET_TAG + ".latency":               [][]float64{{0.1,0.2,0.3},{0.2,0.1,0.2},{0.3,0.2,0.1}},
+            REGION_GNET_TAG + ".upload_bandwidth":      []float64{8000,8000,8000},
+            REGION_GNET_TAG + ".download_bandwidth":    []float64{8000,8000,8000},
+        })
+        gnet := net.gnet.(*RegionGlobalNetwork)
+        gnet.Init(net.sim)
+        return gnet
+    }
+
+    gnet, same, other := newNetwork(7), newNetwork(7), newNetwork(8)
+    counts := make(map[string]int)
+    numDifferent := 0
+    for node := uint32(1); node <= 2000; node++ {
+        region := gnet.GetNodeRegion(node)
+        counts[region]++
+        if same.GetNodeRegion(node) != region {
+            t.Fatalf("node %d: region %s with the same seed, want %s",node,same.GetNodeRegion(node),region)
+        }
+        if other.GetNodeRegion(node) != region {
+            numDifferent++
+        }
+    }
+
+    for region, want := range map[string]int{"a": 400,"b": 600,"c": 1000} {
+        if counts[region] < want - 60 || counts[region] > want + 60 {
+            t.Errorf("region %s: %d nodes, want about %d",region,counts[region],want)
+        }
+    }
+    if numDifferent == 0 {
+        t.Errorf("same regions with a different seed")
+    }
+}
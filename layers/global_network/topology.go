@@ -0,0 +1,384 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "math"
+    "sort"
+    "sync"
+)
+
+const (
+    TOPOLOGY_GNET_TAG                           = "topology_global_network"         // tag for registry, log and config section
+    DEFAULT_TOPOLOGY_DIRECTED                   = false                             // links work both ways
+    DEFAULT_TOPOLOGY_UNLINKED                   = UNLINKED_DROP                     // what to do with p2p messages to nodes that are not linked
+    DEFAULT_LINK_LATENCY                        = -1.0                              // negative: sampled for each message
+    DEFAULT_LINK_BANDWIDTH                      = 0.0                               // 0: unlimited
+    LINKS_ROW_LEN                               = 5                                 // [from,to,latency,bandwidth,loss]
+)
+
+// What to do with p2p messages to nodes that are not linked to the sender
+const (
+    UNLINKED_DROP                               = "drop"                            // lost in the network, reported with GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED
+    UNLINKED_REJECT                             = "reject"                          // not sent at all, reported with GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED
+)
+
+// ==== concrete structures  ====
+
+/*
+    Global network with an explicit graph of links between nodes, directed or
+    undirected, with latency and bandwidth for each link. Messages are only
+    delivered over links: broadcasts reach the nodes linked to the sender
+    (among the targets of the message), and p2p messages to nodes that are not
+    linked are dropped or rejected. The delay of a message is the latency of
+    the link (sampled from the distributions for broadcast and p2p messages,
+    as in DefaultGlobalNetwork, if the link has none) plus its size over the
+    bandwidth of the link (if limited). Losses are faults of the links (see
+    SetLinkFaults): the loss given with a link in the config is set as the
+    loss of its faults.
+
+    Links are read from the config, added and removed with ConnectLink and
+    DisconnectLink, and follow the neighbors of node networks that support it
+    (e.g., DefaultNodeNetwork). Links stay when nodes disconnect, so a node
+    that connects again keeps its links.
+
+    Implements: IGlobalNetwork, ILinkGlobalNetwork, ISnapshotable, and
+    IConfigValidator
+*/
+type TopologyGlobalNetwork struct {
+    *DefaultGlobalNetwork
+
+    directed bool
+    unlinked string
+    defaultLink core.NetworkLink                // attributes of links added without them
+
+    links map[uint64]core.NetworkLink           // by linkKey
+    adjacency map[uint32][]uint32               // sorted nodes linked from each node
+    linkLock sync.RWMutex
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".directed",DEFAULT_TOPOLOGY_DIRECTED)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".unlinked",DEFAULT_TOPOLOGY_UNLINKED)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".links",[][]float64{})
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".default_latency",DEFAULT_LINK_LATENCY)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".default_bandwidth",DEFAULT_LINK_BANDWIDTH)
+
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".broadcast_distribution",DEFAULT_BROADCAST_DISTRIBUTION)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".p2p_distribution",DEFAULT_P2P_DISTRIBUTION)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".broadcast_config",nil)
+    utils.ConfigSetDefault(TOPOLOGY_GNET_TAG + ".p2p_config",nil)
+    setFaultDefaults(TOPOLOGY_GNET_TAG)
+    setPartitionDefaults(TOPOLOGY_GNET_TAG)
+
+    // register factory
+    core.RegisterGlobalNetwork(TOPOLOGY_GNET_TAG,NewTopologyGlobalNetwork)
+}
+
+// factory for TopologyGlobalNetwork: links and distributions are read from the config of the simulation in Init
+func NewTopologyGlobalNetwork() core.IGlobalNetwork {
+    return &TopologyGlobalNetwork{
+        DefaultGlobalNetwork:       NewDefaultGlobalNetwork().(*DefaultGlobalNetwork),
+        directed:                   DEFAULT_TOPOLOGY_DIRECTED,
+        unlinked:                   DEFAULT_TOPOLOGY_UNLINKED,
+        defaultLink:                core.NetworkLink{Latency: DEFAULT_LINK_LATENCY,Bandwidth: DEFAULT_LINK_BANDWIDTH},
+        links:                      make(map[uint64]core.NetworkLink),
+        adjacency:                  make(map[uint32][]uint32),
+        linkLock:                   sync.RWMutex{},
+    }
+}
+
+// ==== methods ====
+
+func (net *TopologyGlobalNetwork) Init(sim core.ISimulation,components ...core.ISimulationComponent){
+    net.DefaultComponent.Init(sim)
+    net.logger = sim.GetLogger(TOPOLOGY_GNET_TAG)
+
+    // the config is checked by ValidateConfig before creating the simulation
+    config := net.GetConfigSection(TOPOLOGY_GNET_TAG)
+    net.directed = config.GetBool("directed")
+    net.unlinked = config.GetString("unlinked")
+    net.defaultLink = core.NetworkLink{
+        Latency:        config.GetFloat64("default_latency"),
+        Bandwidth:      config.GetFloat64("default_bandwidth"),
+    }
+    net.initSamplers(config)
+    net.initFaults(config)
+    net.initPartitions(config)
+
+    // the loss of a link is one of its faults (links without loss keep the faults of the delivery type)
+    links, _ := config.ParseFloat64Matrix("links")
+    for _, row := range links {
+        from, to := uint32(row[0]), uint32(row[1])
+        net.ConnectLink(from,to,&core.NetworkLink{Latency: row[2],Bandwidth: row[3]})
+        if row[4] > 0 {
+            net.SetLinkFaults(from,to,MessageFaults{Loss: row[4]})
+            if !net.directed {
+                net.SetLinkFaults(to,from,MessageFaults{Loss: row[4]})
+            }
+        }
+    }
+
+    net.logger.Debug("initializing with %d links (directed=%v), p2pSampler=%v and broadcastSampler=%v",len(links),net.directed,net.p2pSampler.GetDistName(),net.broadcastSampler.GetDistName())
+}
+
+func (net *TopologyGlobalNetwork) SendMessage(msg core.IMessage) core.IGlobalNetwork {
+    msg.SetTime(net.GetTime())
+    receivers, isBroadcast := net.GetReceivers(msg)
+
+    sampler := net.p2pSampler
+    if isBroadcast {
+        sampler = net.broadcastSampler
+    }
+
+    sender := msg.GetSender()
+    gnet := net.GetSimulation().GetGlobalNetwork()
+    for _, node := range receivers {
+        link, ok := net.GetLink(sender,node.GetID())
+        if !ok {
+            if isBroadcast { // only to linked nodes
+                continue
+            }
+
+            evType := uint16(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED)
+            if net.unlinked == UNLINKED_REJECT {
+                evType = uint16(core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED)
+            }
+            net.ScheduleEvent(utils.NewEvent(evType,&core.MessageFault{Message: msg,Receiver: node.GetID()},gnet),0)
+            continue
+        }
+
+        delay := link.Latency
+        if delay < 0 {
+            delay = sampler.Sample()
+        }
+        if link.Bandwidth > 0 {
+            delay += float64(msg.GetSize()) * 8 / link.Bandwidth
+        }
+
+        net.deliver(msg,node,delay,isBroadcast)
+    }
+
+    return net
+}
+
+/*
+    Implements core.ILinkGlobalNetwork. Without attributes (nil), an existing
+    link is kept as it is, and a new one gets the default attributes.
+*/
+func (net *TopologyGlobalNetwork) ConnectLink(from uint32,to uint32,link *core.NetworkLink) core.ILinkGlobalNetwork {
+    if from == to {
+        return net
+    }
+
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    net.addLink(from,to,link)
+    if !net.directed {
+        net.addLink(to,from,link)
+    }
+
+    return net
+}
+
+// implements core.ILinkGlobalNetwork
+func (net *TopologyGlobalNetwork) DisconnectLink(from uint32,to uint32) core.ILinkGlobalNetwork {
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    net.removeLink(from,to)
+    if !net.directed {
+        net.removeLink(to,from)
+    }
+
+    return net
+}
+
+// add a directed link, with linkLock held
+func (net *TopologyGlobalNetwork) addLink(from uint32,to uint32,link *core.NetworkLink) {
+    key := linkKey(from,to)
+    if _, ok := net.links[key]; ok {
+        if link != nil {
+            net.links[key] = *link
+        }
+        return
+    }
+
+    if link == nil {
+        link = &net.defaultLink
+    }
+    net.links[key] = *link
+
+    nodes := net.adjacency[from]
+    idx := sort.Search(len(nodes),func(i int) bool { return nodes[i] >= to })
+    nodes = append(nodes,0)
+    copy(nodes[idx+1:],nodes[idx:])
+    nodes[idx] = to
+    net.adjacency[from] = nodes
+
+    net.logger.Debug("link from node %d to node %d added",from,to)
+}
+
+// remove a directed link, with linkLock held
+func (net *TopologyGlobalNetwork) removeLink(from uint32,to uint32) {
+    key := linkKey(from,to)
+    if _, ok := net.links[key]; !ok {
+        return
+    }
+    delete(net.links,key)
+
+    nodes := net.adjacency[from]
+    idx := sort.Search(len(nodes),func(i int) bool { return nodes[i] >= to })
+    net.adjacency[from] = append(nodes[:idx],nodes[idx+1:]...)
+    if len(net.adjacency[from]) == 0 {
+        delete(net.adjacency,from)
+    }
+
+    net.logger.Debug("link from node %d to node %d removed",from,to)
+}
+
+// implements core.IConfigValidator: links, their default attributes, distributions, faults, and partitions
+func (net *TopologyGlobalNetwork) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    section := utils.NewConfigSection(config,TOPOLOGY_GNET_TAG,net.GetConfigInstance())
+
+    if unlinked := section.GetString("unlinked"); unlinked != UNLINKED_DROP && unlinked != UNLINKED_REJECT {
+        errs.Add(section.GetKey("unlinked"),"must be %q or %q, got %q",UNLINKED_DROP,UNLINKED_REJECT,unlinked)
+    }
+    if bandwidth := section.GetFloat64("default_bandwidth"); bandwidth < 0 {
+        errs.Add(section.GetKey("default_bandwidth"),"must not be negative")
+    }
+    // a link cannot have a loss and faults of its own
+    faulty := make(map[uint64]bool)
+    if faults, err := section.ParseFloat64Matrix("link_faults"); err == nil {
+        for _, row := range faults {
+            if len(row) == LINK_FAULTS_ROW_LEN {
+                faulty[linkKey(uint32(row[0]),uint32(row[1]))] = true
+            }
+        }
+    }
+
+    links, err := section.ParseFloat64Matrix("links")
+    if err != nil {
+        errs.AddError(section.GetKey("links"),err)
+    }
+    for i, row := range links {
+        if len(row) != LINKS_ROW_LEN {
+            errs.Add(section.GetKey("links"),"row %d: expected [from,to,latency,bandwidth,loss], got %v",i,row)
+            continue
+        }
+        for _, id := range row[:2] {
+            if id < 0 || id > math.MaxUint32 || id != math.Trunc(id) {
+                errs.Add(section.GetKey("links"),"row %d: invalid node id %v",i,id)
+            }
+        }
+        if row[0] == row[1] {
+            errs.Add(section.GetKey("links"),"row %d: a node cannot be linked to itself",i)
+        }
+        if row[3] < 0 {
+            errs.Add(section.GetKey("links"),"row %d: bandwidth must not be negative, got %v",i,row[3])
+        }
+        if row[4] < 0 || row[4] > 1 {
+            errs.Add(section.GetKey("links"),"row %d: loss must be in [0,1], got %v",i,row[4])
+        }
+        from, to := uint32(row[0]), uint32(row[1])
+        if row[4] > 0 && (faulty[linkKey(from,to)] || (!section.GetBool("directed") && faulty[linkKey(to,from)])) {
+            errs.Add(section.GetKey("links"),"row %d: the link has faults in link_faults, set its loss there",i)
+        }
+    }
+
+    net.validateSamplers(section,&errs)
+    net.validateFaults(section,&errs)
+    net.validatePartitions(section,&errs)
+
+    return errs.Err()
+}
+
+/*
+    Implements core.ISnapshotable: broadcast settings, faults, partition, and
+    links.
+*/
+func (net *TopologyGlobalNetwork) Snapshot() ([]byte,error) {
+    base, err := net.DefaultGlobalNetwork.Snapshot()
+    if err != nil {
+        return nil, err
+    }
+
+    net.linkLock.RLock()
+    defer net.linkLock.RUnlock()
+
+    return core.EncodeSnapshot(topologyGlobalNetworkGob{
+        Base:       base,
+        Links:      net.links,
+    })
+}
+
+// implements core.ISnapshotable
+func (net *TopologyGlobalNetwork) Restore(data []byte) error {
+    snapshot := topologyGlobalNetworkGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    if err := net.DefaultGlobalNetwork.Restore(snapshot.Base); err != nil {
+        return err
+    }
+
+    net.linkLock.Lock()
+    defer net.linkLock.Unlock()
+
+    net.links = make(map[uint64]core.NetworkLink)
+    net.adjacency = make(map[uint32][]uint32)
+    for key, link := range snapshot.Links {
+        link := link
+        net.addLink(uint32(key >> 32),uint32(key),&link)
+    }
+
+    return nil
+}
+
+// state of TopologyGlobalNetwork, for snapshots
+type topologyGlobalNetworkGob struct {
+    Base []byte
+    Links map[uint64]core.NetworkLink
+}
+
+// ==== getters ====
+
+func (net *TopologyGlobalNetwork) GetName() string {
+    return TOPOLOGY_GNET_TAG
+}
+
+// implements core.ILinkGlobalNetwork
+func (net *TopologyGlobalNetwork) IsLinked(from uint32,to uint32) bool {
+    _, ok := net.GetLink(from,to)
+    return ok
+}
+
+// implements core.ILinkGlobalNetwork
+func (net *TopologyGlobalNetwork) GetLinks(nodeID uint32) []uint32 {
+    net.linkLock.RLock()
+    defer net.linkLock.RUnlock()
+
+    nodes := make([]uint32,len(net.adjacency[nodeID]))
+    copy(nodes,net.adjacency[nodeID])
+    return nodes
+}
+
+// attributes of the link from a node to another, if linked
+func (net *TopologyGlobalNetwork) GetLink(from uint32,to uint32) (core.NetworkLink,bool) {
+    net.linkLock.RLock()
+    defer net.linkLock.RUnlock()
+
+    link, ok := net.links[linkKey(from,to)]
+    return link, ok
+}
+
+// implements core.ILinkGlobalNetwork
+func (net *TopologyGlobalNetwork) IsDirected() bool {
+    return net.directed
+}
@@ -0,0 +1,51 @@
+package global_network
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Links 1-2 (latency 0.2 s, 8000 bits/s) and 1-3 (sampled latency of 0.1 s,
+    unlimited bandwidth), and messages of 1000 bytes: node 1 broadcasts at
+    time 1 (node 4 is not linked), node 2 sends a p2p message to node 1 at
+    time 2, and node 1 sends one to node 4 at time 2, which is rejected.
+    Directed links only work from node 1.
+*/
+func TestTopologyLinks(t *testing.T) {
+    tests := []struct{
+        directed bool
+        received map[uint32][]float64               // reception times at each node
+        rejected map[uint32]int
+    }{
+        {false,map[uint32][]float64{1: {3.2},2: {2.2},3: {1.1},4: {}},map[uint32]int{4: 1}},
+        {true,map[uint32][]float64{1: {},2: {2.2},3: {1.1},4: {}},map[uint32]int{1: 1,4: 1}},
+    }
+
+    for _, test := range tests {
+        net := newTestNetwork(t,TOPOLOGY_GNET_TAG,4,0.1,map[string]interface{}{
+            TOPOLOGY_GNET_TAG + ".directed":    test.directed,
+            TOPOLOGY_GNET_TAG + ".unlinked":    UNLINKED_REJECT,
+            TOPOLOGY_GNET_TAG + ".links":       [][]float64{{1,2,0.2,8000,0},{1,3,-1,0,0}},
+        })
+        net.send(core.NewBroadcastMessage(0,1).SetSize(1000),1)
+        net.send(core.NewP2PMessage(1,2,1).SetSize(1000),2)
+        net.send(core.NewP2PMessage(2,1,4).SetSize(1000),2)
+        net.run(10)
+
+        for node := uint32(1); node <= 4; node++ {
+            times := make([]float64,0)
+            for _, delivery := range net.nodes[node - 1].nnet.received {
+                times = append(times,delivery.time)
+            }
+            if !equalTimes(times,test.received[node]) {
+                t.Errorf("directed=%v: node %d received messages at %v, want %v",test.directed,node,times,test.received[node])
+            }
+            if got := net.countFaults(core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED,node); got != test.rejected[node] {
+                t.Errorf("directed=%v: %d messages to node %d rejected, want %d",test.directed,got,node,test.rejected[node])
+            }
+        }
+    }
+}
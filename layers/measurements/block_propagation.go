@@ -0,0 +1,396 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    BLOCK_PROPAGATION_TAG                       = "block_propagation"               // tag for registry, config, and log
+    BLOCK_PROPAGATION_METRIC_COVERAGE           = "coverage"                        // metric prefix, followed by the percentage of nodes
+    BLOCK_PROPAGATION_METRIC_DELAYS             = "delays"
+)
+
+var DEFAULT_BLOCK_PROPAGATION_PERCENTILES       = []float64{10,25,50,75,90,99}      // percentiles of reception delays
+var BLOCK_PROPAGATION_COVERAGE                  = []float64{50,90,100}              // node coverage (%) reported for each block
+
+// ==== concrete structures ====
+
+// reception of a single block
+type blockPropagation struct {
+    hash uint64
+    creator uint32
+    time float64
+    numNodes uint32
+    received map[uint32]float64 // node id -> first reception time
+}
+
+// propagation of a single block in the final result
+type BlockPropagationEntry struct {
+    Hash uint64                                 `json:"hash"`
+    Creator uint32                              `json:"creator"`
+    Time float64                                `json:"time"`
+    NumNodes uint32                             `json:"num_nodes"`
+    NumReceived int                             `json:"num_received"`
+    Coverage map[string]*float64                `json:"coverage"`     // "50","90","100" -> time to reach that % of nodes (null if never reached)
+    Delays utils.SampleSummary                  `json:"delays"`       // reception delays of all nodes that received the block
+}
+
+// final result of the module
+type BlockPropagationResult struct {
+    NumBlocks int                               `json:"num_blocks"`
+    Coverage map[string]utils.SampleSummary     `json:"coverage"`     // "50","90","100" -> summary over all blocks that reached that % of nodes
+    Delays utils.SampleSummary                  `json:"delays"`       // reception delays of all blocks and nodes
+    Blocks []BlockPropagationEntry              `json:"blocks,omitempty"`
+}
+
+/*
+    Measures how blocks propagate: records when each node first received every
+    block, based on BLOCK_EVENT_NEW (creation) and on messages carrying an
+    IBlock (NODE_NETWORK_EVENT_MESSAGE_RECEIVED). Reports the time for each
+    block to reach 50%, 90%, and 100% of the nodes, and percentiles of the
+    reception delays per block and for the whole simulation.
+
+    Metrics: "coverage<percentage>" (e.g., "coverage90"), time to reach that
+    percentage of the nodes, for each block that reached it; and "delays",
+    reception delays of all blocks and nodes. Both in order of block creation.
+
+    Implements: ISimulationMeasurementModule, IMetricSource, and IConfigValidator
+*/
+type BlockPropagationModule struct {
+    core.DefaultMeasurementModule
+
+    blocks map[uint64]*blockPropagation
+    order []uint64
+    lock sync.Mutex
+
+    percentiles []float64
+    perBlock bool
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(BLOCK_PROPAGATION_TAG + ".output",BLOCK_PROPAGATION_TAG + ".json")
+    utils.ConfigSetDefault(BLOCK_PROPAGATION_TAG + ".percentiles",DEFAULT_BLOCK_PROPAGATION_PERCENTILES)
+    utils.ConfigSetDefault(BLOCK_PROPAGATION_TAG + ".per_block",true)
+
+    // register factory
+    core.RegisterMeasurementModule(BLOCK_PROPAGATION_TAG,NewBlockPropagationModule)
+}
+
+func NewBlockPropagationModule() core.ISimulationMeasurementModule {
+    return &BlockPropagationModule{
+        DefaultMeasurementModule:   core.NewDefaultMeasurementModule(BLOCK_PROPAGATION_TAG),
+        blocks:                     make(map[uint64]*blockPropagation),
+        order:                      make([]uint64,0,1024),
+        lock:                       sync.Mutex{},
+        percentiles:                nil,
+        perBlock:                   false,
+    }
+}
+
+// ==== methods ====
+
+func (module *BlockPropagationModule) Init(sim core.ISimulation) {
+    module.DefaultMeasurementModule.Init(sim)
+
+    config := sim.GetConfig()
+    module.percentiles = config.GetFloat64Slice(BLOCK_PROPAGATION_TAG + ".percentiles")
+    module.perBlock = config.GetBool(BLOCK_PROPAGATION_TAG + ".per_block")
+
+    hooks := sim.GetHooks()
+    hooks.RegisterPreTrigger(core.BLOCK_EVENT_NEW,module)
+    hooks.RegisterPreTrigger(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,module)
+
+    module.GetLogger().Debug("initializing: registering to new block and message received events")
+}
+
+// implements core.IConfigValidator
+func (module *BlockPropagationModule) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    errs.AddError(BLOCK_PROPAGATION_TAG + ".percentiles",validatePercentiles(config,BLOCK_PROPAGATION_TAG + ".percentiles"))
+
+    return errs.Err()
+}
+
+func (module *BlockPropagationModule) EventPreTrigger(ev utils.IEvent) {
+    switch ev.GetType() {
+    case core.BLOCK_EVENT_NEW:
+        if block, ok := ev.GetData().(core.IBlock); ok {
+            module.blockReceived(block,block.GetCreator(),ev.GetTime(),true)
+        }
+    case core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED:
+        msg, ok := ev.GetData().(core.IMessage)
+        if !ok {
+            return
+        }
+
+        block, ok := msg.GetData().(core.IBlock)
+        if !ok {
+            return
+        }
+
+        if nnet, ok := ev.GetDestination().(core.INodeNetwork); ok && nnet.GetNode() != nil {
+            module.blockReceived(block,nnet.GetNode().GetID(),ev.GetTime(),false)
+        }
+    }
+}
+
+/*
+    Register the first reception of a block by a node. The creation time is the
+    time of the new block event, or the time set in the block if the module
+    missed that event.
+*/
+func (module *BlockPropagationModule) blockReceived(block core.IBlock,nodeID uint32,time float64,created bool) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    hash := block.GetHash()
+    prop, ok := module.blocks[hash]
+    if !ok {
+        creationTime := block.GetTime()
+        if created {
+            creationTime = time
+        }
+
+        prop = &blockPropagation{
+            hash:       hash,
+            creator:    block.GetCreator(),
+            time:       creationTime,
+            numNodes:   module.GetSimulation().GetNumNodes(),
+            received:   make(map[uint32]float64),
+        }
+        module.blocks[hash] = prop
+        module.order = append(module.order,hash)
+    }
+
+    if _, ok := prop.received[nodeID]; !ok {
+        prop.received[nodeID] = time
+    }
+}
+
+// reception of a single block, for snapshots
+type blockPropagationGob struct {
+    Hash uint64
+    Creator uint32
+    Time float64
+    NumNodes uint32
+    Received map[uint32]float64
+}
+
+// implements core.ISnapshotable
+func (module *BlockPropagationModule) Snapshot() ([]byte,error) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    blocks := make([]blockPropagationGob,0,len(module.order))
+    for _, hash := range module.order {
+        prop := module.blocks[hash]
+        blocks = append(blocks,blockPropagationGob{prop.hash,prop.creator,prop.time,prop.numNodes,prop.received})
+    }
+
+    return core.EncodeSnapshot(blocks)
+}
+
+// implements core.ISnapshotable
+func (module *BlockPropagationModule) Restore(data []byte) error {
+    blocks := make([]blockPropagationGob,0)
+    if err := core.DecodeSnapshot(data,&blocks); err != nil {
+        return err
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    module.blocks = make(map[uint64]*blockPropagation,len(blocks))
+    module.order = make([]uint64,0,len(blocks) + 1024)
+    for _, block := range blocks {
+        if block.Received == nil {
+            block.Received = make(map[uint32]float64)
+        }
+
+        module.blocks[block.Hash] = &blockPropagation{
+            hash:       block.Hash,
+            creator:    block.Creator,
+            time:       block.Time,
+            numNodes:   block.NumNodes,
+            received:   block.Received,
+        }
+        module.order = append(module.order,block.Hash)
+    }
+
+    return nil
+}
+
+// ==== getters ====
+
+// sorted reception delays of a block
+func (prop *blockPropagation) delays() []float64 {
+    delays := make([]float64,0,len(prop.received))
+    for _, t := range prop.received {
+        delays = append(delays,t - prop.time)
+    }
+    sort.Float64s(delays)
+
+    return delays
+}
+
+// time for the block to reach the given percentage of nodes (false if never reached)
+func coverageTime(delays []float64,numNodes uint32,percentage float64) (float64,bool) {
+    target := int(math.Ceil(percentage / 100 * float64(numNodes)))
+    if target < 1 {
+        target = 1
+    }
+
+    if len(delays) < target {
+        return 0, false
+    }
+
+    return delays[target-1], true
+}
+
+// time for each block to reach the given percentage of nodes, for all blocks that reached it
+func (module *BlockPropagationModule) GetCoverageTimes(percentage float64) []float64 {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    times := make([]float64,0,len(module.order))
+    for _, hash := range module.order {
+        prop := module.blocks[hash]
+        if t, ok := coverageTime(prop.delays(),prop.numNodes,percentage); ok {
+            times = append(times,t)
+        }
+    }
+
+    return times
+}
+
+// implements core.IMetricSource
+func (module *BlockPropagationModule) GetMetricNames() []string {
+    names := make([]string,0,len(BLOCK_PROPAGATION_COVERAGE) + 1)
+    for _, percentage := range BLOCK_PROPAGATION_COVERAGE {
+        names = append(names,BLOCK_PROPAGATION_METRIC_COVERAGE + coverageKey(percentage))
+    }
+
+    return append(names,BLOCK_PROPAGATION_METRIC_DELAYS)
+}
+
+// implements core.IMetricSource: delays, and coverage times for any percentage in (0,100] (e.g., coverage75)
+func (module *BlockPropagationModule) HasMetric(name string) bool {
+    _, ok := coverageMetric(name)
+    return ok || name == BLOCK_PROPAGATION_METRIC_DELAYS
+}
+
+// implements core.IMetricSource
+func (module *BlockPropagationModule) GetMetric(name string) ([]float64,bool) {
+    if name == BLOCK_PROPAGATION_METRIC_DELAYS {
+        module.lock.Lock()
+        defer module.lock.Unlock()
+
+        delays := make([]float64,0,len(module.order))
+        for _, hash := range module.order {
+            delays = append(delays,module.blocks[hash].delays()...)
+        }
+
+        return delays, true
+    }
+
+    if percentage, ok := coverageMetric(name); ok {
+        return module.GetCoverageTimes(percentage), true
+    }
+
+    return nil, false
+}
+
+// percentage of a coverage metric (e.g., 90 for coverage90), false if the name is not a valid coverage metric
+func coverageMetric(name string) (float64,bool) {
+    if !strings.HasPrefix(name,BLOCK_PROPAGATION_METRIC_COVERAGE) {
+        return 0, false
+    }
+
+    percentage, err := strconv.ParseFloat(name[len(BLOCK_PROPAGATION_METRIC_COVERAGE):],64)
+    if err != nil || percentage <= 0 || percentage > 100 {
+        return 0, false
+    }
+
+    return percentage, true
+}
+
+func (module *BlockPropagationModule) GetFinalResult() interface{} {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    result := BlockPropagationResult{
+        NumBlocks:      len(module.order),
+        Coverage:       make(map[string]utils.SampleSummary),
+    }
+
+    coverage := make(map[string][]float64)
+    allDelays := make([]float64,0,len(module.order))
+    for _, hash := range module.order {
+        prop := module.blocks[hash]
+        delays := prop.delays()
+        allDelays = append(allDelays,delays...)
+
+        entry := BlockPropagationEntry{
+            Hash:           prop.hash,
+            Creator:        prop.creator,
+            Time:           prop.time,
+            NumNodes:       prop.numNodes,
+            NumReceived:    len(delays),
+            Coverage:       make(map[string]*float64),
+            Delays:         utils.NewSampleSummary(delays,module.percentiles),
+        }
+
+        for _, percentage := range BLOCK_PROPAGATION_COVERAGE {
+            key := coverageKey(percentage)
+            if t, ok := coverageTime(delays,prop.numNodes,percentage); ok {
+                entry.Coverage[key] = &t
+                coverage[key] = append(coverage[key],t)
+            } else {
+                entry.Coverage[key] = nil
+            }
+        }
+
+        if module.perBlock {
+            result.Blocks = append(result.Blocks,entry)
+        }
+    }
+
+    for _, percentage := range BLOCK_PROPAGATION_COVERAGE {
+        key := coverageKey(percentage)
+        result.Coverage[key] = utils.NewSampleSummary(coverage[key],module.percentiles)
+    }
+    result.Delays = utils.NewSampleSummary(allDelays,module.percentiles)
+
+    module.GetLogger().Debug("%d blocks measured",result.NumBlocks)
+    return result
+}
+
+func coverageKey(percentage float64) string {
+    return utils.PercentileKey(percentage)[1:]
+}
+
+// percentiles given in a config key must be numbers in [0,100] (shared by the modules)
+func validatePercentiles(config *utils.SimulationConfig,key string) error {
+    percentiles, err := config.ParseFloat64Slice(key)
+    if err != nil {
+        return err
+    }
+
+    for _, p := range percentiles {
+        if p < 0 || p > 100 {
+            return fmt.Errorf("percentile %v out of range [0,100]",p)
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,114 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Two blocks propagating among 4 nodes: the first reaches 3 nodes (delays 0,
+    1 and 2; a second reception is ignored), the second reaches 2 nodes
+    (delays 2 and 4) and its creation is missed, so the time set in the block
+    is used.
+*/
+func newTestBlockPropagation(t *testing.T) *BlockPropagationModule {
+    sim := newTestSimulation(t,4,map[string]interface{}{
+        BLOCK_PROPAGATION_TAG + ".percentiles":     []float64{0,50,100},
+    })
+    module := NewBlockPropagationModule().(*BlockPropagationModule)
+    module.Init(sim)
+
+    first := &testBlock{hash: 1,creator: 1,time: 10}
+    second := &testBlock{hash: 2,parent: 1,creator: 2,time: 20}
+
+    sim.newBlock(first,10)
+    sim.receive(2,core.NewBroadcastMessage(first,1),11)
+    sim.receive(3,core.NewBroadcastMessage(first,1),12)
+    sim.receive(2,core.NewBroadcastMessage(first,3),13)
+    sim.receive(3,core.NewBroadcastMessage(second,2),22)
+    sim.receive(1,core.NewBroadcastMessage(second,2),24)
+
+    return module
+}
+
+// coverage per block (null if never reached) and percentiles of the delays
+func TestBlockPropagationResult(t *testing.T) {
+    result := newTestBlockPropagation(t).GetFinalResult().(BlockPropagationResult)
+
+    if result.NumBlocks != 2 || len(result.Blocks) != 2 {
+        t.Fatalf("%d blocks (%d entries), want 2",result.NumBlocks,len(result.Blocks))
+    }
+
+    tests := []struct{
+        creator uint32
+        numReceived int
+        coverage map[string]float64             // missing if never reached
+        percentiles map[string]float64
+    }{
+        {1,3,map[string]float64{"50": 1},map[string]float64{"p0": 0,"p50": 1,"p100": 2}},
+        {2,2,map[string]float64{"50": 4},map[string]float64{"p0": 2,"p50": 3,"p100": 4}},
+    }
+
+    for i, test := range tests {
+        entry := result.Blocks[i]
+        if entry.Creator != test.creator || entry.NumReceived != test.numReceived || entry.NumNodes != 4 {
+            t.Errorf("block %d: creator %d, %d of %d nodes, want %d, %d of 4",i,entry.Creator,entry.NumReceived,entry.NumNodes,test.creator,test.numReceived)
+        }
+        for _, key := range []string{"50","90","100"} {
+            want, reached := test.coverage[key]
+            if got := entry.Coverage[key]; (got != nil) != reached || (reached && *got != want) {
+                t.Errorf("block %d: coverage %s is %v, want %v (reached: %v)",i,key,got,want,reached)
+            }
+        }
+        for key, want := range test.percentiles {
+            if got := entry.Delays.Percentiles[key]; got != want {
+                t.Errorf("block %d: delay %s is %v, want %v",i,key,got,want)
+            }
+        }
+    }
+
+    if got := result.Coverage["50"]; got.Count != 2 || got.Percentiles["p50"] != 2.5 {
+        t.Errorf("coverage 50: %d blocks, median %v, want 2 blocks, median 2.5",got.Count,got.Percentiles["p50"])
+    }
+    if got := result.Coverage["90"]; got.Count != 0 {
+        t.Errorf("coverage 90: %d blocks, want 0",got.Count)
+    }
+    if result.Delays.Count != 5 || result.Delays.Percentiles["p50"] != 2 || result.Delays.Max != 4 {
+        t.Errorf("delays: %d, median %v, max %v, want 5, 2, 4",result.Delays.Count,result.Delays.Percentiles["p50"],result.Delays.Max)
+    }
+}
+
+// coverage times (for any percentage) and delays, in order of block creation
+func TestBlockPropagationMetrics(t *testing.T) {
+    module := newTestBlockPropagation(t)
+
+    tests := []struct{
+        metric string
+        want []float64
+    }{
+        {"coverage25",[]float64{0,2}},
+        {"coverage50",[]float64{1,4}},
+        {"coverage75",[]float64{2}},
+        {"coverage90",[]float64{}},
+        {"coverage100",[]float64{}},
+        {"delays",[]float64{0,1,2,2,4}},
+    }
+
+    for _, test := range tests {
+        if !module.HasMetric(test.metric) {
+            t.Errorf("%s: not provided",test.metric)
+        }
+        got, ok := module.GetMetric(test.metric)
+        if !ok || !equalFloats(got,test.want) {
+            t.Errorf("%s: got %v (%v), want %v",test.metric,got,ok,test.want)
+        }
+    }
+
+    for _, metric := range []string{"coverage0","coverage101","coveragex","latency"} {
+        if _, ok := module.GetMetric(metric); ok || module.HasMetric(metric) {
+            t.Errorf("%s: provided",metric)
+        }
+    }
+}
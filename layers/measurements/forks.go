@@ -0,0 +1,393 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "sync"
+)
+
+const (
+    FORK_RATE_TAG                               = "fork_rate"                       // tag for registry, config, and log
+)
+
+var DEFAULT_FORK_RATE_PERCENTILES               = []float64{50,90,99}               // percentiles of fork lengths and reorg depths
+
+// ==== concrete structures ====
+
+// reorganizations seen by a single node
+type NodeReorgResult struct {
+    NumAccepted int                             `json:"num_accepted"`
+    NumReorgs int                               `json:"num_reorgs"`
+    MaxDepth uint64                             `json:"max_depth"`
+    Depths map[uint64]int                       `json:"depths"`       // depth -> number of reorgs
+}
+
+// final result of the module
+type ForkRateResult struct {
+    NumBlocks int                               `json:"num_blocks"`
+    MainChainHeight uint64                      `json:"main_chain_height"`
+    NumMainChain int                            `json:"num_main_chain"`
+    NumStale int                                `json:"num_stale"`
+    NumUnresolved int                           `json:"num_unresolved"` // blocks whose chain does not reach a registered genesis
+    StaleRate float64                           `json:"stale_rate"`
+    NumForks int                                `json:"num_forks"`
+    ForkLengths map[uint64]int                  `json:"fork_lengths"` // length -> number of forks
+    ForkLengthSummary utils.SampleSummary       `json:"fork_length_summary"`
+    NumReorgs int                               `json:"num_reorgs"`
+    ReorgDepths map[uint64]int                  `json:"reorg_depths"` // depth -> number of reorgs (all nodes)
+    ReorgDepthSummary utils.SampleSummary       `json:"reorg_depth_summary"`
+    Nodes map[uint32]*NodeReorgResult           `json:"nodes"`
+}
+
+/*
+    Measures forks and stale blocks. Blocks are collected from BLOCK_EVENT_NEW
+    and their chains are resolved with the block registry of the global state.
+    The main chain is the longest chain at the end of the simulation (ties are
+    broken by creation order), and every other block is stale. A fork is a
+    branch of stale blocks leaving the main chain, and its length is the
+    longest path in that branch. Reorganizations are detected when a node
+    accepts a tip (BLOCK_EVENT_ACCEPTED) that does not extend its previous tip:
+    the depth is the number of blocks removed from its previous main chain.
+
+    Both events are emitted by the node layers that create and select blocks
+    (see core/events.go). Blocks whose chain does not reach a genesis block
+    announced with BLOCK_EVENT_NEW cannot be resolved: they are neither main
+    chain nor stale, and are reported with a warning.
+
+    Implements: ISimulationMeasurementModule and IConfigValidator
+*/
+type ForkRateModule struct {
+    core.DefaultMeasurementModule
+
+    state core.ISimulationGlobalState
+    blocks []uint64
+    known map[uint64]bool
+    tips map[uint32]uint64
+    nodes map[uint32]*NodeReorgResult
+    lock sync.Mutex
+
+    percentiles []float64
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(FORK_RATE_TAG + ".output",FORK_RATE_TAG + ".json")
+    utils.ConfigSetDefault(FORK_RATE_TAG + ".percentiles",DEFAULT_FORK_RATE_PERCENTILES)
+
+    // register factory
+    core.RegisterMeasurementModule(FORK_RATE_TAG,NewForkRateModule)
+}
+
+func NewForkRateModule() core.ISimulationMeasurementModule {
+    return &ForkRateModule{
+        DefaultMeasurementModule:   core.NewDefaultMeasurementModule(FORK_RATE_TAG),
+        state:                      nil,
+        blocks:                     make([]uint64,0,1024),
+        known:                      make(map[uint64]bool),
+        tips:                       make(map[uint32]uint64),
+        nodes:                      make(map[uint32]*NodeReorgResult),
+        lock:                       sync.Mutex{},
+        percentiles:                nil,
+    }
+}
+
+// ==== methods ====
+
+func (module *ForkRateModule) Init(sim core.ISimulation) {
+    module.DefaultMeasurementModule.Init(sim)
+    module.percentiles = sim.GetConfig().GetFloat64Slice(FORK_RATE_TAG + ".percentiles")
+
+    // the simulation created from a (validated) config always has a global state
+    module.state = sim.GetGlobalState()
+    if module.state == nil {
+        module.GetLogger().Error("requires a global state (block registry): no blocks will be measured")
+        return
+    }
+
+    // the global state is initialized first, so blocks are registered before reaching this module
+    hooks := sim.GetHooks()
+    hooks.RegisterPreTrigger(core.BLOCK_EVENT_NEW,module)
+    hooks.RegisterPreTrigger(core.BLOCK_EVENT_ACCEPTED,module)
+
+    module.GetLogger().Debug("initializing: registering to new and accepted block events")
+}
+
+// implements core.IConfigValidator
+func (module *ForkRateModule) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    errs.AddError(FORK_RATE_TAG + ".percentiles",validatePercentiles(config,FORK_RATE_TAG + ".percentiles"))
+
+    return errs.Err()
+}
+
+func (module *ForkRateModule) EventPreTrigger(ev utils.IEvent) {
+    block, ok := ev.GetData().(core.IBlock)
+    if !ok {
+        return
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    hash := block.GetHash()
+    if !module.known[hash] {
+        module.known[hash] = true
+        module.blocks = append(module.blocks,hash)
+    }
+
+    if ev.GetType() == core.BLOCK_EVENT_ACCEPTED {
+        if node, ok := ev.GetDestination().(core.INode); ok {
+            module.tipAccepted(node.GetID(),hash)
+        }
+    }
+}
+
+// update the tip of a node, and register a reorganization if needed
+func (module *ForkRateModule) tipAccepted(nodeID uint32,hash uint64) {
+    result, ok := module.nodes[nodeID]
+    if !ok {
+        result = &NodeReorgResult{
+            Depths:     make(map[uint64]int),
+        }
+        module.nodes[nodeID] = result
+    }
+    result.NumAccepted++
+
+    oldTip, ok := module.tips[nodeID]
+    module.tips[nodeID] = hash
+    if !ok || oldTip == hash {
+        return
+    }
+
+    ancestor, ok := module.commonAncestor(oldTip,hash)
+    if !ok || ancestor == oldTip {
+        return
+    }
+
+    oldHeight, _ := module.state.GetBlockHeight(oldTip)
+    ancestorHeight, _ := module.state.GetBlockHeight(ancestor)
+    depth := oldHeight - ancestorHeight
+
+    result.NumReorgs++
+    result.Depths[depth]++
+    if depth > result.MaxDepth {
+        result.MaxDepth = depth
+    }
+    module.GetLogger().Debug("node %d reorganization of depth %d",nodeID,depth)
+}
+
+// common ancestor of two blocks (false if their chains are not registered)
+func (module *ForkRateModule) commonAncestor(a uint64,b uint64) (uint64,bool) {
+    heightA, okA := module.state.GetBlockHeight(a)
+    heightB, okB := module.state.GetBlockHeight(b)
+    if !okA || !okB {
+        return 0, false
+    }
+
+    var ok bool
+    for heightA > heightB {
+        if a, ok = module.parent(a); !ok {
+            return 0, false
+        }
+        heightA--
+    }
+    for heightB > heightA {
+        if b, ok = module.parent(b); !ok {
+            return 0, false
+        }
+        heightB--
+    }
+    for a != b {
+        if a, ok = module.parent(a); !ok {
+            return 0, false
+        }
+        if b, ok = module.parent(b); !ok {
+            return 0, false
+        }
+    }
+
+    return a, true
+}
+
+func (module *ForkRateModule) parent(hash uint64) (uint64,bool) {
+    block := module.state.GetBlock(hash)
+    if block == nil {
+        return 0, false
+    }
+
+    return core.GetBlockParent(block)
+}
+
+// state of the module, for snapshots
+type forkRateGob struct {
+    Blocks []uint64
+    Tips map[uint32]uint64
+    Nodes map[uint32]*NodeReorgResult
+}
+
+// implements core.ISnapshotable
+func (module *ForkRateModule) Snapshot() ([]byte,error) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    return core.EncodeSnapshot(forkRateGob{
+        Blocks:     module.blocks,
+        Tips:       module.tips,
+        Nodes:      module.nodes,
+    })
+}
+
+// implements core.ISnapshotable
+func (module *ForkRateModule) Restore(data []byte) error {
+    snapshot := forkRateGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    module.blocks = append(make([]uint64,0,len(snapshot.Blocks) + 1024),snapshot.Blocks...)
+    module.known = make(map[uint64]bool,len(snapshot.Blocks))
+    for _, hash := range snapshot.Blocks {
+        module.known[hash] = true
+    }
+
+    module.tips = make(map[uint32]uint64)
+    for nodeID, hash := range snapshot.Tips {
+        module.tips[nodeID] = hash
+    }
+
+    module.nodes = make(map[uint32]*NodeReorgResult)
+    for nodeID, node := range snapshot.Nodes {
+        if node.Depths == nil {
+            node.Depths = make(map[uint64]int)
+        }
+        module.nodes[nodeID] = node
+    }
+
+    return nil
+}
+
+// ==== getters ====
+
+// hashes of the blocks in the main chain (longest chain, ties broken by creation order)
+func (module *ForkRateModule) mainChain() (map[uint64]bool,uint64) {
+    mainChain := make(map[uint64]bool)
+
+    var tip uint64
+    var tipHeight uint64
+    found := false
+    for _, hash := range module.blocks {
+        if height, ok := module.state.GetBlockHeight(hash); ok && (!found || height > tipHeight) {
+            tip = hash
+            tipHeight = height
+            found = true
+        }
+    }
+
+    if !found {
+        return mainChain, 0
+    }
+
+    current := tip
+    for {
+        mainChain[current] = true
+        parent, ok := module.parent(current)
+        if !ok {
+            break
+        }
+        current = parent
+    }
+
+    return mainChain, tipHeight
+}
+
+func (module *ForkRateModule) GetFinalResult() interface{} {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    mainChain, height := module.mainChain()
+    result := ForkRateResult{
+        NumBlocks:          len(module.blocks),
+        MainChainHeight:    height,
+        ForkLengths:        make(map[uint64]int),
+        ReorgDepths:        make(map[uint64]int),
+        Nodes:              module.nodes,
+    }
+
+    // stale blocks and fork roots (stale blocks whose parent is not stale)
+    children := make(map[uint64][]uint64)
+    roots := make([]uint64,0,16)
+    for _, hash := range module.blocks {
+        if _, ok := module.state.GetBlockHeight(hash); !ok {
+            result.NumUnresolved++
+            continue
+        }
+        if mainChain[hash] {
+            result.NumMainChain++
+            continue
+        }
+
+        result.NumStale++
+        parent, ok := module.parent(hash)
+        if ok && module.known[parent] && !mainChain[parent] {
+            children[parent] = append(children[parent],hash)
+        } else {
+            roots = append(roots,hash)
+        }
+    }
+
+    if resolved := result.NumMainChain + result.NumStale; resolved > 0 {
+        result.StaleRate = float64(result.NumStale) / float64(resolved)
+    }
+    if result.NumBlocks == 0 {
+        module.GetLogger().Warn("no blocks: nodes must schedule BLOCK_EVENT_NEW when they create blocks")
+    } else if result.NumUnresolved > 0 {
+        module.GetLogger().Warn("%d of %d blocks do not reach a registered genesis block (announce it with BLOCK_EVENT_NEW): they are not counted",result.NumUnresolved,result.NumBlocks)
+    }
+
+    // fork lengths: longest path from each root
+    lengths := make([]float64,0,len(roots))
+    for _, root := range roots {
+        length := forkLength(root,children)
+        result.ForkLengths[length]++
+        lengths = append(lengths,float64(length))
+    }
+    result.NumForks = len(roots)
+    result.ForkLengthSummary = utils.NewSampleSummary(lengths,module.percentiles)
+
+    // reorganizations of all nodes
+    depths := make([]float64,0,64)
+    for _, node := range module.nodes {
+        result.NumReorgs += node.NumReorgs
+        for depth, count := range node.Depths {
+            result.ReorgDepths[depth] += count
+            for i := 0; i < count; i++ {
+                depths = append(depths,float64(depth))
+            }
+        }
+    }
+    result.ReorgDepthSummary = utils.NewSampleSummary(depths,module.percentiles)
+
+    module.GetLogger().Debug("%d blocks, %d stale, %d forks, %d reorganizations",result.NumBlocks,result.NumStale,result.NumForks,result.NumReorgs)
+    return result
+}
+
+// length of the longest branch starting at the given block
+func forkLength(root uint64,children map[uint64][]uint64) uint64 {
+    var length uint64 = 0
+    level := []uint64{root}
+    for len(level) > 0 {
+        length++
+        next := make([]uint64,0,len(level))
+        for _, hash := range level {
+            next = append(next,children[hash]...)
+        }
+        level = next
+    }
+
+    return length
+}
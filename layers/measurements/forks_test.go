@@ -0,0 +1,80 @@
+package measurements
+
+import (
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Main chain 1-2-3-4, a fork 5-6 from the genesis block, a fork 7 from block
+    2, and block 9 whose parent was never announced. Node 1 follows the fork
+    5-6 and switches to block 4 (depth 2), node 2 follows block 7 and switches
+    to block 3 (depth 1), node 3 only sees block 4.
+*/
+func TestForkRate(t *testing.T) {
+    sim := newTestSimulation(t,3,map[string]interface{}{
+        FORK_RATE_TAG + ".percentiles":     []float64{50},
+    })
+    module := NewForkRateModule().(*ForkRateModule)
+    module.Init(sim)
+
+    blocks := map[uint64]*testBlock{
+        1: {hash: 1,creator: 1},
+        2: {hash: 2,parent: 1,creator: 2},
+        3: {hash: 3,parent: 2,creator: 3},
+        4: {hash: 4,parent: 3,creator: 3},
+        5: {hash: 5,parent: 1,creator: 1},
+        6: {hash: 6,parent: 5,creator: 1},
+        7: {hash: 7,parent: 2,creator: 2},
+        9: {hash: 9,parent: 8,creator: 2},
+    }
+    for i, hash := range []uint64{1,2,5,7,6,3,4,9} {
+        sim.newBlock(blocks[hash],float64(i))
+    }
+
+    accepted := map[uint32][]uint64{
+        1: {1,5,6,4},
+        2: {2,7,3,4},
+        3: {4},
+    }
+    for node, tips := range accepted {
+        for i, hash := range tips {
+            sim.accept(node,blocks[hash],float64(10 + i))
+        }
+    }
+
+    result := module.GetFinalResult().(ForkRateResult)
+    if result.NumBlocks != 8 || result.MainChainHeight != 3 || result.NumMainChain != 4 || result.NumStale != 3 || result.NumUnresolved != 1 {
+        t.Errorf("%d blocks, height %d, %d main chain, %d stale, %d unresolved, want 8, 3, 4, 3, 1",result.NumBlocks,result.MainChainHeight,result.NumMainChain,result.NumStale,result.NumUnresolved)
+    }
+    if result.StaleRate != 3.0 / 7.0 {
+        t.Errorf("stale rate %v, want 3/7",result.StaleRate)
+    }
+    if result.NumForks != 2 || result.ForkLengths[1] != 1 || result.ForkLengths[2] != 1 {
+        t.Errorf("%d forks with lengths %v, want 2 forks of lengths 1 and 2",result.NumForks,result.ForkLengths)
+    }
+    if result.NumReorgs != 2 || result.ReorgDepths[1] != 1 || result.ReorgDepths[2] != 1 || result.ReorgDepthSummary.Percentiles["p50"] != 1.5 {
+        t.Errorf("%d reorganizations with depths %v (median %v), want 2 of depths 1 and 2",result.NumReorgs,result.ReorgDepths,result.ReorgDepthSummary.Percentiles["p50"])
+    }
+
+    tests := []struct{
+        node uint32
+        accepted int
+        reorgs int
+        maxDepth uint64
+    }{
+        {1,4,1,2},
+        {2,4,1,1},
+        {3,1,0,0},
+    }
+
+    for _, test := range tests {
+        node, ok := result.Nodes[test.node]
+        if !ok {
+            t.Errorf("node %d: no result",test.node)
+        } else if node.NumAccepted != test.accepted || node.NumReorgs != test.reorgs || node.MaxDepth != test.maxDepth {
+            t.Errorf("node %d: %d accepted, %d reorganizations, max depth %d, want %d, %d, %d",test.node,node.NumAccepted,node.NumReorgs,node.MaxDepth,test.accepted,test.reorgs,test.maxDepth)
+        }
+    }
+}
@@ -0,0 +1,147 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "testing"
+)
+
+// ==== concrete structures ====
+
+/*
+    Simulation with a given time and number of nodes (the rest is a real
+    simulation with the default global state). Events are not run: they are
+    passed to the hooks directly, so the modules see them as if they were.
+*/
+type testSimulation struct {
+    core.ISimulation
+    time float64
+    numNodes uint32
+}
+
+// node with an id only
+type testNode struct {
+    core.INode
+    id uint32
+}
+
+// node network of a node, as the destination of received messages
+type testNodeNetwork struct {
+    core.INodeNetwork
+    node core.INode
+}
+
+// block with a parent, a creation time and transactions
+type testBlock struct {
+    hash uint64
+    parent uint64
+    creator uint32
+    time float64
+    txs []core.ITransaction
+}
+
+// transaction with a hash and a creation time only
+type testTx struct {
+    hash uint64
+    time float64
+}
+
+// ==== factories ====
+
+// simulation with the given number of nodes and settings, and an initialized global state
+func newTestSimulation(t *testing.T,numNodes uint32,settings map[string]interface{}) *testSimulation {
+    t.Helper()
+
+    config := utils.NewSimulationConfig()
+    for key, value := range settings {
+        config.Set(key,value)
+    }
+
+    sim, err := core.NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+
+    testSim := &testSimulation{ISimulation: sim,numNodes: numNodes}
+    testSim.SetGlobalState(core.NewSimulationGlobalState())
+    testSim.GetGlobalState().Init(testSim)
+
+    return testSim
+}
+
+// ==== methods ====
+
+func (sim *testSimulation) GetTime() float64 { return sim.time }
+func (sim *testSimulation) GetNumNodes() uint32 { return sim.numNodes }
+
+// pass an event to the pre-trigger hooks at the given time
+func (sim *testSimulation) trigger(tp uint16,data interface{},dest utils.IEventDestination,time float64) {
+    sim.time = time
+    ev := utils.NewEvent(tp,data,dest)
+    ev.SetTime(time)
+    sim.GetHooks().EventPreTrigger(ev)
+}
+
+// pass an event to the scheduled hooks at the current time, as scheduled with the given delay
+func (sim *testSimulation) schedule(tp uint16,data interface{},dest utils.IEventDestination,delay float64) {
+    ev := utils.NewEvent(tp,data,dest)
+    ev.SetTime(sim.time + delay)
+    sim.GetHooks().EventScheduled(ev)
+}
+
+// a node creates a block (BLOCK_EVENT_NEW)
+func (sim *testSimulation) newBlock(block *testBlock,time float64) {
+    sim.trigger(core.BLOCK_EVENT_NEW,core.IBlock(block),&testNode{id: block.creator},time)
+}
+
+// a node accepts a block as its tip (BLOCK_EVENT_ACCEPTED)
+func (sim *testSimulation) accept(node uint32,block *testBlock,time float64) {
+    sim.trigger(core.BLOCK_EVENT_ACCEPTED,core.IBlock(block),&testNode{id: node},time)
+}
+
+// a node receives a message (NODE_NETWORK_EVENT_MESSAGE_RECEIVED)
+func (sim *testSimulation) receive(node uint32,msg core.IMessage,time float64) {
+    sim.trigger(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,&testNodeNetwork{node: &testNode{id: node}},time)
+}
+
+func (node *testNode) GetID() uint32 { return node.id }
+
+func (nnet *testNodeNetwork) GetNode() core.INode { return nnet.node }
+
+func (block *testBlock) GetHash() uint64 { return block.hash }
+func (block *testBlock) GetType() uint16 { return core.BLOCK_STANDARD }
+func (block *testBlock) GetTime() float64 { return block.time }
+func (block *testBlock) GetCreator() uint32 { return block.creator }
+func (block *testBlock) GetSize() uint64 { return 1000 }
+func (block *testBlock) Verify() bool { return true }
+func (block *testBlock) GetTransactions() map[uint16][]core.ITransaction { return map[uint16][]core.ITransaction{0: block.txs} }
+
+func (block *testBlock) GetReferences() map[uint16][]uint64 {
+    if block.parent == 0 {
+        return map[uint16][]uint64{}
+    }
+
+    return map[uint16][]uint64{core.BREF_STANDARD: {block.parent}}
+}
+
+func (tx *testTx) GetHash() uint64 { return tx.hash }
+func (tx *testTx) GetType() uint16 { return 0 }
+func (tx *testTx) GetTime() float64 { return tx.time }
+func (tx *testTx) GetCreator() uint32 { return 0 }
+func (tx *testTx) GetSize() uint64 { return 250 }
+func (tx *testTx) Verify() bool { return true }
+
+// ==== functions ====
+
+func equalFloats(a []float64,b []float64) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,306 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "math"
+    "sync"
+)
+
+const (
+    MESSAGE_TRAFFIC_TAG                         = "message_traffic"                 // tag for registry, config, and log
+    DEFAULT_MESSAGE_TRAFFIC_INTERVAL            = 1.0                               // length of each interval of the time series (seconds)
+
+    DELIVERY_P2P                                = "p2p"
+    DELIVERY_BROADCAST                          = "broadcast"
+)
+
+// kinds of traffic counted
+const (
+    TRAFFIC_SENT                                = iota
+    TRAFFIC_RECEIVED
+    TRAFFIC_DROPPED
+    TRAFFIC_DUPLICATED
+    TRAFFIC_UPLOADED
+    TRAFFIC_REJECTED
+)
+
+// ==== concrete structures ====
+
+// number of messages and bytes
+type TrafficCounter struct {
+    Messages uint64                             `json:"messages"`
+    Bytes uint64                                `json:"bytes"`
+}
+
+/*
+    Traffic sent (once per message) and uploaded (once per copy transmitted to
+    a receiver) by senders, traffic received, and messages dropped,
+    duplicated or rejected by the global network (by receiver).
+*/
+type TrafficStats struct {
+    Sent TrafficCounter                         `json:"sent"`
+    Uploaded TrafficCounter                     `json:"uploaded"`
+    Received TrafficCounter                     `json:"received"`
+    Dropped TrafficCounter                      `json:"dropped"`
+    Duplicated TrafficCounter                   `json:"duplicated"`
+    Rejected TrafficCounter                     `json:"rejected"`
+}
+
+// aggregate network load during one interval of the time series
+type TrafficSample struct {
+    Time float64                                `json:"time"`         // start of the interval
+    TrafficStats
+    Load float64                                `json:"load"`         // bytes received per second
+    UploadLoad float64                          `json:"upload_load"`  // bytes uploaded per second
+}
+
+// final result of the module
+type MessageTrafficResult struct {
+    Total TrafficStats                          `json:"total"`
+    Nodes map[uint32]*TrafficStats              `json:"nodes"`
+    Tags map[int32]*TrafficStats                `json:"tags"`
+    Delivery map[string]*TrafficStats           `json:"delivery"`     // "p2p" or "broadcast"
+    Interval float64                            `json:"interval"`
+    TimeSeries []TrafficSample                  `json:"time_series"`
+}
+
+/*
+    Counts messages and bytes (IMessage.GetSize) sent and received per node,
+    per message tag, and per delivery type (p2p or broadcast). A message is
+    sent when the global network handles GLOBAL_NETWORK_EVENT_SEND_MESSAGE
+    (once, regardless of the number of targets), and received when a node
+    network handles NODE_NETWORK_EVENT_MESSAGE_RECEIVED. The upload load of
+    senders counts every copy of a message the global network transmits, when
+    the global network schedules its reception or its loss (i.e., when it is
+    sent, or when it is released by a partition), so broadcasts count once per
+    receiver (and duplicates twice), including copies still in flight at the
+    end of the simulation. Messages dropped or duplicated by the global network
+    (GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED and
+    GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED) are counted for their receiver,
+    and duplicates are also counted as received when delivered. It also builds
+    a time series of the aggregate network load, with intervals of configurable
+    length. Messages the global network refuses to send
+    (GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED) are counted for their receiver, and
+    not as uploaded.
+
+    Implements: ISimulationMeasurementModule and IConfigValidator
+*/
+type MessageTrafficModule struct {
+    core.DefaultMeasurementModule
+
+    result MessageTrafficResult
+    lock sync.Mutex
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(MESSAGE_TRAFFIC_TAG + ".output",MESSAGE_TRAFFIC_TAG + ".json")
+    utils.ConfigSetDefault(MESSAGE_TRAFFIC_TAG + ".interval",DEFAULT_MESSAGE_TRAFFIC_INTERVAL)
+
+    // register factory
+    core.RegisterMeasurementModule(MESSAGE_TRAFFIC_TAG,NewMessageTrafficModule)
+}
+
+func NewMessageTrafficModule() core.ISimulationMeasurementModule {
+    return &MessageTrafficModule{
+        DefaultMeasurementModule:   core.NewDefaultMeasurementModule(MESSAGE_TRAFFIC_TAG),
+        result:                     MessageTrafficResult{
+            Nodes:                      make(map[uint32]*TrafficStats),
+            Tags:                       make(map[int32]*TrafficStats),
+            Delivery:                   make(map[string]*TrafficStats),
+            Interval:                   0,
+            TimeSeries:                 make([]TrafficSample,0,1024),
+        },
+        lock:                       sync.Mutex{},
+    }
+}
+
+// ==== methods ====
+
+func (module *MessageTrafficModule) Init(sim core.ISimulation) {
+    module.DefaultMeasurementModule.Init(sim)
+
+    // checked by ValidateConfig (the default is used if the config was not validated)
+    module.result.Interval = sim.GetConfig().GetFloat64(MESSAGE_TRAFFIC_TAG + ".interval")
+    if module.result.Interval <= 0 {
+        module.GetLogger().Error("%s.interval must be positive: using %v",MESSAGE_TRAFFIC_TAG,DEFAULT_MESSAGE_TRAFFIC_INTERVAL)
+        module.result.Interval = DEFAULT_MESSAGE_TRAFFIC_INTERVAL
+    }
+
+    hooks := sim.GetHooks()
+    hooks.RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE,module)
+    hooks.RegisterPreTrigger(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,module)
+    hooks.RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,module)
+    hooks.RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,module)
+    hooks.RegisterPreTrigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED,module)
+    hooks.RegisterScheduled(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,module)
+    hooks.RegisterScheduled(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,module)
+
+    module.GetLogger().Debug("initializing: registering to send message, message received, dropped, duplicated and rejected events")
+}
+
+// implements core.IConfigValidator
+func (module *MessageTrafficModule) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    if config.GetFloat64(MESSAGE_TRAFFIC_TAG + ".interval") <= 0 {
+        errs.Add(MESSAGE_TRAFFIC_TAG + ".interval","must be positive")
+    }
+
+    return errs.Err()
+}
+
+func (module *MessageTrafficModule) EventPreTrigger(ev utils.IEvent) {
+    switch ev.GetType() {
+    case core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE:
+        if msg, ok := ev.GetData().(core.IMessage); ok {
+            module.count(msg,msg.GetSender(),ev.GetTime(),TRAFFIC_SENT)
+        }
+    case core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED:
+        msg, ok := ev.GetData().(core.IMessage)
+        if nnet, isNet := ev.GetDestination().(core.INodeNetwork); ok && isNet && nnet.GetNode() != nil {
+            module.count(msg,nnet.GetNode().GetID(),ev.GetTime(),TRAFFIC_RECEIVED)
+        }
+    case core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED:
+        if fault, ok := ev.GetData().(*core.MessageFault); ok {
+            module.count(fault.Message,fault.Receiver,ev.GetTime(),TRAFFIC_DROPPED)
+        }
+    case core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED:
+        if fault, ok := ev.GetData().(*core.MessageFault); ok {
+            module.count(fault.Message,fault.Receiver,ev.GetTime(),TRAFFIC_DUPLICATED)
+        }
+    case core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED:
+        if fault, ok := ev.GetData().(*core.MessageFault); ok {
+            module.count(fault.Message,fault.Receiver,ev.GetTime(),TRAFFIC_REJECTED)
+        }
+    }
+}
+
+// copies transmitted by the global network: counted for the sender, at the current time
+func (module *MessageTrafficModule) EventScheduled(ev utils.IEvent) {
+    now := module.GetSimulation().GetTime()
+    switch ev.GetType() {
+    case core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED:
+        if msg, ok := ev.GetData().(core.IMessage); ok {
+            module.count(msg,msg.GetSender(),now,TRAFFIC_UPLOADED)
+        }
+    case core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED:
+        if fault, ok := ev.GetData().(*core.MessageFault); ok {
+            module.count(fault.Message,fault.Message.GetSender(),now,TRAFFIC_UPLOADED)
+        }
+    }
+}
+
+// account a message sent, uploaded, received, dropped, duplicated or rejected for a node
+func (module *MessageTrafficModule) count(msg core.IMessage,nodeID uint32,time float64,kind int) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    size := msg.GetSize()
+    delivery := DELIVERY_P2P
+    if core.IsBroadcastDeliveryType(msg.GetDelivery().GetDeliveryType()) {
+        delivery = DELIVERY_BROADCAST
+    }
+
+    statsList := []*TrafficStats{
+        &module.result.Total,
+        getTrafficStats(module.result.Nodes,nodeID),
+        getTrafficStats(module.result.Tags,msg.GetTag()),
+        getTrafficStats(module.result.Delivery,delivery),
+        &module.getSample(time).TrafficStats,
+    }
+
+    for _, stats := range statsList {
+        counter := &stats.Sent
+        switch kind {
+        case TRAFFIC_RECEIVED:
+            counter = &stats.Received
+        case TRAFFIC_DROPPED:
+            counter = &stats.Dropped
+        case TRAFFIC_DUPLICATED:
+            counter = &stats.Duplicated
+        case TRAFFIC_UPLOADED:
+            counter = &stats.Uploaded
+        case TRAFFIC_REJECTED:
+            counter = &stats.Rejected
+        }
+
+        counter.Messages++
+        counter.Bytes += size
+    }
+}
+
+// sample of the time series for the given time (intervals are created up to that time)
+func (module *MessageTrafficModule) getSample(time float64) *TrafficSample {
+    interval := module.result.Interval
+    idx := int(math.Floor(time / interval))
+    for len(module.result.TimeSeries) <= idx {
+        module.result.TimeSeries = append(module.result.TimeSeries,TrafficSample{
+            Time:   float64(len(module.result.TimeSeries)) * interval,
+        })
+    }
+
+    return &module.result.TimeSeries[idx]
+}
+
+// get the stats for the given key, creating them if needed
+func getTrafficStats[K comparable](statsMap map[K]*TrafficStats,key K) *TrafficStats {
+    stats, ok := statsMap[key]
+    if !ok {
+        stats = &TrafficStats{}
+        statsMap[key] = stats
+    }
+
+    return stats
+}
+
+// implements core.ISnapshotable
+func (module *MessageTrafficModule) Snapshot() ([]byte,error) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    return core.EncodeSnapshot(module.result)
+}
+
+// implements core.ISnapshotable
+func (module *MessageTrafficModule) Restore(data []byte) error {
+    result := MessageTrafficResult{}
+    if err := core.DecodeSnapshot(data,&result); err != nil {
+        return err
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    // empty maps are not encoded
+    if result.Nodes == nil {
+        result.Nodes = make(map[uint32]*TrafficStats)
+    }
+    if result.Tags == nil {
+        result.Tags = make(map[int32]*TrafficStats)
+    }
+    if result.Delivery == nil {
+        result.Delivery = make(map[string]*TrafficStats)
+    }
+
+    module.result = result
+    return nil
+}
+
+// ==== getters ====
+
+func (module *MessageTrafficModule) GetFinalResult() interface{} {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    for i := range module.result.TimeSeries {
+        sample := &module.result.TimeSeries[i]
+        sample.Load = float64(sample.Received.Bytes) / module.result.Interval
+        sample.UploadLoad = float64(sample.Uploaded.Bytes) / module.result.Interval
+    }
+
+    module.GetLogger().Debug("%d messages sent (%d copies uploaded), %d received",module.result.Total.Sent.Messages,module.result.Total.Uploaded.Messages,module.result.Total.Received.Messages)
+    return module.result
+}
@@ -0,0 +1,84 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Node 1 broadcasts a message of 100 bytes to nodes 2 to 5 at time 0.5: the
+    copy of node 2 is received at 1.5, the copy of node 3 is duplicated and
+    received at 2.5 and 2.7, the copy of node 4 is dropped, and the copy of
+    node 5 is still in flight at the end. Uploaded copies are counted when the
+    global network schedules them, in the interval of the broadcast.
+*/
+func TestMessageTrafficBroadcast(t *testing.T) {
+    sim := newTestSimulation(t,5,map[string]interface{}{
+        MESSAGE_TRAFFIC_TAG + ".interval":  1.0,
+    })
+    module := NewMessageTrafficModule().(*MessageTrafficModule)
+    module.Init(sim)
+
+    msg := core.NewBroadcastMessage("block",1).SetSize(100)
+    nnet := func(node uint32) *testNodeNetwork { return &testNodeNetwork{node: &testNode{id: node}} }
+    dropped := &core.MessageFault{Message: msg,Receiver: 4}
+    duplicated := &core.MessageFault{Message: msg,Receiver: 3}
+
+    sim.trigger(core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE,msg,nil,0.5)
+    sim.schedule(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,nnet(2),1)
+    sim.schedule(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,duplicated,nil,0)
+    sim.schedule(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,nnet(3),2)
+    sim.schedule(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,nnet(3),2.2)
+    sim.schedule(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,dropped,nil,0)
+    sim.schedule(core.NODE_NETWORK_EVENT_MESSAGE_RECEIVED,msg,nnet(5),10)
+
+    sim.trigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DUPLICATED,duplicated,nil,0.5)
+    sim.trigger(core.GLOBAL_NETWORK_EVENT_MESSAGE_DROPPED,dropped,nil,0.5)
+    sim.receive(2,msg,1.5)
+    sim.receive(3,msg,2.5)
+    sim.receive(3,msg,2.7)
+
+    result := module.GetFinalResult().(MessageTrafficResult)
+
+    tests := []struct{
+        name string
+        stats *TrafficStats
+        sent uint64
+        uploaded uint64
+        received uint64
+        dropped uint64
+        duplicated uint64
+    }{
+        {"total",&result.Total,1,5,3,1,1},
+        {"broadcast",result.Delivery[DELIVERY_BROADCAST],1,5,3,1,1},
+        {"node 1",result.Nodes[1],1,5,0,0,0},
+        {"node 2",result.Nodes[2],0,0,1,0,0},
+        {"node 3",result.Nodes[3],0,0,2,0,1},
+        {"node 4",result.Nodes[4],0,0,0,1,0},
+        {"interval 0",&result.TimeSeries[0].TrafficStats,1,5,0,1,1},
+        {"interval 1",&result.TimeSeries[1].TrafficStats,0,0,1,0,0},
+        {"interval 2",&result.TimeSeries[2].TrafficStats,0,0,2,0,0},
+    }
+
+    for _, test := range tests {
+        if test.stats == nil {
+            t.Errorf("%s: no stats",test.name)
+            continue
+        }
+
+        got := []TrafficCounter{test.stats.Sent,test.stats.Uploaded,test.stats.Received,test.stats.Dropped,test.stats.Duplicated}
+        want := []uint64{test.sent,test.uploaded,test.received,test.dropped,test.duplicated}
+        for i, counter := range got {
+            if counter.Messages != want[i] || counter.Bytes != 100 * want[i] {
+                t.Errorf("%s: sent, uploaded, received, dropped, duplicated are %v, want %v messages of 100 bytes",test.name,got,want)
+                break
+            }
+        }
+    }
+
+    if len(result.TimeSeries) != 3 || result.TimeSeries[0].UploadLoad != 500 || result.TimeSeries[2].Load != 200 {
+        t.Errorf("%d intervals, upload load %v, load %v, want 3 intervals, 500 and 200 bytes/s",len(result.TimeSeries),result.TimeSeries[0].UploadLoad,result.TimeSeries[2].Load)
+    }
+}
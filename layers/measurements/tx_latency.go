@@ -0,0 +1,449 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/utils"
+    "math"
+    "sort"
+    "sync"
+)
+
+const (
+    TX_LATENCY_TAG                              = "tx_latency"                      // tag for registry, config, and log
+    DEFAULT_TX_LATENCY_CONFIRMATIONS            = 6                                 // k: number of blocks for a transaction to be confirmed
+    DEFAULT_TX_LATENCY_WINDOW                   = 60.0                              // length of the throughput sliding window (seconds)
+    DEFAULT_TX_LATENCY_STEP                     = 10.0                              // step between consecutive windows (seconds)
+
+    TX_LATENCY_METRIC_INCLUSION                 = "inclusion"
+    TX_LATENCY_METRIC_FIRST_CONFIRMATION        = "first_confirmation"
+    TX_LATENCY_METRIC_NODE_CONFIRMATION         = "node_confirmation"
+)
+
+var DEFAULT_TX_LATENCY_PERCENTILES              = []float64{50,90,99}               // percentiles of latencies
+
+// ==== concrete structures ====
+
+// life cycle of a single transaction
+type txLatency struct {
+    created float64
+    included float64
+    isIncluded bool
+    confirmed float64               // first k-confirmation by any node
+    isConfirmed bool
+    numConfirmed int                // number of nodes with the transaction k-confirmed
+}
+
+// throughput during one sliding window
+type TxThroughputSample struct {
+    Start float64                               `json:"start"`
+    End float64                                 `json:"end"`
+    IncludedTPS float64                         `json:"included_tps"`
+    ConfirmedTPS float64                        `json:"confirmed_tps"`
+}
+
+// final result of the module
+type TxLatencyResult struct {
+    Confirmations uint64                        `json:"confirmations"`
+    NumTransactions int                         `json:"num_transactions"`
+    NumIncluded int                             `json:"num_included"`
+    NumConfirmed int                            `json:"num_confirmed"`
+    NumConfirmedByAll int                       `json:"num_confirmed_by_all"` // k-confirmed on every node
+    Inclusion utils.SampleSummary               `json:"inclusion"`    // creation -> first inclusion in a block
+    FirstConfirmation utils.SampleSummary       `json:"first_confirmation"` // creation -> first k-confirmation by any node
+    NodeConfirmation utils.SampleSummary        `json:"node_confirmation"`  // creation -> k-confirmation, for every node
+    Window float64                              `json:"window"`
+    Throughput []TxThroughputSample             `json:"throughput"`
+}
+
+/*
+    Measures the latency of transactions: from creation (ITransaction.GetTime)
+    to the first inclusion in a block (BLOCK_EVENT_NEW), and to k-confirmation
+    on each node. A block is k-confirmed on a node when the node accepts a tip
+    (BLOCK_EVENT_ACCEPTED) at least k-1 blocks above it in the same chain, so
+    protocols with immediate finality should use k=1. Chains are resolved with
+    the block registry of the global state. Throughput (transactions per
+    second) is computed over sliding windows, both for inclusion and for
+    confirmation.
+
+    Metrics: "inclusion", "first_confirmation", and "node_confirmation"
+    latencies, in the order they were observed.
+
+    Implements: ISimulationMeasurementModule, IMetricSource, and IConfigValidator
+*/
+type TxLatencyModule struct {
+    core.DefaultMeasurementModule
+
+    state core.ISimulationGlobalState
+    txs map[uint64]*txLatency
+    nodeConfirmed map[uint32]map[uint64]bool    // node -> confirmed blocks
+    nodeLatencies []float64
+    lock sync.Mutex
+
+    confirmations uint64
+    window float64
+    step float64
+    percentiles []float64
+}
+
+// ==== factories ====
+
+func init() {
+    // config
+    utils.ConfigSetDefault(TX_LATENCY_TAG + ".output",TX_LATENCY_TAG + ".json")
+    utils.ConfigSetDefault(TX_LATENCY_TAG + ".confirmations",DEFAULT_TX_LATENCY_CONFIRMATIONS)
+    utils.ConfigSetDefault(TX_LATENCY_TAG + ".window",DEFAULT_TX_LATENCY_WINDOW)
+    utils.ConfigSetDefault(TX_LATENCY_TAG + ".step",DEFAULT_TX_LATENCY_STEP)
+    utils.ConfigSetDefault(TX_LATENCY_TAG + ".percentiles",DEFAULT_TX_LATENCY_PERCENTILES)
+
+    // register factory
+    core.RegisterMeasurementModule(TX_LATENCY_TAG,NewTxLatencyModule)
+}
+
+func NewTxLatencyModule() core.ISimulationMeasurementModule {
+    return &TxLatencyModule{
+        DefaultMeasurementModule:   core.NewDefaultMeasurementModule(TX_LATENCY_TAG),
+        state:                      nil,
+        txs:                        make(map[uint64]*txLatency),
+        nodeConfirmed:              make(map[uint32]map[uint64]bool),
+        nodeLatencies:              make([]float64,0,1024),
+        lock:                       sync.Mutex{},
+        confirmations:              0,
+        window:                     0,
+        step:                       0,
+        percentiles:                nil,
+    }
+}
+
+// ==== methods ====
+
+func (module *TxLatencyModule) Init(sim core.ISimulation) {
+    module.DefaultMeasurementModule.Init(sim)
+
+    config := sim.GetConfig()
+    module.confirmations = config.GetUint64(TX_LATENCY_TAG + ".confirmations")
+    module.window = config.GetFloat64(TX_LATENCY_TAG + ".window")
+    module.step = config.GetFloat64(TX_LATENCY_TAG + ".step")
+    module.percentiles = config.GetFloat64Slice(TX_LATENCY_TAG + ".percentiles")
+
+    // checked by ValidateConfig (the defaults are used if the config was not validated)
+    if module.confirmations == 0 || module.window <= 0 || module.step <= 0 {
+        module.GetLogger().Error("%s.confirmations, window, and step must be positive: using the defaults",TX_LATENCY_TAG)
+        module.confirmations, module.window, module.step = DEFAULT_TX_LATENCY_CONFIRMATIONS, DEFAULT_TX_LATENCY_WINDOW, DEFAULT_TX_LATENCY_STEP
+    }
+
+    // the simulation created from a (validated) config always has a global state
+    module.state = sim.GetGlobalState()
+    if module.state == nil {
+        module.GetLogger().Error("requires a global state (block registry): no transactions will be measured")
+        return
+    }
+
+    hooks := sim.GetHooks()
+    hooks.RegisterPreTrigger(core.BLOCK_EVENT_NEW,module)
+    hooks.RegisterPreTrigger(core.BLOCK_EVENT_ACCEPTED,module)
+
+    module.GetLogger().Debug("initializing: registering to new and accepted block events (k=%d)",module.confirmations)
+}
+
+// implements core.IConfigValidator
+func (module *TxLatencyModule) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    for _, key := range []string{"confirmations","window","step"} {
+        if config.GetFloat64(TX_LATENCY_TAG + "." + key) <= 0 {
+            errs.Add(TX_LATENCY_TAG + "." + key,"must be positive")
+        }
+    }
+    errs.AddError(TX_LATENCY_TAG + ".percentiles",validatePercentiles(config,TX_LATENCY_TAG + ".percentiles"))
+
+    return errs.Err()
+}
+
+func (module *TxLatencyModule) EventPreTrigger(ev utils.IEvent) {
+    block, ok := ev.GetData().(core.IBlock)
+    if !ok {
+        return
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    switch ev.GetType() {
+    case core.BLOCK_EVENT_NEW:
+        module.blockIncluded(block,ev.GetTime())
+    case core.BLOCK_EVENT_ACCEPTED:
+        if node, ok := ev.GetDestination().(core.INode); ok {
+            module.blockIncluded(block,ev.GetTime())
+            module.tipAccepted(node.GetID(),block.GetHash(),ev.GetTime())
+        }
+    }
+}
+
+// register the transactions of a block (first inclusion)
+func (module *TxLatencyModule) blockIncluded(block core.IBlock,time float64) {
+    for _, txList := range block.GetTransactions() {
+        for _, tx := range txList {
+            entry := module.getTx(tx)
+            if !entry.isIncluded || time < entry.included {
+                entry.included = time
+                entry.isIncluded = true
+            }
+        }
+    }
+}
+
+// entry of a transaction, creating it if needed
+func (module *TxLatencyModule) getTx(tx core.ITransaction) *txLatency {
+    hash := tx.GetHash()
+    entry, ok := module.txs[hash]
+    if !ok {
+        entry = &txLatency{
+            created:    tx.GetTime(),
+        }
+        module.txs[hash] = entry
+    }
+
+    return entry
+}
+
+/*
+    A node accepted a new tip: every block at least k-1 blocks below it that
+    was not confirmed on that node yet becomes confirmed.
+*/
+func (module *TxLatencyModule) tipAccepted(nodeID uint32,tip uint64,time float64) {
+    confirmed, ok := module.nodeConfirmed[nodeID]
+    if !ok {
+        confirmed = make(map[uint64]bool)
+        module.nodeConfirmed[nodeID] = confirmed
+    }
+
+    // walk back k-1 blocks
+    current := tip
+    for i := uint64(1); i < module.confirmations; i++ {
+        block := module.state.GetBlock(current)
+        if block == nil {
+            return
+        }
+
+        parent, ok := core.GetBlockParent(block)
+        if !ok {
+            return
+        }
+        current = parent
+    }
+
+    // confirm blocks until one that was already confirmed
+    for !confirmed[current] {
+        block := module.state.GetBlock(current)
+        if block == nil {
+            return
+        }
+
+        confirmed[current] = true
+        for _, txList := range block.GetTransactions() {
+            for _, tx := range txList {
+                entry := module.getTx(tx)
+                module.nodeLatencies = append(module.nodeLatencies,time - entry.created)
+                entry.numConfirmed++
+                if !entry.isConfirmed {
+                    entry.confirmed = time
+                    entry.isConfirmed = true
+                }
+            }
+        }
+
+        parent, ok := core.GetBlockParent(block)
+        if !ok {
+            return
+        }
+        current = parent
+    }
+}
+
+// life cycle of a single transaction, for snapshots
+type txLatencyGob struct {
+    Created float64
+    Included float64
+    IsIncluded bool
+    Confirmed float64
+    IsConfirmed bool
+    NumConfirmed int
+}
+
+// state of the module, for snapshots
+type txLatencyModuleGob struct {
+    Txs map[uint64]txLatencyGob
+    NodeConfirmed map[uint32]map[uint64]bool
+    NodeLatencies []float64
+}
+
+// implements core.ISnapshotable
+func (module *TxLatencyModule) Snapshot() ([]byte,error) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    snapshot := txLatencyModuleGob{
+        Txs:            make(map[uint64]txLatencyGob,len(module.txs)),
+        NodeConfirmed:  module.nodeConfirmed,
+        NodeLatencies:  module.nodeLatencies,
+    }
+    for hash, entry := range module.txs {
+        snapshot.Txs[hash] = txLatencyGob{entry.created,entry.included,entry.isIncluded,entry.confirmed,entry.isConfirmed,entry.numConfirmed}
+    }
+
+    return core.EncodeSnapshot(snapshot)
+}
+
+// implements core.ISnapshotable
+func (module *TxLatencyModule) Restore(data []byte) error {
+    snapshot := txLatencyModuleGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    module.txs = make(map[uint64]*txLatency,len(snapshot.Txs))
+    for hash, entry := range snapshot.Txs {
+        module.txs[hash] = &txLatency{entry.Created,entry.Included,entry.IsIncluded,entry.Confirmed,entry.IsConfirmed,entry.NumConfirmed}
+    }
+
+    module.nodeConfirmed = make(map[uint32]map[uint64]bool)
+    for nodeID, confirmed := range snapshot.NodeConfirmed {
+        if confirmed == nil {
+            confirmed = make(map[uint64]bool)
+        }
+        module.nodeConfirmed[nodeID] = confirmed
+    }
+
+    module.nodeLatencies = append(make([]float64,0,len(snapshot.NodeLatencies) + 1024),snapshot.NodeLatencies...)
+    return nil
+}
+
+// ==== getters ====
+
+// implements core.IMetricSource
+func (module *TxLatencyModule) GetMetricNames() []string {
+    return []string{TX_LATENCY_METRIC_INCLUSION,TX_LATENCY_METRIC_FIRST_CONFIRMATION,TX_LATENCY_METRIC_NODE_CONFIRMATION}
+}
+
+// implements core.IMetricSource
+func (module *TxLatencyModule) HasMetric(name string) bool {
+    for _, metric := range module.GetMetricNames() {
+        if metric == name {
+            return true
+        }
+    }
+
+    return false
+}
+
+// implements core.IMetricSource
+func (module *TxLatencyModule) GetMetric(name string) ([]float64,bool) {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    // latencies sorted by the time they were observed
+    type observation struct {
+        time float64
+        latency float64
+    }
+
+    observations := make([]observation,0,len(module.txs))
+    switch name {
+    case TX_LATENCY_METRIC_INCLUSION:
+        for _, entry := range module.txs {
+            if entry.isIncluded {
+                observations = append(observations,observation{entry.included,entry.included - entry.created})
+            }
+        }
+    case TX_LATENCY_METRIC_FIRST_CONFIRMATION:
+        for _, entry := range module.txs {
+            if entry.isConfirmed {
+                observations = append(observations,observation{entry.confirmed,entry.confirmed - entry.created})
+            }
+        }
+    case TX_LATENCY_METRIC_NODE_CONFIRMATION:
+        latencies := make([]float64,len(module.nodeLatencies))
+        copy(latencies,module.nodeLatencies)
+        return latencies, true
+    default:
+        return nil, false
+    }
+
+    sort.Slice(observations,func(i,j int) bool {
+        if observations[i].time != observations[j].time {
+            return observations[i].time < observations[j].time
+        }
+        return observations[i].latency < observations[j].latency
+    })
+
+    latencies := make([]float64,len(observations))
+    for i, obs := range observations {
+        latencies[i] = obs.latency
+    }
+
+    return latencies, true
+}
+
+func (module *TxLatencyModule) GetFinalResult() interface{} {
+    module.lock.Lock()
+    defer module.lock.Unlock()
+
+    result := TxLatencyResult{
+        Confirmations:      module.confirmations,
+        NumTransactions:    len(module.txs),
+        Window:             module.window,
+        Throughput:         make([]TxThroughputSample,0,64),
+    }
+
+    inclusion := make([]float64,0,len(module.txs))
+    confirmation := make([]float64,0,len(module.txs))
+    includedTimes := make([]float64,0,len(module.txs))
+    confirmedTimes := make([]float64,0,len(module.txs))
+    numNodes := int(module.GetSimulation().GetNumNodes())
+    for _, entry := range module.txs {
+        if entry.isIncluded {
+            result.NumIncluded++
+            inclusion = append(inclusion,entry.included - entry.created)
+            includedTimes = append(includedTimes,entry.included)
+        }
+
+        if entry.isConfirmed {
+            result.NumConfirmed++
+            confirmation = append(confirmation,entry.confirmed - entry.created)
+            confirmedTimes = append(confirmedTimes,entry.confirmed)
+        }
+
+        if numNodes > 0 && entry.numConfirmed >= numNodes {
+            result.NumConfirmedByAll++
+        }
+    }
+
+    result.Inclusion = utils.NewSampleSummary(inclusion,module.percentiles)
+    result.FirstConfirmation = utils.NewSampleSummary(confirmation,module.percentiles)
+    result.NodeConfirmation = utils.NewSampleSummary(module.nodeLatencies,module.percentiles)
+
+    // throughput over sliding windows, until the end of the simulation
+    sort.Float64s(includedTimes)
+    sort.Float64s(confirmedTimes)
+    end := module.GetSimulation().GetTime()
+    for start := 0.0; start < end; start += module.step {
+        windowEnd := math.Min(start + module.window,end)
+        length := windowEnd - start
+        result.Throughput = append(result.Throughput,TxThroughputSample{
+            Start:          start,
+            End:            windowEnd,
+            IncludedTPS:    float64(countInWindow(includedTimes,start,windowEnd)) / length,
+            ConfirmedTPS:   float64(countInWindow(confirmedTimes,start,windowEnd)) / length,
+        })
+    }
+
+    module.GetLogger().Debug("%d transactions, %d included, %d confirmed",result.NumTransactions,result.NumIncluded,result.NumConfirmed)
+    return result
+}
+
+// number of times in [start,end), which must be sorted
+func countInWindow(times []float64,start float64,end float64) int {
+    return sort.SearchFloat64s(times,end) - sort.SearchFloat64s(times,start)
+}
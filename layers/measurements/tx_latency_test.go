@@ -0,0 +1,112 @@
+package measurements
+
+import (
+    "blockchainlab/simulator/core"
+    "testing"
+)
+
+// ==== tests ====
+
+/*
+    Chain 1-2-3-4 with k=2 and 2 nodes: transactions 10 and 11 (created at 1
+    and 2) are included in block 2 at 4, transaction 12 (created at 5) in
+    block 3 at 8. Node 1 accepts blocks 2, 3 and 4 at 5, 9 and 13, so block 2
+    is confirmed at 9 and block 3 at 13; node 2 accepts block 3 at 10. The
+    simulation ends at 20.
+*/
+func newTestTxLatency(t *testing.T) *TxLatencyModule {
+    sim := newTestSimulation(t,2,map[string]interface{}{
+        TX_LATENCY_TAG + ".confirmations":  2,
+        TX_LATENCY_TAG + ".window":         10.0,
+        TX_LATENCY_TAG + ".step":           5.0,
+        TX_LATENCY_TAG + ".percentiles":    []float64{50},
+    })
+    module := NewTxLatencyModule().(*TxLatencyModule)
+    module.Init(sim)
+
+    blocks := []*testBlock{
+        {hash: 1},
+        {hash: 2,parent: 1,txs: []core.ITransaction{&testTx{hash: 10,time: 1},&testTx{hash: 11,time: 2}}},
+        {hash: 3,parent: 2,txs: []core.ITransaction{&testTx{hash: 12,time: 5}}},
+        {hash: 4,parent: 3},
+    }
+
+    sim.newBlock(blocks[0],0)
+    sim.newBlock(blocks[1],4)
+    sim.accept(1,blocks[1],5)
+    sim.newBlock(blocks[2],8)
+    sim.accept(1,blocks[2],9)
+    sim.accept(2,blocks[2],10)
+    sim.newBlock(blocks[3],12)
+    sim.accept(1,blocks[3],13)
+    sim.time = 20
+
+    return module
+}
+
+// latencies of inclusion and k-confirmation, and throughput over sliding windows
+func TestTxLatencyResult(t *testing.T) {
+    result := newTestTxLatency(t).GetFinalResult().(TxLatencyResult)
+
+    if result.NumTransactions != 3 || result.NumIncluded != 3 || result.NumConfirmed != 3 || result.NumConfirmedByAll != 2 {
+        t.Errorf("%d transactions, %d included, %d confirmed, %d by all nodes, want 3, 3, 3, 2",result.NumTransactions,result.NumIncluded,result.NumConfirmed,result.NumConfirmedByAll)
+    }
+
+    latencies := []struct{
+        name string
+        got float64
+        want float64
+    }{
+        {"inclusion min",result.Inclusion.Min,2},
+        {"inclusion max",result.Inclusion.Max,3},
+        {"first confirmation min",result.FirstConfirmation.Min,7},
+        {"first confirmation median",result.FirstConfirmation.Percentiles["p50"],8},
+        {"node confirmation count",float64(result.NodeConfirmation.Count),5},
+        {"node confirmation max",result.NodeConfirmation.Max,9},
+    }
+    for _, latency := range latencies {
+        if latency.got != latency.want {
+            t.Errorf("%s: got %v, want %v",latency.name,latency.got,latency.want)
+        }
+    }
+
+    want := []TxThroughputSample{
+        {Start: 0,End: 10,IncludedTPS: 0.3,ConfirmedTPS: 0.2},
+        {Start: 5,End: 15,IncludedTPS: 0.1,ConfirmedTPS: 0.3},
+        {Start: 10,End: 20,IncludedTPS: 0,ConfirmedTPS: 0.1},
+        {Start: 15,End: 20,IncludedTPS: 0,ConfirmedTPS: 0},
+    }
+    if len(result.Throughput) != len(want) {
+        t.Fatalf("%d windows, want %d",len(result.Throughput),len(want))
+    }
+    for i, sample := range result.Throughput {
+        if sample != want[i] {
+            t.Errorf("window %d: got %+v, want %+v",i,sample,want[i])
+        }
+    }
+}
+
+// latencies in the order they were observed
+func TestTxLatencyMetrics(t *testing.T) {
+    module := newTestTxLatency(t)
+
+    tests := []struct{
+        metric string
+        want []float64
+    }{
+        {TX_LATENCY_METRIC_INCLUSION,[]float64{2,3,3}},
+        {TX_LATENCY_METRIC_FIRST_CONFIRMATION,[]float64{7,8,8}},
+        {TX_LATENCY_METRIC_NODE_CONFIRMATION,[]float64{8,7,9,8,8}},
+    }
+
+    for _, test := range tests {
+        got, ok := module.GetMetric(test.metric)
+        if !ok || !module.HasMetric(test.metric) || !equalFloats(got,test.want) {
+            t.Errorf("%s: got %v (%v), want %v",test.metric,got,ok,test.want)
+        }
+    }
+
+    if _, ok := module.GetMetric("throughput"); ok || module.HasMetric("throughput") {
+        t.Errorf("throughput: provided")
+    }
+}
@@ -18,19 +18,15 @@ type DefaultBehavior struct {
     core.DefaultComponent
 
     node core.INode
+    logger utils.ISimulationLogger
 }
 
 // ==== factories ====
 
-var bLogger utils.ISimulationLogger =  nil
-
 func NewNodeBehavior() core.INodeBehavior {
-    if bLogger == nil {
-        bLogger = utils.GetSimulationLogger(DEFAULT_BEHAVIOR_TAG)
-    }
-
     return &DefaultBehavior {
         node:           nil,
+        logger:         nil,
     }
 }
 
@@ -44,8 +40,9 @@ func init() {
 func (behavior *DefaultBehavior) Init(sim core.ISimulation,components ...core.ISimulationComponent){
     behavior.DefaultComponent.Init(sim)
     behavior.node = components[0].(core.INode)
+    behavior.logger = sim.GetLogger(DEFAULT_BEHAVIOR_TAG)
 
-    bLogger.Debug("node %d behavior initializing",behavior.node.GetID())
+    behavior.logger.Debug("node %d behavior initializing",behavior.node.GetID())
 }
 
 func (behavior *DefaultBehavior) MessageReceived(msg core.IMessage) bool {
@@ -15,13 +15,14 @@ const (
 /* 
     A simple node that follows the given layer implementations
 
-    Implements: INode 
+    Implements: INode and IConfigValidator
 */
 type DefaultNode struct {
     core.DefaultComponent
 
     nodeID uint32
     nodeType uint16
+    logger utils.ISimulationLogger
 
     // layers
     nodeNetwork core.INodeNetwork
@@ -49,17 +50,12 @@ func init() {
     core.RegisterNode(DEFAULT_NODE_TAG,NewDefaultNode)
 }
 
-var nLogger utils.ISimulationLogger = nil
-
 // factory for DefaultNode
 func NewDefaultNode() core.INode {
-    if nLogger == nil {
-        nLogger = utils.GetSimulationLogger(DEFAULT_NODE_TAG)
-    }
-
     return &DefaultNode{
         nodeID:         0,
         nodeType:       core.NODE_TYPE_FULL,
+        logger:         nil,
         nodeNetwork:    nil,
         behavior:       nil,
         // TODO other layers
@@ -101,12 +97,13 @@ func (node *DefaultNode) HandleEvent(event utils.IEvent) bool {
 }
 
 func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulationComponent) {
+    node.logger = sim.GetLogger(DEFAULT_NODE_TAG)
     if node.IsInitialized() {
-        nLogger.Error("node %d already initialized, doing it again",node.nodeID)
+        node.logger.Error("node %d already initialized, doing it again",node.nodeID)
     }
 
     node.DefaultComponent.Init(sim)
-    nLogger.Debug("node %d initializing",node.nodeID)
+    node.logger.Debug("node %d initializing",node.nodeID)
 
     // check if global network was provided
     if len(components) == 0 {
@@ -120,18 +117,18 @@ func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulatio
     
     // set up stack: behavior and node network are mandatory, others are optional
     var layer core.ISimulationComponent
-    config := utils.GetSimulationConfig()
+    config := node.GetConfigSection(DEFAULT_NODE_TAG)
 
     // node network
     layer = node.GetNodeNetwork()
     if layer == nil {
-        nnetConf := config.GetString(DEFAULT_NODE_TAG + ".default_node_network")
+        nnetConf := config.GetString("default_node_network")
 
         nnet := core.NewNodeNetworkFromRegistry(nnetConf)
         if nnet == nil {
             panic(fmt.Sprintf("node %d network not set: %v not registered",node.GetID(),nnetConf))
         } else {
-            nLogger.Debug("node %d is using node network %v",node.GetID(),nnetConf)
+            node.logger.Debug("node %d is using node network %v",node.GetID(),nnetConf)
             node.SetNodeNetwork(nnet)
             layer = nnet
         }
@@ -141,13 +138,13 @@ func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulatio
     // behavior
     layer = node.GetBehavior()
     if layer == nil {
-        behaviorConf := config.GetString(DEFAULT_NODE_TAG + ".default_behavior")
+        behaviorConf := config.GetString("default_behavior")
 
         behavior := core.NewNodeBehaviorFromRegistry(behaviorConf)
         if behavior == nil {
             panic(fmt.Sprintf("node %d behavior not set: %v not registered",node.GetID(),behaviorConf))
         } else {
-            nLogger.Debug("node %d is using behavior %v",node.GetID(),behaviorConf)
+            node.logger.Debug("node %d is using behavior %v",node.GetID(),behaviorConf)
             node.SetBehavior(behavior)
             layer = behavior
         }
@@ -159,14 +156,14 @@ func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulatio
     // state
     layer = node.GetNodeState()
     if layer == nil {
-        stateConf := config.GetString(DEFAULT_NODE_TAG + ".default_state")
+        stateConf := config.GetString("default_state")
 
         ledger := core.NewLedgerFromRegistry(ledgerConf)
         if ledger == nil {
-            nLogger.Debug(no )
+            node.logger.Debug(no )
             panic(fmt.Sprintf("node %d ledger not set: %v not registered",node.GetID(),ledgerConf))
         } else {
-            nLogger.Debug("node %d is using ledger %v",node.GetID(),ledgerConf)
+            node.logger.Debug("node %d is using ledger %v",node.GetID(),ledgerConf)
             node.SetLedger(ledger)
             layer = ledger
         }
@@ -176,13 +173,13 @@ func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulatio
     // consensus
     layer = node.GetConsensusProtocol()
     if layer == nil {
-        consensusConf := config.GetString(DEFAULT_NODE_TAG + ".default_consensus")
+        consensusConf := config.GetString("default_consensus")
 
         consensus := core.NewConsensusProtocolFromRegistry(consensusConf)
         if consensus == nil {
             panic(fmt.Sprintf("node %d consensus not set: %v not registered",node.GetID(),consensusConf))
         } else {
-            nLogger.Debug("node %d is using consensus %v",node.GetID(),consensusConf)
+            node.logger.Debug("node %d is using consensus %v",node.GetID(),consensusConf)
             node.SetConsensusProtocol(consensus)
             layer = consensus
         }
@@ -197,8 +194,28 @@ func (node *DefaultNode) Init(sim core.ISimulation,components ...core.ISimulatio
     }
 }
 
+// implements core.IConfigValidator: default layers, if set, must be registered
+func (node *DefaultNode) ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    section := utils.NewConfigSection(config,DEFAULT_NODE_TAG,node.GetConfigInstance())
+    layers := []struct{
+        key string
+        kind string
+    }{
+        {"default_node_network",core.REGISTRY_NODE_NETWORK},
+        {"default_behavior",core.REGISTRY_NODE_BEHAVIOR},
+    }
+    for _, layer := range layers {
+        if name := section.GetString(layer.key); name != "" && !core.IsRegistered(layer.kind,name) {
+            errs.Add(section.GetKey(layer.key),"no %s factory registered for %q",layer.kind,name)
+        }
+    }
+
+    return errs.Err()
+}
+
 func (node *DefaultNode) Finish() {
-    nLogger.Debug("node %d finishing",node.nodeID)
+    node.logger.Debug("node %d finishing",node.nodeID)
     node.GetNodeNetwork().Finish()
     node.DefaultComponent.Finish()
 }
@@ -15,7 +15,10 @@ const (
 /*
     Simple implementation of a node network layer. It keeps a list of neighbors
     that can be added or removed by the node behavior (no protocol for this is
-    implemented). Any message received is relayed to the node behavior.
+    implemented). If the global network has explicit links
+    (core.ILinkGlobalNetwork), neighbors are also linked there; an undirected
+    link is only removed when neither node has the other as a neighbor. Any
+    message received is relayed to the node behavior.
 
     Implements: INodeNetwork
 */
@@ -26,22 +29,18 @@ type DefaultNodeNetwork struct {
     globalNet core.IGlobalNetwork
     neighbors []uint32
     neighborLock sync.RWMutex
+    logger utils.ISimulationLogger
 }
 
 // ==== factories ====
 
-var nnetLogger utils.ISimulationLogger =  nil
-
 func NewNodeNetwork() core.INodeNetwork {
-    if nnetLogger == nil {
-        nnetLogger = utils.GetSimulationLogger(DEFAULT_NODE_NETWORK_TAG)
-    }
-
     return &DefaultNodeNetwork {
         node:           nil,
         globalNet:      nil,
         neighbors:      make([]uint32,0,10),
         neighborLock:   sync.RWMutex{},
+        logger:         nil,
     }
 }
 
@@ -54,13 +53,14 @@ func init() {
 
 func (net *DefaultNodeNetwork) Init(sim core.ISimulation,components ...core.ISimulationComponent){
     net.DefaultComponent.Init(sim)
+    net.logger = sim.GetLogger(DEFAULT_NODE_NETWORK_TAG)
     
     if len(components) < 2 {
         panic("DefaultNodeNetwork requires a node and a global network to initialize")
     }
 
     net.node = components[0].(core.INode)
-    nnetLogger.Debug("node %d network initializing",net.node.GetID())
+    net.logger.Debug("node %d network initializing",net.node.GetID())
 
     gnet := components[1].(core.IGlobalNetwork)
     if gnet == nil {
@@ -94,7 +94,7 @@ func (net *DefaultNodeNetwork) HandleEvent(event utils.IEvent) bool {
         dest.Disconnect()
         return true
     default:
-        nnetLogger.Debug("unknown event %d",event.GetType())
+        net.logger.Debug("unknown event %d",event.GetType())
     }
 
     return false
@@ -103,6 +103,13 @@ func (net *DefaultNodeNetwork) HandleEvent(event utils.IEvent) bool {
 func (net *DefaultNodeNetwork) Connect(gnet core.IGlobalNetwork) {
     net.globalNet = gnet
     net.globalNet.Connect(net.node)
+
+    // neighbors added before connecting
+    if lnet, ok := gnet.(core.ILinkGlobalNetwork); ok {
+        for _, nodeID := range net.GetNeighbors() {
+            lnet.ConnectLink(net.node.GetID(),nodeID,nil)
+        }
+    }
 }
 
 func (net *DefaultNodeNetwork) Disconnect() {
@@ -121,12 +128,12 @@ func (net *DefaultNodeNetwork) SendMessage(msg core.IMessage) {
         gnet := net.GetGlobalNetwork()
         net.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE,msg,gnet),0)
     } else {
-        nnetLogger.Debug("cannot send message: node %d is disconneted",net.node.GetID())
+        net.logger.Debug("cannot send message: node %d is disconneted",net.node.GetID())
     }
 }
 
 func (net *DefaultNodeNetwork) MessageReceived(msg core.IMessage) bool {
-    nnetLogger.Debug("node %d received message %d from %d",net.node.GetID(),msg.GetTag(),msg.GetSender())
+    net.logger.Debug("node %d received message %d from %d",net.node.GetID(),msg.GetTag(),msg.GetSender())
     return net.node.GetBehavior().MessageReceived(msg)
 }
 
@@ -134,7 +141,7 @@ func (net *DefaultNodeNetwork) SendNode(tag int32,data interface{},target uint32
     msg := core.NewP2PMessage(data,net.node.GetID(),target)
     msg.SetTag(tag)
     
-    nnetLogger.Debug("node %d sending message %d to node %d",net.node.GetID(),tag,target)
+    net.logger.Debug("node %d sending message %d to node %d",net.node.GetID(),tag,target)
     net.SendMessage(msg)
 }
 
@@ -142,7 +149,7 @@ func (net *DefaultNodeNetwork) SendBroadcast(tag int32,data interface{}) {
     msg := core.NewBroadcastMessage(data,net.node.GetID())
     msg.SetTag(tag)
     
-    nnetLogger.Debug("node %d broadcasting message %d",net.node.GetID(),tag)
+    net.logger.Debug("node %d broadcasting message %d",net.node.GetID(),tag)
     net.SendMessage(msg)
 }
 
@@ -150,7 +157,7 @@ func (net *DefaultNodeNetwork) SendNeighbors(tag int32,data interface{}) {
     msg := core.NewP2PMessageNodes(data,net.node.GetID(),net.GetNeighbors())
     msg.SetTag(tag)
 
-    nnetLogger.Debug("node %d sending message %d to neighbors",net.node.GetID(),tag)
+    net.logger.Debug("node %d sending message %d to neighbors",net.node.GetID(),tag)
     net.SendMessage(msg)
 }
 
@@ -160,9 +167,12 @@ func (net *DefaultNodeNetwork) AddNeighbor(nodeID uint32){
     }
     
     net.neighborLock.Lock()
-    defer net.neighborLock.Unlock()
-
     net.neighbors = append(net.neighbors,nodeID)
+    net.neighborLock.Unlock()
+
+    if gnet, ok := net.GetGlobalNetwork().(core.ILinkGlobalNetwork); ok {
+        gnet.ConnectLink(net.node.GetID(),nodeID,nil)
+    }
 }
 
 func (net *DefaultNodeNetwork) RemoveNeighbor(nodeID uint32){
@@ -171,8 +181,6 @@ func (net *DefaultNodeNetwork) RemoveNeighbor(nodeID uint32){
     }
 
     net.neighborLock.Lock()
-    defer net.neighborLock.Unlock()
-
     last := len(net.neighbors) - 1
     for i,n := range net.neighbors {
         if n == nodeID {
@@ -181,6 +189,12 @@ func (net *DefaultNodeNetwork) RemoveNeighbor(nodeID uint32){
             break
         }
     }
+    net.neighborLock.Unlock()
+
+    // an undirected link is also the link of the other node, which may still have this one as a neighbor
+    if gnet, ok := net.GetGlobalNetwork().(core.ILinkGlobalNetwork); ok && (gnet.IsDirected() || !net.isNeighborOf(nodeID)) {
+        gnet.DisconnectLink(net.node.GetID(),nodeID)
+    }
 }
 
 func (net *DefaultNodeNetwork) IsNeighbor(nodeID uint32) bool {
@@ -196,6 +210,35 @@ func (net *DefaultNodeNetwork) IsNeighbor(nodeID uint32) bool {
     return false
 }
 
+// whether this node is a neighbor of another node
+func (net *DefaultNodeNetwork) isNeighborOf(nodeID uint32) bool {
+    node := net.GetSimulation().GetNode(nodeID)
+    if node == nil || node.GetNodeNetwork() == nil {
+        return false
+    }
+
+    return node.GetNodeNetwork().IsNeighbor(net.node.GetID())
+}
+
+// implements core.ISnapshotable: list of neighbors
+func (net *DefaultNodeNetwork) Snapshot() ([]byte,error) {
+    return core.EncodeSnapshot(net.GetNeighbors())
+}
+
+// implements core.ISnapshotable
+func (net *DefaultNodeNetwork) Restore(data []byte) error {
+    neighbors := make([]uint32,0)
+    if err := core.DecodeSnapshot(data,&neighbors); err != nil {
+        return err
+    }
+
+    net.neighborLock.Lock()
+    defer net.neighborLock.Unlock()
+
+    net.neighbors = neighbors
+    return nil
+}
+
 // ==== getters ====
 
 func (net *DefaultNodeNetwork) GetNeighbors() []uint32 {
@@ -218,6 +261,10 @@ func (net *DefaultNodeNetwork) GetGlobalNetwork() core.IGlobalNetwork {
     return net.globalNet
 }
 
+func (net *DefaultNodeNetwork) GetNode() core.INode {
+    return net.node
+}
+
 func (net *DefaultNodeNetwork) GetName() string {
     return DEFAULT_NODE_NETWORK_TAG
 }
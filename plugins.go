@@ -0,0 +1,50 @@
+package simulator
+
+import (
+    "blockchainlab/simulator/utils"
+    "fmt"
+    "plugin"
+)
+
+func init() {
+    // Go plugins (.so files) with user-defined layers, opened before creating the simulation
+    utils.ConfigSetDefault(CONFIG_SETUP_TAG + ".plugins",[]string{})
+}
+
+// ==== functions ====
+
+/*
+    Open the Go plugins listed in "setup.plugins". A plugin is a main package
+    built with "go build -buildmode=plugin" that imports the simulator packages
+    and registers its layers in init functions, like the layers of the
+    simulator: once it is opened, its factories can be used in the config as
+    the built-in ones. Plugins must be built with the same Go version and the
+    same versions of the simulator packages as the binary that loads them.
+
+    Relative paths are resolved from the working directory. Opening the same
+    plugin again has no effect, so this can be called for every simulation.
+    The defaults registered by the plugins are applied to config.
+*/
+func LoadPlugins(config *utils.SimulationConfig) error {
+    for _, path := range config.GetStringSlice(CONFIG_SETUP_TAG + ".plugins") {
+        if err := LoadPlugin(path); err != nil {
+            return err
+        }
+    }
+
+    config.ApplyDefaults()
+    return nil
+}
+
+// open a single plugin (registering an existing name panics in its init, reported as an error)
+func LoadPlugin(path string) error {
+    var err error
+    if panicErr := recoverPanic(func() { _, err = plugin.Open(path) }); panicErr != nil {
+        err = panicErr
+    }
+    if err != nil {
+        return fmt.Errorf("cannot load plugin %s: %v",path,err)
+    }
+
+    return nil
+}
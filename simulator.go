@@ -4,13 +4,16 @@ import (
     "blockchainlab/simulator/core"
     "blockchainlab/simulator/utils"
     _ "blockchainlab/simulator/layers/global_network"
+    _ "blockchainlab/simulator/layers/measurements"
     _ "blockchainlab/simulator/layers/node"
     _ "blockchainlab/simulator/layers/node/application"
     _ "blockchainlab/simulator/layers/node/node_network"
     _ "blockchainlab/simulator/layers/node/behavior"
     // TODO _ "blockchainlab/simulator/layers/node/consensus"
     // TODO _ "blockchainlab/simulator/layers/node/ledger"
+    "errors"
     "fmt"
+    "strings"
 )
 
 const (
@@ -35,17 +38,43 @@ func init() {
     utils.ConfigSetDefault(CONFIG_SETUP_TAG + ".node_applications_list",[][]string{[]string{}})
 }
 
-// create a simulation from config file, section "setup"
-func NewSimulationFromConfig() core.ISimulation {
-    sim := core.NewSimulation()
-    config := utils.GetSimulationConfig()
+// create a simulation from a config file, section "setup" (see NewSimulationWithConfig)
+func NewSimulationFromConfig(path string) (core.ISimulation,error) {
+    config, err := utils.NewSimulationConfigFromFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("cannot read config %s: %w",path,err)
+    }
+
+    return NewSimulationWithConfig(config)
+}
+
+/*
+    Create a simulation from the given configuration, section "setup". The
+    simulation and its components use only this configuration, so several
+    simulations can be created and run in the same process. The plugins in
+    "setup.plugins" are loaded first (see LoadPlugins), then the configuration
+    is checked with ValidateConfig: if there is any problem, the simulation is
+    not created and the error is a utils.ConfigErrors with all of them.
+*/
+func NewSimulationWithConfig(config *utils.SimulationConfig) (core.ISimulation,error) {
+    if err := LoadPlugins(config); err != nil {
+        return nil, err
+    }
+    if err := ValidateConfig(config); err != nil {
+        return nil, err
+    }
+
+    sim, err := core.NewSimulationWithConfig(config)
+    if err != nil {
+        return nil, err
+    }
 
-    // end condition
+    // end condition: conditions composed with "and"/"or" may be given as separate parameters
     endConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".end_condition")
-    if len(endConf) != 2 {
-        panic("end_condition should be int the format: [\"name\",\"parameter\"]")
+    endCondition, err := core.NewEndConditionFromRegistry(endConf[0],strings.Join(endConf[1:],","))
+    if err != nil {
+        return nil, err
     }
-    endCondition := core.NewEndConditionFromRegistry(endConf[0],endConf[1])
 
     // global network
     gnetConf := config.GetString(CONFIG_SETUP_TAG + ".global_network")
@@ -55,46 +84,19 @@ func NewSimulationFromConfig() core.ISimulation {
     gstateConf := config.GetString(CONFIG_SETUP_TAG + ".global_state")
     gstate := core.NewGlobalStateFromRegistry(gstateConf)
 
-    // node implementations
+    // node implementations, counts and layers of each group (same length, checked by ValidateConfig)
     nodeConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_list")
-    if len(nodeConf) == 0 {
-        panic("cannot create simulation: no node implementation given")
-    }
-   
-    // node counts
     nodeCounts := config.GetIntSlice(CONFIG_SETUP_TAG + ".node_count_list")
-    if len(nodeCounts) != len(nodeConf) {
-        panic("cannot create simulation: node_count_list must have the same length of node_list")
-    }
-
-    // node network 
     nnetConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_network_list")
-    if len(nnetConf) != len(nodeConf) {
-        panic("cannot create simulation: node_network_list must have the same length of node_list")
-    }
-
-    // behavior
     behaviorConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_behavior_list")
-    if len(behaviorConf) != len(nodeConf) {
-        panic("cannot create simulation: node_behavior_list must have the same length of node_list")
-    }
 
     /* TODO other layers
-    // ledger
     ledgerConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_ledger_list")
-    if len(ledgerConf) != len(nodeConf) {
-        panic("cannot create simulation: node_ledger_list must have the same length of node_list")
-    }
-
-    // consensus protocol 
     consensusConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_consensus_list")
-    if len(consensusConf) != len(nodeConf) {
-        panic("cannot create simulation: node_consensus_list must have the same length of node_list")
-    }
     */
 
     // applications (optional)
-    applicationsConf := config.GetSliceStringSlice(CONFIG_SETUP_TAG + ".node_applications_list")
+    applicationsConf, _ := config.GetSliceStringSlice(CONFIG_SETUP_TAG + ".node_applications_list")
 
     // set up simulation
     sim.SetEndCondition(endCondition).SetGlobalNetwork(gnet).SetGlobalState(gstate)
@@ -104,30 +106,12 @@ func NewSimulationFromConfig() core.ISimulation {
         count := nodeCounts[idx]
         for i := 0; i < count; i++ {
             nodeInstance := core.NewNodeFromRegistry(nodeConf[idx])
-            if nodeInstance == nil {
-                panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",nodeConf[idx]))
-            }
-
             nnetInstance := core.NewNodeNetworkFromRegistry(nnetConf[idx])
-            if nnetInstance == nil {
-                panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",nnetConf[idx]))
-            }
-            
             behaviorInstance := core.NewNodeBehaviorFromRegistry(behaviorConf[idx])
-            if behaviorInstance == nil {
-                panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",behaviorConf[idx]))
-            }
 
             /* TODO instantiate other layers
             ledgerInstance := core.NewLedgerFromRegistry(ledgerConf[idx])
-            if ledgerInstance == nil {
-                panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",ledgerConf[idx]))
-            }
-
             consensusInstance := core.NewConsensusProtocolFromRegistry(consensusConf[idx])
-            if consensusInstance == nil {
-                panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",consensusConf[idx]))
-            }
             */
 
             nodeInstance.SetNodeNetwork(nnetInstance).
@@ -136,14 +120,8 @@ func NewSimulationFromConfig() core.ISimulation {
                 //SetConsensusProtocol(consensusInstance).
 
             if len(applicationsConf) > idx {
-                appList := applicationsConf[idx]
-                for _, appName := range appList {
-                    app := core.NewApplicationFromRegistry(appName)
-                    if app != nil {
-                        nodeInstance.AddApplication(app)
-                    } else {
-                        panic(fmt.Sprintf("cannot create simulation: no factory registered for %v",appName))
-                    }
+                for _, appName := range applicationsConf[idx] {
+                    nodeInstance.AddApplication(core.NewApplicationFromRegistry(appName))
                 }
             }
 
@@ -151,6 +129,172 @@ func NewSimulationFromConfig() core.ISimulation {
         }
     }
 
-    return sim 
+    return sim, nil
 }
 
+/*
+    Check the configuration without creating the simulation, and return
+    utils.ConfigErrors with every problem found (nil if none):
+        - keys and sections that are not read by any component (typos)
+        - "setup" section: registered factories for all layers, lengths of
+          the lists of the node groups, parameters of the end condition
+        - settings of the components in use that implement
+          core.IConfigValidator (e.g., distributions of the global network),
+          with their instance sections ("<name>@<instance>")
+        - instance sections not referred to by any component
+        - sections of the simulation itself (see core.ValidateSimulationConfig)
+    Plugins must be loaded before (see LoadPlugins), so that their factories
+    and keys are known.
+*/
+func ValidateConfig(config *utils.SimulationConfig) error {
+    errs := make(utils.ConfigErrors,0)
+    errs.AddError("",config.ValidateKeys())
+
+    // factories are only created to check their config, each at most once
+    validated := make(map[string]bool)
+    validate := func(kind string,key string,name string,factory func() interface{}) {
+        if !core.IsRegistered(kind,name) {
+            errs.Add(CONFIG_SETUP_TAG + "." + key,"no %s factory registered for %q",kind,name)
+            return
+        }
+        if !validated[kind + "/" + name] {
+            validated[kind + "/" + name] = true
+            errs.AddError(name,core.ValidateComponentConfig(factory(),config))
+        }
+    }
+
+    // end condition: factories report invalid parameters
+    endConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".end_condition")
+    if len(endConf) < 2 {
+        errs.Add(CONFIG_SETUP_TAG + ".end_condition","expected [\"name\",\"parameter\"] or [\"and\"|\"or\",\"name(parameter)\",...]")
+    } else if !core.IsRegistered(core.REGISTRY_END_CONDITION,endConf[0]) {
+        errs.Add(CONFIG_SETUP_TAG + ".end_condition","no %s factory registered for %q",core.REGISTRY_END_CONDITION,endConf[0])
+    } else {
+        if endCondition, err := core.NewEndConditionFromRegistry(endConf[0],strings.Join(endConf[1:],",")); err != nil {
+            errs.AddError(CONFIG_SETUP_TAG + ".end_condition",err)
+        } else {
+            errs.AddError(CONFIG_SETUP_TAG + ".end_condition",core.ValidateComponentConfig(endCondition,config))
+        }
+    }
+
+    // global components
+    gnetConf := config.GetString(CONFIG_SETUP_TAG + ".global_network")
+    validate(core.REGISTRY_GLOBAL_NETWORK,"global_network",gnetConf,func() interface{} { return core.NewGlobalNetworkFromRegistry(gnetConf) })
+    gstateConf := config.GetString(CONFIG_SETUP_TAG + ".global_state")
+    validate(core.REGISTRY_GLOBAL_STATE,"global_state",gstateConf,func() interface{} { return core.NewGlobalStateFromRegistry(gstateConf) })
+
+    // node groups
+    nodeConf := config.GetStringSlice(CONFIG_SETUP_TAG + ".node_list")
+    if len(nodeConf) == 0 {
+        errs.Add(CONFIG_SETUP_TAG + ".node_list","no node implementation given")
+    }
+    for _, name := range nodeConf {
+        name := name
+        validate(core.REGISTRY_NODE,"node_list",name,func() interface{} { return core.NewNodeFromRegistry(name) })
+    }
+
+    nodeCounts := config.GetIntSlice(CONFIG_SETUP_TAG + ".node_count_list")
+    if len(nodeCounts) != len(nodeConf) {
+        errs.Add(CONFIG_SETUP_TAG + ".node_count_list","must have the same length of node_list (%d), got %d",len(nodeConf),len(nodeCounts))
+    }
+    for _, count := range nodeCounts {
+        if count < 0 {
+            errs.Add(CONFIG_SETUP_TAG + ".node_count_list","node counts must not be negative, got %d",count)
+        }
+    }
+
+    layers := []struct{
+        key string
+        kind string
+        factory func(string) interface{}
+    }{
+        {"node_network_list",core.REGISTRY_NODE_NETWORK,func(name string) interface{} { return core.NewNodeNetworkFromRegistry(name) }},
+        {"node_behavior_list",core.REGISTRY_NODE_BEHAVIOR,func(name string) interface{} { return core.NewNodeBehaviorFromRegistry(name) }},
+    }
+    for _, layer := range layers {
+        names := config.GetStringSlice(CONFIG_SETUP_TAG + "." + layer.key)
+        if len(names) != len(nodeConf) {
+            errs.Add(CONFIG_SETUP_TAG + "." + layer.key,"must have the same length of node_list (%d), got %d",len(nodeConf),len(names))
+        }
+        for _, name := range names {
+            name, factory := name, layer.factory
+            validate(layer.kind,layer.key,name,func() interface{} { return factory(name) })
+        }
+    }
+
+    // applications (optional)
+    applicationsConf, err := config.GetSliceStringSlice(CONFIG_SETUP_TAG + ".node_applications_list")
+    errs.AddError(CONFIG_SETUP_TAG + ".node_applications_list",err)
+    for _, appList := range applicationsConf {
+        for _, name := range appList {
+            name := name
+            validate(core.REGISTRY_APPLICATION,"node_applications_list",name,func() interface{} { return core.NewApplicationFromRegistry(name) })
+        }
+    }
+
+    // instance sections that no "<name>@<instance>" refers to (e.g., a misspelled instance)
+    referenced := referencedInstanceSections(config)
+    for _, section := range config.GetInstanceSections() {
+        if !referenced[section] {
+            errs.Add(section,"instance section not used by any component (expected \"%s\" in the config)",strings.Replace(section,".",utils.CONFIG_INSTANCE_SEPARATOR,1))
+        }
+    }
+
+    // simulation, trace, measurements and debugger
+    errs.AddError("",core.ValidateSimulationConfig(config))
+
+    return errs.Err()
+}
+
+/*
+    Instance sections ("<name>.<instance>") referred to as "<name>@<instance>"
+    by any value of the config: the lists of the "setup" section, but also the
+    settings of components that create other components (e.g.,
+    default_node.default_behavior).
+*/
+func referencedInstanceSections(config *utils.SimulationConfig) map[string]bool {
+    sections := make(map[string]bool)
+    var collect func(value interface{})
+    collect = func(value interface{}) {
+        switch v := value.(type) {
+        case string:
+            if name, instance := utils.SplitConfigInstance(v); instance != "" {
+                sections[name + "." + instance] = true
+            }
+        case []string:
+            for _, item := range v {
+                collect(item)
+            }
+        case [][]string:
+            for _, item := range v {
+                collect(item)
+            }
+        case []interface{}:
+            for _, item := range v {
+                collect(item)
+            }
+        }
+    }
+
+    for _, key := range config.GetKeys() {
+        collect(config.Get(key))
+    }
+
+    return sections
+}
+
+// run f and return the value it panicked with as an error (nil if it did not panic)
+func recoverPanic(f func()) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            if e, ok := r.(error); ok {
+                err = e
+            } else {
+                err = errors.New(fmt.Sprint(r))
+            }
+        }
+    }()
+
+    f()
+    return nil
+}
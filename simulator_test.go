@@ -0,0 +1,587 @@
+package simulator
+
+import (
+    "blockchainlab/simulator/core"
+    "blockchainlab/simulator/layers/global_network"
+    "blockchainlab/simulator/utils"
+    "bytes"
+    "encoding/binary"
+    "encoding/gob"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+const (
+    TEST_MINER_TAG                              = "test_miner"
+    TEST_EVENT_MINE                             = 30001
+    TEST_EVENT_STATUS                           = 30002
+    TEST_MINING_INTERVAL                        = 2.0                               // mean time between blocks of a node (seconds)
+    TEST_STATUS_PERIOD                          = 1.0
+    TEST_STOP_NODE                              = 1                                 // node that stops mining...
+    TEST_STOP_TIME                              = 25.0                              // ...at its first status after this time
+)
+
+// ==== concrete structures ====
+
+// block of the test miner (exported fields, so it can be encoded in checkpoints)
+type testBlock struct {
+    Hash uint64
+    Parent uint64
+    Creator uint32
+    Time float64
+}
+
+// state of the test miner, for snapshots
+type testMinerGob struct {
+    Tip uint64
+    Heights map[uint64]int
+    Mined uint64
+    MiningTimer uint64
+}
+
+/*
+    Node behavior that mines blocks at exponential intervals (drawn from the
+    random number generator of the simulation), floods them, and follows the
+    longest chain. Blocks are mined with a timer created with the first block
+    (so it is re-bound by id when resuming), and a periodic status timer
+    created when initialized stops node TEST_STOP_NODE after TEST_STOP_TIME by
+    cancelling both timers.
+
+    Implements: INodeBehavior and ISnapshotable
+*/
+type testMiner struct {
+    core.DefaultComponent
+
+    node core.INode
+    tip uint64
+    heights map[uint64]int
+    mined uint64
+    mining utils.ITimer                         // nil until the first block
+    status utils.ITimer
+    stopped bool
+}
+
+// events triggered after a given time, described independently of memory addresses
+type testEventLog struct {
+    after float64
+    events []string
+}
+
+// ==== factories ====
+
+func init() {
+    gob.Register(&testBlock{})
+    core.RegisterNodeBehavior(TEST_MINER_TAG,func() core.INodeBehavior {
+        return &testMiner{heights: map[uint64]int{0: 0}}
+    })
+}
+
+// config of a small simulation of test miners, with the given settings
+func newTestConfig(settings map[string]interface{}) *utils.SimulationConfig {
+    config := utils.NewSimulationConfig()
+    config.Set("simulation.seed",7)
+    config.Set("setup.end_condition",[]string{"time","40.0"})
+    config.Set("setup.node_list",[]string{"default_node"})
+    config.Set("setup.node_count_list",[]int{8})
+    config.Set("setup.node_network_list",[]string{"default_node_network"})
+    config.Set("setup.node_behavior_list",[]string{TEST_MINER_TAG})
+    for key, value := range settings {
+        config.Set(key,value)
+    }
+
+    return config
+}
+
+// run a simulation and return the events triggered after the given time
+func runTestSimulation(t *testing.T,config *utils.SimulationConfig,after float64) []string {
+    t.Helper()
+
+    sim, err := NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+
+    log := &testEventLog{after: after}
+    sim.GetHooks().RegisterPreTriggerAll(log)
+    if err := sim.Run(); err != nil {
+        t.Fatalf("simulation failed: %v",err)
+    }
+
+    return log.events
+}
+
+// records of a trace file
+func readTestTrace(t *testing.T,path string) []core.TraceRecord {
+    t.Helper()
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatalf("cannot read trace: %v",err)
+    }
+
+    reader := bytes.NewReader(data[len(core.TRACE_MAGIC) + 2:])
+    records := make([]core.TraceRecord,0,len(data) / core.TRACE_RECORD_SIZE)
+    for {
+        record := core.TraceRecord{}
+        if err := binary.Read(reader,binary.LittleEndian,&record); err != nil {
+            return records
+        }
+        records = append(records,record)
+    }
+}
+
+// ==== methods ====
+
+func (block *testBlock) GetHash() uint64 { return block.Hash }
+func (block *testBlock) GetType() uint16 { return core.BLOCK_STANDARD }
+func (block *testBlock) GetTime() float64 { return block.Time }
+func (block *testBlock) GetCreator() uint32 { return block.Creator }
+func (block *testBlock) GetSize() uint64 { return 1000 }
+func (block *testBlock) Verify() bool { return true }
+func (block *testBlock) GetTransactions() map[uint16][]core.ITransaction { return nil }
+
+func (block *testBlock) GetReferences() map[uint16][]uint64 {
+    if block.Parent == 0 {
+        return map[uint16][]uint64{}
+    }
+
+    return map[uint16][]uint64{core.BREF_STANDARD: {block.Parent}}
+}
+
+func (miner *testMiner) Init(sim core.ISimulation,components ...core.ISimulationComponent) {
+    miner.DefaultComponent.Init(sim)
+    miner.node = components[0].(core.INode)
+    miner.ScheduleEvent(utils.NewEvent(TEST_EVENT_MINE,nil,miner),sim.GetRNG().ExpFloat64() * TEST_MINING_INTERVAL)
+    miner.status = miner.SchedulePeriodic(utils.NewEvent(TEST_EVENT_STATUS,nil,miner),TEST_STATUS_PERIOD,TEST_STATUS_PERIOD,0)
+}
+
+func (miner *testMiner) HandleEvent(ev utils.IEvent) bool {
+    if miner.DefaultComponent.HandleEvent(ev) {
+        return true
+    }
+    if ev.GetType() == TEST_EVENT_STATUS {
+        miner.checkStop()
+        return true
+    }
+    if ev.GetType() != TEST_EVENT_MINE {
+        return false
+    }
+    if miner.stopped {
+        panic(fmt.Sprintf("node %d mines after its timers were cancelled",miner.node.GetID()))
+    }
+
+    miner.mined++
+    block := &testBlock{
+        Hash:       uint64(miner.node.GetID()) << 32 | miner.mined,
+        Parent:     miner.tip,
+        Creator:    miner.node.GetID(),
+        Time:       miner.GetTime(),
+    }
+    miner.ScheduleEvent(utils.NewEvent(core.BLOCK_EVENT_NEW,core.IBlock(block),miner),0)
+    miner.accept(block)
+
+    delay := miner.GetSimulation().GetRNG().ExpFloat64() * TEST_MINING_INTERVAL
+    if miner.mining == nil {
+        miner.mining = miner.ScheduleTimer(utils.NewEvent(TEST_EVENT_MINE,nil,miner),delay)
+    } else {
+        miner.mining.Reset(delay)
+    }
+
+    return true
+}
+
+// cancel the timers of the stopping node (both must still be active)
+func (miner *testMiner) checkStop() {
+    if miner.stopped {
+        panic(fmt.Sprintf("node %d has a status after its timers were cancelled",miner.node.GetID()))
+    }
+    if miner.node.GetID() != TEST_STOP_NODE || miner.GetTime() < TEST_STOP_TIME {
+        return
+    }
+
+    miner.stopped = true
+    if !miner.status.Cancel() || miner.status.IsPending() {
+        panic("status timer was not active")
+    }
+    if miner.mining != nil && (!miner.mining.Cancel() || miner.mining.IsPending()) {
+        panic("mining timer was not active")
+    }
+}
+
+func (miner *testMiner) MessageReceived(msg core.IMessage) bool {
+    if block, ok := msg.GetData().(*testBlock); ok {
+        if _, seen := miner.heights[block.Hash]; !seen {
+            miner.accept(block)
+        }
+    }
+
+    return true
+}
+
+// add a block to the chain, switch tip if it is longer, and flood it
+func (miner *testMiner) accept(block *testBlock) {
+    miner.heights[block.Hash] = miner.heights[block.Parent] + 1
+    if miner.heights[block.Hash] > miner.heights[miner.tip] {
+        miner.tip = block.Hash
+        miner.ScheduleEvent(utils.NewEvent(core.BLOCK_EVENT_ACCEPTED,core.IBlock(block),miner.node),0)
+    }
+
+    miner.node.GetNodeNetwork().SendBroadcast(1,block)
+}
+
+func (miner *testMiner) Snapshot() ([]byte,error) {
+    snapshot := testMinerGob{Tip: miner.tip,Heights: miner.heights,Mined: miner.mined}
+    if miner.mining != nil {
+        snapshot.MiningTimer = miner.mining.GetID()
+    }
+
+    return core.EncodeSnapshot(snapshot)
+}
+
+func (miner *testMiner) Restore(data []byte) error {
+    snapshot := testMinerGob{}
+    if err := core.DecodeSnapshot(data,&snapshot); err != nil {
+        return err
+    }
+
+    miner.tip, miner.heights, miner.mined = snapshot.Tip, snapshot.Heights, snapshot.Mined
+    if snapshot.MiningTimer != 0 {
+        if miner.mining = miner.GetSimulation().GetTimer(snapshot.MiningTimer); miner.mining == nil {
+            return fmt.Errorf("mining timer %d not restored",snapshot.MiningTimer)
+        }
+    }
+
+    return nil
+}
+
+func (log *testEventLog) EventPreTrigger(ev utils.IEvent) {
+    if ev.GetTime() <= log.after || ev.GetType() < core.NODE_EVENT_INIT {
+        return
+    }
+
+    dest := fmt.Sprintf("%T",ev.GetDestination())
+    switch d := ev.GetDestination().(type) {
+    case core.INode:
+        dest = fmt.Sprintf("node/%d",d.GetID())
+    case core.INodeNetwork:
+        dest = fmt.Sprintf("node/%d/network",d.GetNode().GetID())
+    case *testMiner:
+        dest = fmt.Sprintf("node/%d/behavior",d.node.GetID())
+    }
+
+    data := ""
+    switch d := ev.GetData().(type) {
+    case core.IBlock:
+        data = fmt.Sprintf("block %x",d.GetHash())
+    case core.IMessage:
+        data = fmt.Sprintf("message from %d: %v",d.GetSender(),d.GetData())
+    }
+
+    log.events = append(log.events,fmt.Sprintf("%v %d %s %s",ev.GetTime(),ev.GetType(),dest,data))
+}
+
+// ==== getters ====
+
+func (miner *testMiner) GetName() string {
+    return TEST_MINER_TAG
+}
+
+// ==== tests ====
+
+// a run resumed from a checkpoint at T triggers the same events after T as an uninterrupted run
+func TestResumeMatchesUninterruptedRun(t *testing.T) {
+    const checkpointTime = 20.0
+    dir := t.TempDir()
+
+    full := runTestSimulation(t,newTestConfig(map[string]interface{}{
+        "simulation.checkpoint_times":      []float64{checkpointTime},
+        "simulation.checkpoint_output":     filepath.Join(dir,"checkpoint-{time}.gob"),
+    }),checkpointTime)
+    resumed := runTestSimulation(t,newTestConfig(map[string]interface{}{
+        "simulation.resume":                filepath.Join(dir,fmt.Sprintf("checkpoint-%v.gob",checkpointTime)),
+    }),checkpointTime)
+
+    if len(full) == 0 {
+        t.Fatalf("no events after the checkpoint")
+    }
+    for i := 0; i < len(full) && i < len(resumed); i++ {
+        if full[i] != resumed[i] {
+            t.Fatalf("event %d after the checkpoint differs:\n  uninterrupted: %s\n  resumed:       %s",i,full[i],resumed[i])
+        }
+    }
+    if len(full) != len(resumed) {
+        t.Fatalf("uninterrupted run triggered %d events after the checkpoint, resumed run %d",len(full),len(resumed))
+    }
+}
+
+// the verifier reports the first record where the execution diverges from the trace
+func TestTraceVerifierReportsFirstDivergence(t *testing.T) {
+    dir := t.TempDir()
+    traces := []string{filepath.Join(dir,"seed7.trace"),filepath.Join(dir,"seed8.trace")}
+    for i, seed := range []int{7,8} {
+        runTestSimulation(t,newTestConfig(map[string]interface{}{
+            "simulation.seed":  seed,
+            "trace.mode":       core.TRACE_MODE_RECORD,
+            "trace.file":       traces[i],
+        }),0)
+    }
+
+    expected, actual := readTestTrace(t,traces[0]), readTestTrace(t,traces[1])
+    first := 0
+    for first < len(expected) && first < len(actual) && expected[first] == actual[first] {
+        first++
+    }
+    if first == len(expected) || first == len(actual) {
+        t.Fatalf("traces with different seeds do not diverge")
+    }
+
+    // the same seed matches its own trace
+    config := newTestConfig(map[string]interface{}{"trace.mode": core.TRACE_MODE_VERIFY,"trace.file": traces[0]})
+    sim, err := NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+    if err := sim.Run(); err != nil {
+        t.Fatalf("execution with the same seed diverges: %v",err)
+    }
+
+    // another seed diverges at the first different record
+    config = newTestConfig(map[string]interface{}{"simulation.seed": 8,"trace.mode": core.TRACE_MODE_VERIFY,"trace.file": traces[0]})
+    sim, err = NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+    err = sim.Run()
+    if !core.IsTraceDivergence(err) {
+        t.Fatalf("expected a trace divergence, got %v",err)
+    }
+
+    divergence := sim.GetTrace().(*core.TraceVerifier).GetDivergence()
+    if divergence.Index != uint64(first) {
+        t.Errorf("divergence at record %d, want %d",divergence.Index,first)
+    }
+    if divergence.Expected == nil || *divergence.Expected != expected[first] {
+        t.Errorf("expected record %v, want %v",divergence.Expected,&expected[first])
+    }
+    if divergence.Actual == nil || *divergence.Actual != actual[first] {
+        t.Errorf("actual record %v, want %v",divergence.Actual,&actual[first])
+    }
+}
+
+// timers cancelled after resuming from a checkpoint stop their pending and recurring events
+func TestTimersCancelledAfterResume(t *testing.T) {
+    const checkpointTime = 20.0
+    dir := t.TempDir()
+
+    runTestSimulation(t,newTestConfig(map[string]interface{}{
+        "simulation.checkpoint_times":      []float64{checkpointTime},
+        "simulation.checkpoint_output":     filepath.Join(dir,"checkpoint-{time}.gob"),
+    }),checkpointTime)
+    resumed := runTestSimulation(t,newTestConfig(map[string]interface{}{
+        "simulation.resume":                filepath.Join(dir,fmt.Sprintf("checkpoint-%v.gob",checkpointTime)),
+    }),checkpointTime)
+
+    dest := fmt.Sprintf("node/%d/behavior",TEST_STOP_NODE)
+    statuses, mined, last := 0, 0, 0.0
+    for _, event := range resumed {
+        var time float64
+        var evType uint16
+        var evDest string
+        fmt.Sscanf(event,"%g %d %s",&time,&evType,&evDest)
+        if evDest != dest {
+            continue
+        }
+        switch evType {
+        case TEST_EVENT_STATUS:
+            statuses++
+            last = time
+        case TEST_EVENT_MINE:
+            mined++
+        }
+    }
+
+    if statuses == 0 || mined == 0 {
+        t.Fatalf("node %d: %d statuses and %d blocks after resuming, want both",TEST_STOP_NODE,statuses,mined)
+    }
+    if last != TEST_STOP_TIME {
+        t.Errorf("node %d: last status at %v, want %v (timers cancelled)",TEST_STOP_NODE,last,TEST_STOP_TIME)
+    }
+}
+
+// the config file is read from the given path, and a file that cannot be read is an error
+func TestNewSimulationFromConfig(t *testing.T) {
+    path := filepath.Join(t.TempDir(),"config.toml")
+    if err := os.WriteFile(path,[]byte("[simulation]\nname = \"from_file\"\n"),0644); err != nil {
+        t.Fatal(err)
+    }
+
+    sim, err := NewSimulationFromConfig(path)
+    if err != nil {
+        t.Fatalf("unexpected error: %v",err)
+    }
+    if name := sim.GetConfig().GetString("simulation.name"); name != "from_file" {
+        t.Errorf("simulation.name is %q, want from_file",name)
+    }
+
+    if sim, err := NewSimulationFromConfig(path + ".missing"); sim != nil || err == nil {
+        t.Errorf("missing config file: simulation created")
+    }
+}
+
+// invalid parameters of end conditions, modules and distributions are reported by key, without creating the simulation
+func TestValidateConfigReportsInvalidParameters(t *testing.T) {
+    tests := []struct{
+        settings map[string]interface{}
+        key string
+    }{
+        {map[string]interface{}{"setup.end_condition": []string{"time","soon"}},"setup.end_condition"},
+        {map[string]interface{}{"setup.end_condition": []string{"or","height(10,2)","time(60)"}},"setup.end_condition"},
+        {map[string]interface{}{"setup.end_condition": []string{"and","time(60)","bogus(1)"}},"setup.end_condition"},
+        {map[string]interface{}{"setup.end_condition": []string{"ci","tx_latency.unknown,0.05"},"measurements.measurement_modules": []string{"tx_latency"}},"ci_end_condition"},
+        {map[string]interface{}{"measurements.measurement_modules": []string{"message_traffic"},"message_traffic.interval": 0},"message_traffic.interval"},
+        {map[string]interface{}{"measurements.measurement_modules": []string{"tx_latency"},"tx_latency.step": -1},"tx_latency.step"},
+        {map[string]interface{}{"default_global_network.p2p_distribution": "normal","default_global_network.p2p_config": []string{"1"}},"default_global_network.p2p_config"},
+        {map[string]interface{}{"setup.global_network": "topology_global_network","topology_global_network.links": [][]float64{{1,2,0.1,0,0.5}},"topology_global_network.link_faults": [][]float64{{2,1,0,0.1,0}}},"topology_global_network.links"},
+    }
+
+    for _, test := range tests {
+        sim, err := NewSimulationWithConfig(newTestConfig(test.settings))
+        if sim != nil || err == nil {
+            t.Errorf("%v: simulation created",test.settings)
+            continue
+        }
+
+        errs, ok := err.(utils.ConfigErrors)
+        if !ok {
+            t.Errorf("%v: got %T (%v), want utils.ConfigErrors",test.settings,err,err)
+            continue
+        }
+        found := false
+        for _, e := range errs {
+            found = found || e.Key == test.key
+        }
+        if !found {
+            t.Errorf("%v: no error for key %s in %v",test.settings,test.key,err)
+        }
+    }
+}
+
+// metrics of the ci end condition that modules provide without listing them (e.g., any coverage percentage) are accepted
+func TestValidateConfigAcceptsUnlistedMetrics(t *testing.T) {
+    for _, metric := range []string{"block_propagation.coverage75","block_propagation.coverage90","block_propagation.delays"} {
+        config := newTestConfig(map[string]interface{}{
+            "setup.end_condition":                  []string{"ci",metric + ",0.05"},
+            "measurements.measurement_modules":     []string{"block_propagation"},
+        })
+        if err := ValidateConfig(config); err != nil {
+            t.Errorf("%s: %v",metric,err)
+        }
+    }
+
+    config := newTestConfig(map[string]interface{}{
+        "setup.end_condition":                  []string{"ci","block_propagation.coverage150,0.05"},
+        "measurements.measurement_modules":     []string{"block_propagation"},
+    })
+    if err := ValidateConfig(config); err == nil {
+        t.Errorf("block_propagation.coverage150: no error")
+    }
+}
+
+// the loss of configured links is set in their faults, and p2p messages to nodes that are not linked are reported as rejected
+func TestTopologyLinkLossAndRejectedMessages(t *testing.T) {
+    config := newTestConfig(map[string]interface{}{
+        "setup.global_network":                 "topology_global_network",
+        "topology_global_network.unlinked":     "reject",
+        "topology_global_network.links":        [][]float64{{1,2,0.1,0,0.5}},
+    })
+    sim, err := NewSimulationWithConfig(config)
+    if err != nil {
+        t.Fatalf("cannot create simulation: %v",err)
+    }
+
+    gnet := sim.GetGlobalNetwork().(*global_network.TopologyGlobalNetwork)
+    sim.ScheduleEvent(utils.NewEvent(core.GLOBAL_NETWORK_EVENT_SEND_MESSAGE,core.NewP2PMessage("ping",1,3),gnet),1.0)
+
+    log := &testEventLog{}
+    sim.GetHooks().RegisterPreTriggerAll(log)
+    if err := sim.Run(); err != nil {
+        t.Fatalf("simulation failed: %v",err)
+    }
+
+    for _, link := range [][2]uint32{{1,2},{2,1}} {
+        if loss := gnet.GetFaults(link[0],link[1],false).Loss; loss != 0.5 {
+            t.Errorf("link %v: loss %v, want 0.5",link,loss)
+        }
+    }
+    if loss := gnet.GetFaults(1,3,false).Loss; loss != 0 {
+        t.Errorf("link [1 3]: loss %v, want 0",loss)
+    }
+
+    rejected := 0
+    for _, ev := range log.events {
+        if strings.HasPrefix(ev,fmt.Sprintf("1 %d ",core.GLOBAL_NETWORK_EVENT_MESSAGE_REJECTED)) {
+            rejected++
+        }
+    }
+    if rejected != 1 {
+        t.Errorf("%d rejected messages, want 1",rejected)
+    }
+}
+
+/*
+    Links follow the neighbors of node networks: an undirected link is only
+    removed when both nodes have removed each other, a directed one when its
+    node removes the neighbor.
+*/
+func TestTopologyLinksFollowNeighbors(t *testing.T) {
+    tests := []struct{
+        directed bool
+        linked [][3]bool                            // links 1-2, 2-1 and 1-3 after each step
+    }{
+        {false,[][3]bool{{true,true,true},{true,true,false},{false,false,false}}},
+        {true,[][3]bool{{true,true,true},{false,true,false},{false,false,false}}},
+    }
+
+    for _, test := range tests {
+        config := newTestConfig(map[string]interface{}{
+            "setup.end_condition":                  []string{"time","1.0"},
+            "setup.global_network":                 "topology_global_network",
+            "topology_global_network.directed":     test.directed,
+        })
+        sim, err := NewSimulationWithConfig(config)
+        if err != nil {
+            t.Fatalf("cannot create simulation: %v",err)
+        }
+        if err := sim.Run(); err != nil {
+            t.Fatalf("simulation failed: %v",err)
+        }
+
+        gnet := sim.GetGlobalNetwork().(core.ILinkGlobalNetwork)
+        nnet := func(nodeID uint32) core.INodeNetwork { return sim.GetNode(nodeID).GetNodeNetwork() }
+        steps := []func(){
+            func() {
+                nnet(1).AddNeighbor(2)
+                nnet(2).AddNeighbor(1)
+                nnet(1).AddNeighbor(3)
+            },
+            func() {
+                nnet(1).RemoveNeighbor(2)
+                nnet(1).RemoveNeighbor(3)
+            },
+            func() {
+                nnet(2).RemoveNeighbor(1)
+            },
+        }
+
+        for i, step := range steps {
+            step()
+            got := [3]bool{gnet.IsLinked(1,2),gnet.IsLinked(2,1),gnet.IsLinked(1,3)}
+            if got != test.linked[i] {
+                t.Errorf("directed=%v, step %d: links 1-2, 2-1 and 1-3 are %v, want %v",test.directed,i,got,test.linked[i])
+            }
+        }
+    }
+}
@@ -1,10 +1,15 @@
 package utils
 
 import (
+    "github.com/pelletier/go-toml/v2"
+    "github.com/spf13/cast"
     "github.com/spf13/viper"
-    "flag"
     "fmt"
-    "reflect"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
 )
 
 // ==== constants ====
@@ -17,139 +22,359 @@ const (
 
 // ==== concrete structs ====
 
-// this is just a wrapper around Viper
+/*
+    Configuration of a simulation: this is just a wrapper around a Viper
+    instance. Every instance starts from the defaults registered with
+    ConfigSetDefault, so simulations in the same process can use different
+    configurations.
+*/
 type SimulationConfig struct {
+    viper *viper.Viper
 }
 
 // ==== factories ====
 
-// global singleton
-var configInstance *SimulationConfig = nil
-var configFile *string
+// defaults registered by the packages (in init, or when plugins are loaded)
+var configDefaults map[string]interface{} = make(map[string]interface{})
+var configDefaultsLock sync.RWMutex
 
-// set up basic configuration
-func init(){
-    // flag for config file
-    configFile = flag.String("config", DEFAULT_CONFIG_FILE, "configuration file")
-}
-
-// parse config file
-func initConfig(){
-    configInstance = &SimulationConfig{}
-    flag.Parse()
-
-    // read config file
-    viper.AddConfigPath(".")
-    viper.SetConfigFile(*configFile)
-    err := viper.ReadInConfig()
-    if err != nil && *configFile != DEFAULT_CONFIG_FILE {
-        panic(fmt.Errorf("%w",err)) 
+// configuration with the defaults only
+func NewSimulationConfig() *SimulationConfig {
+    config := &SimulationConfig{
+        viper:  viper.New(),
     }
+    config.ApplyDefaults()
+
+    return config
 }
 
-// get the singleton
-func GetSimulationConfig() *SimulationConfig {
-    if configInstance == nil {
-        initConfig()
+// configuration read from a file, on top of the defaults
+func NewSimulationConfigFromFile(path string) (*SimulationConfig,error) {
+    config := NewSimulationConfig()
+    config.viper.SetConfigFile(path)
+    if err := config.viper.ReadInConfig(); err != nil {
+        return nil, err
     }
 
-    return configInstance
+    return config, nil
 }
 
 // ==== getters ====
 
 // config setup operations: a wrapper on top of Viper
 
+// register a default value for all configurations (call it in init)
 func ConfigSetDefault(key string, value interface{}){
-    viper.SetDefault(key,value)
+    configDefaultsLock.Lock()
+    configDefaults[key] = value
+    configDefaultsLock.Unlock()
+}
+
+// copy of all registered defaults, by key
+func ConfigGetDefaults() map[string]interface{} {
+    configDefaultsLock.RLock()
+    defer configDefaultsLock.RUnlock()
+
+    defaults := make(map[string]interface{},len(configDefaults))
+    for key, value := range configDefaults {
+        defaults[key] = value
+    }
+
+    return defaults
+}
+
+/*
+    Apply the registered defaults again, e.g. after loading plugins that
+    register new ones (defaults are applied when the configuration is
+    created).
+*/
+func (config *SimulationConfig) ApplyDefaults() {
+    configDefaultsLock.RLock()
+    defer configDefaultsLock.RUnlock()
+
+    for key, value := range configDefaults {
+        config.viper.SetDefault(key,value)
+    }
+}
+
+// write the effective configuration (defaults merged with the file and overrides) as TOML
+func (config *SimulationConfig) WriteTOML(w io.Writer) error {
+    return toml.NewEncoder(w).Encode(config.viper.AllSettings())
+}
+
+// all keys of the effective configuration
+func (config *SimulationConfig) GetKeys() []string {
+    return config.viper.AllKeys()
+}
+
+/*
+    Keys set in the configuration (in the file or with Set) without a
+    registered default, sorted. Components register a default for every key
+    they read, so these are usually typos. Keys of instance sections
+    ("<name>.<instance>.<key>", see ConfigSection) are known if "<name>.<key>"
+    is.
+*/
+func (config *SimulationConfig) GetUnknownKeys() []string {
+    configDefaultsLock.RLock()
+    defer configDefaultsLock.RUnlock()
+
+    unknown := make([]string,0)
+    for _, key := range config.viper.AllKeys() {
+        if _, ok := configDefaults[key]; ok {
+            continue
+        }
+        if _, ok := configDefaults[instanceBaseKey(key)]; ok {
+            continue
+        }
+        unknown = append(unknown,key)
+    }
+    sort.Strings(unknown)
+
+    return unknown
+}
+
+// instance sections ("<name>.<instance>") with at least a known key, sorted
+func (config *SimulationConfig) GetInstanceSections() []string {
+    configDefaultsLock.RLock()
+    defer configDefaultsLock.RUnlock()
+
+    found := make(map[string]bool)
+    sections := make([]string,0)
+    for _, key := range config.viper.AllKeys() {
+        if _, ok := configDefaults[key]; ok {
+            continue
+        }
+        if _, ok := configDefaults[instanceBaseKey(key)]; ok {
+            parts := strings.SplitN(key,".",3)
+            section := parts[0] + "." + parts[1]
+            if !found[section] {
+                found[section] = true
+                sections = append(sections,section)
+            }
+        }
+    }
+    sort.Strings(sections)
+
+    return sections
+}
+
+/*
+    Unknown keys of the configuration (see GetUnknownKeys), reported as an
+    unknown section if no key of their section is known (e.g., a misspelled
+    section name), or as an unknown key of a known section otherwise.
+*/
+func (config *SimulationConfig) ValidateKeys() error {
+    errs := make(ConfigErrors,0)
+    reported := make(map[string]bool)
+    for _, key := range config.GetUnknownKeys() {
+        section, _, nested := strings.Cut(key,".")
+        switch {
+        case !nested:
+            errs.Add(key,"unknown key (keys belong to a section)")
+        case ConfigHasSection(section):
+            errs.Add(key,"unknown key of section [%s]",section)
+        case !reported[section]:
+            errs.Add(section,"unknown section")
+            reported[section] = true
+        }
+    }
+
+    return errs.Err()
+}
+
+// "<name>.<key>" for a key of an instance section "<name>.<instance>.<key>" (empty otherwise)
+func instanceBaseKey(key string) string {
+    parts := strings.SplitN(key,".",3)
+    if len(parts) < 3 {
+        return ""
+    }
+
+    return parts[0] + "." + parts[2]
+}
+
+// check if a section has registered defaults, i.e. it is read by some component
+func ConfigHasSection(section string) bool {
+    configDefaultsLock.RLock()
+    defer configDefaultsLock.RUnlock()
+
+    for key := range configDefaults {
+        if strings.HasPrefix(key,section + ".") {
+            return true
+        }
+    }
+
+    return false
 }
 
 func (config *SimulationConfig) IsSet(key string) bool {
-    return viper.IsSet(key)
+    return config.viper.IsSet(key)
 }
 
 func (config *SimulationConfig) Set(key string, value interface{}) {
-    viper.Set(key,value)
+    config.viper.Set(key,value)
 }
 
 func (config *SimulationConfig) Get(key string) interface{} {
-    return viper.Get(key)
+    return config.viper.Get(key)
 }
 
-func GetBool(key string) bool {
-    return viper.GetBool(key)
+func (config *SimulationConfig) GetBool(key string) bool {
+    return config.viper.GetBool(key)
 }
 
 func (config *SimulationConfig) GetFloat64(key string) float64 {
-    return viper.GetFloat64(key)
+    return config.viper.GetFloat64(key)
 }
 
 func (config *SimulationConfig) GetInt(key string) int {
-    return viper.GetInt(key)
+    return config.viper.GetInt(key)
 }
 
 func (config *SimulationConfig) GetInt32(key string) int32 {
-    return viper.GetInt32(key)
+    return config.viper.GetInt32(key)
 }
 
 func (config *SimulationConfig) GetInt64(key string) int64 {
-    return viper.GetInt64(key)
+    return config.viper.GetInt64(key)
 }
 
 func (config *SimulationConfig) GetIntSlice(key string) []int {
-    return viper.GetIntSlice(key)
+    return config.viper.GetIntSlice(key)
 }
 
 func (config *SimulationConfig) GetUint(key string) uint {
-    return viper.GetUint(key)
+    return config.viper.GetUint(key)
 }
 
 func (config *SimulationConfig) GetUint32(key string) uint32 {
-    return viper.GetUint32(key)
+    return config.viper.GetUint32(key)
 }
 
 func (config *SimulationConfig) GetUint64(key string) uint64 {
-    return viper.GetUint64(key)
+    return config.viper.GetUint64(key)
 }
 
 func (config *SimulationConfig) GetString(key string) string {
-    return viper.GetString(key)
+    return config.viper.GetString(key)
 }
 
 func (config *SimulationConfig) GetStringMap(key string) map[string]interface{} {
-    return viper.GetStringMap(key)
+    return config.viper.GetStringMap(key)
 }
 
 func (config *SimulationConfig) GetStringMapString(key string) map[string]string {
-    return viper.GetStringMapString(key)
+    return config.viper.GetStringMapString(key)
 }
 
 func (config *SimulationConfig) GetStringMapStringSlice(key string) map[string][]string {
-    return viper.GetStringMapStringSlice(key)
+    return config.viper.GetStringMapStringSlice(key)
 }
 
-func (config *SimulationConfig) GetSliceStringSlice(key string) [][]string {
-    var ret [][]string = nil
-    
+/*
+    List of lists of strings (e.g., [["a","b"],[]]). An error (without the key)
+    is returned if the value has a different structure; a key that is not set
+    gives nil.
+*/
+func (config *SimulationConfig) GetSliceStringSlice(key string) ([][]string,error) {
     value := config.Get(key)
-    if reflect.TypeOf(value) == reflect.TypeOf(ret) {
-        return value.([][]string)
+    switch v := value.(type) {
+    case nil:
+        return nil, nil
+    case [][]string:
+        return v, nil
+    case []interface{}:
+        ret := make([][]string,0,len(v))
+        for _, item := range v {
+            var strList []string
+            switch list := item.(type) {
+            case []string:
+                strList = list
+            case []interface{}:
+                strList = make([]string,0,len(list))
+                for _, str := range list {
+                    s, ok := str.(string)
+                    if !ok {
+                        return nil, fmt.Errorf("expected a list of lists of strings, got %v",value)
+                    }
+                    strList = append(strList,s)
+                }
+            default:
+                return nil, fmt.Errorf("expected a list of lists of strings, got %v",value)
+            }
+            ret = append(ret,strList)
+        }
+        return ret, nil
     }
 
-    sliceList := value.([]interface{})
-    ret = make([][]string,0,len(sliceList))
-    for i, item := range sliceList {
-        strList := item.([]interface{})
-        ret = append(ret,make([]string,0,len(strList)))
-        for _, str := range strList {
-            ret[i] = append(ret[i],str.(string))
-        }
+    return nil, fmt.Errorf("expected a list of lists of strings, got %v",value)
+}
+
+// list of numbers, panics if a value is not a number (see ParseFloat64Slice)
+func (config *SimulationConfig) GetFloat64Slice(key string) []float64 {
+    ret, err := config.ParseFloat64Slice(key)
+    if err != nil {
+        panic(fmt.Errorf("%s: %w",key,err))
     }
 
     return ret
 }
 
+// list of numbers, or an error if a value is not a number
+func (config *SimulationConfig) ParseFloat64Slice(key string) ([]float64,error) {
+    strList := config.GetStringSlice(key)
+    ret := make([]float64,0,len(strList))
+    for _, str := range strList {
+        f, err := strconv.ParseFloat(str,64)
+        if err != nil {
+            return nil, err
+        }
+        ret = append(ret,f)
+    }
+
+    return ret, nil
+}
+
+/*
+    Matrix of numbers given as a list of lists (e.g., [[1,2],[3,4]]; rows may
+    have different lengths), or an error (without the key) if a value is not a
+    number. A key that is not set gives nil.
+*/
+func (config *SimulationConfig) ParseFloat64Matrix(key string) ([][]float64,error) {
+    value := config.Get(key)
+    switch v := value.(type) {
+    case nil:
+        return nil, nil
+    case [][]float64:
+        return v, nil
+    case []interface{}:
+        ret := make([][]float64,0,len(v))
+        for i, row := range v {
+            values, ok := row.([]interface{})
+            if !ok {
+                if floats, ok := row.([]float64); ok {
+                    ret = append(ret,floats)
+                    continue
+                }
+                return nil, fmt.Errorf("row %d: expected a list of numbers, got %v",i,row)
+            }
+
+            floats := make([]float64,0,len(values))
+            for _, item := range values {
+                f, err := cast.ToFloat64E(item)
+                if err != nil {
+                    return nil, fmt.Errorf("row %d: %v",i,err)
+                }
+                floats = append(floats,f)
+            }
+            ret = append(ret,floats)
+        }
+        return ret, nil
+    }
+
+    return nil, fmt.Errorf("expected a list of lists of numbers, got %v",value)
+}
+
 func (config *SimulationConfig) GetStringSlice(key string) []string {
-    return viper.GetStringSlice(key)
+    return config.viper.GetStringSlice(key)
 }
 
@@ -0,0 +1,81 @@
+package utils
+
+import (
+    "fmt"
+    "strings"
+)
+
+// ==== concrete structures ====
+
+// problem with a key of the configuration
+type ConfigError struct {
+    Key string
+    Err error
+}
+
+/*
+    Every problem found validating a configuration, in the order they were
+    found. It is an error itself, so validations can return it as it is (use
+    Err to get nil when there are no problems).
+*/
+type ConfigErrors []*ConfigError
+
+// ==== factories ====
+
+func NewConfigError(key string,format string,args ...interface{}) *ConfigError {
+    return &ConfigError{
+        Key:    key,
+        Err:    fmt.Errorf(format,args...),
+    }
+}
+
+// ==== methods ====
+
+func (err *ConfigError) Error() string {
+    if err.Key == "" {
+        return err.Err.Error()
+    }
+
+    return err.Key + ": " + err.Err.Error()
+}
+
+func (err *ConfigError) Unwrap() error {
+    return err.Err
+}
+
+// one problem per line
+func (errs ConfigErrors) Error() string {
+    lines := make([]string,len(errs))
+    for i, err := range errs {
+        lines[i] = err.Error()
+    }
+
+    return strings.Join(lines,"\n")
+}
+
+// add a problem with a key
+func (errs *ConfigErrors) Add(key string,format string,args ...interface{}) {
+    *errs = append(*errs,NewConfigError(key,format,args...))
+}
+
+// add an error found for a key: errors of nested validations are added as they are
+func (errs *ConfigErrors) AddError(key string,err error) {
+    switch e := err.(type) {
+    case nil:
+    case ConfigErrors:
+        *errs = append(*errs,e...)
+    case *ConfigError:
+        *errs = append(*errs,e)
+    default:
+        *errs = append(*errs,&ConfigError{Key: key,Err: err})
+    }
+}
+
+// the problems as an error, nil if there are none
+func (errs ConfigErrors) Err() error {
+    if len(errs) == 0 {
+        return nil
+    }
+
+    return errs
+}
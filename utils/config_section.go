@@ -0,0 +1,130 @@
+package utils
+
+import (
+    "strings"
+)
+
+const (
+    CONFIG_INSTANCE_SEPARATOR               = "@"       // "<name>@<instance>" in lists of factories
+)
+
+// ==== concrete structures ====
+
+/*
+    View of the section of a component in a configuration, with keys relative
+    to the section (e.g., "p2p_config" instead of
+    "default_global_network.p2p_config"). Components created as
+    "<name>@<instance>" read the section "<name>.<instance>" (e.g.,
+    [default_node_network.fast]), which falls back to the section "<name>" for
+    the keys it does not set: instance sections only need the settings that
+    differ, and defaults are registered for the section "<name>" only.
+*/
+type ConfigSection struct {
+    config *SimulationConfig
+    name string
+    instance string                         // empty for the section of the component
+}
+
+// ==== factories ====
+
+// view of section name, or of its instance section if instance is not empty
+func NewConfigSection(config *SimulationConfig,name string,instance string) *ConfigSection {
+    return &ConfigSection{
+        config:     config,
+        name:       name,
+        instance:   instance,
+    }
+}
+
+// ==== methods ====
+
+// full key the value of a key of the section is read from (the instance section if set there)
+func (section *ConfigSection) GetKey(key string) string {
+    if section.instance != "" {
+        instanceKey := section.name + "." + section.instance + "." + key
+        if section.config.IsSet(instanceKey) {
+            return instanceKey
+        }
+    }
+
+    return section.name + "." + key
+}
+
+func (section *ConfigSection) IsSet(key string) bool {
+    return section.config.IsSet(section.GetKey(key))
+}
+
+func (section *ConfigSection) Get(key string) interface{} {
+    return section.config.Get(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetBool(key string) bool {
+    return section.config.GetBool(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetFloat64(key string) float64 {
+    return section.config.GetFloat64(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetInt(key string) int {
+    return section.config.GetInt(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetInt64(key string) int64 {
+    return section.config.GetInt64(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetUint64(key string) uint64 {
+    return section.config.GetUint64(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetString(key string) string {
+    return section.config.GetString(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetStringSlice(key string) []string {
+    return section.config.GetStringSlice(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetIntSlice(key string) []int {
+    return section.config.GetIntSlice(section.GetKey(key))
+}
+
+func (section *ConfigSection) GetFloat64Slice(key string) []float64 {
+    return section.config.GetFloat64Slice(section.GetKey(key))
+}
+
+func (section *ConfigSection) ParseFloat64Slice(key string) ([]float64,error) {
+    return section.config.ParseFloat64Slice(section.GetKey(key))
+}
+
+func (section *ConfigSection) ParseFloat64Matrix(key string) ([][]float64,error) {
+    return section.config.ParseFloat64Matrix(section.GetKey(key))
+}
+
+// ==== getters ====
+
+// name of the section, "<name>.<instance>" for instance sections
+func (section *ConfigSection) GetName() string {
+    if section.instance == "" {
+        return section.name
+    }
+
+    return section.name + "." + section.instance
+}
+
+func (section *ConfigSection) GetInstance() string {
+    return section.instance
+}
+
+func (section *ConfigSection) GetConfig() *SimulationConfig {
+    return section.config
+}
+
+// ==== functions ====
+
+// split "<name>@<instance>" (the instance is empty if not given)
+func SplitConfigInstance(key string) (string,string) {
+    name, instance, _ := strings.Cut(key,CONFIG_INSTANCE_SEPARATOR)
+    return name, instance
+}
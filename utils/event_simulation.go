@@ -2,6 +2,8 @@ package utils
 
 import (
     "container/heap"
+    "math/rand"
+    "sort"
     "sync"
 )
 
@@ -15,8 +17,17 @@ const (
 type IEventSimulation interface {
     Step() bool
     Now() float64
+    LastActivity() float64                          // time of the last handled event that was not scheduled by a timer
     Schedule(event IEvent,delay float64)
+    ScheduleTimer(event IEvent,delay float64) ITimer // schedule an event that can be cancelled or reset
+    SchedulePeriodic(event IEvent,delay float64,period float64,jitter float64) ITimer // recurring event
+    GetTimer(id uint64) ITimer                      // pending or recurring timer with the given id (nil if none)
+    Peek() (IEvent,float64,bool)                    // next pending event and its time (false if the queue is empty)
     GetHooks() *SimulationHooks
+
+    GetQueueSnapshot() EventQueueSnapshot           // copy of the pending events (e.g., for checkpoints)
+    SetQueueSnapshot(snapshot EventQueueSnapshot)   // replace the pending events and the current time
+    SetRNG(rng *rand.Rand)                          // random number generator for the jitter of periodic timers
 }
 
 // ==== concrete structures ====
@@ -26,11 +37,33 @@ type queueItem struct {
     event IEvent
 	time float64
     id uint64
+    index int           // position in the heap (-1 if not in the queue)
+    batched bool        // taken from the queue in a batch, but not triggered yet
+    timer *Timer        // timer of the event (nil for plain events)
 }
 
 // priority queue
 type priorityQueue []*queueItem
 
+// pending event with its scheduled time and order of scheduling
+type ScheduledEvent struct {
+    Event IEvent
+    Time float64
+    ID uint64
+    Period float64      // period of recurring events (0 for one-shot events)
+    Jitter float64
+    TimerID uint64      // id of the timer of the event (0 for plain events)
+}
+
+// state of the event queue: current time, next event and timer ids, and pending events (in order)
+type EventQueueSnapshot struct {
+    Time float64
+    LastActivity float64
+    NextID uint64
+    NextTimerID uint64
+    Events []ScheduledEvent
+}
+
 /*
     Event simulation
 
@@ -38,9 +71,13 @@ type priorityQueue []*queueItem
 */
 type EventSimulation struct {
     currentTime float64
+    lastActivity float64
     nextID uint64
+    nextTimerID uint64
+    timers map[uint64]*Timer                    // timers that are pending or recurring, by id
     queue priorityQueue
     hooks *SimulationHooks
+    rng *rand.Rand
     lock sync.RWMutex
 }
 
@@ -49,7 +86,10 @@ type EventSimulation struct {
 func NewEventSimulation() IEventSimulation {
     return &EventSimulation{
         currentTime:    0.0,
+        lastActivity:   0.0,
         nextID:         0,
+        nextTimerID:    1,
+        timers:         make(map[uint64]*Timer),
         queue:          make(priorityQueue,0,INITIAL_QUEUE_SIZE),
         hooks:          NewSimulationHooks(),
         lock:           sync.RWMutex{},
@@ -75,11 +115,15 @@ func (queue priorityQueue) Less(i,j int) bool {
 // interface required by heap
 func (queue priorityQueue) Swap(i,j int) {
 	queue[i], queue[j] = queue[j], queue[i]
+    queue[i].index = i
+    queue[j].index = j
 }
 
 // interface required by heap
 func (queue *priorityQueue) Push(item interface{}) {
-	*queue = append(*queue, item.(*queueItem))
+    qItem := item.(*queueItem)
+    qItem.index = len(*queue)
+	*queue = append(*queue,qItem)
 }
 
 // interface required by heap
@@ -87,6 +131,7 @@ func (queue *priorityQueue) Pop() interface{} {
 	n := len(*queue)
 	item := (*queue)[n-1]
 	*queue = (*queue)[:n-1]
+    item.index = -1
 	return item
 }
 
@@ -106,29 +151,66 @@ func (sim *EventSimulation) Step() bool {
     state := event.GetState()
     if state != EVENT_STATE_ABORTED {
         sim.currentTime = item.time
+        if item.timer == nil {
+            sim.lastActivity = item.time
+        }
     }
 
     sim.lock.Unlock()
    
     // handle event
     if state != EVENT_STATE_ABORTED {
-        event.SetTime(sim.currentTime)
-        sim.hooks.EventPreTrigger(event) // pre trigger hook
+        sim.trigger(item)
+    }
 
-        dest := event.GetDestination()
-        handled := false
-        if dest != nil {
-            handled = dest.HandleEvent(event)
-        }
+    return true
+}
 
-        // open: what should the state if dest == nil?
-        if handled {
-            event.SetState(EVENT_STATE_HANDLED)
-        } else {
-            event.SetState(EVENT_STATE_NOTHANDLED)
-        }
+// handle an event: set its time, call its destination and the trigger hooks
+func (sim *EventSimulation) trigger(item *queueItem) {
+    if !sim.isTriggerable(item) {
+        return
+    }
+
+    event := item.event
+    event.SetTime(item.time)
+    sim.hooks.EventPreTrigger(event) // pre trigger hook
+
+    dest := event.GetDestination()
+    handled := false
+    if dest != nil {
+        handled = dest.HandleEvent(event)
+    }
+
+    // open: what should the state if dest == nil?
+    if handled {
+        event.SetState(EVENT_STATE_HANDLED)
+    } else {
+        event.SetState(EVENT_STATE_NOTHANDLED)
+    }
+
+    sim.hooks.EventPostTrigger(event) // post trigger hook
+
+    if item.timer != nil {
+        item.timer.rearm()
+    }
+}
+
+/*
+    Whether an event taken from the queue must still be triggered: it may have
+    been aborted, or its timer cancelled or reset (back in the queue), by the
+    handler of a previous event of the same batch.
+*/
+func (sim *EventSimulation) isTriggerable(item *queueItem) bool {
+    sim.lock.Lock()
+    defer sim.lock.Unlock()
 
-        sim.hooks.EventPostTrigger(event) // post trigger hook
+    item.batched = false
+    if item.event.GetState() == EVENT_STATE_ABORTED {
+        return false
+    }
+    if item.timer != nil && (item.timer.stopped || item.index >= 0) {
+        return false
     }
 
     return true
@@ -142,6 +224,14 @@ func (sim *EventSimulation) Now() float64 {
     return sim.currentTime
 }
 
+// time of the last handled event that was not scheduled by a timer (e.g., to detect quiescence)
+func (sim *EventSimulation) LastActivity() float64 {
+    sim.lock.RLock()
+    defer sim.lock.RUnlock()
+
+    return sim.lastActivity
+}
+
 func (sim *EventSimulation) Schedule(event IEvent,delay float64) {
     sim.lock.Lock()
 
@@ -160,7 +250,178 @@ func (sim *EventSimulation) Schedule(event IEvent,delay float64) {
     sim.hooks.EventScheduled(event) // scheduled hook
 }
 
+// random number generator used for the jitter of periodic timers
+func (sim *EventSimulation) SetRNG(rng *rand.Rand) {
+    sim.lock.Lock()
+    defer sim.lock.Unlock()
+
+    sim.rng = rng
+}
+
+// schedule an event and return a handle to cancel or reset it
+func (sim *EventSimulation) ScheduleTimer(event IEvent,delay float64) ITimer {
+    return sim.schedulePeriodic(event,delay,0,0)
+}
+
+// schedule an event after delay that recurs every period (plus/minus jitter) until cancelled
+func (sim *EventSimulation) SchedulePeriodic(event IEvent,delay float64,period float64,jitter float64) ITimer {
+    if period <= 0 {
+        panic("periodic timer with non-positive period")
+    }
+
+    return sim.schedulePeriodic(event,delay,period,jitter)
+}
+
+func (sim *EventSimulation) schedulePeriodic(event IEvent,delay float64,period float64,jitter float64) ITimer {
+    sim.lock.Lock()
+    timer := &Timer{
+        sim:        sim,
+        id:         sim.nextTimerID,
+        item:       &queueItem{event: event,index: -1},
+        period:     period,
+        jitter:     jitter,
+    }
+    sim.nextTimerID++
+    sim.lock.Unlock()
+
+    timer.item.timer = timer
+    timer.Reset(delay)
+
+    return timer
+}
+
+/*
+    Timer with the given id (see ITimer.GetID), if it is pending or recurring.
+    Components that keep handles to timers across checkpoints save their ids
+    in their snapshots and get the handles back with GetTimer when restored.
+*/
+func (sim *EventSimulation) GetTimer(id uint64) ITimer {
+    sim.lock.RLock()
+    defer sim.lock.RUnlock()
+
+    if timer, ok := sim.timers[id]; ok {
+        return timer
+    }
+
+    return nil
+}
+
 func (sim *EventSimulation) GetHooks() *SimulationHooks {
     return sim.hooks
 }
 
+// next pending event and its time, without removing it from the queue
+func (sim *EventSimulation) Peek() (IEvent,float64,bool) {
+    sim.lock.RLock()
+    defer sim.lock.RUnlock()
+
+    if len(sim.queue) == 0 {
+        return nil, 0, false
+    }
+
+    return sim.queue[0].event, sim.queue[0].time, true
+}
+
+// copy of the pending events, in the order they will be triggered (aborted events are omitted)
+func (sim *EventSimulation) GetQueueSnapshot() EventQueueSnapshot {
+    sim.lock.RLock()
+    defer sim.lock.RUnlock()
+
+    items := make(priorityQueue,len(sim.queue))
+    copy(items,sim.queue)
+    sort.Slice(items,items.Less)
+
+    snapshot := EventQueueSnapshot{
+        Time:           sim.currentTime,
+        LastActivity:   sim.lastActivity,
+        NextID:         sim.nextID,
+        NextTimerID:    sim.nextTimerID,
+        Events:         make([]ScheduledEvent,0,len(items)),
+    }
+
+    for _, item := range items {
+        if item.event.GetState() == EVENT_STATE_ABORTED {
+            continue
+        }
+
+        scheduled := ScheduledEvent{
+            Event:  item.event,
+            Time:   item.time,
+            ID:     item.id,
+        }
+        if item.timer != nil {
+            scheduled.Period = item.timer.period
+            scheduled.Jitter = item.timer.jitter
+            scheduled.TimerID = item.timer.id
+        }
+        snapshot.Events = append(snapshot.Events,scheduled)
+    }
+
+    return snapshot
+}
+
+/*
+    Replace the pending events and the current time (scheduled hooks are not
+    called). The events previously in the queue are discarded: they are
+    aborted, and their timers are stopped. Timers in the snapshot keep their
+    ids: a timer of the simulation with the same id (e.g., created by a
+    component when initialized) gets the restored event, so handles to it
+    remain valid, and the others get new handles (see GetTimer).
+*/
+func (sim *EventSimulation) SetQueueSnapshot(snapshot EventQueueSnapshot) {
+    sim.lock.Lock()
+
+    // discard the current events, keeping the timers to re-bind
+    discarded := make([]IEvent,0,len(sim.queue))
+    previous := sim.timers
+    for _, item := range sim.queue {
+        item.index = -1
+        discarded = append(discarded,item.event)
+        if item.timer != nil {
+            item.timer.stopped = true
+        }
+    }
+    for _, timer := range previous {
+        timer.stopped = true
+    }
+
+    sim.currentTime = snapshot.Time
+    sim.lastActivity = snapshot.LastActivity
+    sim.nextID = snapshot.NextID
+    if snapshot.NextTimerID > sim.nextTimerID {
+        sim.nextTimerID = snapshot.NextTimerID
+    }
+    sim.timers = make(map[uint64]*Timer)
+    sim.queue = make(priorityQueue,0,len(snapshot.Events) + INITIAL_QUEUE_SIZE)
+    for _, scheduled := range snapshot.Events {
+        scheduled.Event.SetTime(scheduled.Time)
+        item := &queueItem{
+            event:  scheduled.Event,
+            time:   scheduled.Time,
+            id:     scheduled.ID,
+            index:  len(sim.queue),
+        }
+        if scheduled.TimerID != 0 || scheduled.Period > 0 {
+            timer, ok := previous[scheduled.TimerID]
+            if !ok || scheduled.TimerID == 0 {
+                timer = &Timer{sim: sim,id: scheduled.TimerID}
+            }
+            timer.item = item
+            timer.period = scheduled.Period
+            timer.jitter = scheduled.Jitter
+            timer.stopped = false
+            item.timer = timer
+            if timer.id != 0 {
+                sim.timers[timer.id] = timer
+            }
+        }
+        sim.queue = append(sim.queue,item)
+    }
+    heap.Init(&sim.queue)
+
+    sim.lock.Unlock()
+
+    for _, event := range discarded {
+        event.Abort()
+    }
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+    "testing"
+)
+
+// timers of the replaced queue are stopped, and restored timers are re-bound to their handles by id
+func TestSetQueueSnapshotRebindsTimers(t *testing.T) {
+    sim := NewEventSimulation()
+    triggered := make(map[uint16]int)
+    dest := &funcDestination{handle: func(event IEvent) bool {
+        triggered[event.GetType()]++
+        return true
+    }}
+
+    periodic := sim.SchedulePeriodic(NewEvent(1,nil,dest),1,1,0)
+    oneShot := sim.ScheduleTimer(NewEvent(2,nil,dest),5)
+    snapshot := sim.GetQueueSnapshot()
+    later := sim.ScheduleTimer(NewEvent(3,nil,dest),2)           // not in the snapshot
+
+    // restore copies of the events, as decoded from a checkpoint
+    for i, scheduled := range snapshot.Events {
+        snapshot.Events[i].Event = NewEvent(scheduled.Event.GetType(),nil,dest)
+    }
+    sim.SetQueueSnapshot(snapshot)
+
+    if later.IsPending() || later.Cancel() {
+        t.Errorf("timer of the replaced queue is still active")
+    }
+    if sim.GetTimer(later.GetID()) != nil {
+        t.Errorf("timer of the replaced queue can be retrieved")
+    }
+    if sim.GetTimer(periodic.GetID()) != periodic || sim.GetTimer(oneShot.GetID()) != oneShot {
+        t.Fatalf("restored timers are not re-bound to their handles")
+    }
+
+    sim.Step()
+    if !periodic.Cancel() || periodic.IsPending() {
+        t.Errorf("cancelling the restored periodic timer: not active or still pending")
+    }
+    oneShot.Reset(10)
+    for sim.Step() {
+    }
+
+    if triggered[1] != 1 || triggered[2] != 1 || triggered[3] != 0 {
+        t.Errorf("triggered %v, want events 1 and 2 once",triggered)
+    }
+    if oneShot.GetTime() != 11 {
+        t.Errorf("reset timer triggered at %v, want 11",oneShot.GetTime())
+    }
+}
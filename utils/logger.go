@@ -4,6 +4,7 @@ import (
     "go.uber.org/zap"
     "encoding/json"
     "fmt"
+    "sync"
 )
 
 // ==== interfaces ====
@@ -26,6 +27,7 @@ type ISimulationLogger interface {
 */
 type TagSimulationLogger struct {
     tag string
+    zap *zap.SugaredLogger
 }
 
 /*
@@ -36,12 +38,19 @@ type TagSimulationLogger struct {
 type NopSimulationLogger struct {
 }
 
-// ==== factories ====
+/*
+    Loggers of a simulation, built according to the "logger" section of its
+    configuration. There is one wrapper per tag, sharing the same zap logger.
+*/
+type SimulationLoggers struct {
+    zap *zap.SugaredLogger
+    loggers map[string]ISimulationLogger
+    nop ISimulationLogger
+    logAllTags bool
+    lock sync.Mutex
+}
 
-var zapInstance *zap.SugaredLogger = nil
-var loggerInstance map[string]ISimulationLogger = make(map[string]ISimulationLogger)
-var nopLoggerInstance ISimulationLogger
-var logAllTags bool = false
+// ==== factories ====
 
 // config
 func init(){
@@ -50,92 +59,78 @@ func init(){
     ConfigSetDefault("logger.tag_list", []string{"all"})
 }
 
-// build the logger according to the configuration
-func GetSimulationLogger(tag string) ISimulationLogger {
-    // singleton
-    if zapInstance == nil {
-        config := GetSimulationConfig()
-        level := config.GetString("logger.level")
-        outputList := config.GetStringSlice("logger.output_list")
-        tagList := config.GetStringSlice("logger.tag_list")
-
-        // nop logger
-        if level == "off" || len(outputList) == 0 || len(tagList) == 0 {
-            logger := zap.NewNop()
-            zapInstance = logger.Sugar()
-        } else {
-            // zap config
-            outputConfig, err := json.Marshal(outputList)
-            if err != nil {
-                panic(err)
-            }
-            rawJSON := []byte(`{
-                "level": "` + level + `",
-                "outputPaths": ` + string(outputConfig) + `,
-                "encoding": "console",
-                "encoderConfig": {
-                    "messageKey":"message",
-                    "levelKey":"level",
-                    "levelEncoder":"capital",
-                    "timeKey":"time",
-                    "timeEncoder":"ISO8601",
-                    "consoleSeparator": "\t"
-                }
-            }`)
-
-            var cfg zap.Config
-            if err := json.Unmarshal(rawJSON, &cfg); err != nil {
-                panic(err)
-            }
-
-            // create logger
-            logger, err := cfg.Build()
-            if err != nil {
-                panic(err)
-            }
-
-            // use sugared version
-            zapInstance = logger.Sugar()
-        }
+// build the loggers according to the configuration
+func NewSimulationLoggers(config *SimulationConfig) *SimulationLoggers {
+    level := config.GetString("logger.level")
+    outputList := config.GetStringSlice("logger.output_list")
+    tagList := config.GetStringSlice("logger.tag_list")
 
-        // build wrappers
-        nopLoggerInstance = &NopSimulationLogger{}
+    loggers := &SimulationLoggers{
+        zap:        nil,
+        loggers:    make(map[string]ISimulationLogger),
+        nop:        &NopSimulationLogger{},
+        logAllTags: false,
+        lock:       sync.Mutex{},
+    }
 
-        for i := range tagList {
-            if tagList[i] == "all" {
-                logAllTags = true
-                break
-            }
+    // nop logger
+    if level == "off" || len(outputList) == 0 || len(tagList) == 0 {
+        logger := zap.NewNop()
+        loggers.zap = logger.Sugar()
+    } else {
+        // zap config
+        outputConfig, err := json.Marshal(outputList)
+        if err != nil {
+            panic(err)
         }
-        
-        if(!logAllTags) {
-            for i := range tagList {
-                t := tagList[i]
-                loggerInstance[t] = &TagSimulationLogger{
-                    tag:    t,
-                }
+        rawJSON := []byte(`{
+            "level": "` + level + `",
+            "outputPaths": ` + string(outputConfig) + `,
+            "encoding": "console",
+            "encoderConfig": {
+                "messageKey":"message",
+                "levelKey":"level",
+                "levelEncoder":"capital",
+                "timeKey":"time",
+                "timeEncoder":"ISO8601",
+                "consoleSeparator": "\t"
             }
+        }`)
+
+        var cfg zap.Config
+        if err := json.Unmarshal(rawJSON, &cfg); err != nil {
+            panic(err)
         }
-    }
 
-    // create a wrapper for every new tag
-    if logAllTags {
-        if _, ok := loggerInstance[tag]; !ok {
-            loggerInstance[tag] = &TagSimulationLogger{
-                tag:    tag,
-            }
+        // create logger
+        logger, err := cfg.Build()
+        if err != nil {
+            panic(err)
         }
 
-        return loggerInstance[tag]
+        // use sugared version
+        loggers.zap = logger.Sugar()
     }
 
-    // return tag-specific logger
-    if instance, ok := loggerInstance[tag]; ok {
-        return instance
+    // build wrappers
+    for i := range tagList {
+        if tagList[i] == "all" {
+            loggers.logAllTags = true
+            break
+        }
+    }
+    
+    if(!loggers.logAllTags) {
+        for i := range tagList {
+            t := tagList[i]
+            loggers.loggers[t] = &TagSimulationLogger{
+                tag:    t,
+                zap:    loggers.zap,
+            }
+        }
     }
 
-    // the given tag is not logged
-    return nopLoggerInstance
+    return loggers
 }
 
 // ==== methods ====
@@ -155,27 +150,57 @@ func (logger *NopSimulationLogger) Error(template string, args ...interface{}) {
 func (logger *NopSimulationLogger) Sync() {
 }
 
+// get the logger for a tag (a logger that never logs if the tag is not in "logger.tag_list")
+func (loggers *SimulationLoggers) Get(tag string) ISimulationLogger {
+    loggers.lock.Lock()
+    defer loggers.lock.Unlock()
+
+    // create a wrapper for every new tag
+    if loggers.logAllTags {
+        if _, ok := loggers.loggers[tag]; !ok {
+            loggers.loggers[tag] = &TagSimulationLogger{
+                tag:    tag,
+                zap:    loggers.zap,
+            }
+        }
+
+        return loggers.loggers[tag]
+    }
+
+    // return tag-specific logger
+    if instance, ok := loggers.loggers[tag]; ok {
+        return instance
+    }
+
+    // the given tag is not logged
+    return loggers.nop
+}
+
+func (loggers *SimulationLoggers) Sync() {
+    loggers.zap.Sync()
+}
+
 func (logger *TagSimulationLogger) taggedTemplate(template string) string {
     return fmt.Sprintf("%s\t%s",logger.tag,template)
 }
 
 func (logger *TagSimulationLogger) Debug(template string, args ...interface{}) {
-    zapInstance.Debugf(logger.taggedTemplate(template),args...)
+    logger.zap.Debugf(logger.taggedTemplate(template),args...)
 }
 
 func (logger *TagSimulationLogger) Info(template string, args ...interface{}) {
-    zapInstance.Infof(logger.taggedTemplate(template),args...)
+    logger.zap.Infof(logger.taggedTemplate(template),args...)
 }
 
 func (logger *TagSimulationLogger) Warn(template string, args ...interface{}) {
-    zapInstance.Warnf(logger.taggedTemplate(template),args...)
+    logger.zap.Warnf(logger.taggedTemplate(template),args...)
 }
 
 func (logger *TagSimulationLogger) Error(template string, args ...interface{}) {
-    zapInstance.Errorf(logger.taggedTemplate(template),args...)
+    logger.zap.Errorf(logger.taggedTemplate(template),args...)
 }
 
 func (logger *TagSimulationLogger) Sync() {
-    zapInstance.Sync()
+    logger.zap.Sync()
 }
 
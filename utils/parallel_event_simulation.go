@@ -0,0 +1,149 @@
+package utils
+
+import (
+    "container/heap"
+    "runtime"
+    "sync"
+)
+
+// ==== concrete structures ====
+
+/*
+    Multi-threaded event simulation. Events are taken from the queue in
+    batches: a batch starts with the next event and bundles all events within
+    the batch window (in simulation time), up to the batch size. All events of
+    a batch are considered to happen "at the same time", so they are handled in
+    parallel and the batch ends with a barrier. The simulation time is set to
+    the time of the last event of the batch before any handler runs, so events
+    scheduled by the handlers start from that time, never before events
+    already handled in the batch, and the simulation time never goes back.
+    Events of a batch that are aborted, or whose timer is cancelled or reset,
+    by the handlers of previous events of the batch are not triggered.
+
+    Events of the same group are handled sequentially, in the order of the
+    queue. The group function maps an event to its group (by default, its
+    destination), e.g. all components of a node can be in the same group to
+    make event handling thread-safe at the node level. Handlers and hooks that
+    share state between groups must be thread-safe.
+
+    A batch size of 1 reverts back to the sequential EventSimulation.
+
+    Implements: IEventSimulation
+*/
+type ParallelEventSimulation struct {
+    *EventSimulation
+
+    batchWindow float64
+    batchSize int
+    workers int
+    groupFunc func(IEvent) interface{}
+}
+
+// ==== factories ====
+
+/*
+    Creates a parallel event simulation. The number of workers is the maximum
+    number of groups handled at the same time (GOMAXPROCS if not positive). If
+    groupFunc is nil, events are grouped by destination.
+*/
+func NewParallelEventSimulation(batchWindow float64,batchSize int,workers int,groupFunc func(IEvent) interface{}) IEventSimulation {
+    if workers <= 0 {
+        workers = runtime.GOMAXPROCS(0)
+    }
+
+    if groupFunc == nil {
+        groupFunc = func(ev IEvent) interface{} {
+            return ev.GetDestination()
+        }
+    }
+
+    return &ParallelEventSimulation{
+        EventSimulation:    NewEventSimulation().(*EventSimulation),
+        batchWindow:        batchWindow,
+        batchSize:          batchSize,
+        workers:            workers,
+        groupFunc:          groupFunc,
+    }
+}
+
+// ==== methods ====
+
+// trigger next batch of events
+func (sim *ParallelEventSimulation) Step() bool {
+    if sim.batchSize <= 1 {
+        return sim.EventSimulation.Step()
+    }
+
+    // get the next batch from the priority queue (aborted events are discarded)
+    sim.lock.Lock()
+
+    if len(sim.queue) == 0 {
+        sim.lock.Unlock()
+        return false
+    }
+
+    batch := make([]*queueItem,0,sim.batchSize)
+    for len(sim.queue) > 0 && len(batch) < sim.batchSize {
+        if len(batch) > 0 && sim.queue[0].time > batch[0].time + sim.batchWindow {
+            break
+        }
+
+        item := heap.Pop(&sim.queue).(*queueItem)
+        if item.event.GetState() != EVENT_STATE_ABORTED {
+            item.batched = true
+            batch = append(batch,item)
+            if item.timer == nil {
+                sim.lastActivity = item.time
+            }
+        }
+    }
+
+    // events scheduled with a negative delay must not move the time back
+    if len(batch) > 0 && batch[len(batch)-1].time > sim.currentTime {
+        sim.currentTime = batch[len(batch)-1].time
+    }
+
+    sim.lock.Unlock()
+
+    // split the batch in groups, keeping the order of the queue within each group
+    groups := make([][]*queueItem,0,len(batch))
+    groupIndex := make(map[interface{}]int)
+    for _, item := range batch {
+        key := sim.groupFunc(item.event)
+        idx, ok := groupIndex[key]
+        if !ok {
+            idx = len(groups)
+            groupIndex[key] = idx
+            groups = append(groups,make([]*queueItem,0,1))
+        }
+        groups[idx] = append(groups[idx],item)
+    }
+
+    // handle groups in parallel
+    if len(groups) == 1 {
+        sim.triggerGroup(groups[0])
+        return true
+    }
+
+    wg := sync.WaitGroup{}
+    sem := make(chan struct{},sim.workers)
+    for _, group := range groups {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(group []*queueItem) {
+            defer wg.Done()
+            sim.triggerGroup(group)
+            <-sem
+        }(group)
+    }
+    wg.Wait() // barrier
+
+    return true
+}
+
+// handle the events of a group sequentially
+func (sim *ParallelEventSimulation) triggerGroup(group []*queueItem) {
+    for _, item := range group {
+        sim.trigger(item)
+    }
+}
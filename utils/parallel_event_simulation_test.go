@@ -0,0 +1,95 @@
+package utils
+
+import (
+    "testing"
+)
+
+// destination calling a function for each event
+type funcDestination struct {
+    handle func(event IEvent) bool
+}
+
+func (dest *funcDestination) HandleEvent(event IEvent) bool {
+    return dest.handle(event)
+}
+
+// events of a batch aborted, cancelled or reset by a previous handler of the batch are not triggered
+func TestParallelBatchSkipsAbortedEvents(t *testing.T) {
+    sim := NewParallelEventSimulation(10,100,1,nil)
+    triggered := make(map[uint16]int)
+
+    var aborted IEvent
+    var cancelled, reset ITimer
+    dest := &funcDestination{}
+    dest.handle = func(event IEvent) bool {
+        triggered[event.GetType()]++
+        if event.GetType() == 1 {
+            aborted.Abort()
+            if !cancelled.Cancel() {
+                t.Errorf("cancelling a timer taken in the batch: got inactive")
+            }
+            reset.Reset(20)
+        }
+        return true
+    }
+
+    sim.Schedule(NewEvent(1,nil,dest),1)
+    aborted = NewEvent(2,nil,dest)
+    sim.Schedule(aborted,2)
+    cancelled = sim.ScheduleTimer(NewEvent(3,nil,dest),3)
+    reset = sim.ScheduleTimer(NewEvent(4,nil,dest),4)
+
+    if !sim.Step() {
+        t.Fatalf("first batch: queue is empty")
+    }
+    if triggered[1] != 1 || triggered[2] != 0 || triggered[3] != 0 || triggered[4] != 0 {
+        t.Fatalf("first batch: triggered %v, want only event 1",triggered)
+    }
+    if cancelled.IsPending() {
+        t.Errorf("cancelled timer is pending")
+    }
+    if !reset.IsPending() || reset.GetTime() != 24 {
+        t.Errorf("reset timer: pending=%v at %v, want pending at 24",reset.IsPending(),reset.GetTime())
+    }
+
+    for sim.Step() {
+    }
+    if triggered[4] != 1 || triggered[2] != 0 || triggered[3] != 0 {
+        t.Errorf("after all batches: triggered %v, want events 1 and 4 once",triggered)
+    }
+}
+
+// events scheduled by the handlers of a batch are never before events already handled
+func TestParallelBatchTimeIsMonotonic(t *testing.T) {
+    sim := NewParallelEventSimulation(1,100,1,nil)
+    times := make([]float64,0)
+
+    dest := &funcDestination{}
+    dest.handle = func(event IEvent) bool {
+        if sim.Now() < event.GetTime() {
+            t.Errorf("event %d at %v handled at time %v",event.GetType(),event.GetTime(),sim.Now())
+        }
+        times = append(times,event.GetTime())
+        if event.GetType() == 1 {
+            sim.Schedule(NewEvent(3,nil,dest),0.1)
+        }
+        return true
+    }
+
+    sim.Schedule(NewEvent(1,nil,dest),1)
+    sim.Schedule(NewEvent(2,nil,dest),2)
+    for sim.Step() {
+    }
+
+    if len(times) != 3 {
+        t.Fatalf("got %d events, want 3",len(times))
+    }
+    for i := 1; i < len(times); i++ {
+        if times[i] < times[i-1] {
+            t.Errorf("time goes back: %v",times)
+        }
+    }
+    if times[2] != 2.1 {
+        t.Errorf("event scheduled in the batch at %v, want 2.1 (end of the batch plus delay)",times[2])
+    }
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+    "math/bits"
+    "sync"
+)
+
+// ==== concrete structures ====
+
+/*
+    Thread-safe source of random numbers, so the same random number generator
+    can be shared by event handlers running in parallel. The generator is
+    xoshiro256** (seeded with splitmix64), whose whole state is four words, so
+    it can be saved and restored at once (e.g., for checkpoints), no matter how
+    many values were drawn.
+
+    Implements: rand.Source64
+*/
+type LockedSource struct {
+    state RandomState
+    lock sync.Mutex
+}
+
+// state of a LockedSource
+type RandomState [4]uint64
+
+// ==== factories ====
+
+func NewLockedSource(seed int64) *LockedSource {
+    source := &LockedSource{
+        lock:   sync.Mutex{},
+    }
+    source.Seed(seed)
+
+    return source
+}
+
+// ==== methods ====
+
+func (source *LockedSource) Int63() int64 {
+    return int64(source.Uint64() >> 1)
+}
+
+func (source *LockedSource) Uint64() uint64 {
+    source.lock.Lock()
+    defer source.lock.Unlock()
+
+    s := &source.state
+    result := bits.RotateLeft64(s[1] * 5,7) * 9
+    t := s[1] << 17
+    s[2] ^= s[0]
+    s[3] ^= s[1]
+    s[1] ^= s[2]
+    s[0] ^= s[3]
+    s[2] ^= t
+    s[3] = bits.RotateLeft64(s[3],45)
+
+    return result
+}
+
+// reset the state from a seed, expanded with splitmix64 (never all zeros)
+func (source *LockedSource) Seed(seed int64) {
+    source.lock.Lock()
+    defer source.lock.Unlock()
+
+    x := uint64(seed)
+    for i := range source.state {
+        x += 0x9e3779b97f4a7c15
+        z := x
+        z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+        z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+        source.state[i] = z ^ (z >> 31)
+    }
+}
+
+// restore a state returned by GetState
+func (source *LockedSource) SetState(state RandomState) {
+    source.lock.Lock()
+    defer source.lock.Unlock()
+
+    source.state = state
+}
+
+// ==== getters ====
+
+// current state, so the source can continue from here later (see SetState)
+func (source *LockedSource) GetState() RandomState {
+    source.lock.Lock()
+    defer source.lock.Unlock()
+
+    return source.state
+}
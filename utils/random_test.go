@@ -0,0 +1,32 @@
+package utils
+
+import (
+    "testing"
+)
+
+// a restored state continues the same sequence, whatever was drawn since
+func TestLockedSourceRestoresState(t *testing.T) {
+    source := NewLockedSource(42)
+    for i := 0; i < 1000; i++ {
+        source.Uint64()
+    }
+    state := source.GetState()
+
+    want := make([]uint64,10)
+    for i := range want {
+        want[i] = source.Uint64()
+    }
+
+    restored := NewLockedSource(7)
+    restored.SetState(state)
+    for i := range want {
+        if got := restored.Uint64(); got != want[i] {
+            t.Fatalf("draw %d after restoring: got %d, want %d",i,got,want[i])
+        }
+    }
+
+    source.Seed(42)
+    if source.GetState() == state || source.GetState() != NewLockedSource(42).GetState() {
+        t.Errorf("seeding does not reset the state")
+    }
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+    "fmt"
     "math"
     "math/rand"
 )
@@ -38,56 +39,47 @@ type DefaultSampler struct {
 
         Note: all values are in seconds. Negative values for min or max indicate
         no limit.
+
+    An error is returned for unknown distributions or a wrong number of
+    parameters (see CheckSamplerConfig).
 */
-func NewSampler(distName string, distConfig []float64, rng *rand.Rand) ISimulationSampler {
+func NewSampler(distName string, distConfig []float64, rng *rand.Rand) (ISimulationSampler,error) {
+    if err := CheckSamplerConfig(distName,distConfig); err != nil {
+        return nil, err
+    }
+
     switch distName {
     case "exponential":
-        var avg float64
-        var min float64
-        var max float64
-
-        if len(distConfig) == 3 {
-            avg = distConfig[0]
-            min = distConfig[1]
-            max = distConfig[2]
-        } else {
-            panic("distribution exponential requires three parameters: [average,min,max]")
-        }
+        return NewExponentialSampler(distConfig[0],distConfig[1],distConfig[2],rng), nil
+    case "uniform":
+        return NewUniformSampler(distConfig[0],distConfig[1],rng), nil
+    case "normal":
+        return NewNormalSampler(distConfig[0],distConfig[1],distConfig[2],distConfig[3],rng), nil
+    }
 
-        return NewExponentialSampler(avg,min,max,rng)
+    return nil, fmt.Errorf("distribution %s not supported",distName)
+}
+
+// check the distribution name and the number of its parameters, without creating the sampler
+func CheckSamplerConfig(distName string, distConfig []float64) error {
+    switch distName {
+    case "exponential":
+        if len(distConfig) != 3 {
+            return fmt.Errorf("distribution exponential requires three parameters: [average,min,max], got %d",len(distConfig))
+        }
     case "uniform":
-        var min float64
-        var max float64
-
-        if len(distConfig) == 2 {
-            min = distConfig[0]
-            max = distConfig[1]
-        } else {
-            panic("distribution uniform requires two parameters: [min,max]")
+        if len(distConfig) != 2 {
+            return fmt.Errorf("distribution uniform requires two parameters: [min,max], got %d",len(distConfig))
         }
-
-        return NewUniformSampler(min,max,rng)
     case "normal":
-        var avg float64
-        var std float64
-        var min float64
-        var max float64
-
-        if len(distConfig) == 4 {
-            avg = distConfig[0]
-            std = distConfig[1]
-            min = distConfig[2]
-            max = distConfig[3]
-        } else {
-            panic("distribution normal requires four parameters: [average,stddev,min,max]")
+        if len(distConfig) != 4 {
+            return fmt.Errorf("distribution normal requires four parameters: [average,stddev,min,max], got %d",len(distConfig))
         }
-
-        return NewNormalSampler(avg,std,min,max,rng)
     case "zipf":
         // TODO build zipf lambda
-        panic("distribution zipf not supported yet")
+        return fmt.Errorf("distribution zipf not supported yet")
     default:
-        panic("distribution " + distName + " not supported")
+        return fmt.Errorf("distribution %s not supported",distName)
     }
 
     return nil
@@ -0,0 +1,239 @@
+package utils
+
+import (
+    "math"
+    "sort"
+    "strconv"
+)
+
+// ==== concrete structures ====
+
+// summary of a sample: used by measurement modules to report distributions
+type SampleSummary struct {
+    Count int                               `json:"count"`
+    Mean float64                            `json:"mean"`
+    StdDev float64                          `json:"stddev"`
+    Min float64                             `json:"min"`
+    Max float64                             `json:"max"`
+    Percentiles map[string]float64          `json:"percentiles"`
+}
+
+// ==== factories ====
+
+/*
+    Summarize the given values (they do not need to be sorted). Percentiles are
+    given in the range [0,100], and are keyed as "p<value>" (e.g. "p50").
+*/
+func NewSampleSummary(values []float64,percentiles []float64) SampleSummary {
+    summary := SampleSummary{
+        Count:          len(values),
+        Percentiles:    make(map[string]float64),
+    }
+
+    if len(values) == 0 {
+        return summary
+    }
+
+    sorted := make([]float64,len(values))
+    copy(sorted,values)
+    sort.Float64s(sorted)
+
+    summary.Mean = Mean(sorted)
+    summary.StdDev = StdDev(sorted)
+    summary.Min = sorted[0]
+    summary.Max = sorted[len(sorted)-1]
+    for _, p := range percentiles {
+        summary.Percentiles[PercentileKey(p)] = Percentile(sorted,p)
+    }
+
+    return summary
+}
+
+// ==== functions ====
+
+// arithmetic mean (0 for an empty sample)
+func Mean(values []float64) float64 {
+    if len(values) == 0 {
+        return 0
+    }
+
+    sum := 0.0
+    for _, v := range values {
+        sum += v
+    }
+
+    return sum / float64(len(values))
+}
+
+// sample standard deviation (0 for less than two values)
+func StdDev(values []float64) float64 {
+    if len(values) < 2 {
+        return 0
+    }
+
+    mean := Mean(values)
+    sum := 0.0
+    for _, v := range values {
+        sum += (v - mean) * (v - mean)
+    }
+
+    return math.Sqrt(sum / float64(len(values) - 1))
+}
+
+/*
+    Percentile p (in the range [0,100]) of already sorted values, using linear
+    interpolation between the closest ranks.
+*/
+func Percentile(sorted []float64,p float64) float64 {
+    n := len(sorted)
+    if n == 0 {
+        return 0
+    } else if n == 1 || p <= 0 {
+        return sorted[0]
+    } else if p >= 100 {
+        return sorted[n-1]
+    }
+
+    rank := p / 100 * float64(n - 1)
+    low := int(math.Floor(rank))
+    frac := rank - float64(low)
+    if low + 1 >= n {
+        return sorted[n-1]
+    }
+
+    return sorted[low] + frac * (sorted[low+1] - sorted[low])
+}
+
+// key used for percentiles in summaries: 50 -> "p50", 99.9 -> "p99.9"
+func PercentileKey(p float64) string {
+    return "p" + strconv.FormatFloat(p,'f',-1,64)
+}
+
+/*
+    Means of consecutive batches of the values (in order), for confidence
+    intervals of correlated observations (method of batch means). When the
+    values do not split evenly, the oldest ones are discarded. Returns nil if
+    there are fewer values than batches.
+*/
+func BatchMeans(values []float64,numBatches int) []float64 {
+    if numBatches < 1 || len(values) < numBatches {
+        return nil
+    }
+
+    batchSize := len(values) / numBatches
+    values = values[len(values) - batchSize*numBatches:]
+    means := make([]float64,numBatches)
+    for i := range means {
+        means[i] = Mean(values[i*batchSize:(i+1)*batchSize])
+    }
+
+    return means
+}
+
+/*
+    Confidence interval of the mean of independent values, based on the
+    Student's t distribution: returns the mean and the half-width of the
+    interval (0 for less than two values).
+*/
+func ConfidenceInterval(values []float64,confidence float64) (float64,float64) {
+    n := len(values)
+    if n < 2 {
+        return Mean(values), 0
+    }
+
+    t := StudentTQuantile(1 - (1 - confidence) / 2,float64(n - 1))
+    return Mean(values), t * StdDev(values) / math.Sqrt(float64(n))
+}
+
+// quantile p (in the range (0,1)) of the Student's t distribution with df degrees of freedom
+func StudentTQuantile(p float64,df float64) float64 {
+    if p <= 0 {
+        return math.Inf(-1)
+    } else if p >= 1 {
+        return math.Inf(1)
+    } else if p < 0.5 {
+        return -StudentTQuantile(1 - p,df)
+    }
+
+    // bracket and bisect the cdf
+    low, high := 0.0, 1.0
+    for StudentTCDF(high,df) < p {
+        low = high
+        high *= 2
+    }
+
+    for i := 0; i < 100 && high - low > 1e-12 * high; i++ {
+        mid := (low + high) / 2
+        if StudentTCDF(mid,df) < p {
+            low = mid
+        } else {
+            high = mid
+        }
+    }
+
+    return (low + high) / 2
+}
+
+// cumulative distribution function of the Student's t distribution with df degrees of freedom
+func StudentTCDF(t float64,df float64) float64 {
+    tail := 0.5 * RegularizedIncompleteBeta(df / 2,0.5,df / (df + t*t))
+    if t < 0 {
+        return tail
+    }
+
+    return 1 - tail
+}
+
+/*
+    Regularized incomplete beta function I_x(a,b), evaluated with its continued
+    fraction (modified Lentz's method).
+*/
+func RegularizedIncompleteBeta(a float64,b float64,x float64) float64 {
+    if x <= 0 {
+        return 0
+    } else if x >= 1 {
+        return 1
+    }
+
+    // the continued fraction converges quickly for x < (a+1)/(a+b+2)
+    if x > (a + 1) / (a + b + 2) {
+        return 1 - RegularizedIncompleteBeta(b,a,1 - x)
+    }
+
+    lgammaA, _ := math.Lgamma(a)
+    lgammaB, _ := math.Lgamma(b)
+    lgammaAB, _ := math.Lgamma(a + b)
+    front := math.Exp(lgammaAB - lgammaA - lgammaB + a*math.Log(x) + b*math.Log(1 - x)) / a
+
+    const tiny = 1e-300
+    f, c, d := 1.0, 1.0, 0.0
+    for i := 0; i <= 300; i++ {
+        m := float64(i / 2)
+        var numerator float64
+        if i == 0 {
+            numerator = 1
+        } else if i % 2 == 0 {
+            numerator = m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+        } else {
+            numerator = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+        }
+
+        d = 1 + numerator * d
+        if math.Abs(d) < tiny {
+            d = tiny
+        }
+        d = 1 / d
+
+        c = 1 + numerator / c
+        if math.Abs(c) < tiny {
+            c = tiny
+        }
+
+        f *= c * d
+        if math.Abs(1 - c*d) < 1e-14 {
+            break
+        }
+    }
+
+    return front * (f - 1)
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+    "container/heap"
+)
+
+// ==== interfaces ====
+
+/*
+    Handle to a scheduled event. Unlike IEvent.Abort, cancelling a timer
+    removes its event from the queue right away. A timer can be reset any
+    number of times, including after it was triggered or cancelled (e.g.,
+    protocol timeouts restarted on every message).
+*/
+type ITimer interface {
+    Cancel() bool                   // remove the event from the queue and stop recurring (false if it was not active)
+    Reset(delay float64)            // (re)schedule the event after delay, replacing the pending occurrence if any
+    IsPending() bool                // check if the event is in the queue
+    IsPeriodic() bool               // check if the event recurs after being triggered
+    GetEvent() IEvent               // get the event of the timer
+    GetTime() float64               // get the time of the pending (or last) occurrence
+    GetPeriod() float64             // get the period (0 for one-shot timers)
+    GetJitter() float64             // get the maximum jitter added to the period
+    GetID() uint64                  // get the id of the timer, unique in the simulation and kept in checkpoints
+}
+
+// ==== concrete structures ====
+
+/*
+    Timer of an EventSimulation. Periodic timers are scheduled again right
+    after their event is handled, after the period plus a jitter drawn
+    uniformly from [-jitter,jitter] (never before the current time). The same
+    event is reused for every occurrence.
+
+    Implements: ITimer
+*/
+type Timer struct {
+    sim *EventSimulation
+    id uint64
+    item *queueItem
+    period float64
+    jitter float64
+    stopped bool                    // timer was cancelled
+}
+
+// ==== methods ====
+
+func (timer *Timer) Cancel() bool {
+    sim := timer.sim
+    sim.lock.Lock()
+
+    // an event taken in a batch is not in the queue anymore, but not triggered yet
+    pending := timer.item.index >= 0 || timer.item.batched
+    active := pending || (timer.period > 0 && !timer.stopped)
+    timer.stopped = true
+    if timer.item.index >= 0 {
+        heap.Remove(&sim.queue,timer.item.index)
+    }
+    if sim.timers[timer.id] == timer {
+        delete(sim.timers,timer.id)
+    }
+
+    sim.lock.Unlock()
+
+    if pending {
+        timer.item.event.Abort()
+    }
+
+    return active
+}
+
+func (timer *Timer) Reset(delay float64) {
+    sim := timer.sim
+    sim.lock.Lock()
+
+    item := timer.item
+    timer.stopped = false
+    item.time = sim.currentTime + delay
+    item.id = sim.nextID
+    sim.nextID++
+
+    if item.index >= 0 {
+        heap.Fix(&sim.queue,item.index)
+    } else {
+        heap.Push(&sim.queue,item)
+    }
+    sim.timers[timer.id] = timer
+
+    sim.lock.Unlock()
+
+    item.event.SetState(EVENT_STATE_PENDING)
+    item.event.SetTime(item.time)
+    sim.hooks.EventScheduled(item.event) // scheduled hook
+}
+
+// schedule the next occurrence of a periodic timer after its event was handled
+func (timer *Timer) rearm() {
+    sim := timer.sim
+    sim.lock.Lock()
+
+    // one-shot timers are forgotten once triggered, unless reset by the handler
+    if timer.period <= 0 {
+        if timer.item.index < 0 && sim.timers[timer.id] == timer {
+            delete(sim.timers,timer.id)
+        }
+        sim.lock.Unlock()
+        return
+    }
+
+    item := timer.item
+    if timer.stopped || item.index >= 0 { // cancelled or reset by the handler
+        sim.lock.Unlock()
+        return
+    }
+
+    item.time = item.time + timer.nextDelay()
+    if item.time < sim.currentTime {
+        item.time = sim.currentTime
+    }
+    item.id = sim.nextID
+    sim.nextID++
+    heap.Push(&sim.queue,item)
+
+    sim.lock.Unlock()
+
+    item.event.SetState(EVENT_STATE_PENDING)
+    item.event.SetTime(item.time)
+    sim.hooks.EventScheduled(item.event) // scheduled hook
+}
+
+// period plus jitter (no jitter without a random number generator)
+func (timer *Timer) nextDelay() float64 {
+    delay := timer.period
+    if timer.jitter > 0 && timer.sim.rng != nil {
+        delay += (2*timer.sim.rng.Float64() - 1) * timer.jitter
+    }
+
+    if delay < 0 {
+        return 0
+    }
+
+    return delay
+}
+
+// ==== getters ====
+
+func (timer *Timer) IsPending() bool {
+    timer.sim.lock.RLock()
+    defer timer.sim.lock.RUnlock()
+
+    return timer.item.index >= 0 || timer.item.batched
+}
+
+func (timer *Timer) IsPeriodic() bool {
+    return timer.period > 0
+}
+
+func (timer *Timer) GetEvent() IEvent {
+    return timer.item.event
+}
+
+func (timer *Timer) GetTime() float64 {
+    timer.sim.lock.RLock()
+    defer timer.sim.lock.RUnlock()
+
+    return timer.item.time
+}
+
+func (timer *Timer) GetPeriod() float64 {
+    return timer.period
+}
+
+func (timer *Timer) GetJitter() float64 {
+    return timer.jitter
+}
+
+func (timer *Timer) GetID() uint64 {
+    return timer.id
+}